@@ -6,16 +6,16 @@ import (
 	bbolt2 "github.com/coreos/bbolt"
 	"github.com/google/go-github/v33/github"
 	"github.com/jessevdk/go-flags"
-	"github.com/lightningnetwork/lnd/lnrpc"
 	config "github.com/sputn1ck/github-bounty"
-	"github.com/sputn1ck/github-bounty/lnd"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"github.com/sputn1ck/github-bounty/rates"
 	"github.com/sputn1ck/github-bounty/tracker"
 	"golang.org/x/oauth2"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 )
 
 // flow:
@@ -37,7 +37,7 @@ func run() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	shutdown := make(chan struct{})
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		defer close(shutdown)
@@ -59,13 +59,6 @@ func run() error {
 	if err != nil {
 		return err
 	}
-	// create admin lnd client
-	cc, err := lnd.ConnectFromLndConnectWithTimeout(ctx, cfg.LndConnect, time.Second*10)
-	if err != nil {
-		return fmt.Errorf("connecting to lnd error: %v", err)
-	}
-	defer cc.Close()
-	lndClient := lnrpc.NewLightningClient(cc)
 	// create boltdb
 	boltDb, err := bbolt2.Open(cfg.DbFilePath, 0600, nil)
 	if err != nil {
@@ -76,6 +69,30 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("unable to create issue store: %v", err)
 	}
+	repoStore, err := tracker.NewRepositoryStore(boltDb)
+	if err != nil {
+		return fmt.Errorf("unable to create repository store: %v", err)
+	}
+	outboxStore, err := tracker.NewPendingOperationStore(boltDb)
+	if err != nil {
+		return fmt.Errorf("unable to create outbox store: %v", err)
+	}
+	healthStore, err := tracker.NewNodeHealthStore(boltDb)
+	if err != nil {
+		return fmt.Errorf("unable to create health store: %v", err)
+	}
+	optOutStore, err := tracker.NewLeaderboardOptOutStore(boltDb)
+	if err != nil {
+		return fmt.Errorf("unable to create opt out store: %v", err)
+	}
+	deliveryStore, err := tracker.NewNotificationDeliveryStore(boltDb)
+	if err != nil {
+		return fmt.Errorf("unable to create delivery store: %v", err)
+	}
+	ledgerStore, err := tracker.NewBountyLedgerStore(boltDb)
+	if err != nil {
+		return fmt.Errorf("unable to create ledger store: %v", err)
+	}
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: cfg.GithubAccessToken},
 	)
@@ -85,15 +102,48 @@ func run() error {
 	if err != nil {
 		return err
 	}
-	githubClient := tracker.NewGithubService(cfg.HttpUrl, client)
-	issueService := tracker.NewIssueService(cfg, issueStore, githubClient, lndClient)
+	githubClient := tracker.NewGithubService(client)
+	pool := lightning.NewPool(cfg.NodeDialTimeout, cfg.NodeIdleTimeout)
+	go pool.Run(ctx)
+	rateProvider, err := rates.New(cfg.RateProvider, cfg.RateSource)
+	if err != nil {
+		return fmt.Errorf("unable to create rate provider: %v", err)
+	}
+	rateProvider = rates.NewCachedProvider(rateProvider, cfg.RateCacheDuration)
+
+	issueService := tracker.NewIssueService(cfg, issueStore, repoStore, healthStore, outboxStore, optOutStore, deliveryStore, ledgerStore, githubClient, pool, rateProvider)
+
+	if cfg.NostrKey != "" {
+		nostrKey, err := nostr.PrivateKeyFromHex(cfg.NostrKey)
+		if err != nil {
+			return fmt.Errorf("invalid nostr key: %v", err)
+		}
+		if len(cfg.NostrRelays) == 0 {
+			return fmt.Errorf("nostr requires at least one relay")
+		}
+		relays := nostr.NewRelayPool(cfg.NostrRelays)
+		defer relays.Close()
+		issueService.SetNostr(nostrKey, relays)
+	}
 
+	err = issueService.StartEventHandlers(ctx)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("recovering invoices \n")
 	err = issueService.RecoverPayments(ctx)
 	if err != nil {
 		return err
 	}
 
+	err = issueService.StartOnchainWatchers(ctx)
+	if err != nil {
+		return err
+	}
+	issueService.StartHealthMonitor(ctx)
+	issueService.StartClaimExpiry(ctx)
+	issueService.StartReconciliation(ctx)
+
 	webhookHandler, err := tracker.NewWebhookHandler(cfg, issueService, meta.Hooks)
 	if err != nil {
 		return fmt.Errorf("error starting http handler %v", err)
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"github.com/sputn1ck/github-bounty/tracker"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// verifies the ledger of a bounty bot: the hash chain, the preimages of the
+// donations and the totals of the bounties
+type options struct {
+	Url     string   `long:"url" description:"http url of the bounty bot" default:"http://localhost:8123"`
+	File    string   `long:"file" description:"verify a ledger saved from the ledger endpoint instead of requesting it"`
+	Anchors []string `long:"anchor" description:"hash of a ledger entry that must be part of the ledger, like the one of a bounty comment, can be given multiple times"`
+}
+
+func main() {
+	err := run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	opts := &options{}
+	_, err := flags.Parse(opts)
+	if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	verifier := tracker.NewLedgerVerifier()
+	hashes := make(map[string]uint64)
+	var last *tracker.LedgerResponse
+	if opts.File != "" {
+		last, err = readLedger(opts.File)
+		if err != nil {
+			return err
+		}
+		err = verify(verifier, hashes, last.Entries)
+		if err != nil {
+			return err
+		}
+	} else {
+		client := &http.Client{Timeout: time.Minute}
+		for {
+			var since uint64
+			if verifier.Head != nil {
+				since = verifier.Head.Seq
+			}
+			res, err := fetchLedger(client, opts.Url, since)
+			if err != nil {
+				return err
+			}
+			last = res
+			if len(res.Entries) == 0 {
+				break
+			}
+			err = verify(verifier, hashes, res.Entries)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if verifier.Head == nil {
+		fmt.Printf("ledger is empty \n")
+		return nil
+	}
+	fmt.Printf("verified %v entries, head is %v \n", verifier.Head.Seq, verifier.Head.Hash)
+
+	var failures []string
+	if last.Head != nil && last.Head.Seq > verifier.Head.Seq {
+		failures = append(failures, fmt.Sprintf("ledger head %v wasn't returned", last.Head.Seq))
+	}
+	for _, anchor := range last.Anchors {
+		if seq, ok := hashes[anchor.Hash]; !ok || seq != anchor.Seq {
+			failures = append(failures, fmt.Sprintf("anchor %v of entry %v isn't part of the ledger", anchor.Hash, anchor.Seq))
+		}
+	}
+	for _, anchor := range opts.Anchors {
+		seq, ok := hashes[strings.ToLower(anchor)]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("anchor %v isn't part of the ledger", anchor))
+			continue
+		}
+		fmt.Printf("anchor %v is entry %v \n", anchor, seq)
+	}
+
+	ids := make([]int64, 0, len(verifier.Totals))
+	for id := range verifier.Totals {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		totals := verifier.Totals[id]
+		fmt.Printf("bounty %v: donated %v sats, paid out %v sats, awarded %v sats, refunded %v sats \n",
+			id, totals.Donated, totals.PaidOut, totals.Awarded, totals.Refunded)
+		claimed, ok := last.Bounties[id]
+		if !ok {
+			continue
+		}
+		if *claimed != *totals {
+			failures = append(failures, fmt.Sprintf("bounty %v claims %v sats donated, %v sats paid out, %v sats awarded and %v sats refunded",
+				id, claimed.Donated, claimed.PaidOut, claimed.Awarded, claimed.Refunded))
+		}
+	}
+	for id := range last.Bounties {
+		if _, ok := verifier.Totals[id]; !ok {
+			failures = append(failures, fmt.Sprintf("bounty %v has no ledger entries", id))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("ledger doesn't match:\n%s", strings.Join(failures, "\n"))
+	}
+	fmt.Printf("ledger is consistent \n")
+	return nil
+}
+
+func verify(verifier *tracker.LedgerVerifier, hashes map[string]uint64, entries []*tracker.LedgerEntry) error {
+	for _, entry := range entries {
+		err := verifier.Add(entry)
+		if err != nil {
+			return err
+		}
+		hashes[entry.Hash] = entry.Seq
+	}
+	return nil
+}
+
+func fetchLedger(client *http.Client, url string, since uint64) (*tracker.LedgerResponse, error) {
+	res, err := client.Get(fmt.Sprintf("%s/ledger?since=%d", strings.TrimSuffix(url, "/"), since))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch the ledger: %v", res.Status)
+	}
+	ledger := &tracker.LedgerResponse{}
+	err = json.NewDecoder(res.Body).Decode(ledger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ledger: %v", err)
+	}
+	return ledger, nil
+}
+
+func readLedger(path string) (*tracker.LedgerResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ledger := &tracker.LedgerResponse{}
+	err = json.NewDecoder(f).Decode(ledger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ledger: %v", err)
+	}
+	return ledger, nil
+}
@@ -1,29 +1,94 @@
 package config
 
+import "time"
+
 var (
-	DefaultSecret        = "secret"
-	DefaultHttpUrl       = "http://localhost:8123"
-	DefaultListenAddress = "0.0.0.0:8123"
-	DefaultStaticFilePath = "./dist"
-	DefaultDbFilePath = "./db"
+	DefaultSecret                = "secret"
+	DefaultHttpUrl               = "http://localhost:8123"
+	DefaultListenAddress         = "0.0.0.0:8123"
+	DefaultStaticFilePath        = "./dist"
+	DefaultDbFilePath            = "./db"
+	DefaultOnchainConfirmations  = 3
+	DefaultNodeDialTimeout       = time.Second * 10
+	DefaultNodeIdleTimeout       = time.Minute * 10
+	DefaultHealthCheckInterval   = time.Minute * 5
+	DefaultOutageNotifyAfter     = time.Hour * 6
+	DefaultRateProvider          = ""
+	DefaultCurrency              = "USD"
+	DefaultRateCacheDuration     = time.Minute
+	DefaultRepoFileCacheDuration = time.Hour
+	DefaultBountyLabels          = []string{"bounty"}
+	DefaultFeedThresholds        = []int64{10000, 100000, 1000000, 10000000}
+	DefaultNotifyTimeout         = time.Second * 10
+	DefaultSmtpFrom              = "bounty@localhost"
+	DefaultEmailDigestHour       = 8
+	DefaultClaimExpiry           = time.Hour * 24 * 30
+	DefaultLedgerAnchorInterval  = time.Hour
+	DefaultReconcileInterval     = time.Hour * 6
 )
 
 type Config struct {
-	GithubAccessToken string `long:"token" description:"github access token with full repo permissions" required:"true"`
-	Secret            string `long:"secret" description:"webhook secret"`
-	HttpUrl           string `long:"http-url" description:"http url for invoice delivery"`
-	ListenAddress     string `long:"listen-address" description:"listen address"`
-	DbFilePath        string `long:"db-filepath" description:"path to db file"`
-	StaticFilePath	string `long:"static-filepath" description:"path to web files"`
-	LndConnect string `long:"lndconnect" description:"lndconnect string with admin permissions" required:"true"`
+	GithubAccessToken     string        `long:"token" description:"github access token with full repo permissions" required:"true"`
+	Secret                string        `long:"secret" description:"webhook secret"`
+	HttpUrl               string        `long:"http-url" description:"http url for invoice delivery"`
+	ListenAddress         string        `long:"listen-address" description:"listen address"`
+	DbFilePath            string        `long:"db-filepath" description:"path to db file"`
+	StaticFilePath        string        `long:"static-filepath" description:"path to web files"`
+	LndConnect            string        `long:"lndconnect" description:"optional connection uri of the default benefactor node, used for repositories that don't pass their own node"`
+	NodeHosts             []string      `long:"node-host" description:"host that connection uris passed in the node parameter of webhook urls may connect to, can be given multiple times, the node parameter is rejected if none are given"`
+	AdminToken            string        `long:"admin-token" description:"bearer token for admin endpoints, admin endpoints are disabled if empty"`
+	OnchainConfirmations  int           `long:"onchain-confs" description:"confirmations required before an on-chain donation is credited"`
+	AllowOnchainPayouts   bool          `long:"allow-onchain-payouts" description:"allow paying out bounties on-chain through the benefactor node, requires an lndconnect string with onchain permissions"`
+	NodeDialTimeout       time.Duration `long:"node-dial-timeout" description:"timeout for connecting to benefactor nodes"`
+	NodeIdleTimeout       time.Duration `long:"node-idle-timeout" description:"duration after which unused connections to benefactor nodes are closed"`
+	HealthCheckInterval   time.Duration `long:"health-check-interval" description:"interval in which benefactor nodes are probed, 0 disables probing"`
+	OutageNotifyAfter     time.Duration `long:"outage-notify-after" description:"duration of a node outage after which the maintainer is notified"`
+	RateProvider          string        `long:"rate-provider" description:"exchange rate provider, one of coingecko, kraken, static or file, fiat amounts are disabled if empty"`
+	RateSource            string        `long:"rate-source" description:"rates of the static provider like USD:50000,EUR:42000, or the json file of the file provider"`
+	Currency              string        `long:"currency" description:"default fiat currency of repositories"`
+	RateCacheDuration     time.Duration `long:"rate-cache-duration" description:"duration for which exchange rates are cached"`
+	RestoreEditedComments bool          `long:"restore-edited-comments" description:"restore the text of bounty comments that were edited by other users"`
+	RepoFileCacheDuration time.Duration `long:"repo-file-cache-duration" description:"duration after which the configuration files of repositories are read again, push events refresh them immediately"`
+	BountyLabels          []string      `long:"bounty-label" description:"label that marks issues as bounties, can be given multiple times and is overridden by the repository file"`
+	CreateLabels          bool          `long:"create-labels" description:"create the bounty labels when a repository is registered"`
+	FeedThresholds        []int64       `long:"feed-threshold" description:"bounty amount in sats that is announced in the feeds when a bounty reaches it, can be given multiple times"`
+	NotifyTimeout         time.Duration `long:"notify-timeout" description:"timeout for delivering notifications to slack, discord, matrix and webhooks"`
+	SmtpAddress           string        `long:"smtp-address" description:"host:port of the smtp server for email notifications, emails are disabled if empty"`
+	SmtpUser              string        `long:"smtp-user" description:"smtp user, authentication is skipped if empty"`
+	SmtpPassword          string        `long:"smtp-password" description:"smtp password"`
+	SmtpFrom              string        `long:"smtp-from" description:"sender address of email notifications"`
+	EmailDigestHour       int           `long:"email-digest-hour" description:"hour of the day in UTC at which the daily donation digests are sent"`
+	ClaimExpiry           time.Duration `long:"claim-expiry" description:"duration after which claims that weren't awarded expire, 0 disables expiry"`
+	NostrKey              string        `long:"nostr-key" description:"hex encoded private key the bounties are published with on nostr, nostr is disabled if empty"`
+	NostrRelays           []string      `long:"nostr-relay" description:"relay the bounties and zap receipts are published to, can be given multiple times"`
+	LedgerAnchorInterval  time.Duration `long:"ledger-anchor-interval" description:"interval in which the head of the ledger is published on nostr if it changed, 0 disables anchoring"`
+	ReconcileInterval     time.Duration `long:"reconcile-interval" description:"interval in which the stored payments are reconciled with the invoices of the benefactor nodes, 0 disables reconciliation"`
+	ReconcileFix          bool          `long:"reconcile-fix" description:"fix the discrepancies found by periodic reconciliations instead of only reporting them"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Secret:        DefaultSecret,
-		HttpUrl:       DefaultHttpUrl,
-		ListenAddress: DefaultListenAddress,
-		DbFilePath:    DefaultDbFilePath,
-		StaticFilePath: DefaultStaticFilePath,
+		Secret:                DefaultSecret,
+		HttpUrl:               DefaultHttpUrl,
+		ListenAddress:         DefaultListenAddress,
+		DbFilePath:            DefaultDbFilePath,
+		StaticFilePath:        DefaultStaticFilePath,
+		OnchainConfirmations:  DefaultOnchainConfirmations,
+		NodeDialTimeout:       DefaultNodeDialTimeout,
+		NodeIdleTimeout:       DefaultNodeIdleTimeout,
+		HealthCheckInterval:   DefaultHealthCheckInterval,
+		OutageNotifyAfter:     DefaultOutageNotifyAfter,
+		RateProvider:          DefaultRateProvider,
+		Currency:              DefaultCurrency,
+		RateCacheDuration:     DefaultRateCacheDuration,
+		RepoFileCacheDuration: DefaultRepoFileCacheDuration,
+		BountyLabels:          DefaultBountyLabels,
+		FeedThresholds:        DefaultFeedThresholds,
+		NotifyTimeout:         DefaultNotifyTimeout,
+		SmtpFrom:              DefaultSmtpFrom,
+		EmailDigestHour:       DefaultEmailDigestHour,
+		ClaimExpiry:           DefaultClaimExpiry,
+		LedgerAnchorInterval:  DefaultLedgerAnchorInterval,
+		ReconcileInterval:     DefaultReconcileInterval,
 	}
 }
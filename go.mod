@@ -3,13 +3,20 @@ module github.com/sputn1ck/github-bounty
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/btcsuite/btcd v0.21.0-beta.0.20201208033208-6bd4c64a54fa
+	github.com/btcsuite/btcutil v1.0.2
 	github.com/coreos/bbolt v1.3.3
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1
 	github.com/google/go-github/v33 v33.0.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lightningnetwork/lnd v0.12.0-beta
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
 	google.golang.org/grpc v1.24.0
 	gopkg.in/go-playground/webhooks.v5 v5.17.0
 	gopkg.in/macaroon.v2 v2.0.0
+	gopkg.in/yaml.v2 v2.2.3
 )
@@ -0,0 +1,317 @@
+package lightning
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clnNode talks to core lightning through the clnrest plugin.
+type clnNode struct {
+	baseUrl string
+	rune    string
+	client  *http.Client
+}
+
+func newClnNode(uri *url.URL) (*clnNode, error) {
+	rune := uri.Query().Get("rune")
+	if rune == "" {
+		return nil, fmt.Errorf("clnrest uri requires a rune")
+	}
+	client, err := httpClientWithCert(uri.Query().Get("cert"))
+	if err != nil {
+		return nil, err
+	}
+	return &clnNode{baseUrl: "https://" + uri.Host, rune: rune, client: client}, nil
+}
+
+type clnInvoice struct {
+	Label              string `json:"label"`
+	Bolt11             string `json:"bolt11"`
+	PaymentHash        string `json:"payment_hash"`
+	Status             string `json:"status"`
+	AmountMsat         msat   `json:"amount_msat"`
+	AmountReceivedMsat msat   `json:"amount_received_msat"`
+	PaymentPreimage    string `json:"payment_preimage"`
+	PaidAt             int64  `json:"paid_at"`
+}
+
+func (n *clnNode) CreateInvoice(ctx context.Context, req *InvoiceRequest) (*Invoice, error) {
+	label, err := randomLabel()
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{
+		"amount_msat": req.Value * 1000,
+		"label":       label,
+		"description": req.Memo,
+	}
+	if req.Value == 0 {
+		params["amount_msat"] = "any"
+	}
+	if req.Expiry != 0 {
+		params["expiry"] = req.Expiry
+	}
+	if req.RouteHints {
+		params["exposeprivatechannels"] = true
+	}
+	if req.DescriptionHash != nil {
+		// core lightning hashes the description itself
+		hash := sha256.Sum256([]byte(req.Description))
+		if !bytes.Equal(hash[:], req.DescriptionHash) {
+			return nil, DescriptionHashError
+		}
+		params["description"] = req.Description
+		params["deschashonly"] = true
+	}
+	res := &clnInvoice{}
+	err = n.call(ctx, "invoice", params, res)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := hex.DecodeString(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &Invoice{
+		PaymentRequest: res.Bolt11,
+		PaymentHash:    paymentHash,
+		Value:          req.Value,
+		State:          InvoiceOpen,
+	}, nil
+}
+
+func (n *clnNode) LookupInvoice(ctx context.Context, paymentHash []byte) (*Invoice, error) {
+	inv, err := n.lookupInvoice(ctx, paymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return inv.toInvoice()
+}
+
+func (n *clnNode) ListInvoices(ctx context.Context) ([]*Invoice, error) {
+	res := &struct {
+		Invoices []*clnInvoice `json:"invoices"`
+	}{}
+	err := n.call(ctx, "listinvoices", map[string]interface{}{}, res)
+	if err != nil {
+		return nil, err
+	}
+	invoices := make([]*Invoice, 0, len(res.Invoices))
+	for _, inv := range res.Invoices {
+		invoice, err := inv.toInvoice()
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, nil
+}
+
+func (n *clnNode) SubscribeInvoice(ctx context.Context, paymentHash []byte) (InvoiceSubscription, error) {
+	return newPollingSubscription(ctx, n, paymentHash), nil
+}
+
+func (n *clnNode) PayInvoice(ctx context.Context, payreq string) (*Payment, error) {
+	res := &struct {
+		PaymentHash     string `json:"payment_hash"`
+		PaymentPreimage string `json:"payment_preimage"`
+		Status          string `json:"status"`
+		AmountMsat      msat   `json:"amount_msat"`
+		AmountSentMsat  msat   `json:"amount_sent_msat"`
+	}{}
+	err := n.call(ctx, "pay", map[string]interface{}{"bolt11": payreq}, res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != "complete" {
+		return nil, fmt.Errorf("payment %v", res.Status)
+	}
+	paymentHash, err := hex.DecodeString(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	preimage, err := hex.DecodeString(res.PaymentPreimage)
+	if err != nil {
+		return nil, err
+	}
+	return &Payment{
+		PaymentHash: paymentHash,
+		Preimage:    preimage,
+		Fee:         int64(res.AmountSentMsat-res.AmountMsat) / 1000,
+	}, nil
+}
+
+func (n *clnNode) DecodeInvoice(ctx context.Context, payreq string) (*PayReq, error) {
+	res := &struct {
+		Payee       string `json:"payee"`
+		PaymentHash string `json:"payment_hash"`
+		AmountMsat  msat   `json:"amount_msat"`
+		Description string `json:"description"`
+		CreatedAt   int64  `json:"created_at"`
+		Expiry      int64  `json:"expiry"`
+	}{}
+	err := n.call(ctx, "decode", map[string]interface{}{"string": payreq}, res)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := hex.DecodeString(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &PayReq{
+		Destination: res.Payee,
+		PaymentHash: paymentHash,
+		Value:       int64(res.AmountMsat) / 1000,
+		Memo:        res.Description,
+		Timestamp:   res.CreatedAt,
+		Expiry:      res.Expiry,
+	}, nil
+}
+
+func (n *clnNode) GetInfo(ctx context.Context) (*NodeInfo, error) {
+	res := &struct {
+		Id    string `json:"id"`
+		Alias string `json:"alias"`
+	}{}
+	err := n.call(ctx, "getinfo", map[string]interface{}{}, res)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeInfo{Pubkey: res.Id, Alias: res.Alias}, nil
+}
+
+func (n *clnNode) Close() error {
+	return nil
+}
+
+func (n *clnNode) lookupInvoice(ctx context.Context, paymentHash []byte) (*clnInvoice, error) {
+	res := &struct {
+		Invoices []*clnInvoice `json:"invoices"`
+	}{}
+	err := n.call(ctx, "listinvoices", map[string]interface{}{"payment_hash": hex.EncodeToString(paymentHash)}, res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Invoices) != 1 {
+		return nil, fmt.Errorf("invoice %x not found", paymentHash)
+	}
+	return res.Invoices[0], nil
+}
+
+func (n *clnNode) call(ctx context.Context, method string, params interface{}, res interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.baseUrl+"/v1/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Rune", n.rune)
+	req.Header.Set("Content-Type", "application/json")
+	return doJson(n.client, req, res)
+}
+
+func (inv *clnInvoice) toInvoice() (*Invoice, error) {
+	paymentHash, err := hex.DecodeString(inv.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &Invoice{
+		PaymentRequest: inv.Bolt11,
+		PaymentHash:    paymentHash,
+		Value:          int64(inv.AmountMsat) / 1000,
+		SettleDate:     inv.PaidAt,
+	}
+	switch inv.Status {
+	case "paid":
+		invoice.State = InvoiceSettled
+		invoice.Value = int64(inv.AmountReceivedMsat) / 1000
+		invoice.Preimage, err = hex.DecodeString(inv.PaymentPreimage)
+		if err != nil {
+			return nil, err
+		}
+	case "expired":
+		invoice.State = InvoiceCanceled
+	default:
+		invoice.State = InvoiceOpen
+	}
+	return invoice, nil
+}
+
+// msat unmarshals millisatoshi amounts that are either numbers or strings
+// with a "msat" suffix, depending on the core lightning version.
+type msat int64
+
+func (m *msat) UnmarshalJSON(data []byte) error {
+	str := strings.TrimSuffix(strings.Trim(string(data), "\""), "msat")
+	if str == "" || str == "null" {
+		*m = 0
+		return nil
+	}
+	val, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return err
+	}
+	*m = msat(val)
+	return nil
+}
+
+func randomLabel() (string, error) {
+	labelBytes := make([]byte, 16)
+	if _, err := rand.Read(labelBytes); err != nil {
+		return "", err
+	}
+	return "bounty-" + hex.EncodeToString(labelBytes), nil
+}
+
+// httpClientWithCert returns a http client that trusts the base64url encoded
+// DER certificate, or the system roots if no certificate is given.
+func httpClientWithCert(cert string) (*http.Client, error) {
+	client := &http.Client{Timeout: time.Second * 30}
+	if cert == "" {
+		return client, nil
+	}
+	certBytes, err := base64.RawURLEncoding.DecodeString(cert)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode base64 cert: %v", err)
+	}
+	parsedCert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse cert: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsedCert)
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return client, nil
+}
+
+func doJson(client *http.Client, req *http.Request, res interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%v returned %v: %s", req.URL.Path, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, res)
+}
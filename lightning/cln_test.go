@@ -0,0 +1,56 @@
+package lightning
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClnDescriptionHash(t *testing.T) {
+	var params map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = nil
+		if r.URL.Path != "/v1/invoice" || r.Header.Get("Rune") != "rune" {
+			t.Errorf("unexpected request %v", r.URL.Path)
+		}
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(&clnInvoice{Bolt11: "lnbcrt1", PaymentHash: "00", Status: "unpaid"})
+	}))
+	defer server.Close()
+	node := &clnNode{baseUrl: server.URL, rune: "rune", client: server.Client()}
+	ctx := context.Background()
+
+	_, err := node.CreateInvoice(ctx, &InvoiceRequest{Memo: "memo", Value: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["description"] != "memo" || params["deschashonly"] != nil {
+		t.Fatalf("unexpected params %v", params)
+	}
+
+	description := `{"kind":9734,"content":"zap"}`
+	hash := sha256.Sum256([]byte(description))
+	_, err = node.CreateInvoice(ctx, &InvoiceRequest{Memo: "memo", Value: 1000, DescriptionHash: hash[:], Description: description})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["description"] != description || params["deschashonly"] != true {
+		t.Fatalf("expected the exact description to be hashed, got %v", params)
+	}
+
+	// the memo isn't what the hash commits to
+	params = nil
+	_, err = node.CreateInvoice(ctx, &InvoiceRequest{Memo: description, Value: 1000, DescriptionHash: hash[:], Description: "memo"})
+	if err != DescriptionHashError {
+		t.Fatalf("expected description hash error, got %v", err)
+	}
+	if params != nil {
+		t.Fatal("invoice with mismatching description was requested")
+	}
+}
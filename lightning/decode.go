@@ -0,0 +1,48 @@
+package lightning
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"strings"
+)
+
+// DecodePayReq decodes an invoice without a node, the network is taken from
+// the invoice prefix.
+func DecodePayReq(payreq string) (*PayReq, error) {
+	payreq = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(payreq, "lightning:"), "LIGHTNING:"))
+	var net *chaincfg.Params
+	switch {
+	case strings.HasPrefix(payreq, "lnbcrt"):
+		net = &chaincfg.RegressionNetParams
+	case strings.HasPrefix(payreq, "lnbc"):
+		net = &chaincfg.MainNetParams
+	case strings.HasPrefix(payreq, "lntb"):
+		net = &chaincfg.TestNet3Params
+	case strings.HasPrefix(payreq, "lnsb"):
+		net = &chaincfg.SimNetParams
+	default:
+		return nil, fmt.Errorf("unknown invoice network")
+	}
+	inv, err := zpay32.Decode(payreq, net)
+	if err != nil {
+		return nil, err
+	}
+	if inv.PaymentHash == nil {
+		return nil, fmt.Errorf("invoice has no payment hash")
+	}
+	decoded := &PayReq{
+		Destination: hex.EncodeToString(inv.Destination.SerializeCompressed()),
+		PaymentHash: inv.PaymentHash[:],
+		Timestamp:   inv.Timestamp.Unix(),
+		Expiry:      int64(inv.Expiry().Seconds()),
+	}
+	if inv.MilliSat != nil {
+		decoded.Value = int64(inv.MilliSat.ToSatoshis())
+	}
+	if inv.Description != nil {
+		decoded.Memo = *inv.Description
+	}
+	return decoded, nil
+}
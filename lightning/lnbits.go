@@ -0,0 +1,263 @@
+package lightning
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// lnbitsNode uses the wallet api of an lnbits instance. Invoice keys are
+// sufficient for receiving donations, paying out requires an admin key.
+type lnbitsNode struct {
+	baseUrl string
+	key     string
+	client  *http.Client
+}
+
+func newLnbitsNode(uri *url.URL) (*lnbitsNode, error) {
+	key := uri.Query().Get("key")
+	if key == "" {
+		return nil, fmt.Errorf("lnbits uri requires a key")
+	}
+	return &lnbitsNode{
+		baseUrl: "https://" + uri.Host + uri.Path,
+		key:     key,
+		client:  &http.Client{Timeout: time.Second * 30},
+	}, nil
+}
+
+func (n *lnbitsNode) CreateInvoice(ctx context.Context, req *InvoiceRequest) (*Invoice, error) {
+	params := map[string]interface{}{
+		"out":    false,
+		"amount": req.Value,
+		"memo":   req.Memo,
+	}
+	if req.Expiry != 0 {
+		params["expiry"] = req.Expiry
+	}
+	if req.DescriptionHash != nil {
+		params["description_hash"] = hex.EncodeToString(req.DescriptionHash)
+	}
+	res := &struct {
+		PaymentHash    string `json:"payment_hash"`
+		PaymentRequest string `json:"payment_request"`
+		Bolt11         string `json:"bolt11"`
+	}{}
+	err := n.call(ctx, http.MethodPost, "/api/v1/payments", params, res)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := hex.DecodeString(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	payreq := res.PaymentRequest
+	if payreq == "" {
+		payreq = res.Bolt11
+	}
+	return &Invoice{
+		PaymentRequest: payreq,
+		PaymentHash:    paymentHash,
+		Value:          req.Value,
+		State:          InvoiceOpen,
+	}, nil
+}
+
+func (n *lnbitsNode) LookupInvoice(ctx context.Context, paymentHash []byte) (*Invoice, error) {
+	res := &struct {
+		Paid     bool   `json:"paid"`
+		Preimage string `json:"preimage"`
+		Details  struct {
+			Bolt11  string          `json:"bolt11"`
+			Amount  int64           `json:"amount"`
+			Status  string          `json:"status"`
+			Time    json.RawMessage `json:"time"`
+			Expiry  json.RawMessage `json:"expiry"`
+			Pending bool            `json:"pending"`
+		} `json:"details"`
+	}{}
+	err := n.call(ctx, http.MethodGet, "/api/v1/payments/"+hex.EncodeToString(paymentHash), nil, res)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &Invoice{
+		PaymentRequest: res.Details.Bolt11,
+		PaymentHash:    paymentHash,
+		Value:          res.Details.Amount / 1000,
+		State:          InvoiceOpen,
+	}
+	switch {
+	case res.Paid:
+		invoice.State = InvoiceSettled
+		invoice.SettleDate = parseTimestamp(res.Details.Time)
+		invoice.Preimage, err = hex.DecodeString(res.Preimage)
+		if err != nil {
+			return nil, err
+		}
+	case res.Details.Status == "failed":
+		invoice.State = InvoiceCanceled
+	default:
+		expiry := parseTimestamp(res.Details.Expiry)
+		if expiry != 0 && expiry < time.Now().Unix() {
+			invoice.State = InvoiceCanceled
+		}
+	}
+	return invoice, nil
+}
+
+func (n *lnbitsNode) ListInvoices(ctx context.Context) ([]*Invoice, error) {
+	var res []*struct {
+		PaymentHash string `json:"payment_hash"`
+		Bolt11      string `json:"bolt11"`
+		Amount      int64  `json:"amount"`
+		// older versions only return pending, newer ones only status
+		Pending  *bool           `json:"pending"`
+		Status   string          `json:"status"`
+		Preimage string          `json:"preimage"`
+		Time     json.RawMessage `json:"time"`
+	}
+	err := n.call(ctx, http.MethodGet, "/api/v1/payments", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	var invoices []*Invoice
+	for _, payment := range res {
+		if payment.Amount <= 0 {
+			// outgoing payment
+			continue
+		}
+		paymentHash, err := hex.DecodeString(payment.PaymentHash)
+		if err != nil {
+			return nil, err
+		}
+		invoice := &Invoice{
+			PaymentRequest: payment.Bolt11,
+			PaymentHash:    paymentHash,
+			Value:          payment.Amount / 1000,
+			State:          InvoiceOpen,
+		}
+		switch {
+		case payment.Status == "failed":
+			invoice.State = InvoiceCanceled
+		case payment.Status == "success", payment.Status == "" && payment.Pending != nil && !*payment.Pending:
+			invoice.State = InvoiceSettled
+			invoice.SettleDate = parseTimestamp(payment.Time)
+			invoice.Preimage, _ = hex.DecodeString(payment.Preimage)
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, nil
+}
+
+func (n *lnbitsNode) SubscribeInvoice(ctx context.Context, paymentHash []byte) (InvoiceSubscription, error) {
+	return newPollingSubscription(ctx, n, paymentHash), nil
+}
+
+func (n *lnbitsNode) PayInvoice(ctx context.Context, payreq string) (*Payment, error) {
+	res := &struct {
+		PaymentHash string `json:"payment_hash"`
+	}{}
+	err := n.call(ctx, http.MethodPost, "/api/v1/payments", map[string]interface{}{"out": true, "bolt11": payreq}, res)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := hex.DecodeString(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := n.LookupInvoice(ctx, paymentHash)
+	if err != nil {
+		return nil, err
+	}
+	if inv.State != InvoiceSettled {
+		return nil, fmt.Errorf("payment %x is still pending", paymentHash)
+	}
+	return &Payment{PaymentHash: paymentHash, Preimage: inv.Preimage}, nil
+}
+
+func (n *lnbitsNode) DecodeInvoice(ctx context.Context, payreq string) (*PayReq, error) {
+	res := &struct {
+		PaymentHash string `json:"payment_hash"`
+		AmountMsat  int64  `json:"amount_msat"`
+		Description string `json:"description"`
+		Payee       string `json:"payee"`
+		Date        int64  `json:"date"`
+		Expiry      int64  `json:"expiry"`
+	}{}
+	err := n.call(ctx, http.MethodPost, "/api/v1/payments/decode", map[string]interface{}{"data": payreq}, res)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := hex.DecodeString(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &PayReq{
+		Destination: res.Payee,
+		PaymentHash: paymentHash,
+		Value:       res.AmountMsat / 1000,
+		Memo:        res.Description,
+		Timestamp:   res.Date,
+		Expiry:      res.Expiry,
+	}, nil
+}
+
+// GetInfo returns the wallet name as alias, lnbits doesn't expose the pubkey
+// of its funding source.
+func (n *lnbitsNode) GetInfo(ctx context.Context) (*NodeInfo, error) {
+	res := &struct {
+		Name string `json:"name"`
+	}{}
+	err := n.call(ctx, http.MethodGet, "/api/v1/wallet", nil, res)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeInfo{Alias: res.Name}, nil
+}
+
+func (n *lnbitsNode) Close() error {
+	return nil
+}
+
+func (n *lnbitsNode) call(ctx context.Context, method string, path string, params interface{}, res interface{}) error {
+	var body []byte
+	if params != nil {
+		var err error
+		body, err = json.Marshal(params)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, n.baseUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", n.key)
+	req.Header.Set("Content-Type", "application/json")
+	return doJson(n.client, req, res)
+}
+
+// parseTimestamp parses timestamps that are either unix seconds or RFC3339
+// strings, depending on the lnbits version.
+func parseTimestamp(raw json.RawMessage) int64 {
+	var unix float64
+	if err := json.Unmarshal(raw, &unix); err == nil {
+		return int64(unix)
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return 0
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999", "2006-01-02 15:04:05.999999"} {
+		t, err := time.Parse(layout, str)
+		if err == nil {
+			return t.Unix()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package lightning
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLnbitsInvoiceStates(t *testing.T) {
+	preimage := strings.Repeat("01", 32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/payments":
+			w.Write([]byte(`[
+				{"payment_hash": "01", "bolt11": "old-pending", "amount": 1000000, "pending": true},
+				{"payment_hash": "02", "bolt11": "old-paid", "amount": 2000000, "pending": false, "preimage": "` + preimage + `", "time": 1600000000},
+				{"payment_hash": "03", "bolt11": "new-pending", "amount": 3000000, "status": "pending"},
+				{"payment_hash": "04", "bolt11": "new-paid", "amount": 4000000, "status": "success", "preimage": "` + preimage + `", "time": "2020-09-13T12:26:40Z"},
+				{"payment_hash": "05", "bolt11": "new-failed", "amount": 5000000, "status": "failed"},
+				{"payment_hash": "06", "bolt11": "unknown", "amount": 6000000},
+				{"payment_hash": "07", "bolt11": "outgoing", "amount": -7000000, "status": "success"}
+			]`))
+		case "/api/v1/payments/01":
+			w.Write([]byte(`{"paid": false, "details": {"bolt11": "pending", "amount": 1000000, "status": "pending"}}`))
+		case "/api/v1/payments/02":
+			w.Write([]byte(`{"paid": true, "preimage": "` + preimage + `", "details": {"bolt11": "paid", "amount": 2000000, "status": "success", "time": 1600000000}}`))
+		case "/api/v1/payments/05":
+			w.Write([]byte(`{"paid": false, "details": {"bolt11": "failed", "amount": 5000000, "status": "failed"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	node := &lnbitsNode{baseUrl: server.URL, key: "key", client: server.Client()}
+	ctx := context.Background()
+
+	invoices, err := node.ListInvoices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]InvoiceState{
+		"old-pending": InvoiceOpen,
+		"old-paid":    InvoiceSettled,
+		"new-pending": InvoiceOpen,
+		"new-paid":    InvoiceSettled,
+		"new-failed":  InvoiceCanceled,
+		"unknown":     InvoiceOpen,
+	}
+	if len(invoices) != len(expected) {
+		t.Fatalf("expected %v incoming invoices, got %v", len(expected), len(invoices))
+	}
+	for _, invoice := range invoices {
+		state, ok := expected[invoice.PaymentRequest]
+		if !ok || invoice.State != state {
+			t.Fatalf("expected invoice %v to be in state %v, got %v", invoice.PaymentRequest, state, invoice.State)
+		}
+		if state == InvoiceSettled && (hex.EncodeToString(invoice.Preimage) != preimage || invoice.SettleDate != 1600000000) {
+			t.Fatalf("settled invoice %v misses preimage or settle date: %+v", invoice.PaymentRequest, invoice)
+		}
+	}
+
+	tests := []struct {
+		hash  string
+		state InvoiceState
+		value int64
+	}{
+		{"01", InvoiceOpen, 1000},
+		{"02", InvoiceSettled, 2000},
+		{"05", InvoiceCanceled, 5000},
+	}
+	for _, test := range tests {
+		hash, _ := hex.DecodeString(test.hash)
+		invoice, err := node.LookupInvoice(ctx, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invoice.State != test.state || invoice.Value != test.value {
+			t.Fatalf("expected invoice %v with %v sats in state %v, got %+v", test.hash, test.value, test.state, invoice)
+		}
+	}
+	if _, err := node.LookupInvoice(ctx, []byte{9}); err == nil {
+		t.Fatal("expected an error for an unknown invoice")
+	}
+}
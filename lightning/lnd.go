@@ -0,0 +1,242 @@
+package lightning
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/sputn1ck/github-bounty/lnd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+type lndNode struct {
+	cc       *grpc.ClientConn
+	client   lnrpc.LightningClient
+	invoices invoicesrpc.InvoicesClient
+}
+
+func connectLnd(ctx context.Context, lndConnect string) (*lndNode, error) {
+	cc, err := lnd.ConnectFromLndConnect(ctx, lndConnect)
+	if err != nil {
+		return nil, err
+	}
+	return &lndNode{
+		cc:       cc,
+		client:   lnrpc.NewLightningClient(cc),
+		invoices: invoicesrpc.NewInvoicesClient(cc),
+	}, nil
+}
+
+func (n *lndNode) CreateInvoice(ctx context.Context, req *InvoiceRequest) (*Invoice, error) {
+	inv, err := n.client.AddInvoice(ctx, &lnrpc.Invoice{
+		Memo:            req.Memo,
+		Value:           req.Value,
+		Expiry:          req.Expiry,
+		Private:         req.RouteHints,
+		DescriptionHash: req.DescriptionHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Invoice{
+		PaymentRequest: inv.PaymentRequest,
+		PaymentHash:    inv.RHash,
+		Value:          req.Value,
+		State:          InvoiceOpen,
+	}, nil
+}
+
+func (n *lndNode) LookupInvoice(ctx context.Context, paymentHash []byte) (*Invoice, error) {
+	inv, err := n.client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash})
+	if err != nil {
+		return nil, err
+	}
+	return lndInvoice(inv), nil
+}
+
+func (n *lndNode) ListInvoices(ctx context.Context) ([]*Invoice, error) {
+	var invoices []*Invoice
+	var offset uint64
+	for {
+		res, err := n.client.ListInvoices(ctx, &lnrpc.ListInvoiceRequest{
+			IndexOffset:    offset,
+			NumMaxInvoices: listInvoicesPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, inv := range res.Invoices {
+			invoices = append(invoices, lndInvoice(inv))
+		}
+		if len(res.Invoices) < listInvoicesPageSize {
+			return invoices, nil
+		}
+		offset = res.LastIndexOffset
+	}
+}
+
+func (n *lndNode) SubscribeInvoice(ctx context.Context, paymentHash []byte) (InvoiceSubscription, error) {
+	sub, err := n.invoices.SubscribeSingleInvoice(ctx, &invoicesrpc.SubscribeSingleInvoiceRequest{
+		RHash: paymentHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lndInvoiceSubscription{sub: sub}, nil
+}
+
+func (n *lndNode) PayInvoice(ctx context.Context, payreq string) (*Payment, error) {
+	res, err := n.client.SendPaymentSync(ctx, &lnrpc.SendRequest{PaymentRequest: payreq})
+	if err != nil {
+		return nil, err
+	}
+	if res.PaymentError != "" {
+		return nil, fmt.Errorf("payment failed: %v", res.PaymentError)
+	}
+	var fee int64
+	if res.PaymentRoute != nil {
+		fee = res.PaymentRoute.TotalFees
+	}
+	return &Payment{PaymentHash: res.PaymentHash, Preimage: res.PaymentPreimage, Fee: fee}, nil
+}
+
+func (n *lndNode) DecodeInvoice(ctx context.Context, payreq string) (*PayReq, error) {
+	res, err := n.client.DecodePayReq(ctx, &lnrpc.PayReqString{PayReq: payreq})
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := hex.DecodeString(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &PayReq{
+		Destination: res.Destination,
+		PaymentHash: paymentHash,
+		Value:       res.NumSatoshis,
+		Memo:        res.Description,
+		Timestamp:   res.Timestamp,
+		Expiry:      res.Expiry,
+	}, nil
+}
+
+func (n *lndNode) GetInfo(ctx context.Context) (*NodeInfo, error) {
+	res, err := n.client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return &NodeInfo{Pubkey: res.IdentityPubkey, Alias: res.Alias}, nil
+}
+
+func (n *lndNode) NewAddress(ctx context.Context) (string, error) {
+	res, err := n.client.NewAddress(ctx, &lnrpc.NewAddressRequest{Type: lnrpc.AddressType_WITNESS_PUBKEY_HASH})
+	if err != nil {
+		return "", err
+	}
+	return res.Address, nil
+}
+
+func (n *lndNode) GetTransactions(ctx context.Context) ([]*Transaction, error) {
+	res, err := n.client.GetTransactions(ctx, &lnrpc.GetTransactionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var txs []*Transaction
+	for _, tx := range res.Transactions {
+		transaction, err := lndTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, transaction)
+	}
+	return txs, nil
+}
+
+func (n *lndNode) SubscribeTransactions(ctx context.Context) (TransactionSubscription, error) {
+	sub, err := n.client.SubscribeTransactions(ctx, &lnrpc.GetTransactionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return &lndTransactionSubscription{sub: sub}, nil
+}
+
+func (n *lndNode) SendCoins(ctx context.Context, address string, sats int64, targetConf int32, label string) (string, error) {
+	res, err := n.client.SendCoins(ctx, &lnrpc.SendCoinsRequest{
+		Addr:       address,
+		Amount:     sats,
+		TargetConf: targetConf,
+		Label:      label,
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Txid, nil
+}
+
+func (n *lndNode) Close() error {
+	return n.cc.Close()
+}
+
+func (n *lndNode) healthy() bool {
+	state := n.cc.GetState()
+	return state != connectivity.TransientFailure && state != connectivity.Shutdown
+}
+
+type lndInvoiceSubscription struct {
+	sub invoicesrpc.Invoices_SubscribeSingleInvoiceClient
+}
+
+func (s *lndInvoiceSubscription) Recv() (*Invoice, error) {
+	inv, err := s.sub.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return lndInvoice(inv), nil
+}
+
+type lndTransactionSubscription struct {
+	sub lnrpc.Lightning_SubscribeTransactionsClient
+}
+
+func (s *lndTransactionSubscription) Recv() (*Transaction, error) {
+	tx, err := s.sub.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return lndTransaction(tx)
+}
+
+func lndInvoice(inv *lnrpc.Invoice) *Invoice {
+	invoice := &Invoice{
+		PaymentRequest: inv.PaymentRequest,
+		PaymentHash:    inv.RHash,
+		Preimage:       inv.RPreimage,
+		Value:          inv.Value,
+		SettleDate:     inv.SettleDate,
+	}
+	switch inv.State {
+	case lnrpc.Invoice_OPEN:
+		invoice.State = InvoiceOpen
+	case lnrpc.Invoice_ACCEPTED:
+		invoice.State = InvoiceAccepted
+	case lnrpc.Invoice_SETTLED:
+		invoice.State = InvoiceSettled
+		invoice.Value = inv.AmtPaidSat
+	case lnrpc.Invoice_CANCELED:
+		invoice.State = InvoiceCanceled
+	}
+	return invoice
+}
+
+func lndTransaction(tx *lnrpc.Transaction) (*Transaction, error) {
+	rawTx, err := hex.DecodeString(tx.RawTxHex)
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		TxHash:           tx.TxHash,
+		NumConfirmations: tx.NumConfirmations,
+		RawTx:            rawTx,
+	}, nil
+}
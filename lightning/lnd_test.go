@@ -0,0 +1,17 @@
+package lightning
+
+import (
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"testing"
+)
+
+func TestLndInvoice(t *testing.T) {
+	inv := &lnrpc.Invoice{Value: 1000, AmtPaidSat: 1500, State: lnrpc.Invoice_OPEN}
+	if invoice := lndInvoice(inv); invoice.State != InvoiceOpen || invoice.Value != 1000 {
+		t.Fatalf("expected the requested amount of open invoices, got %+v", invoice)
+	}
+	inv.State = lnrpc.Invoice_SETTLED
+	if invoice := lndInvoice(inv); invoice.State != InvoiceSettled || invoice.Value != 1500 {
+		t.Fatalf("expected the paid amount of settled invoices, got %+v", invoice)
+	}
+}
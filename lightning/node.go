@@ -0,0 +1,200 @@
+package lightning
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	UnsupportedBackendError = fmt.Errorf("unsupported lightning backend")
+	NotSupportedError       = fmt.Errorf("not supported by lightning backend")
+	DescriptionHashError    = fmt.Errorf("description hash doesn't match the description")
+)
+
+// invoices requested at once by backends that list invoices in pages
+const listInvoicesPageSize = 1000
+
+type InvoiceState int
+
+const (
+	InvoiceOpen InvoiceState = iota
+	InvoiceAccepted
+	InvoiceSettled
+	InvoiceCanceled
+)
+
+type InvoiceRequest struct {
+	Memo   string
+	Value  int64
+	Expiry int64
+	// include route hints for private channels, if the backend supports it
+	RouteHints bool
+	// sha256 of the description, committed to instead of the memo itself
+	DescriptionHash []byte
+	// exact text the description hash commits to, like the lnurl metadata or
+	// the zap request, for backends that hash the description themselves
+	Description string
+}
+
+type Invoice struct {
+	PaymentRequest string
+	PaymentHash    []byte
+	Preimage       []byte
+	// amount in sats, the received amount of settled invoices, which can be
+	// more than the requested one
+	Value      int64
+	State      InvoiceState
+	SettleDate int64
+}
+
+type PayReq struct {
+	Destination string
+	PaymentHash []byte
+	Value       int64
+	Memo        string
+	Timestamp   int64
+	Expiry      int64
+}
+
+type NodeInfo struct {
+	Pubkey string
+	Alias  string
+}
+
+type Payment struct {
+	PaymentHash []byte
+	Preimage    []byte
+	Fee         int64
+}
+
+// InvoiceSubscription delivers state updates of a single invoice, starting
+// with its current state.
+type InvoiceSubscription interface {
+	Recv() (*Invoice, error)
+}
+
+// LightningNode is a lightning backend that can receive donations and pay out
+// bounties.
+type LightningNode interface {
+	CreateInvoice(ctx context.Context, req *InvoiceRequest) (*Invoice, error)
+	LookupInvoice(ctx context.Context, paymentHash []byte) (*Invoice, error)
+	SubscribeInvoice(ctx context.Context, paymentHash []byte) (InvoiceSubscription, error)
+	PayInvoice(ctx context.Context, payreq string) (*Payment, error)
+	DecodeInvoice(ctx context.Context, payreq string) (*PayReq, error)
+	GetInfo(ctx context.Context) (*NodeInfo, error)
+	Close() error
+}
+
+type Transaction struct {
+	TxHash           string
+	NumConfirmations int32
+	RawTx            []byte
+}
+
+// TransactionSubscription delivers new wallet transactions.
+type TransactionSubscription interface {
+	Recv() (*Transaction, error)
+}
+
+// OnchainWallet is implemented by backends that expose their on-chain wallet.
+type OnchainWallet interface {
+	NewAddress(ctx context.Context) (string, error)
+	GetTransactions(ctx context.Context) ([]*Transaction, error)
+	SubscribeTransactions(ctx context.Context) (TransactionSubscription, error)
+	SendCoins(ctx context.Context, address string, sats int64, targetConf int32, label string) (string, error)
+}
+
+// InvoiceLister is implemented by backends that can list all of their
+// incoming invoices.
+type InvoiceLister interface {
+	ListInvoices(ctx context.Context) ([]*Invoice, error)
+}
+
+// ConnectWithTimeout uses Connect to connect to a node but also aborts after
+// a given timeout duration.
+func ConnectWithTimeout(ctx context.Context, uri string, timeout time.Duration) (LightningNode, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return Connect(ctx, uri)
+}
+
+// Connect connects to a node, the backend is chosen by the scheme of the uri:
+//
+//	lndconnect://host:port?cert=...&macaroon=...
+//	clnrest://host:port?rune=...&cert=...
+//	lnbits://host?key=...
+//	nostr+walletconnect://walletpubkey?relay=...&secret=...
+func Connect(ctx context.Context, uri string) (LightningNode, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "lndconnect":
+		return connectLnd(ctx, uri)
+	case "clnrest":
+		return newClnNode(u)
+	case "lnbits":
+		return newLnbitsNode(u)
+	case "nostr+walletconnect", "nostrwalletconnect":
+		return connectNwc(ctx, u)
+	}
+	return nil, UnsupportedBackendError
+}
+
+// BackendHost returns the host a backend connects to for the connection uri,
+// the relay for nostr wallet connect.
+func BackendHost(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "lndconnect", "clnrest", "lnbits":
+		return u.Hostname(), nil
+	case "nostr+walletconnect", "nostrwalletconnect":
+		relay, err := url.Parse(u.Query().Get("relay"))
+		if err != nil {
+			return "", err
+		}
+		return relay.Hostname(), nil
+	}
+	return "", UnsupportedBackendError
+}
+
+// pollingSubscription implements InvoiceSubscription for backends without
+// invoice streams by looking up the invoice until its state changes.
+type pollingSubscription struct {
+	ctx         context.Context
+	node        LightningNode
+	paymentHash []byte
+	interval    time.Duration
+	lastState   *InvoiceState
+}
+
+func newPollingSubscription(ctx context.Context, node LightningNode, paymentHash []byte) *pollingSubscription {
+	return &pollingSubscription{ctx: ctx, node: node, paymentHash: paymentHash, interval: time.Second * 2}
+}
+
+func (sub *pollingSubscription) Recv() (*Invoice, error) {
+	for {
+		if sub.lastState != nil {
+			select {
+			case <-sub.ctx.Done():
+				return nil, sub.ctx.Err()
+			case <-time.After(sub.interval):
+			}
+		}
+		inv, err := sub.node.LookupInvoice(sub.ctx, sub.paymentHash)
+		if err != nil {
+			return nil, err
+		}
+		if sub.lastState == nil || *sub.lastState != inv.State {
+			sub.lastState = &inv.State
+			return inv, nil
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package lightning
+
+import (
+	"testing"
+)
+
+func TestBackendHost(t *testing.T) {
+	for _, test := range []struct {
+		uri  string
+		host string
+	}{
+		{"lndconnect://node.example.com:10009?cert=abc&macaroon=def", "node.example.com"},
+		{"clnrest://10.0.0.1:3010?rune=abc", "10.0.0.1"},
+		{"lnbits://legend.lnbits.com?key=abc", "legend.lnbits.com"},
+		{"nostr+walletconnect://b889ff5b?relay=wss%3A%2F%2Frelay.example.com&secret=abc", "relay.example.com"},
+	} {
+		host, err := BackendHost(test.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != test.host {
+			t.Fatalf("expected host %v for %v, got %v", test.host, test.uri, host)
+		}
+	}
+	if _, err := BackendHost("http://169.254.169.254/"); err != UnsupportedBackendError {
+		t.Fatalf("expected unsupported backend, got %v", err)
+	}
+}
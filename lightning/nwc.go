@@ -0,0 +1,249 @@
+package lightning
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"net/url"
+	"time"
+)
+
+const (
+	nwcRequestKind  = 23194
+	nwcResponseKind = 23195
+
+	nwcRequestTimeout = time.Minute
+)
+
+// nwcNode is a wallet reached through nostr wallet connect (NIP-47).
+type nwcNode struct {
+	walletPubkey string
+	key          *nostr.PrivateKey
+	relay        *nostr.Relay
+}
+
+type nwcResponse struct {
+	ResultType string `json:"result_type"`
+	Error      *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Result json.RawMessage `json:"result"`
+}
+
+type nwcTransaction struct {
+	Invoice     string `json:"invoice"`
+	PaymentHash string `json:"payment_hash"`
+	Preimage    string `json:"preimage"`
+	Amount      int64  `json:"amount"`
+	SettledAt   int64  `json:"settled_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+func connectNwc(ctx context.Context, uri *url.URL) (*nwcNode, error) {
+	walletPubkey := uri.Host
+	if walletPubkey == "" {
+		walletPubkey = uri.Opaque
+	}
+	relayUrl := uri.Query().Get("relay")
+	if walletPubkey == "" || relayUrl == "" {
+		return nil, fmt.Errorf("nostr wallet connect uri requires a wallet pubkey and relay")
+	}
+	key, err := nostr.PrivateKeyFromHex(uri.Query().Get("secret"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid nostr wallet connect secret: %v", err)
+	}
+	relay, err := nostr.ConnectRelay(ctx, relayUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &nwcNode{walletPubkey: walletPubkey, key: key, relay: relay}, nil
+}
+
+func (n *nwcNode) CreateInvoice(ctx context.Context, req *InvoiceRequest) (*Invoice, error) {
+	params := map[string]interface{}{
+		"amount":      req.Value * 1000,
+		"description": req.Memo,
+	}
+	if req.Expiry != 0 {
+		params["expiry"] = req.Expiry
+	}
+	if req.DescriptionHash != nil {
+		params["description_hash"] = hex.EncodeToString(req.DescriptionHash)
+	}
+	res := &nwcTransaction{}
+	err := n.request(ctx, "make_invoice", params, res)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := res.toInvoice()
+	if err != nil {
+		return nil, err
+	}
+	inv.Value = req.Value
+	return inv, nil
+}
+
+func (n *nwcNode) LookupInvoice(ctx context.Context, paymentHash []byte) (*Invoice, error) {
+	res := &nwcTransaction{}
+	err := n.request(ctx, "lookup_invoice", map[string]interface{}{"payment_hash": hex.EncodeToString(paymentHash)}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res.toInvoice()
+}
+
+func (n *nwcNode) ListInvoices(ctx context.Context) ([]*Invoice, error) {
+	var invoices []*Invoice
+	for offset := 0; ; offset += listInvoicesPageSize {
+		res := &struct {
+			Transactions []*nwcTransaction `json:"transactions"`
+		}{}
+		err := n.request(ctx, "list_transactions", map[string]interface{}{
+			"type":   "incoming",
+			"offset": offset,
+			"limit":  listInvoicesPageSize,
+		}, res)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range res.Transactions {
+			invoice, err := tx.toInvoice()
+			if err != nil {
+				return nil, err
+			}
+			invoices = append(invoices, invoice)
+		}
+		if len(res.Transactions) < listInvoicesPageSize {
+			return invoices, nil
+		}
+	}
+}
+
+func (n *nwcNode) SubscribeInvoice(ctx context.Context, paymentHash []byte) (InvoiceSubscription, error) {
+	return newPollingSubscription(ctx, n, paymentHash), nil
+}
+
+func (n *nwcNode) PayInvoice(ctx context.Context, payreq string) (*Payment, error) {
+	res := &struct {
+		Preimage string `json:"preimage"`
+		FeesPaid int64  `json:"fees_paid"`
+	}{}
+	err := n.request(ctx, "pay_invoice", map[string]interface{}{"invoice": payreq}, res)
+	if err != nil {
+		return nil, err
+	}
+	preimage, err := hex.DecodeString(res.Preimage)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash := sha256.Sum256(preimage)
+	return &Payment{PaymentHash: paymentHash[:], Preimage: preimage, Fee: res.FeesPaid / 1000}, nil
+}
+
+// DecodeInvoice decodes the invoice locally, as NIP-47 has no decode method.
+func (n *nwcNode) DecodeInvoice(ctx context.Context, payreq string) (*PayReq, error) {
+	return DecodePayReq(payreq)
+}
+
+func (n *nwcNode) GetInfo(ctx context.Context) (*NodeInfo, error) {
+	res := &struct {
+		Pubkey string `json:"pubkey"`
+		Alias  string `json:"alias"`
+	}{}
+	err := n.request(ctx, "get_info", map[string]interface{}{}, res)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeInfo{Pubkey: res.Pubkey, Alias: res.Alias}, nil
+}
+
+func (n *nwcNode) Close() error {
+	return n.relay.Close()
+}
+
+func (n *nwcNode) healthy() bool {
+	return !n.relay.IsClosed()
+}
+
+func (n *nwcNode) request(ctx context.Context, method string, params interface{}, res interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, nwcRequestTimeout)
+	defer cancel()
+	content, err := json.Marshal(map[string]interface{}{"method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	encrypted, err := n.key.Encrypt(n.walletPubkey, string(content))
+	if err != nil {
+		return err
+	}
+	ev := nostr.NewEvent(nwcRequestKind, encrypted, nostr.Tag{"p", n.walletPubkey})
+	err = ev.Sign(n.key)
+	if err != nil {
+		return err
+	}
+	sub, err := n.relay.Subscribe(ctx, nostr.Filter{
+		Kinds:   []int{nwcResponseKind},
+		Authors: []string{n.walletPubkey},
+		Tags:    map[string][]string{"e": {ev.Id}},
+	})
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	err = n.relay.Publish(ctx, ev)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no response to %v: %v", method, ctx.Err())
+		case resEv := <-sub.Events:
+			if resEv.TagValue("e") != ev.Id {
+				continue
+			}
+			decrypted, err := n.key.Decrypt(n.walletPubkey, resEv.Content)
+			if err != nil {
+				return err
+			}
+			nwcRes := &nwcResponse{}
+			err = json.Unmarshal([]byte(decrypted), nwcRes)
+			if err != nil {
+				return err
+			}
+			if nwcRes.Error != nil {
+				return fmt.Errorf("%v failed: %v %v", method, nwcRes.Error.Code, nwcRes.Error.Message)
+			}
+			return json.Unmarshal(nwcRes.Result, res)
+		}
+	}
+}
+
+func (tx *nwcTransaction) toInvoice() (*Invoice, error) {
+	paymentHash, err := hex.DecodeString(tx.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &Invoice{
+		PaymentRequest: tx.Invoice,
+		PaymentHash:    paymentHash,
+		Value:          tx.Amount / 1000,
+		SettleDate:     tx.SettledAt,
+		State:          InvoiceOpen,
+	}
+	switch {
+	case tx.SettledAt != 0:
+		invoice.State = InvoiceSettled
+		invoice.Preimage, err = hex.DecodeString(tx.Preimage)
+		if err != nil {
+			return nil, err
+		}
+	case tx.ExpiresAt != 0 && tx.ExpiresAt < time.Now().Unix():
+		invoice.State = InvoiceCanceled
+	}
+	return invoice, nil
+}
@@ -0,0 +1,273 @@
+package lightning
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nwcRequest struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// fakeWallet is a relay that answers NIP-47 requests as the wallet itself.
+type fakeWallet struct {
+	t      *testing.T
+	key    *nostr.PrivateKey
+	server *httptest.Server
+	// returns the result or the error code and message of a request
+	handle   func(req *nwcRequest) (interface{}, string, string)
+	requests []*nwcRequest
+	conns    []*websocket.Conn
+	sync.Mutex
+}
+
+func newFakeWallet(t *testing.T, handle func(req *nwcRequest) (interface{}, string, string)) *fakeWallet {
+	key, err := nostr.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet := &fakeWallet{t: t, key: key, handle: handle}
+	wallet.server = httptest.NewServer(http.HandlerFunc(wallet.serve))
+	t.Cleanup(wallet.server.Close)
+	return wallet
+}
+
+func (w *fakeWallet) uri(secret *nostr.PrivateKey) string {
+	relay := "ws" + strings.TrimPrefix(w.server.URL, "http")
+	return "nostr+walletconnect://" + w.key.PublicKey() + "?relay=" + relay + "&secret=" + secret.Hex()
+}
+
+func (w *fakeWallet) serve(rw http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(rw, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	w.Lock()
+	w.conns = append(w.conns, conn)
+	w.Unlock()
+	var subId string
+	for {
+		var msg []json.RawMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		var msgType string
+		json.Unmarshal(msg[0], &msgType)
+		switch msgType {
+		case "REQ":
+			json.Unmarshal(msg[1], &subId)
+		case "EVENT":
+			ev := &nostr.Event{}
+			json.Unmarshal(msg[1], ev)
+			conn.WriteJSON([]interface{}{"OK", ev.Id, true, ""})
+			if ev.Kind != nwcRequestKind || ev.TagValue("p") != w.key.PublicKey() {
+				continue
+			}
+			// an unrelated response of the wallet is skipped by the client
+			conn.WriteJSON([]interface{}{"EVENT", subId, w.response(ev.Pubkey, strings.Repeat("00", 32), `{"result_type":"get_info","result":{}}`)})
+			conn.WriteJSON([]interface{}{"EVENT", subId, w.response(ev.Pubkey, ev.Id, w.answer(ev))})
+		}
+	}
+}
+
+// disconnect drops the connections of all clients.
+func (w *fakeWallet) disconnect() {
+	w.Lock()
+	defer w.Unlock()
+	for _, conn := range w.conns {
+		conn.Close()
+	}
+}
+
+func (w *fakeWallet) answer(ev *nostr.Event) string {
+	decrypted, err := w.key.Decrypt(ev.Pubkey, ev.Content)
+	if err != nil {
+		w.t.Errorf("unable to decrypt request: %v", err)
+		return ""
+	}
+	req := &nwcRequest{}
+	err = json.Unmarshal([]byte(decrypted), req)
+	if err != nil {
+		w.t.Errorf("invalid request %v: %v", decrypted, err)
+		return ""
+	}
+	w.Lock()
+	w.requests = append(w.requests, req)
+	w.Unlock()
+	res := map[string]interface{}{"result_type": req.Method}
+	result, code, message := w.handle(req)
+	if code != "" {
+		res["error"] = map[string]string{"code": code, "message": message}
+	} else {
+		res["result"] = result
+	}
+	content, _ := json.Marshal(res)
+	return string(content)
+}
+
+func (w *fakeWallet) response(client string, requestId string, content string) *nostr.Event {
+	encrypted, err := w.key.Encrypt(client, content)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	ev := nostr.NewEvent(nwcResponseKind, encrypted, nostr.Tag{"p", client}, nostr.Tag{"e", requestId})
+	err = ev.Sign(w.key)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	return ev
+}
+
+func TestNwcInvoices(t *testing.T) {
+	ctx := context.Background()
+	preimage := strings.Repeat("02", 32)
+	wallet := newFakeWallet(t, func(req *nwcRequest) (interface{}, string, string) {
+		switch req.Method {
+		case "make_invoice":
+			return map[string]interface{}{
+				"type":         "incoming",
+				"invoice":      "lnbcrt10u1invoice",
+				"payment_hash": strings.Repeat("01", 32),
+				"amount":       req.Params["amount"],
+				"expires_at":   time.Now().Add(time.Hour).Unix(),
+			}, "", ""
+		case "lookup_invoice":
+			switch req.Params["payment_hash"] {
+			case strings.Repeat("01", 32):
+				return map[string]interface{}{
+					"invoice":      "lnbcrt10u1invoice",
+					"payment_hash": strings.Repeat("01", 32),
+					"preimage":     preimage,
+					"amount":       1500000,
+					"settled_at":   1600000000,
+				}, "", ""
+			case strings.Repeat("03", 32):
+				return map[string]interface{}{
+					"invoice":      "lnbcrt1expired",
+					"payment_hash": strings.Repeat("03", 32),
+					"amount":       1000,
+					"expires_at":   1600000000,
+				}, "", ""
+			}
+			return nil, "NOT_FOUND", "invoice not found"
+		}
+		return nil, "NOT_IMPLEMENTED", "unknown method"
+	})
+	client, err := nostr.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := Connect(ctx, wallet.uri(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	inv, err := node.CreateInvoice(ctx, &InvoiceRequest{Value: 1000, Memo: "bounty", Expiry: 600, DescriptionHash: []byte{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.PaymentRequest != "lnbcrt10u1invoice" || hex.EncodeToString(inv.PaymentHash) != strings.Repeat("01", 32) ||
+		inv.Value != 1000 || inv.State != InvoiceOpen {
+		t.Fatalf("unexpected invoice %+v", inv)
+	}
+	wallet.Lock()
+	params := wallet.requests[0].Params
+	wallet.Unlock()
+	// amounts are sent in msat
+	if params["amount"] != float64(1000000) || params["description"] != "bounty" || params["expiry"] != float64(600) ||
+		params["description_hash"] != "0102" {
+		t.Fatalf("unexpected make_invoice params %v", params)
+	}
+
+	hash, _ := hex.DecodeString(strings.Repeat("01", 32))
+	inv, err = node.LookupInvoice(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.State != InvoiceSettled || inv.Value != 1500 || hex.EncodeToString(inv.Preimage) != preimage || inv.SettleDate != 1600000000 {
+		t.Fatalf("unexpected settled invoice %+v", inv)
+	}
+	hash, _ = hex.DecodeString(strings.Repeat("03", 32))
+	inv, err = node.LookupInvoice(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.State != InvoiceCanceled {
+		t.Fatalf("expected the expired invoice to be canceled, got %+v", inv)
+	}
+
+	// errors of the wallet are returned
+	hash, _ = hex.DecodeString(strings.Repeat("04", 32))
+	_, err = node.LookupInvoice(ctx, hash)
+	if err == nil || !strings.Contains(err.Error(), "lookup_invoice failed: NOT_FOUND invoice not found") {
+		t.Fatalf("expected the wallet error, got %v", err)
+	}
+	_, err = node.GetInfo(ctx)
+	if err == nil || !strings.Contains(err.Error(), "NOT_IMPLEMENTED") {
+		t.Fatalf("expected the wallet error, got %v", err)
+	}
+}
+
+func TestNwcNoResponse(t *testing.T) {
+	wallet := newFakeWallet(t, nil)
+	// requests for another wallet aren't answered
+	other, err := nostr.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := nostr.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := strings.Replace(wallet.uri(client), wallet.key.PublicKey(), other.PublicKey(), 1)
+	node, err := Connect(context.Background(), uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	_, err = node.CreateInvoice(ctx, &InvoiceRequest{Value: 1000})
+	if err == nil || !strings.Contains(err.Error(), "no response to make_invoice") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// the node is unhealthy once the relay is gone
+	wallet.disconnect()
+	nwc := node.(*nwcNode)
+	deadline := time.Now().Add(time.Second * 5)
+	for nwc.healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("node stayed healthy without relay")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestConnectNwcInvalidUri(t *testing.T) {
+	key, err := nostr.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uri := range []string{
+		"nostr+walletconnect://?relay=ws://localhost&secret=" + key.Hex(),
+		"nostr+walletconnect://" + key.PublicKey() + "?secret=" + key.Hex(),
+		"nostr+walletconnect://" + key.PublicKey() + "?relay=ws://localhost&secret=nothex",
+	} {
+		if _, err := Connect(context.Background(), uri); err == nil {
+			t.Fatalf("connected with invalid uri %v", uri)
+		}
+	}
+}
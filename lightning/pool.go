@@ -0,0 +1,342 @@
+package lightning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	minBackoff = time.Second * 2
+	maxBackoff = time.Minute * 5
+)
+
+type ConnState string
+
+const (
+	StateIdle        ConnState = "idle"
+	StateConnecting  ConnState = "connecting"
+	StateReady       ConnState = "ready"
+	StateUnreachable ConnState = "unreachable"
+)
+
+type NodeStatus struct {
+	State       ConnState
+	LastError   string
+	LastSuccess time.Time
+	LastUsed    time.Time
+	Failures    int
+	NextAttempt time.Time
+}
+
+// healthChecker is implemented by backends that keep a connection open and
+// can tell whether it is still usable.
+type healthChecker interface {
+	healthy() bool
+}
+
+// DialFunc connects to the node of a connection uri.
+type DialFunc func(ctx context.Context, uri string) (LightningNode, error)
+
+// Pool keeps connections to nodes open, keyed by their connection uri.
+// Failed dials are retried with exponential backoff and connections that
+// haven't been used for the idle timeout are closed.
+type Pool struct {
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+	dial        DialFunc
+
+	entries map[string]*poolEntry
+	sync.Mutex
+}
+
+type poolEntry struct {
+	conn        *poolConn
+	dialing     chan struct{}
+	lastUsed    time.Time
+	lastSuccess time.Time
+	lastErr     error
+	failures    int
+	nextAttempt time.Time
+}
+
+// poolConn is a connection to a node. Broken connections are detached from
+// their entry, so the next Get reconnects, and closed once the last caller
+// released them.
+type poolConn struct {
+	node     LightningNode
+	refs     int
+	detached bool
+}
+
+func NewPool(dialTimeout, idleTimeout time.Duration) *Pool {
+	return &Pool{
+		dialTimeout: dialTimeout,
+		idleTimeout: idleTimeout,
+		dial:        Connect,
+		entries:     make(map[string]*poolEntry),
+	}
+}
+
+// SetDialer replaces how the pool connects to nodes, which allows serving
+// nodes that aren't reachable by a connection uri, like in-memory nodes.
+func (p *Pool) SetDialer(dial DialFunc) {
+	p.Lock()
+	defer p.Unlock()
+	p.dial = dial
+}
+
+// Get returns a connected node for the uri, dialing it if necessary. The
+// returned release func must be called once the node is no longer used,
+// the node itself must not be closed.
+func (p *Pool) Get(ctx context.Context, uri string) (LightningNode, func(), error) {
+	for {
+		p.Lock()
+		entry, ok := p.entries[uri]
+		if !ok {
+			entry = &poolEntry{}
+			p.entries[uri] = entry
+		}
+		if entry.conn != nil && !isHealthy(entry.conn.node) {
+			entry.detach(fmt.Errorf("connection lost"))
+		}
+		if entry.conn != nil {
+			conn := entry.conn
+			conn.refs++
+			entry.lastUsed = time.Now()
+			p.Unlock()
+			return conn.node, p.releaseFunc(entry, conn), nil
+		}
+		if entry.dialing != nil {
+			dialing := entry.dialing
+			p.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+		if time.Now().Before(entry.nextAttempt) {
+			err := fmt.Errorf("node unreachable, retrying in %v: %v", time.Until(entry.nextAttempt).Round(time.Second), entry.lastErr)
+			p.Unlock()
+			return nil, nil, err
+		}
+		dialing := make(chan struct{})
+		entry.dialing = dialing
+		dial := p.dial
+		p.Unlock()
+
+		dialCtx, cancel := context.WithTimeout(ctx, p.dialTimeout)
+		node, err := dial(dialCtx, uri)
+		cancel()
+
+		p.Lock()
+		entry.dialing = nil
+		close(dialing)
+		if err != nil {
+			entry.lastErr = err
+			entry.failures++
+			entry.nextAttempt = time.Now().Add(backoff(entry.failures))
+			p.Unlock()
+			return nil, nil, err
+		}
+		entry.conn = &poolConn{node: node}
+		entry.lastErr = nil
+		entry.failures = 0
+		entry.nextAttempt = time.Time{}
+		entry.lastSuccess = time.Now()
+		p.Unlock()
+	}
+}
+
+// ReportFailure detaches the connection of a node after a failed call, so the
+// next Get reconnects. Callers that still hold the node keep it until they
+// release it.
+func (p *Pool) ReportFailure(uri string, err error) {
+	p.Lock()
+	defer p.Unlock()
+	entry, ok := p.entries[uri]
+	if !ok || entry.conn == nil {
+		return
+	}
+	entry.detach(err)
+	entry.failures++
+	entry.nextAttempt = time.Now().Add(backoff(entry.failures))
+}
+
+// IsConnectionError returns whether a call failed because the node couldn't
+// be reached, rather than the node rejecting the call.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, nostr.RelayClosedError) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// ReportSuccess records a successful call to a node.
+func (p *Pool) ReportSuccess(uri string) {
+	p.Lock()
+	defer p.Unlock()
+	entry, ok := p.entries[uri]
+	if !ok {
+		return
+	}
+	entry.lastSuccess = time.Now()
+}
+
+// Status returns the connectivity state of the node.
+func (p *Pool) Status(uri string) NodeStatus {
+	p.Lock()
+	defer p.Unlock()
+	entry, ok := p.entries[uri]
+	if !ok {
+		return NodeStatus{State: StateIdle}
+	}
+	return entry.status()
+}
+
+// Statuses returns the connectivity states of all known nodes.
+func (p *Pool) Statuses() map[string]NodeStatus {
+	p.Lock()
+	defer p.Unlock()
+	statuses := make(map[string]NodeStatus)
+	for uri, entry := range p.entries {
+		statuses[uri] = entry.status()
+	}
+	return statuses
+}
+
+// Run evicts idle connections until the context is canceled.
+func (p *Pool) Run(ctx context.Context) {
+	if p.idleTimeout <= 0 {
+		<-ctx.Done()
+		p.Close()
+		return
+	}
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return
+		case <-ticker.C:
+			p.evictIdle()
+		}
+	}
+}
+
+// Close closes all connections.
+func (p *Pool) Close() {
+	p.Lock()
+	defer p.Unlock()
+	for uri, entry := range p.entries {
+		if entry.conn != nil {
+			entry.conn.node.Close()
+		}
+		delete(p.entries, uri)
+	}
+}
+
+func (p *Pool) evictIdle() {
+	p.Lock()
+	defer p.Unlock()
+	for uri, entry := range p.entries {
+		if (entry.conn != nil && entry.conn.refs > 0) || time.Since(entry.lastUsed) < p.idleTimeout {
+			continue
+		}
+		if entry.conn != nil {
+			entry.conn.node.Close()
+			entry.conn = nil
+		}
+		if entry.failures == 0 && entry.dialing == nil {
+			delete(p.entries, uri)
+		}
+	}
+}
+
+func (p *Pool) releaseFunc(entry *poolEntry, conn *poolConn) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.Lock()
+			defer p.Unlock()
+			conn.refs--
+			if conn.detached {
+				if conn.refs == 0 {
+					conn.node.Close()
+				}
+				return
+			}
+			entry.lastUsed = time.Now()
+		})
+	}
+}
+
+// detach removes a broken connection from the entry, it is closed right away
+// if no caller holds it.
+func (entry *poolEntry) detach(err error) {
+	conn := entry.conn
+	entry.conn = nil
+	entry.lastErr = err
+	conn.detached = true
+	if conn.refs == 0 {
+		conn.node.Close()
+	}
+}
+
+func (entry *poolEntry) status() NodeStatus {
+	status := NodeStatus{
+		LastSuccess: entry.lastSuccess,
+		LastUsed:    entry.lastUsed,
+		Failures:    entry.failures,
+		NextAttempt: entry.nextAttempt,
+	}
+	if entry.lastErr != nil {
+		status.LastError = entry.lastErr.Error()
+	}
+	switch {
+	case entry.dialing != nil:
+		status.State = StateConnecting
+	case entry.conn != nil && isHealthy(entry.conn.node):
+		status.State = StateReady
+	case entry.failures > 0:
+		status.State = StateUnreachable
+	default:
+		status.State = StateIdle
+	}
+	return status
+}
+
+func isHealthy(node LightningNode) bool {
+	if checker, ok := node.(healthChecker); ok {
+		return checker.healthy()
+	}
+	return true
+}
+
+func backoff(failures int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,243 @@
+package lightning
+
+import (
+	"context"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	for _, test := range []struct {
+		err        error
+		connection bool
+	}{
+		{nil, false},
+		{fmt.Errorf("invoice not found"), false},
+		{status.Error(codes.NotFound, "unable to locate invoice"), false},
+		{status.Error(codes.Unavailable, "transport is closing"), true},
+		{status.Error(codes.DeadlineExceeded, "context deadline exceeded"), true},
+		{&url.Error{Op: "Post", URL: "https://node", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}}, true},
+		{nostr.RelayClosedError, true},
+		{context.DeadlineExceeded, true},
+	} {
+		if connection := IsConnectionError(test.err); connection != test.connection {
+			t.Fatalf("expected %v for %v, got %v", test.connection, test.err, connection)
+		}
+	}
+}
+
+// poolNode counts how often it is closed, the other node methods aren't used
+// by the pool.
+type poolNode struct {
+	LightningNode
+	id        int
+	closed    int
+	unhealthy bool
+}
+
+func (n *poolNode) Close() error {
+	n.closed++
+	return nil
+}
+
+func (n *poolNode) healthy() bool {
+	return !n.unhealthy
+}
+
+// testPool returns a pool whose dials return new nodes, or the error of fail
+// if it is set.
+func testPool(idleTimeout time.Duration) (*Pool, *[]*poolNode, *error) {
+	var nodes []*poolNode
+	var fail error
+	pool := NewPool(time.Second, idleTimeout)
+	pool.SetDialer(func(ctx context.Context, uri string) (LightningNode, error) {
+		if fail != nil {
+			return nil, fail
+		}
+		node := &poolNode{id: len(nodes) + 1}
+		nodes = append(nodes, node)
+		return node, nil
+	})
+	return pool, &nodes, &fail
+}
+
+func TestPoolReuse(t *testing.T) {
+	ctx := context.Background()
+	pool, nodes, _ := testPool(0)
+	first, release1, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, release2, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || len(*nodes) != 1 {
+		t.Fatalf("expected one connection to be shared, dialed %v", len(*nodes))
+	}
+	release1()
+	release1()
+	release2()
+	if (*nodes)[0].closed != 0 {
+		t.Fatal("released connection was closed")
+	}
+	if status := pool.Status("uri"); status.State != StateReady {
+		t.Fatalf("expected ready connection, got %v", status.State)
+	}
+	third, release3, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release3()
+	if third != first || len(*nodes) != 1 {
+		t.Fatal("released connection wasn't reused")
+	}
+}
+
+func TestPoolBackoff(t *testing.T) {
+	defer func(min, max time.Duration) {
+		minBackoff, maxBackoff = min, max
+	}(minBackoff, maxBackoff)
+	minBackoff, maxBackoff = time.Millisecond*50, time.Millisecond*150
+	for failures, expected := range []time.Duration{0: minBackoff, 1: minBackoff, 2: minBackoff * 2, 3: maxBackoff, 10: maxBackoff} {
+		if expected != 0 && backoff(failures) != expected {
+			t.Fatalf("expected a backoff of %v after %v failures, got %v", expected, failures, backoff(failures))
+		}
+	}
+
+	ctx := context.Background()
+	pool, nodes, fail := testPool(0)
+	*fail = fmt.Errorf("connection refused")
+	_, _, err := pool.Get(ctx, "uri")
+	if err != *fail {
+		t.Fatalf("expected the dial error, got %v", err)
+	}
+	*fail = nil
+	_, _, err = pool.Get(ctx, "uri")
+	if err == nil || !strings.Contains(err.Error(), "node unreachable, retrying") {
+		t.Fatalf("expected the node to be backed off, got %v", err)
+	}
+	status := pool.Status("uri")
+	if status.State != StateUnreachable || status.Failures != 1 || status.LastError != "connection refused" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	time.Sleep(minBackoff)
+	_, release, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	status = pool.Status("uri")
+	if len(*nodes) != 1 || status.State != StateReady || status.Failures != 0 {
+		t.Fatalf("expected the node to be redialed after the backoff, got %+v", status)
+	}
+}
+
+func TestPoolReportFailure(t *testing.T) {
+	defer func(min time.Duration) {
+		minBackoff = min
+	}(minBackoff)
+	minBackoff = 0
+	ctx := context.Background()
+	pool, nodes, _ := testPool(0)
+	broken, release1, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, release2, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one caller's failure doesn't close the node under the other caller
+	pool.ReportFailure("uri", fmt.Errorf("transport is closing"))
+	pool.ReportFailure("uri", fmt.Errorf("transport is closing"))
+	if (*nodes)[0].closed != 0 {
+		t.Fatal("node was closed while it was held")
+	}
+	if status := pool.Status("uri"); status.Failures != 1 {
+		t.Fatalf("expected the detached connection to count once, got %v failures", status.Failures)
+	}
+	fresh, release3, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == broken || len(*nodes) != 2 {
+		t.Fatal("expected a new connection after the failure")
+	}
+	release1()
+	if (*nodes)[0].closed != 0 {
+		t.Fatal("node was closed before the last caller released it")
+	}
+	release2()
+	release2()
+	if (*nodes)[0].closed != 1 {
+		t.Fatalf("expected the detached node to be closed once, got %v", (*nodes)[0].closed)
+	}
+	release3()
+	if (*nodes)[1].closed != 0 {
+		t.Fatal("releasing the old connection closed the new one")
+	}
+
+	// unhealthy connections are replaced on the next Get and closed right away
+	// if nobody holds them
+	(*nodes)[1].unhealthy = true
+	_, release4, err := pool.Get(ctx, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release4()
+	if len(*nodes) != 3 || (*nodes)[1].closed != 1 {
+		t.Fatal("expected the unhealthy connection to be replaced")
+	}
+}
+
+func TestPoolEvictIdle(t *testing.T) {
+	ctx := context.Background()
+	pool, nodes, _ := testPool(time.Millisecond * 20)
+	_, release, err := pool.Get(ctx, "held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, idleRelease, err := pool.Get(ctx, "idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idleRelease()
+	time.Sleep(time.Millisecond * 30)
+	pool.evictIdle()
+	held, idle := (*nodes)[0], (*nodes)[1]
+	if held.closed != 0 {
+		t.Fatal("held connection was evicted")
+	}
+	if idle.closed != 1 || pool.Status("idle").State != StateIdle {
+		t.Fatal("idle connection wasn't evicted")
+	}
+
+	release()
+	time.Sleep(time.Millisecond * 30)
+	pool.evictIdle()
+	if held.closed != 1 {
+		t.Fatal("released connection wasn't evicted")
+	}
+	_, release, err = pool.Get(ctx, "held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if len(*nodes) != 3 {
+		t.Fatal("expected evicted connections to be redialed")
+	}
+	pool.Close()
+	if (*nodes)[2].closed != 1 {
+		t.Fatal("close didn't close the open connection")
+	}
+}
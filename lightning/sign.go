@@ -0,0 +1,105 @@
+package lightning
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"strings"
+)
+
+const (
+	// prefix of signed messages, shared by lnd and core lightning
+	signedMessagePrefix = "Lightning Signed Message:"
+
+	zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+)
+
+// MessageSigner is implemented by backends that sign messages with their node
+// key. Signatures are zbase32 encoded recoverable signatures like the ones of
+// lnd's signmessage.
+type MessageSigner interface {
+	SignMessage(ctx context.Context, msg []byte) (string, error)
+	// VerifyMessage returns the hex encoded pubkey that signed the message
+	VerifyMessage(ctx context.Context, msg []byte, signature string) (string, error)
+}
+
+func (n *lndNode) SignMessage(ctx context.Context, msg []byte) (string, error) {
+	res, err := n.client.SignMessage(ctx, &lnrpc.SignMessageRequest{Msg: msg})
+	if err != nil {
+		return "", err
+	}
+	return res.Signature, nil
+}
+
+func (n *lndNode) VerifyMessage(ctx context.Context, msg []byte, signature string) (string, error) {
+	// lnd only reports signatures of nodes in its graph as valid, the
+	// recovered pubkey is returned regardless
+	res, err := n.client.VerifyMessage(ctx, &lnrpc.VerifyMessageRequest{Msg: msg, Signature: signature})
+	if err != nil {
+		return "", err
+	}
+	return res.Pubkey, nil
+}
+
+func (n *clnNode) SignMessage(ctx context.Context, msg []byte) (string, error) {
+	res := &struct {
+		Zbase string `json:"zbase"`
+	}{}
+	err := n.call(ctx, "signmessage", map[string]interface{}{"message": string(msg)}, res)
+	if err != nil {
+		return "", err
+	}
+	return res.Zbase, nil
+}
+
+func (n *clnNode) VerifyMessage(ctx context.Context, msg []byte, signature string) (string, error) {
+	res := &struct {
+		Pubkey   string `json:"pubkey"`
+		Verified bool   `json:"verified"`
+	}{}
+	err := n.call(ctx, "checkmessage", map[string]interface{}{"message": string(msg), "zbase": signature}, res)
+	if err != nil {
+		return "", err
+	}
+	if !res.Verified {
+		return "", fmt.Errorf("invalid signature")
+	}
+	return res.Pubkey, nil
+}
+
+// RecoverMessagePubkey returns the hex encoded pubkey that signed the message
+// without asking a node.
+func RecoverMessagePubkey(msg []byte, signature string) (string, error) {
+	sig, err := decodeZbase32(signature)
+	if err != nil {
+		return "", err
+	}
+	digest := chainhash.DoubleHashB(append([]byte(signedMessagePrefix), msg...))
+	pubkey, _, err := btcec.RecoverCompact(btcec.S256(), sig, digest)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(pubkey.SerializeCompressed()), nil
+}
+
+func decodeZbase32(s string) ([]byte, error) {
+	var res []byte
+	var buffer, bits uint
+	for _, c := range strings.ToLower(s) {
+		i := strings.IndexRune(zbase32Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid zbase32 character %q", c)
+		}
+		buffer = buffer<<5 | uint(i)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			res = append(res, byte(buffer>>bits))
+			buffer &= 1<<bits - 1
+		}
+	}
+	return res, nil
+}
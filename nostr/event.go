@@ -0,0 +1,127 @@
+package nostr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type Tag []string
+
+type Event struct {
+	Id        string `json:"id"`
+	Pubkey    string `json:"pubkey"`
+	CreatedAt int64  `json:"created_at"`
+	Kind      int    `json:"kind"`
+	Tags      []Tag  `json:"tags"`
+	Content   string `json:"content"`
+	Sig       string `json:"sig"`
+}
+
+// NewEvent creates an unsigned event.
+func NewEvent(kind int, content string, tags ...Tag) *Event {
+	if tags == nil {
+		tags = []Tag{}
+	}
+	return &Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+}
+
+// Serialize returns the canonical serialization of the event described in NIP-01.
+func (ev *Event) Serialize() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode([]interface{}{0, ev.Pubkey, ev.CreatedAt, ev.Kind, ev.Tags, ev.Content})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Sign sets the pubkey, id and signature of the event.
+func (ev *Event) Sign(key *PrivateKey) error {
+	ev.Pubkey = key.PublicKey()
+	serialized, err := ev.Serialize()
+	if err != nil {
+		return err
+	}
+	id := sha256.Sum256(serialized)
+	sig, err := key.Sign(id[:])
+	if err != nil {
+		return err
+	}
+	ev.Id = hex.EncodeToString(id[:])
+	ev.Sig = hex.EncodeToString(sig)
+	return nil
+}
+
+// Verify checks the id and signature of the event.
+func (ev *Event) Verify() error {
+	serialized, err := ev.Serialize()
+	if err != nil {
+		return err
+	}
+	id := sha256.Sum256(serialized)
+	if hex.EncodeToString(id[:]) != ev.Id {
+		return fmt.Errorf("invalid event id")
+	}
+	sig, err := hex.DecodeString(ev.Sig)
+	if err != nil {
+		return err
+	}
+	ok, err := Verify(ev.Pubkey, id[:], sig)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid event signature")
+	}
+	return nil
+}
+
+// TagValue returns the first value of the first tag with the given name.
+func (ev *Event) TagValue(name string) string {
+	for _, tag := range ev.Tags {
+		if len(tag) > 1 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+type Filter struct {
+	Ids     []string            `json:"ids,omitempty"`
+	Authors []string            `json:"authors,omitempty"`
+	Kinds   []int               `json:"kinds,omitempty"`
+	Tags    map[string][]string `json:"-"`
+	Since   int64               `json:"since,omitempty"`
+	Limit   int                 `json:"limit,omitempty"`
+}
+
+// MarshalJSON adds the tag filters as "#<name>" keys.
+func (f Filter) MarshalJSON() ([]byte, error) {
+	type filter Filter
+	jData, err := json.Marshal(filter(f))
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Tags) == 0 {
+		return jData, nil
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(jData, &m); err != nil {
+		return nil, err
+	}
+	for name, values := range f.Tags {
+		m["#"+name] = values
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,83 @@
+package nostr
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"strings"
+)
+
+var InvalidPaddingError = fmt.Errorf("invalid padding")
+
+// Encrypt encrypts a message for the given public key as described in NIP-04.
+func (key *PrivateKey) Encrypt(pubkey string, msg string) (string, error) {
+	secret, err := key.sharedSecret(pubkey)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	padding := aes.BlockSize - len(msg)%aes.BlockSize
+	plaintext := append([]byte(msg), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	return base64.StdEncoding.EncodeToString(ciphertext) + "?iv=" + base64.StdEncoding.EncodeToString(iv), nil
+}
+
+// Decrypt decrypts a NIP-04 message of the given public key.
+func (key *PrivateKey) Decrypt(pubkey string, content string) (string, error) {
+	parts := strings.Split(content, "?iv=")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid encrypted content")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	iv, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return "", fmt.Errorf("invalid encrypted content")
+	}
+	secret, err := key.sharedSecret(pubkey)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return "", InvalidPaddingError
+	}
+	pad := plaintext[len(plaintext)-padding:]
+	if subtle.ConstantTimeCompare(pad, bytes.Repeat([]byte{byte(padding)}, padding)) != 1 {
+		return "", InvalidPaddingError
+	}
+	return string(plaintext[:len(plaintext)-padding]), nil
+}
+
+// sharedSecret returns the x coordinate of the ecdh point.
+func (key *PrivateKey) sharedSecret(pubkey string) ([]byte, error) {
+	pub, err := parsePublicKey(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	return secp256k1.GenerateSharedSecret(key.key, pub), nil
+}
@@ -0,0 +1,73 @@
+package nostr
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	alice, err := NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"", "hello", "exactly 16 bytes", `{"method":"pay_invoice"}`} {
+		content, err := alice.Encrypt(bob.PublicKey(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := bob.Decrypt(alice.PublicKey(), content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != msg {
+			t.Fatalf("expected %q, got %q", msg, decrypted)
+		}
+	}
+}
+
+func TestDecryptPadding(t *testing.T) {
+	alice, err := NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := alice.sharedSecret(bob.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(plaintext []byte) string {
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iv := make([]byte, aes.BlockSize)
+		ciphertext := make([]byte, len(plaintext))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+		return base64.StdEncoding.EncodeToString(ciphertext) + "?iv=" + base64.StdEncoding.EncodeToString(iv)
+	}
+
+	valid := append([]byte("hello world!"), 4, 4, 4, 4)
+	if msg, err := bob.Decrypt(alice.PublicKey(), encrypt(valid)); err != nil || msg != "hello world!" {
+		t.Fatalf("valid padding was rejected: %v", err)
+	}
+	for _, plaintext := range [][]byte{
+		append([]byte("hello world!"), 4, 4, 3, 4),
+		append([]byte("hello world!"), 1, 4, 4, 4),
+		append([]byte("hello world!!!!"), 0),
+		append([]byte("hello world!!!!"), 17),
+	} {
+		_, err := bob.Decrypt(alice.PublicKey(), encrypt(plaintext))
+		if err != InvalidPaddingError {
+			t.Fatalf("expected invalid padding for %v, got %v", plaintext, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package nostr
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	relayDialTimeout = time.Second * 10
+	// relays of a single publish that aren't part of the pool
+	maxExtraRelays = 5
+)
+
+var (
+	// addresses that relays of untrusted events may not resolve to, besides
+	// loopback, link-local, multicast and unspecified addresses
+	privateNetworks = mustParseCIDRs(
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"fc00::/7",
+	)
+
+	// publicDialer only connects to public addresses, so events from
+	// untrusted sources can't make the bot connect to internal services
+	// through hostnames that resolve to them.
+	publicDialer = &websocket.Dialer{
+		NetDialContext: (&net.Dialer{
+			Control: func(network, address string, conn syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return fmt.Errorf("relay address %v isn't public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		HandshakeTimeout: relayDialTimeout,
+	}
+)
+
+// Publisher publishes events to relays.
+type Publisher interface {
+	// Publish sends the event to the relays of the publisher and the extra
+	// relays, it succeeds if at least one relay accepted the event.
+	Publish(ctx context.Context, ev *Event, extra ...string) error
+}
+
+// RelayPool keeps connections to a set of relays and reconnects to relays
+// whose connection was lost.
+type RelayPool struct {
+	urls   []string
+	relays map[string]*Relay
+	sync.Mutex
+}
+
+func NewRelayPool(urls []string) *RelayPool {
+	return &RelayPool{urls: urls, relays: make(map[string]*Relay)}
+}
+
+func (pool *RelayPool) Publish(ctx context.Context, ev *Event, extra ...string) error {
+	targets := make(map[string]bool)
+	for _, u := range pool.urls {
+		targets[u] = true
+	}
+	added := 0
+	for _, u := range extra {
+		if added >= maxExtraRelays || targets[u] || !IsPublicRelayUrl(u) {
+			continue
+		}
+		targets[u] = false
+		added++
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no relays to publish to")
+	}
+	errs := make(chan error, len(targets))
+	for u, pooled := range targets {
+		go func(u string, pooled bool) {
+			errs <- pool.publish(ctx, u, pooled, ev)
+		}(u, pooled)
+	}
+	var failed []string
+	for range targets {
+		if err := <-errs; err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) == len(targets) {
+		return fmt.Errorf("unable to publish event %v: %v", ev.Id, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// publish sends the event to a relay, connections to relays that aren't part
+// of the pool are closed afterwards.
+func (pool *RelayPool) publish(ctx context.Context, u string, pooled bool, ev *Event) error {
+	relay, err := pool.relay(ctx, u, pooled)
+	if err != nil {
+		return fmt.Errorf("%v: %v", u, err)
+	}
+	if !pooled {
+		defer relay.Close()
+	}
+	err = relay.Publish(ctx, ev)
+	if err != nil {
+		return fmt.Errorf("%v: %v", u, err)
+	}
+	return nil
+}
+
+func (pool *RelayPool) relay(ctx context.Context, u string, pooled bool) (*Relay, error) {
+	if pooled {
+		pool.Lock()
+		relay, ok := pool.relays[u]
+		pool.Unlock()
+		if ok && !relay.IsClosed() {
+			return relay, nil
+		}
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, relayDialTimeout)
+	defer cancel()
+	dialer := websocket.DefaultDialer
+	if !pooled {
+		dialer = publicDialer
+	}
+	relay, err := connectRelay(dialCtx, dialer, u)
+	if err != nil {
+		return nil, err
+	}
+	if pooled {
+		pool.Lock()
+		if existing, ok := pool.relays[u]; ok && !existing.IsClosed() {
+			// connected concurrently
+			pool.Unlock()
+			relay.Close()
+			return existing, nil
+		}
+		pool.relays[u] = relay
+		pool.Unlock()
+	}
+	return relay, nil
+}
+
+// Close closes the connections of the pool.
+func (pool *RelayPool) Close() {
+	pool.Lock()
+	defer pool.Unlock()
+	for u, relay := range pool.relays {
+		relay.Close()
+		delete(pool.relays, u)
+	}
+}
+
+// IsRelayUrl checks whether the url is a websocket url.
+func IsRelayUrl(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "wss" || parsed.Scheme == "ws") && parsed.Host != ""
+}
+
+// IsPublicRelayUrl checks whether the url is a wss url whose host isn't
+// local or an address of a private network. Hostnames are checked again when
+// the relay is dialed.
+func IsPublicRelayUrl(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme != "wss" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return isPublicIP(ip)
+	}
+	return true
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,120 @@
+package nostr
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIsPublicRelayUrl(t *testing.T) {
+	for u, public := range map[string]bool{
+		"wss://relay.example.com":      true,
+		"wss://relay.example.com:4433": true,
+		"wss://1.1.1.1":                true,
+		"wss://[2606:4700::1111]":      true,
+		"ws://relay.example.com":       false,
+		"https://relay.example.com":    false,
+		"wss://":                       false,
+		"not a url":                    false,
+		"wss://localhost":              false,
+		"wss://LOCALHOST.:7000":        false,
+		"wss://relay.localhost":        false,
+		"wss://127.0.0.1":              false,
+		"wss://10.1.2.3":               false,
+		"wss://100.64.0.1":             false,
+		"wss://172.16.0.1":             false,
+		"wss://192.168.178.1":          false,
+		"wss://169.254.169.254":        false,
+		"wss://0.0.0.0":                false,
+		"wss://224.0.0.1":              false,
+		"wss://[::1]":                  false,
+		"wss://[::]":                   false,
+		"wss://[fe80::1]":              false,
+		"wss://[fd12:3456::1]":         false,
+		"wss://[::ffff:127.0.0.1]":     false,
+	} {
+		if IsPublicRelayUrl(u) != public {
+			t.Errorf("expected IsPublicRelayUrl(%q) to be %v", u, public)
+		}
+	}
+}
+
+// testRelay counts the connections of a local websocket server.
+type testRelay struct {
+	server      *httptest.Server
+	connections int
+	sync.Mutex
+}
+
+func newTestRelay(t *testing.T) *testRelay {
+	relay := &testRelay{}
+	upgrader := websocket.Upgrader{}
+	relay.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relay.Lock()
+		relay.connections++
+		relay.Unlock()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(relay.server.Close)
+	return relay
+}
+
+func (relay *testRelay) url() string {
+	return "ws" + strings.TrimPrefix(relay.server.URL, "http")
+}
+
+func TestPublishSkipsLocalRelays(t *testing.T) {
+	ctx := context.Background()
+	relay := newTestRelay(t)
+	key, err := NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := NewEvent(1, "hello")
+	err = ev.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := strings.Replace(relay.url(), "ws://", "wss://", 1)
+	pool := NewRelayPool(nil)
+	defer pool.Close()
+	if err := pool.Publish(ctx, ev, relay.url(), local); err == nil {
+		t.Fatal("publishing to local relays succeeded")
+	}
+	relay.Lock()
+	defer relay.Unlock()
+	if relay.connections != 0 {
+		t.Fatalf("connected to a local relay %v times", relay.connections)
+	}
+}
+
+func TestPublicDialer(t *testing.T) {
+	ctx := context.Background()
+	relay := newTestRelay(t)
+	// configured relays may be local
+	conn, err := ConnectRelay(ctx, relay.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	// relays of untrusted events may not, even behind a hostname
+	port := relay.server.URL[strings.LastIndex(relay.server.URL, ":"):]
+	for _, u := range []string{relay.url(), "ws://localhost" + port} {
+		if _, err := connectRelay(ctx, publicDialer, u); err == nil || !strings.Contains(err.Error(), "isn't public") {
+			t.Fatalf("expected the public dialer to refuse %v, got %v", u, err)
+		}
+	}
+}
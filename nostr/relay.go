@@ -0,0 +1,219 @@
+package nostr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+var (
+	RelayClosedError = fmt.Errorf("relay connection closed")
+)
+
+// Relay is a websocket connection to a nostr relay.
+type Relay struct {
+	Url  string
+	conn *websocket.Conn
+
+	subs     map[string]chan *Event
+	oks      map[string]chan error
+	closed   chan struct{}
+	writeMtx sync.Mutex
+	sync.Mutex
+}
+
+type Subscription struct {
+	Id     string
+	Events <-chan *Event
+	relay  *Relay
+}
+
+// ConnectRelay opens a websocket connection to the relay.
+func ConnectRelay(ctx context.Context, url string) (*Relay, error) {
+	return connectRelay(ctx, websocket.DefaultDialer, url)
+}
+
+func connectRelay(ctx context.Context, dialer *websocket.Dialer, url string) (*Relay, error) {
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	relay := &Relay{
+		Url:    url,
+		conn:   conn,
+		subs:   make(map[string]chan *Event),
+		oks:    make(map[string]chan error),
+		closed: make(chan struct{}),
+	}
+	go relay.readLoop()
+	return relay, nil
+}
+
+// Publish sends the event to the relay and waits for it to be accepted.
+func (r *Relay) Publish(ctx context.Context, ev *Event) error {
+	okChan := make(chan error, 1)
+	r.Lock()
+	r.oks[ev.Id] = okChan
+	r.Unlock()
+	defer func() {
+		r.Lock()
+		delete(r.oks, ev.Id)
+		r.Unlock()
+	}()
+	err := r.write([]interface{}{"EVENT", ev})
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-okChan:
+		return err
+	case <-r.closed:
+		return RelayClosedError
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribe requests events matching the filters. Events are delivered until
+// the subscription is closed.
+func (r *Relay) Subscribe(ctx context.Context, filters ...Filter) (*Subscription, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+	events := make(chan *Event, 16)
+	r.Lock()
+	r.subs[id] = events
+	r.Unlock()
+	req := []interface{}{"REQ", id}
+	for _, filter := range filters {
+		req = append(req, filter)
+	}
+	err := r.write(req)
+	if err != nil {
+		r.Lock()
+		delete(r.subs, id)
+		r.Unlock()
+		return nil, err
+	}
+	return &Subscription{Id: id, Events: events, relay: r}, nil
+}
+
+// Close stops the subscription.
+func (sub *Subscription) Close() error {
+	sub.relay.Lock()
+	delete(sub.relay.subs, sub.Id)
+	sub.relay.Unlock()
+	return sub.relay.write([]interface{}{"CLOSE", sub.Id})
+}
+
+// IsClosed returns whether the connection to the relay has been lost.
+func (r *Relay) IsClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the connection to the relay.
+func (r *Relay) Close() error {
+	return r.conn.Close()
+}
+
+func (r *Relay) write(msg interface{}) error {
+	r.writeMtx.Lock()
+	defer r.writeMtx.Unlock()
+	return r.conn.WriteJSON(msg)
+}
+
+func (r *Relay) readLoop() {
+	defer close(r.closed)
+	for {
+		var msg []json.RawMessage
+		err := r.conn.ReadJSON(&msg)
+		if err != nil {
+			return
+		}
+		if len(msg) < 2 {
+			continue
+		}
+		var msgType string
+		if err := json.Unmarshal(msg[0], &msgType); err != nil {
+			continue
+		}
+		switch msgType {
+		case "EVENT":
+			r.handleEvent(msg)
+		case "OK":
+			r.handleOk(msg)
+		case "NOTICE":
+			var notice string
+			json.Unmarshal(msg[1], &notice)
+			fmt.Printf("notice from relay %v: %v \n", r.Url, notice)
+		}
+	}
+}
+
+func (r *Relay) handleEvent(msg []json.RawMessage) {
+	if len(msg) != 3 {
+		return
+	}
+	var subId string
+	if err := json.Unmarshal(msg[1], &subId); err != nil {
+		return
+	}
+	ev := &Event{}
+	if err := json.Unmarshal(msg[2], ev); err != nil {
+		return
+	}
+	if err := ev.Verify(); err != nil {
+		fmt.Printf("invalid event from relay %v: %v \n", r.Url, err)
+		return
+	}
+	r.Lock()
+	events, ok := r.subs[subId]
+	r.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case events <- ev:
+	default:
+		fmt.Printf("dropped event %v from relay %v \n", ev.Id, r.Url)
+	}
+}
+
+func (r *Relay) handleOk(msg []json.RawMessage) {
+	if len(msg) < 3 {
+		return
+	}
+	var id, reason string
+	var accepted bool
+	if err := json.Unmarshal(msg[1], &id); err != nil {
+		return
+	}
+	if err := json.Unmarshal(msg[2], &accepted); err != nil {
+		return
+	}
+	if len(msg) > 3 {
+		json.Unmarshal(msg[3], &reason)
+	}
+	r.Lock()
+	okChan, ok := r.oks[id]
+	r.Unlock()
+	if !ok {
+		return
+	}
+	if accepted {
+		okChan <- nil
+	} else {
+		okChan <- fmt.Errorf("event rejected by relay %v: %v", r.Url, reason)
+	}
+}
@@ -0,0 +1,187 @@
+package nostr
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// PrivateKey is a secp256k1 key used to sign nostr events with BIP-340
+// schnorr signatures. Scalar and field arithmetic use the constant time types
+// of the secp256k1 package that btcec is built on.
+type PrivateKey struct {
+	key *secp256k1.PrivateKey
+}
+
+// NewPrivateKey creates a random private key.
+func NewPrivateKey() (*PrivateKey, error) {
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{key: key}, nil
+}
+
+// PrivateKeyFromHex parses a hex encoded private key.
+func PrivateKeyFromHex(str string) (*PrivateKey, error) {
+	keyBytes, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyBytes) != 32 {
+		return nil, fmt.Errorf("invalid private key length %v", len(keyBytes))
+	}
+	var d secp256k1.ModNScalar
+	if overflow := d.SetByteSlice(keyBytes); overflow || d.IsZero() {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	return &PrivateKey{key: secp256k1.NewPrivateKey(&d)}, nil
+}
+
+// Hex returns the hex encoded private key.
+func (key *PrivateKey) Hex() string {
+	return hex.EncodeToString(key.key.Serialize())
+}
+
+// PublicKey returns the hex encoded x-only public key.
+func (key *PrivateKey) PublicKey() string {
+	return hex.EncodeToString(key.key.PubKey().SerializeCompressed()[1:])
+}
+
+// Sign creates a BIP-340 schnorr signature over a 32 byte message, using
+// fresh auxiliary randomness for the nonce.
+func (key *PrivateKey) Sign(msg []byte) ([]byte, error) {
+	var aux [32]byte
+	if _, err := rand.Read(aux[:]); err != nil {
+		return nil, err
+	}
+	return key.signWithAux(msg, aux[:])
+}
+
+func (key *PrivateKey) signWithAux(msg []byte, aux []byte) ([]byte, error) {
+	if len(msg) != 32 {
+		return nil, fmt.Errorf("invalid message length %v", len(msg))
+	}
+	d := key.key.Key
+	defer d.Zero()
+	pubBytes := key.key.PubKey().SerializeCompressed()
+	if pubBytes[0] == secp256k1.PubKeyFormatCompressedOdd {
+		d.Negate()
+	}
+	px := pubBytes[1:]
+
+	// k = tagged_hash("BIP0340/nonce", (d xor tagged_hash("BIP0340/aux", a)) || P || m)
+	t := taggedHash("BIP0340/aux", aux)
+	dBytes := d.Bytes()
+	for i := range t {
+		t[i] ^= dBytes[i]
+	}
+	var k secp256k1.ModNScalar
+	k.SetByteSlice(taggedHash("BIP0340/nonce", t, px, msg))
+	defer k.Zero()
+	if k.IsZero() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	var R secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&k, &R)
+	R.ToAffine()
+	if R.Y.IsOdd() {
+		k.Negate()
+	}
+	var rx [32]byte
+	R.X.PutBytes(&rx)
+
+	// s = k + e*d mod n
+	var e secp256k1.ModNScalar
+	e.SetByteSlice(taggedHash("BIP0340/challenge", rx[:], px, msg))
+	s := new(secp256k1.ModNScalar).Mul2(&e, &d).Add(&k)
+	sBytes := s.Bytes()
+	sig := append(rx[:], sBytes[:]...)
+
+	// guard against faults that would leak the key
+	pub, err := liftX(px)
+	if err != nil || !verify(pub, msg, sig) {
+		return nil, fmt.Errorf("created invalid signature")
+	}
+	return sig, nil
+}
+
+// Verify checks a BIP-340 schnorr signature of a hex encoded x-only public key.
+func Verify(pubkey string, msg []byte, sig []byte) (bool, error) {
+	if len(sig) != 64 {
+		return false, fmt.Errorf("invalid signature length %v", len(sig))
+	}
+	pub, err := parsePublicKey(pubkey)
+	if err != nil {
+		return false, err
+	}
+	return verify(pub, msg, sig), nil
+}
+
+// verify checks a signature of a public key with even y coordinate.
+func verify(pub *secp256k1.PublicKey, msg []byte, sig []byte) bool {
+	if len(msg) != 32 {
+		return false
+	}
+	var r secp256k1.FieldVal
+	if overflow := r.SetByteSlice(sig[:32]); overflow {
+		return false
+	}
+	var s secp256k1.ModNScalar
+	if overflow := s.SetByteSlice(sig[32:]); overflow {
+		return false
+	}
+
+	// R = s*G - e*P
+	var e secp256k1.ModNScalar
+	e.SetByteSlice(taggedHash("BIP0340/challenge", sig[:32], pub.SerializeCompressed()[1:], msg))
+	e.Negate()
+	var P, R, sG, eP secp256k1.JacobianPoint
+	pub.AsJacobian(&P)
+	secp256k1.ScalarBaseMultNonConst(&s, &sG)
+	secp256k1.ScalarMultNonConst(&e, &P, &eP)
+	secp256k1.AddNonConst(&sG, &eP, &R)
+	if (R.X.IsZero() && R.Y.IsZero()) || R.Z.IsZero() {
+		return false
+	}
+	R.ToAffine()
+	return !R.Y.IsOdd() && r.Equals(&R.X)
+}
+
+// parsePublicKey returns the point with even y coordinate for a hex encoded
+// x-only public key.
+func parsePublicKey(pubkey string) (*secp256k1.PublicKey, error) {
+	xBytes, err := hex.DecodeString(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	if len(xBytes) != 32 {
+		return nil, fmt.Errorf("invalid public key length %v", len(xBytes))
+	}
+	return liftX(xBytes)
+}
+
+func liftX(xBytes []byte) (*secp256k1.PublicKey, error) {
+	var x, y secp256k1.FieldVal
+	if overflow := x.SetByteSlice(xBytes); overflow {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	if !secp256k1.DecompressY(&x, false, &y) {
+		return nil, fmt.Errorf("public key is not on the curve")
+	}
+	y.Normalize()
+	return secp256k1.NewPublicKey(&x, &y), nil
+}
+
+func taggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,103 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Coingecko uses the simple price api of coingecko.
+type Coingecko struct {
+	client  *http.Client
+	baseUrl string
+}
+
+func NewCoingecko() *Coingecko {
+	return &Coingecko{client: &http.Client{Timeout: time.Second * 10}, baseUrl: "https://api.coingecko.com/api/v3"}
+}
+
+func (p *Coingecko) Rate(ctx context.Context, currency string) (*Rate, error) {
+	currency = strings.ToUpper(currency)
+	res := make(map[string]map[string]float64)
+	err := getJson(ctx, p.client, p.baseUrl+"/simple/price?ids=bitcoin&vs_currencies="+url.QueryEscape(strings.ToLower(currency)), &res)
+	if err != nil {
+		return nil, err
+	}
+	price, ok := res["bitcoin"][strings.ToLower(currency)]
+	if !ok {
+		return nil, UnsupportedCurrencyError
+	}
+	if err := validPrice(price); err != nil {
+		return nil, err
+	}
+	return &Rate{Currency: currency, Price: price, Source: "coingecko", Timestamp: time.Now().Unix()}, nil
+}
+
+// Kraken uses the public ticker of the kraken exchange, which only supports
+// the currencies that are traded there.
+type Kraken struct {
+	client  *http.Client
+	baseUrl string
+}
+
+func NewKraken() *Kraken {
+	return &Kraken{client: &http.Client{Timeout: time.Second * 10}, baseUrl: "https://api.kraken.com/0/public"}
+}
+
+func (p *Kraken) Rate(ctx context.Context, currency string) (*Rate, error) {
+	currency = strings.ToUpper(currency)
+	res := &struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			// last trade closed, price and lot volume
+			Close []string `json:"c"`
+		} `json:"result"`
+	}{}
+	err := getJson(ctx, p.client, p.baseUrl+"/Ticker?pair="+url.QueryEscape("XBT"+currency), res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Error) != 0 {
+		return nil, UnsupportedCurrencyError
+	}
+	for _, ticker := range res.Result {
+		if len(ticker.Close) == 0 {
+			break
+		}
+		price, err := strconv.ParseFloat(ticker.Close[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		if err = validPrice(price); err != nil {
+			return nil, err
+		}
+		return &Rate{Currency: currency, Price: price, Source: "kraken", Timestamp: time.Now().Unix()}, nil
+	}
+	return nil, UnsupportedCurrencyError
+}
+
+func getJson(ctx context.Context, client *http.Client, url string, res interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v returned %v: %s", req.URL.Host, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, res)
+}
@@ -0,0 +1,75 @@
+package rates
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoingecko(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		switch r.URL.Query().Get("vs_currencies") {
+		case "usd":
+			w.Write([]byte(`{"bitcoin": {"usd": 50000}}`))
+		case "zero":
+			w.Write([]byte(`{"bitcoin": {"zero": 0}}`))
+		default:
+			w.Write([]byte(`{"bitcoin": {}}`))
+		}
+	}))
+	defer server.Close()
+	provider := NewCoingecko()
+	provider.baseUrl = server.URL
+
+	rate, err := provider.Rate(context.Background(), "usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Currency != "USD" || rate.Price != 50000 || rate.Source != "coingecko" {
+		t.Fatalf("unexpected rate %+v", rate)
+	}
+	if _, err := provider.Rate(context.Background(), "zero"); err != InvalidPriceError {
+		t.Fatalf("expected invalid price, got %v", err)
+	}
+	// currencies come from donors and must not add query parameters
+	_, err = provider.Rate(context.Background(), "usd&ids=ethereum")
+	if err != UnsupportedCurrencyError {
+		t.Fatalf("expected unsupported currency, got %v", err)
+	}
+	if query != "ids=bitcoin&vs_currencies=usd%26ids%3Dethereum" {
+		t.Fatalf("currency wasn't escaped: %v", query)
+	}
+}
+
+func TestKraken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("pair") {
+		case "XBTUSD":
+			w.Write([]byte(`{"error": [], "result": {"XXBTZUSD": {"c": ["50000.1", "0.01"]}}}`))
+		case "XBTZERO":
+			w.Write([]byte(`{"error": [], "result": {"XBTZERO": {"c": ["0", "0.01"]}}}`))
+		default:
+			w.Write([]byte(`{"error": ["EQuery:Unknown asset pair"]}`))
+		}
+	}))
+	defer server.Close()
+	provider := NewKraken()
+	provider.baseUrl = server.URL
+
+	rate, err := provider.Rate(context.Background(), "usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Currency != "USD" || rate.Price != 50000.1 || rate.Source != "kraken" {
+		t.Fatalf("unexpected rate %+v", rate)
+	}
+	if _, err := provider.Rate(context.Background(), "zero"); err != InvalidPriceError {
+		t.Fatalf("expected invalid price, got %v", err)
+	}
+	if _, err := provider.Rate(context.Background(), "GBP"); err != UnsupportedCurrencyError {
+		t.Fatalf("expected unsupported currency, got %v", err)
+	}
+}
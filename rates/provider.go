@@ -0,0 +1,112 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	UnsupportedCurrencyError = fmt.Errorf("currency is not supported by the rate provider")
+	UnknownProviderError     = fmt.Errorf("unknown rate provider")
+	DisabledError            = fmt.Errorf("exchange rates are disabled")
+	InvalidPriceError        = fmt.Errorf("rate provider returned an invalid price")
+)
+
+// Rate is the price of one bitcoin in a fiat currency.
+type Rate struct {
+	Currency  string
+	Price     float64
+	Source    string
+	Timestamp int64
+}
+
+// Provider returns exchange rates for fiat currencies.
+type Provider interface {
+	Rate(ctx context.Context, currency string) (*Rate, error)
+}
+
+// ToSats converts a fiat amount to satoshis, it returns 0 for rates without a
+// valid price.
+func (r *Rate) ToSats(fiat float64) int64 {
+	if r.Price <= 0 || math.IsNaN(r.Price) || math.IsInf(r.Price, 0) {
+		return 0
+	}
+	return int64(math.Round(fiat / r.Price * 1e8))
+}
+
+// ToFiat converts satoshis to a fiat amount.
+func (r *Rate) ToFiat(sats int64) float64 {
+	return float64(sats) / 1e8 * r.Price
+}
+
+// New returns the provider with the given name. Static providers take their
+// rates from the source, which is either a list like "USD:50000,EUR:42000" or
+// a json file mapping currencies to prices. Without a name rates are disabled,
+// as the price apis are only queried when the operator opts in.
+func New(name string, source string) (Provider, error) {
+	switch name {
+	case "":
+		return Disabled{}, nil
+	case "coingecko":
+		return NewCoingecko(), nil
+	case "kraken":
+		return NewKraken(), nil
+	case "static":
+		return ParseStatic(source)
+	case "file":
+		return NewFile(source), nil
+	default:
+		return nil, UnknownProviderError
+	}
+}
+
+// Disabled is the provider if no rate provider is configured.
+type Disabled struct{}
+
+func (Disabled) Rate(ctx context.Context, currency string) (*Rate, error) {
+	return nil, DisabledError
+}
+
+// validPrice rejects prices that can't be converted with.
+func validPrice(price float64) error {
+	if price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		return InvalidPriceError
+	}
+	return nil
+}
+
+// CachedProvider caches the rates of a provider, so that price apis are not
+// queried on every invoice.
+type CachedProvider struct {
+	provider Provider
+	ttl      time.Duration
+
+	rates map[string]*Rate
+	sync.Mutex
+}
+
+func NewCachedProvider(provider Provider, ttl time.Duration) *CachedProvider {
+	return &CachedProvider{provider: provider, ttl: ttl, rates: make(map[string]*Rate)}
+}
+
+func (p *CachedProvider) Rate(ctx context.Context, currency string) (*Rate, error) {
+	currency = strings.ToUpper(currency)
+	p.Lock()
+	rate, ok := p.rates[currency]
+	p.Unlock()
+	if ok && time.Since(time.Unix(rate.Timestamp, 0)) < p.ttl {
+		return rate, nil
+	}
+	rate, err := p.provider.Rate(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	p.rates[currency] = rate
+	p.Unlock()
+	return rate, nil
+}
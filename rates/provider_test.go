@@ -0,0 +1,84 @@
+package rates
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestToSats(t *testing.T) {
+	rate := &Rate{Currency: "USD", Price: 50000}
+	if sats := rate.ToSats(5); sats != 10000 {
+		t.Fatalf("expected 10000 sats, got %v", sats)
+	}
+	if fiat := rate.ToFiat(10000); fiat != 5 {
+		t.Fatalf("expected 5 USD, got %v", fiat)
+	}
+	for _, price := range []float64{0, -50000} {
+		rate = &Rate{Currency: "USD", Price: price}
+		if sats := rate.ToSats(5); sats != 0 {
+			t.Fatalf("price %v converted to %v sats", price, sats)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	provider, err := New("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Rate(context.Background(), "USD"); err != DisabledError {
+		t.Fatalf("expected disabled rates, got %v", err)
+	}
+	if _, err := New("unknown", ""); err != UnknownProviderError {
+		t.Fatalf("expected unknown provider, got %v", err)
+	}
+	provider, err = New("static", "usd:50000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := provider.Rate(context.Background(), "USD"); err != nil || rate.Price != 50000 {
+		t.Fatalf("unexpected static rate %+v: %v", rate, err)
+	}
+}
+
+type countingProvider struct {
+	calls int
+	sync.Mutex
+}
+
+func (p *countingProvider) Rate(ctx context.Context, currency string) (*Rate, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.calls++
+	return &Rate{Currency: currency, Price: float64(p.calls), Timestamp: time.Now().Unix()}, nil
+}
+
+func TestCachedProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := &countingProvider{}
+	cached := NewCachedProvider(provider, time.Minute)
+	for i := 0; i < 3; i++ {
+		rate, err := cached.Rate(ctx, "usd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate.Price != 1 || rate.Currency != "USD" {
+			t.Fatalf("rate wasn't cached: %+v", rate)
+		}
+	}
+	if _, err := cached.Rate(ctx, "EUR"); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 2 {
+		t.Fatalf("expected 2 calls to the provider, got %v", provider.calls)
+	}
+
+	expired := NewCachedProvider(provider, 0)
+	first, _ := expired.Rate(ctx, "USD")
+	second, _ := expired.Rate(ctx, "USD")
+	if first.Price == second.Price {
+		t.Fatal("expired rate was served from the cache")
+	}
+}
@@ -0,0 +1,77 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Static returns fixed rates, it is meant for tests and regtest setups.
+type Static map[string]float64
+
+// ParseStatic parses a list of rates like "USD:50000,EUR:42000".
+func ParseStatic(list string) (Static, error) {
+	static := make(Static)
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid rate %v, expected CURRENCY:PRICE", entry)
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate %v: %v", entry, err)
+		}
+		if err = validPrice(price); err != nil {
+			return nil, fmt.Errorf("invalid rate %v: %v", entry, err)
+		}
+		static[strings.ToUpper(strings.TrimSpace(parts[0]))] = price
+	}
+	return static, nil
+}
+
+func (s Static) Rate(ctx context.Context, currency string) (*Rate, error) {
+	currency = strings.ToUpper(currency)
+	price, ok := s[currency]
+	if !ok || price <= 0 {
+		return nil, UnsupportedCurrencyError
+	}
+	return &Rate{Currency: currency, Price: price, Source: "static", Timestamp: time.Now().Unix()}, nil
+}
+
+// File reads the rates from a json file mapping currencies to prices on every
+// request, so the file can be changed while the bot is running.
+type File struct {
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Rate(ctx context.Context, currency string) (*Rate, error) {
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	static := make(Static)
+	err = json.Unmarshal(content, &static)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse rate file: %v", err)
+	}
+	for cur, price := range static {
+		static[strings.ToUpper(cur)] = price
+	}
+	rate, err := static.Rate(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	rate.Source = "file"
+	return rate, nil
+}
@@ -0,0 +1,79 @@
+package rates
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseStatic(t *testing.T) {
+	static, err := ParseStatic(" usd:50000, EUR:42000.5 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(static) != 2 || static["USD"] != 50000 || static["EUR"] != 42000.5 {
+		t.Fatalf("unexpected rates %v", static)
+	}
+	for _, list := range []string{"USD", "USD:abc", "USD:0", "USD:-1", "USD:NaN"} {
+		if _, err := ParseStatic(list); err == nil {
+			t.Fatalf("invalid rates %v were accepted", list)
+		}
+	}
+}
+
+func TestStaticRate(t *testing.T) {
+	ctx := context.Background()
+	static := Static{"USD": 50000, "EUR": 0}
+	rate, err := static.Rate(ctx, "usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Currency != "USD" || rate.Price != 50000 || rate.Source != "static" {
+		t.Fatalf("unexpected rate %+v", rate)
+	}
+	for _, currency := range []string{"GBP", "EUR"} {
+		if _, err := static.Rate(ctx, currency); err != UnsupportedCurrencyError {
+			t.Fatalf("expected unsupported currency for %v, got %v", currency, err)
+		}
+	}
+}
+
+func TestFileRate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rates.json")
+	file := NewFile(path)
+	if _, err := file.Rate(ctx, "USD"); err == nil {
+		t.Fatal("missing file returned a rate")
+	}
+
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"usd": 50000}`)
+	rate, err := file.Rate(ctx, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Price != 50000 || rate.Source != "file" {
+		t.Fatalf("unexpected rate %+v", rate)
+	}
+	// the file is read again on every request
+	write(`{"USD": 60000}`)
+	rate, err = file.Rate(ctx, "usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Price != 60000 {
+		t.Fatalf("changed file wasn't read, price is %v", rate.Price)
+	}
+	if _, err := file.Rate(ctx, "EUR"); err != UnsupportedCurrencyError {
+		t.Fatalf("expected unsupported currency, got %v", err)
+	}
+	write(`{"USD": "a lot"}`)
+	if _, err := file.Rate(ctx, "USD"); err == nil {
+		t.Fatal("invalid file returned a rate")
+	}
+}
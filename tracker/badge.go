@@ -0,0 +1,109 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	badgePath = "/badge"
+
+	badgeLabel = "⚡ bounty"
+	badgeColor = "#f7931a"
+	// approximate width of a character of the badge font and the padding
+	// around each text
+	badgeCharWidth = 7
+	badgePadding   = 10
+)
+
+// handleBadge renders a shields style svg badge with the live total of a
+// bounty, or of all active bounties of a repository.
+func (wh *WebhookHandler) handleBadge(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	var sats int64
+	switch {
+	case query.Get(issueidkey) != "":
+		issueId, err := strconv.ParseInt(query.Get(issueidkey), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+			return
+		}
+		bountyIssue, err := wh.is.GetBountyIssue(r.Context(), issueId)
+		if err == ErrDoesNotExist {
+			writeError(w, http.StatusNotFound, "bounty not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+			return
+		}
+		sats = bountyIssue.Bounty
+	case query.Get(repokey) != "":
+		names := strings.Split(query.Get(repokey), "/")
+		if len(names) != 2 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, %s must be owner/repo", repokey))
+			return
+		}
+		var err error
+		sats, err = wh.is.RepoBountyTotal(r.Context(), names[0], names[1])
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, require %s or %s", issueidkey, repokey))
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// GitHub proxies images, the badge should not be cached for long
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	w.Write(badgeSvg(badgeLabel, formatThousands(sats)+" sats"))
+}
+
+// RepoBountyTotal returns the total of all active bounties of a repository.
+func (srv *IssueService) RepoBountyTotal(ctx context.Context, owner string, name string) (int64, error) {
+	bountyIssues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var sats int64
+	for _, bountyIssue := range bountyIssues {
+		if bountyIssue.Active && bountyIssue.Owner == owner && bountyIssue.Repo == name {
+			sats += bountyIssue.Bounty
+		}
+	}
+	return sats, nil
+}
+
+func badgeSvg(label string, value string) []byte {
+	labelWidth := utf8.RuneCountInString(label)*badgeCharWidth + badgePadding
+	valueWidth := utf8.RuneCountInString(value)*badgeCharWidth + badgePadding
+	width := labelWidth + valueWidth
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="14">%[4]s</text><text x="%[8]d" y="14">%[5]s</text></g></svg>`,
+		width, labelWidth, valueWidth, label, value, badgeColor, labelWidth/2, labelWidth+valueWidth/2))
+}
+
+// formatThousands formats an amount with thousands separators, like 25,000.
+func formatThousands(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s
+}
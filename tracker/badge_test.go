@@ -0,0 +1,130 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve calls a handler with a GET request of the target.
+func serve(handle func(http.ResponseWriter, *http.Request), target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handle(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// svgTexts checks that the svg is well formed and returns its texts.
+func svgTexts(t *testing.T, svg []byte) []string {
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	var texts []string
+	var inText bool
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return texts
+		}
+		if err != nil {
+			t.Fatalf("invalid svg %s: %v", svg, err)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if token.Name.Local == "svg" && token.Name.Space != "http://www.w3.org/2000/svg" {
+				t.Fatalf("svg has namespace %q", token.Name.Space)
+			}
+			inText = token.Name.Local == "text"
+		case xml.CharData:
+			if inText {
+				texts = append(texts, string(token))
+			}
+		case xml.EndElement:
+			inText = false
+		}
+	}
+}
+
+func TestBadgeSvg(t *testing.T) {
+	texts := svgTexts(t, badgeSvg(badgeLabel, "21,000 sats"))
+	if len(texts) != 2 || texts[0] != badgeLabel || texts[1] != "21,000 sats" {
+		t.Fatalf("unexpected badge texts %q", texts)
+	}
+	// the label is escaped
+	texts = svgTexts(t, badgeSvg(`<script>"&"</script>`, "1 sats"))
+	if texts[0] != `<script>"&"</script>` {
+		t.Fatalf("unexpected label %q", texts[0])
+	}
+	// the width follows the length of the texts
+	for value, width := range map[string]string{"1 sats": `width="118"`, "1,000,000 sats": `width="174"`} {
+		if svg := badgeSvg(badgeLabel, value); !bytes.HasPrefix(svg, []byte(`<svg xmlns="http://www.w3.org/2000/svg" `+width)) {
+			t.Fatalf("expected %v for %v, got %s", width, value, svg[:80])
+		}
+	}
+}
+
+func TestFormatThousands(t *testing.T) {
+	for n, expected := range map[int64]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1,000",
+		21000:    "21,000",
+		1234567:  "1,234,567",
+		-1000:    "-1,000",
+		-100:     "-100",
+		-1234567: "-1,234,567",
+	} {
+		if s := formatThousands(n); s != expected {
+			t.Errorf("expected %v to be formatted as %v, got %v", n, expected, s)
+		}
+	}
+}
+
+func TestHandleBadge(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	handle := func(w http.ResponseWriter, r *http.Request) { wh.handleBadge(w, r, nil) }
+	ts.addIssue(t, 1)
+	ts.creditedInvoice(t, 1, 21000)
+	ts.addIssue(t, 2)
+	ts.creditedInvoice(t, 2, 4000)
+	ts.addIssue(t, 3)
+	ts.creditedInvoice(t, 3, 5000)
+	err := ts.CloseIssue(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, value := range map[string]string{
+		badgePath + "?issue_id=1":        "21,000 sats",
+		badgePath + "?issue_id=3":        "5,000 sats",
+		badgePath + "?repo=owner/repo":   "25,000 sats",
+		badgePath + "?repo=other/repo":   "0 sats",
+		badgePath + "?issue_id=1&repo=x": "21,000 sats",
+	} {
+		rec := serve(handle, target)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+			t.Fatalf("unexpected response %v %v for %v", rec.Code, rec.Header().Get("Content-Type"), target)
+		}
+		if !strings.Contains(rec.Header().Get("Cache-Control"), "no-cache") {
+			t.Fatalf("badge may be cached: %v", rec.Header().Get("Cache-Control"))
+		}
+		if texts := svgTexts(t, rec.Body.Bytes()); len(texts) != 2 || texts[1] != value {
+			t.Fatalf("expected %v for %v, got %q", value, target, texts)
+		}
+	}
+
+	for target, code := range map[string]int{
+		badgePath:                   http.StatusBadRequest,
+		badgePath + "?issue_id=abc": http.StatusBadRequest,
+		badgePath + "?issue_id=9":   http.StatusNotFound,
+		badgePath + "?repo=owner":   http.StatusBadRequest,
+	} {
+		if rec := serve(handle, target); rec.Code != code {
+			t.Fatalf("expected %v for %v, got %v", code, target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	boardPath = "/board"
+
+	statekey = "state"
+	sortkey  = "sort"
+	minkey   = "min"
+	querykey = "q"
+)
+
+// BoardData is passed to the bounty board page of an owner or a repository.
+type BoardData struct {
+	Owner string
+	Repo  string
+	// current filters
+	State string
+	Sort  string
+	Min   int64
+	Query string
+
+	Bounties []*BoardEntry
+	// totals of all bounties, regardless of the filters
+	Active      int
+	Closed      int
+	ActiveTotal int64
+	TotalRaised int64
+}
+
+type BoardEntry struct {
+	Id        int64
+	Title     string
+	Repo      string
+	Number    int64
+	Url       string
+	DonateUrl string
+	Bounty    int64
+	Fiat      string
+	Donors    int
+	Active    bool
+	// zero if unknown
+	CreatedAt time.Time
+	ClosedAt  time.Time
+}
+
+// ListBounties returns the bounties of an owner, or of one of its
+// repositories if the name is given.
+func (srv *IssueService) ListBounties(ctx context.Context, owner string, name string) ([]*BountyIssue, error) {
+	bountyIssues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var res []*BountyIssue
+	for _, bountyIssue := range bountyIssues {
+		if !strings.EqualFold(bountyIssue.Owner, owner) {
+			continue
+		}
+		if name != "" && !strings.EqualFold(bountyIssue.Repo, name) {
+			continue
+		}
+		res = append(res, bountyIssue)
+	}
+	return res, nil
+}
+
+// handleBoard renders the bounty board of an owner or a repository. The list
+// can be filtered by state, minimum amount and title and sorted by amount,
+// age or donors.
+func (wh *WebhookHandler) handleBoard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+	data := &BoardData{
+		Owner: ps.ByName("owner"),
+		Repo:  ps.ByName("repo"),
+		State: query.Get(statekey),
+		Sort:  query.Get(sortkey),
+		Query: strings.TrimSpace(query.Get(querykey)),
+	}
+	if data.State == "" {
+		data.State = "active"
+	}
+	if data.Sort == "" {
+		data.Sort = "amount"
+	}
+	if data.State != "active" && data.State != "closed" && data.State != "all" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s, use active, closed or all", statekey))
+		return
+	}
+	if query.Get(minkey) != "" {
+		min, err := strconv.ParseInt(query.Get(minkey), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+			return
+		}
+		data.Min = min
+	}
+	less, ok := boardSorts[data.Sort]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s, use amount, newest, oldest or donors", sortkey))
+		return
+	}
+	bountyIssues, err := wh.is.ListBounties(r.Context(), data.Owner, data.Repo)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	for _, bountyIssue := range bountyIssues {
+		data.TotalRaised += bountyIssue.Bounty
+		if bountyIssue.Active {
+			data.Active++
+			data.ActiveTotal += bountyIssue.Bounty
+		} else {
+			data.Closed++
+		}
+		if (data.State == "active" && !bountyIssue.Active) || (data.State == "closed" && bountyIssue.Active) {
+			continue
+		}
+		if bountyIssue.Bounty < data.Min {
+			continue
+		}
+		if data.Query != "" && !strings.Contains(strings.ToLower(bountyIssue.Title), strings.ToLower(data.Query)) {
+			continue
+		}
+		data.Bounties = append(data.Bounties, wh.boardEntry(bountyIssue))
+	}
+	sort.SliceStable(data.Bounties, func(i, j int) bool {
+		return less(data.Bounties[i], data.Bounties[j])
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = wh.boardTmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("unable to render bounty board %v", err)
+	}
+}
+
+var boardSorts = map[string]func(a, b *BoardEntry) bool{
+	"amount": func(a, b *BoardEntry) bool { return a.Bounty > b.Bounty },
+	"newest": func(a, b *BoardEntry) bool { return a.CreatedAt.After(b.CreatedAt) },
+	"oldest": func(a, b *BoardEntry) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"donors": func(a, b *BoardEntry) bool { return a.Donors > b.Donors },
+}
+
+func (wh *WebhookHandler) boardEntry(bountyIssue *BountyIssue) *BoardEntry {
+	entry := &BoardEntry{
+		Id:        bountyIssue.Id,
+		Title:     bountyIssue.Title,
+		Repo:      bountyIssue.Owner + "/" + bountyIssue.Repo,
+		Number:    bountyIssue.Number,
+		Url:       bountyIssue.HtmlUrl,
+		DonateUrl: wh.is.donateUrl(bountyIssue.Id),
+		Bounty:    bountyIssue.Bounty,
+		Donors:    bountyIssue.TotalPayments,
+		Active:    bountyIssue.Active,
+	}
+	if entry.Url == "" {
+		entry.Url = bountyIssue.Url
+	}
+	if entry.Title == "" {
+		entry.Title = fmt.Sprintf("%s#%d", entry.Repo, entry.Number)
+	}
+	if bountyIssue.Rate != nil {
+		entry.Fiat = fmt.Sprintf("%.2f %s", bountyIssue.Rate.ToFiat(bountyIssue.Bounty), bountyIssue.Rate.Currency)
+	}
+	if bountyIssue.CreatedAt != 0 {
+		entry.CreatedAt = time.Unix(bountyIssue.CreatedAt, 0)
+	}
+	if bountyIssue.ClosedAt != 0 {
+		entry.ClosedAt = time.Unix(bountyIssue.ClosedAt, 0)
+	}
+	return entry
+}
+
+// formatAge formats the time since t roughly, like 3 days.
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Hour:
+		return "less than an hour"
+	case d < time.Hour*24:
+		return plural(int64(d/time.Hour), "hour")
+	case d < time.Hour*24*60:
+		return plural(int64(d/(time.Hour*24)), "day")
+	case d < time.Hour*24*730:
+		return plural(int64(d/(time.Hour*24*30)), "month")
+	}
+	return plural(int64(d/(time.Hour*24*365)), "year")
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return strconv.FormatInt(n, 10) + " " + unit + "s"
+}
@@ -0,0 +1,140 @@
+package tracker
+
+import (
+	"context"
+	"github.com/julienschmidt/httprouter"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// boardTestService returns bounties of two repositories of owner, one of
+// them closed, and a bounty of another owner.
+func boardTestService(t *testing.T) *testService {
+	ctx := context.Background()
+	ts := newTestService(t)
+	now := time.Now()
+	for _, bounty := range []struct {
+		id      int64
+		owner   string
+		repo    string
+		title   string
+		sats    int64
+		donors  int
+		created time.Time
+	}{
+		{1, "owner", "repo", "Fix the <b>bug</b>", 5000, 1, now.Add(-time.Hour * 72)},
+		{2, "owner", "repo", "Add dark mode", 20000, 3, now.Add(-time.Hour * 24)},
+		{3, "owner", "repo", "Closed issue", 1000, 5, now.Add(-time.Hour * 240)},
+		{4, "owner", "other", "", 3000, 2, now.Add(-time.Hour * 48)},
+		{5, "someone", "repo", "Foreign bounty", 50000, 9, now},
+	} {
+		ts.addIssue(t, bounty.id)
+		if bounty.id == 3 {
+			err := ts.CloseIssue(ctx, bounty.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		ts.Lock()
+		issue := ts.issue(t, bounty.id)
+		issue.Owner, issue.Repo, issue.Title = bounty.owner, bounty.repo, bounty.title
+		issue.Bounty, issue.TotalPayments, issue.CreatedAt = bounty.sats, bounty.donors, bounty.created.Unix()
+		err := ts.store.Update(ctx, issue)
+		ts.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ts
+}
+
+func TestHandleBoard(t *testing.T) {
+	ts := boardTestService(t)
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	wh.boardTmpl = template.Must(template.New("board").Parse(
+		`{{.Active}} {{.Closed}} {{.ActiveTotal}} {{.TotalRaised}}|{{range .Bounties}}{{.Id}} {{end}}`))
+
+	tests := []struct {
+		owner string
+		repo  string
+		query string
+		body  string
+	}{
+		{"owner", "", "", "3 1 28000 29000|2 1 4 "},
+		{"OWNER", "", "", "3 1 28000 29000|2 1 4 "},
+		{"owner", "repo", "", "2 1 25000 26000|2 1 "},
+		{"owner", "other", "", "1 0 3000 3000|4 "},
+		{"owner", "", "?state=closed", "3 1 28000 29000|3 "},
+		{"owner", "", "?state=all&sort=donors", "3 1 28000 29000|3 2 4 1 "},
+		{"owner", "", "?state=all&sort=newest", "3 1 28000 29000|2 4 1 3 "},
+		{"owner", "", "?state=all&sort=oldest", "3 1 28000 29000|3 1 4 2 "},
+		{"owner", "", "?min=4000", "3 1 28000 29000|2 1 "},
+		{"owner", "", "?q=+DARK+", "3 1 28000 29000|2 "},
+		{"nobody", "", "", "0 0 0 0|"},
+	}
+	for _, test := range tests {
+		handle := func(w http.ResponseWriter, r *http.Request) {
+			wh.handleBoard(w, r, httprouter.Params{{Key: "owner", Value: test.owner}, {Key: "repo", Value: test.repo}})
+		}
+		rec := serve(handle, boardPath+test.query)
+		if rec.Code != http.StatusOK || rec.Body.String() != test.body {
+			t.Fatalf("expected %q for %v/%v%v, got %v %q", test.body, test.owner, test.repo, test.query, rec.Code, rec.Body.String())
+		}
+	}
+
+	handle := func(w http.ResponseWriter, r *http.Request) {
+		wh.handleBoard(w, r, httprouter.Params{{Key: "owner", Value: "owner"}})
+	}
+	for _, query := range []string{"?state=open", "?sort=random", "?min=lots"} {
+		if rec := serve(handle, boardPath+query); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected %v to be rejected, got %v", query, rec.Code)
+		}
+	}
+}
+
+func TestBoardPage(t *testing.T) {
+	ts := boardTestService(t)
+	ts.cfg.StaticFilePath = "../dist"
+	wh, err := NewWebhookHandler(ts.cfg, ts.IssueService, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(func(w http.ResponseWriter, r *http.Request) {
+		wh.handleBoard(w, r, httprouter.Params{{Key: "owner", Value: "owner"}})
+	}, boardPath+"?state=all")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("unexpected response %v %v", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{"Add dark mode", "Fix the &lt;b&gt;bug&lt;/b&gt;", "owner/other#4", "Closed issue", "20,000"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("board doesn't contain %q", expected)
+		}
+	}
+	if strings.Contains(body, "<b>bug</b>") || strings.Contains(body, "Foreign bounty") {
+		t.Fatal("board contains unescaped titles or bounties of other owners")
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	now := time.Now()
+	for d, expected := range map[time.Duration]string{
+		time.Minute:              "less than an hour",
+		time.Hour:                "1 hour",
+		time.Hour * 5:            "5 hours",
+		time.Hour * 24:           "1 day",
+		time.Hour * 24 * 59:      "59 days",
+		time.Hour * 24 * 90:      "3 months",
+		time.Hour * 24 * 365 * 3: "3 years",
+	} {
+		if age := formatAge(now.Add(-d)); age != expected {
+			t.Errorf("expected %v for %v, got %v", expected, d, age)
+		}
+	}
+	if age := formatAge(time.Time{}); age != "" {
+		t.Errorf("expected no age for unknown times, got %v", age)
+	}
+}
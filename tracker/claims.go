@@ -0,0 +1,284 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// commands in issue comments
+	claimCommand       = "/claim"
+	awardCommand       = "/award"
+	leaderboardCommand = "/leaderboard"
+
+	maxDonorNameLength = 39
+
+	claimExpiryInterval = time.Hour
+)
+
+var (
+	NoClaimsError     = fmt.Errorf("issue has no bounty to award")
+	InvalidLoginError = fmt.Errorf("invalid github login")
+	DonorNameError    = fmt.Errorf("donor name must be at most 39 letters, digits, spaces, - or _")
+
+	loginRegexp     = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,38})$`)
+	donorNameRegexp = regexp.MustCompile(`^[\p{L}\p{N} _-]+$`)
+
+	// author associations that may award bounties
+	maintainerAssociations = map[string]bool{"OWNER": true, "MEMBER": true, "COLLABORATOR": true}
+)
+
+// Claim is a hunter's claim on a bounty, a claim is awarded by a maintainer
+// once the work is done.
+type Claim struct {
+	Hunter    string
+	ClaimedAt int64
+	// amount awarded to the hunter, 0 if not awarded yet
+	Awarded   int64
+	AwardedAt int64
+	// unix time the claim expired without an award, see Config.ClaimExpiry
+	ExpiredAt int64
+}
+
+// DonorInfo is what a donor optionally tells about themselves on the
+// donation page.
+type DonorInfo struct {
+	Note string
+	// github login or nickname shown on leaderboards
+	Name string
+	// hide the donation from leaderboards
+	Private bool
+}
+
+type OptOutStore interface {
+	Put(ctx context.Context, login string) error
+	Delete(ctx context.Context, login string) error
+	ListAll(ctx context.Context) ([]string, error)
+}
+
+func (info *DonorInfo) validate() error {
+	if len(info.Note) > maxNoteLength {
+		return NoteTooLongError
+	}
+	info.Name = strings.TrimPrefix(strings.TrimSpace(info.Name), "@")
+	if info.Name != "" && (len(info.Name) > maxDonorNameLength || !donorNameRegexp.MatchString(info.Name)) {
+		return DonorNameError
+	}
+	return nil
+}
+
+// ClaimBounty records the claim of a hunter on an active bounty, claiming
+// twice has no effect. Closed bounties can't be claimed.
+func (srv *IssueService) ClaimBounty(ctx context.Context, issueId int64, hunter string) error {
+	if !loginRegexp.MatchString(hunter) {
+		return InvalidLoginError
+	}
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err := srv.store.Get(ctx, issueId)
+	if err == ErrDoesNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !issue.Active {
+		return InactiveError
+	}
+	claim := findClaim(issue, hunter)
+	if claim != nil && claim.ExpiredAt == 0 {
+		return nil
+	}
+	fmt.Printf("%v claimed the bounty on %v \n", hunter, issue.Url)
+	if claim != nil {
+		// claiming again renews an expired claim
+		claim.ClaimedAt = time.Now().Unix()
+		claim.ExpiredAt = 0
+	} else {
+		issue.Claims = append(issue.Claims, &Claim{Hunter: hunter, ClaimedAt: time.Now().Unix()})
+	}
+	event := newEvent(EventClaimCreated, issue)
+	event.Hunter = hunter
+	return srv.commit(ctx, issue, event)
+}
+
+// AwardClaim awards an amount of the bounty to a hunter, who doesn't need
+// to have claimed it before. Without an amount the remaining bounty is
+// awarded.
+func (srv *IssueService) AwardClaim(ctx context.Context, issueId int64, hunter string, sats int64) (*Claim, error) {
+	hunter = strings.TrimPrefix(hunter, "@")
+	if !loginRegexp.MatchString(hunter) {
+		return nil, InvalidLoginError
+	}
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err := srv.store.Get(ctx, issueId)
+	if err != nil {
+		return nil, err
+	}
+	remaining := issue.Bounty - awarded(issue)
+	if sats == 0 {
+		sats = remaining
+	}
+	if sats <= 0 || sats > remaining {
+		if remaining <= 0 {
+			return nil, NoClaimsError
+		}
+		return nil, fmt.Errorf("invalid award %v, remaining bounty is %v", sats, remaining)
+	}
+	claim := findClaim(issue, hunter)
+	if claim == nil {
+		claim = &Claim{Hunter: hunter, ClaimedAt: time.Now().Unix()}
+		issue.Claims = append(issue.Claims, claim)
+	}
+	claim.Awarded += sats
+	claim.AwardedAt = time.Now().Unix()
+	claim.ExpiredAt = 0
+	fmt.Printf("awarded %v of the bounty on %v to %v \n", sats, issue.Url, hunter)
+	event := newEvent(EventClaimAwarded, issue)
+	event.Amount = sats
+	event.Hunter = hunter
+	err = srv.commit(ctx, issue, event)
+	if err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+// StartClaimExpiry periodically expires the claims of active bounties that
+// weren't awarded within the configured claim expiry.
+func (srv *IssueService) StartClaimExpiry(ctx context.Context) {
+	if srv.cfg.ClaimExpiry == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(claimExpiryInterval)
+		defer ticker.Stop()
+		for {
+			err := srv.expireClaims(ctx)
+			if err != nil {
+				fmt.Printf("error expiring claims: %v \n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (srv *IssueService) expireClaims(ctx context.Context) error {
+	srv.Lock()
+	defer srv.Unlock()
+	bountyIssues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(-srv.cfg.ClaimExpiry).Unix()
+	for _, issue := range bountyIssues {
+		if !issue.Active {
+			continue
+		}
+		var events []*Event
+		for _, claim := range issue.Claims {
+			if claim.Awarded != 0 || claim.ExpiredAt != 0 || claim.ClaimedAt > deadline {
+				continue
+			}
+			fmt.Printf("claim of %v on %v expired \n", claim.Hunter, issue.Url)
+			claim.ExpiredAt = time.Now().Unix()
+			event := newEvent(EventClaimExpired, issue)
+			event.Hunter = claim.Hunter
+			events = append(events, event)
+		}
+		if len(events) == 0 {
+			continue
+		}
+		err = srv.commit(ctx, issue, events...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLeaderboardOptOut hides or shows a github login or donor name on all
+// leaderboards.
+func (srv *IssueService) SetLeaderboardOptOut(ctx context.Context, login string, optOut bool) error {
+	login = strings.ToLower(strings.TrimPrefix(login, "@"))
+	if login == "" {
+		return InvalidLoginError
+	}
+	if optOut {
+		return srv.optOutStore.Put(ctx, login)
+	}
+	return srv.optOutStore.Delete(ctx, login)
+}
+
+// HandleCommentCommand executes the commands of an issue comment: hunters
+// claim a bounty with /claim, maintainers award it with /award @login
+// [sats] and everyone can opt out of the leaderboards with /leaderboard
+// opt-out.
+func (srv *IssueService) HandleCommentCommand(ctx context.Context, issueId int64, author string, association string, body string) error {
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case claimCommand:
+		return srv.ClaimBounty(ctx, issueId, author)
+	case awardCommand:
+		if !maintainerAssociations[association] {
+			fmt.Printf("ignoring award of %v, who is no maintainer \n", author)
+			return nil
+		}
+		if len(fields) < 2 {
+			return InvalidLoginError
+		}
+		var sats int64
+		if len(fields) > 2 {
+			var err error
+			sats, err = strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid award amount %v", fields[2])
+			}
+		}
+		_, err := srv.AwardClaim(ctx, issueId, fields[1], sats)
+		if err == ErrDoesNotExist {
+			return nil
+		}
+		return err
+	case leaderboardCommand:
+		if len(fields) < 2 {
+			return nil
+		}
+		switch fields[1] {
+		case "opt-out":
+			return srv.SetLeaderboardOptOut(ctx, author, true)
+		case "opt-in":
+			return srv.SetLeaderboardOptOut(ctx, author, false)
+		}
+	}
+	return nil
+}
+
+func findClaim(issue *BountyIssue, hunter string) *Claim {
+	for _, claim := range issue.Claims {
+		if strings.EqualFold(claim.Hunter, hunter) {
+			return claim
+		}
+	}
+	return nil
+}
+
+func awarded(issue *BountyIssue) int64 {
+	var sats int64
+	for _, claim := range issue.Claims {
+		sats += claim.Awarded
+	}
+	return sats
+}
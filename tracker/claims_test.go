@@ -0,0 +1,129 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+// lastEvents returns the events committed after the sequence number.
+func lastEvents(t *testing.T, ts *testService, since uint64) []*Event {
+	events, err := ts.store.EventsSince(context.Background(), since, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestClaimBounty(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	ts.addIssue(t, 1)
+	seq, err := ts.store.LastEventSeq(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ts.ClaimBounty(ctx, 1, "hunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := lastEvents(t, ts, seq)
+	if len(events) != 1 || events[0].Type != EventClaimCreated || events[0].Hunter != "hunter" {
+		t.Fatalf("expected a claim event, got %+v", events)
+	}
+	seq = events[0].Seq
+
+	// claiming twice has no effect
+	err = ts.ClaimBounty(ctx, 1, "Hunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := lastEvents(t, ts, seq); len(events) != 0 {
+		t.Fatalf("second claim emitted %+v", events)
+	}
+	if claims := ts.issue(t, 1).Claims; len(claims) != 1 {
+		t.Fatalf("expected one claim, got %v", len(claims))
+	}
+
+	if err := ts.ClaimBounty(ctx, 2, "hunter"); err != nil {
+		t.Fatalf("claim on an issue without bounty failed: %v", err)
+	}
+	if err := ts.ClaimBounty(ctx, 1, "-invalid"); err != InvalidLoginError {
+		t.Fatalf("expected invalid login, got %v", err)
+	}
+	err = ts.CloseIssue(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.ClaimBounty(ctx, 1, "other"); err != InactiveError {
+		t.Fatalf("expected closed bounty to be rejected, got %v", err)
+	}
+	if claims := ts.issue(t, 1).Claims; len(claims) != 1 {
+		t.Fatalf("closed bounty was claimed: %v claims", len(claims))
+	}
+}
+
+func TestAwardLedger(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	issue.Bounty = 10000
+	err := ts.store.Update(ctx, issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// awards before the ledger started get opening entries
+	_, err = ts.AwardClaim(ctx, 1, "@early", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.startLedger(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.AwardClaim(ctx, 1, "late", 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range lastEvents(t, ts, 0) {
+		if event.Type != EventClaimAwarded {
+			continue
+		}
+		err = ts.handleLedgerEvent(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := ts.Ledger(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewLedgerVerifier()
+	var hunters []string
+	for _, entry := range res.Entries {
+		err = verifier.Add(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Type == LedgerAward {
+			hunters = append(hunters, entry.Hunter)
+		}
+	}
+	if len(hunters) != 2 || hunters[0] != "early" || hunters[1] != "late" {
+		t.Fatalf("expected an award entry per award, got %v", hunters)
+	}
+	totals := verifier.Totals[1]
+	claimed := res.Bounties[1]
+	if totals.Awarded != 3000 || claimed == nil || *claimed != *totals {
+		t.Fatalf("ledger totals %+v don't match the bounty %+v", totals, claimed)
+	}
+
+	// the hunter is part of the hash
+	entry := *res.Entries[len(res.Entries)-1]
+	entry.Hunter = "mallory"
+	if entry.ComputeHash() == entry.Hash {
+		t.Fatal("changing the hunter kept the hash")
+	}
+}
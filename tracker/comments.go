@@ -0,0 +1,46 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+)
+
+// CommentDeleted posts the bounty comment again if the deleted comment was
+// the bot's comment of a bounty.
+func (srv *IssueService) CommentDeleted(ctx context.Context, issueId int64, commentId int64) error {
+	srv.Lock()
+	issue, err := srv.store.Get(ctx, issueId)
+	if err == ErrDoesNotExist || (err == nil && issue.CommentId != commentId) {
+		srv.Unlock()
+		return nil
+	}
+	if err != nil {
+		srv.Unlock()
+		return err
+	}
+	fmt.Printf("bounty comment %v on %v was deleted, posting it again \n", commentId, issue.Url)
+	issue.CommentId = 0
+	err = srv.store.Update(ctx, issue)
+	srv.Unlock()
+	if err != nil {
+		return err
+	}
+	return srv.enqueueComment(ctx, issueId)
+}
+
+// CommentEdited restores the text of the bot's comment of a bounty after
+// someone else edited it, if enabled.
+func (srv *IssueService) CommentEdited(ctx context.Context, issueId int64, commentId int64) error {
+	if !srv.cfg.RestoreEditedComments {
+		return nil
+	}
+	issue, err := srv.store.Get(ctx, issueId)
+	if err == ErrDoesNotExist || (err == nil && issue.CommentId != commentId) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("bounty comment %v on %v was edited, restoring it \n", commentId, issue.Url)
+	return srv.enqueueComment(ctx, issueId)
+}
@@ -0,0 +1,600 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type EmailRole string
+
+const (
+	EmailMaintainer EmailRole = "maintainer"
+	EmailHunter     EmailRole = "hunter"
+)
+
+type EmailKind string
+
+const (
+	EmailDonation     EmailKind = "donation"
+	EmailGoalReached  EmailKind = "goal_reached"
+	EmailNodeOutage   EmailKind = "node_outage"
+	EmailClaimAwarded EmailKind = "claim_awarded"
+	EmailClaimExpired EmailKind = "claim_expired"
+	EmailDigest       EmailKind = "digest"
+	EmailConfirm      EmailKind = "confirm"
+
+	emailPath = "/email"
+
+	tokenkey = "token"
+
+	emailConfirmExpiry  = time.Hour * 24 * 7
+	emailDigestCursor   = "email_digest"
+	emailDigestInterval = time.Minute * 10
+)
+
+var (
+	InvalidEmailTokenError = fmt.Errorf("invalid or expired email token")
+
+	// events that may be sent as emails
+	emailEvents = []EventType{
+		EventInvoiceSettled,
+		EventOnchainDeposit,
+		EventNodeOutage,
+		EventClaimAwarded,
+		EventClaimExpired,
+	}
+
+	// emailTemplates are text/templates of the emails, the first line is the
+	// subject. See EmailData.
+	emailTemplates = map[EmailKind]string{
+		EmailDonation: `{{thousands .Amount}} sats donated to {{.Owner}}/{{.Repo}}#{{.Number}}
+
+Someone donated {{thousands .Amount}} sats to the bounty on {{.Title}}, the bounty is now {{thousands .Bounty}} sats.
+
+{{.IssueUrl}}
+`,
+		EmailGoalReached: `The bounty on {{.Owner}}/{{.Repo}}#{{.Number}} reached its goal
+
+The bounty on {{.Title}} reached {{thousands .Bounty}} sats, the goal of the repository is {{thousands .Goal}} sats.
+
+{{.IssueUrl}}
+`,
+		EmailNodeOutage: `Your lightning node is unreachable
+
+The lightning node of the bounty on {{.Owner}}/{{.Repo}}#{{.Number}} {{.Title}} can't be reached, donations are paused until it is back online.
+
+{{.IssueUrl}}
+`,
+		EmailClaimAwarded: `You were awarded {{thousands .Amount}} sats on {{.Owner}}/{{.Repo}}#{{.Number}}
+
+Congratulations @{{.Hunter}}, the maintainers awarded you {{thousands .Amount}} sats of the bounty on {{.Title}}.
+
+{{.IssueUrl}}
+`,
+		EmailClaimExpired: `Your claim on {{.Owner}}/{{.Repo}}#{{.Number}} expired
+
+@{{.Hunter}}, your claim on the bounty on {{.Title}} expired. Comment /claim on the issue to claim it again.
+
+{{.IssueUrl}}
+`,
+		EmailDigest: `{{thousands .Total}} sats donated to {{.Owner}}/{{.Repo}} today
+
+Donations since the last digest:
+{{range .Donations}}
+- {{thousands .Amount}} sats in {{.Count}} donation{{if ne .Count 1}}s{{end}} to #{{.Number}} {{.Title}}, now {{thousands .Bounty}} sats
+  {{.IssueUrl}}
+{{- end}}
+`,
+		EmailConfirm: `Confirm your bounty notifications of {{.Owner}}/{{.Repo}}
+
+Someone subscribed this address to the bounty notifications of {{.Owner}}/{{.Repo}}. Open the following link to confirm:
+
+{{.ConfirmUrl}}
+
+If it wasn't you, ignore this email.
+`,
+	}
+	emailFooter = `
+--
+Unsubscribe: {{.UnsubscribeUrl}}
+`
+)
+
+// EmailSubscriber is a confirmed recipient of the email notifications of a
+// repository. Maintainers get donations, reached goals and node outages,
+// hunters get awards and expired claims of their login.
+type EmailSubscriber struct {
+	Address string    `json:"address"`
+	Role    EmailRole `json:"role"`
+	// github login of hunters
+	Login string `json:"login,omitempty"`
+	// batch donations into a daily digest
+	Digest       bool  `json:"digest,omitempty"`
+	SubscribedAt int64 `json:"subscribed_at"`
+}
+
+// EmailData is passed to the email templates.
+type EmailData struct {
+	Owner     string
+	Repo      string
+	Number    int64
+	Title     string
+	IssueUrl  string
+	DonateUrl string
+	Bounty    int64
+	Amount    int64
+	Goal      int64
+	Hunter    string
+	// donations of the digest per bounty
+	Donations      []*DigestDonation
+	Total          int64
+	ConfirmUrl     string
+	UnsubscribeUrl string
+}
+
+type DigestDonation struct {
+	Number   int64
+	Title    string
+	IssueUrl string
+	Amount   int64
+	Count    int
+	Bounty   int64
+}
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// SMTPMailer sends plain text emails through an smtp server.
+type SMTPMailer struct {
+	address string
+	from    string
+	auth    smtp.Auth
+}
+
+func NewSMTPMailer(address string, user string, password string, from string) *SMTPMailer {
+	mailer := &SMTPMailer{address: address, from: from}
+	if user != "" {
+		host := strings.Split(address, ":")[0]
+		mailer.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return mailer
+}
+
+func (mailer *SMTPMailer) Send(to string, subject string, body string) error {
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", mailer.from)
+	fmt.Fprintf(msg, "To: %s\r\n", to)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(msg)
+	_, err := w.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(mailer.address, mailer.auth, mailer.from, []string{to}, msg.Bytes())
+}
+
+// emailToken is signed into the confirmation and unsubscribe links.
+type emailToken struct {
+	Action  string    `json:"action"`
+	Owner   string    `json:"owner"`
+	Repo    string    `json:"repo"`
+	Address string    `json:"address"`
+	Role    EmailRole `json:"role"`
+	Login   string    `json:"login,omitempty"`
+	Digest  bool      `json:"digest,omitempty"`
+	// unix time, 0 if the token doesn't expire
+	Expires int64 `json:"expires,omitempty"`
+}
+
+func (srv *IssueService) signEmailToken(token *emailToken) string {
+	payload, _ := json.Marshal(token)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(srv.cfg.Secret))
+	mac.Write([]byte("email:" + encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (srv *IssueService) parseEmailToken(signed string, action string) (*emailToken, error) {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 2 {
+		return nil, InvalidEmailTokenError
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, InvalidEmailTokenError
+	}
+	mac := hmac.New(sha256.New, []byte(srv.cfg.Secret))
+	mac.Write([]byte("email:" + parts[0]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, InvalidEmailTokenError
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, InvalidEmailTokenError
+	}
+	token := &emailToken{}
+	err = json.Unmarshal(payload, token)
+	if err != nil || token.Action != action || (token.Expires != 0 && token.Expires < time.Now().Unix()) {
+		return nil, InvalidEmailTokenError
+	}
+	return token, nil
+}
+
+// Validate checks the address, role and login of a subscriber.
+func (sub *EmailSubscriber) Validate() error {
+	address, err := mail.ParseAddress(sub.Address)
+	if err != nil {
+		return fmt.Errorf("invalid email address %v", sub.Address)
+	}
+	sub.Address = address.Address
+	switch sub.Role {
+	case EmailMaintainer:
+		sub.Login = ""
+	case EmailHunter:
+		sub.Login = strings.TrimPrefix(sub.Login, "@")
+		if !loginRegexp.MatchString(sub.Login) {
+			return InvalidLoginError
+		}
+		sub.Digest = false
+	default:
+		return fmt.Errorf("unknown role %v, use maintainer or hunter", sub.Role)
+	}
+	return nil
+}
+
+func (sub *EmailSubscriber) matches(other *EmailSubscriber) bool {
+	return strings.EqualFold(sub.Address, other.Address) && sub.Role == other.Role && strings.EqualFold(sub.Login, other.Login)
+}
+
+// RequestEmailSubscription sends a confirmation link to the address, the
+// subscription is stored once it is confirmed.
+func (srv *IssueService) RequestEmailSubscription(ctx context.Context, owner string, name string, sub *EmailSubscriber) error {
+	if srv.mailer == nil {
+		return fmt.Errorf("email notifications are disabled")
+	}
+	err := sub.Validate()
+	if err != nil {
+		return err
+	}
+	repo, err := srv.repoStore.Get(ctx, owner, name)
+	if err == ErrDoesNotExist {
+		return fmt.Errorf("repository %v/%v is not registered", owner, name)
+	}
+	if err != nil {
+		return err
+	}
+	token := &emailToken{
+		Action:  "confirm",
+		Owner:   repo.Owner,
+		Repo:    repo.Name,
+		Address: sub.Address,
+		Role:    sub.Role,
+		Login:   sub.Login,
+		Digest:  sub.Digest,
+		Expires: time.Now().Add(emailConfirmExpiry).Unix(),
+	}
+	data := &EmailData{
+		Owner:      repo.Owner,
+		Repo:       repo.Name,
+		ConfirmUrl: srv.cfg.HttpUrl + emailPath + "/confirm?" + tokenkey + "=" + url.QueryEscape(srv.signEmailToken(token)),
+	}
+	return srv.enqueueEmail(ctx, "email/confirm/"+emailHash(repo.FullName(), sub.Address, string(sub.Role), sub.Login), sub.Address, EmailConfirm, data)
+}
+
+// ConfirmEmailSubscription stores the subscription of a confirmation token,
+// replacing an earlier subscription of the same address and role.
+func (srv *IssueService) ConfirmEmailSubscription(ctx context.Context, signed string) (*EmailSubscriber, error) {
+	token, err := srv.parseEmailToken(signed, "confirm")
+	if err != nil {
+		return nil, err
+	}
+	sub := &EmailSubscriber{
+		Address:      token.Address,
+		Role:         token.Role,
+		Login:        token.Login,
+		Digest:       token.Digest,
+		SubscribedAt: time.Now().Unix(),
+	}
+	srv.repoMtx.Lock()
+	defer srv.repoMtx.Unlock()
+	repo, err := srv.repoStore.Get(ctx, token.Owner, token.Repo)
+	if err != nil {
+		return nil, err
+	}
+	var subscribers []*EmailSubscriber
+	for _, existing := range repo.EmailSubscribers {
+		if !existing.matches(sub) {
+			subscribers = append(subscribers, existing)
+		}
+	}
+	repo.EmailSubscribers = append(subscribers, sub)
+	err = srv.repoStore.Put(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("%v subscribed to the emails of %v \n", sub.Role, repo.FullName())
+	return sub, nil
+}
+
+// Unsubscribe removes the subscription of an unsubscribe token.
+func (srv *IssueService) Unsubscribe(ctx context.Context, signed string) error {
+	token, err := srv.parseEmailToken(signed, "unsubscribe")
+	if err != nil {
+		return err
+	}
+	srv.repoMtx.Lock()
+	defer srv.repoMtx.Unlock()
+	repo, err := srv.repoStore.Get(ctx, token.Owner, token.Repo)
+	if err != nil {
+		return err
+	}
+	sub := &EmailSubscriber{Address: token.Address, Role: token.Role, Login: token.Login}
+	var subscribers []*EmailSubscriber
+	for _, existing := range repo.EmailSubscribers {
+		if !existing.matches(sub) {
+			subscribers = append(subscribers, existing)
+		}
+	}
+	repo.EmailSubscribers = subscribers
+	return srv.repoStore.Put(ctx, repo)
+}
+
+func (srv *IssueService) unsubscribeUrl(repo *Repository, sub *EmailSubscriber) string {
+	token := &emailToken{
+		Action:  "unsubscribe",
+		Owner:   repo.Owner,
+		Repo:    repo.Name,
+		Address: sub.Address,
+		Role:    sub.Role,
+		Login:   sub.Login,
+	}
+	return srv.cfg.HttpUrl + emailPath + "/unsubscribe?" + tokenkey + "=" + url.QueryEscape(srv.signEmailToken(token))
+}
+
+// handleEmailEvent emails the subscribers of the repository that are
+// interested in the event.
+func (srv *IssueService) handleEmailEvent(ctx context.Context, event *Event) error {
+	if srv.mailer == nil {
+		return nil
+	}
+	repo, err := srv.repoStore.Get(ctx, event.Owner, event.Repo)
+	if err == ErrDoesNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(repo.EmailSubscribers) == 0 {
+		return nil
+	}
+	data := &EmailData{
+		Owner:     event.Owner,
+		Repo:      event.Repo,
+		Number:    event.Number,
+		Title:     fmt.Sprintf("%s/%s#%d", event.Owner, event.Repo, event.Number),
+		IssueUrl:  event.Url,
+		DonateUrl: srv.donateUrl(event.IssueId),
+		Bounty:    event.Bounty,
+		Amount:    event.Amount,
+		Goal:      srv.repoFile(ctx, event.Owner, event.Repo).Goal,
+		Hunter:    event.Hunter,
+	}
+	if issue, err := srv.store.Get(ctx, event.IssueId); err == nil && issue.Title != "" {
+		data.Title = issue.Title
+	}
+	goalReached := data.Goal > 0 && event.Bounty >= data.Goal && event.Bounty-event.Amount < data.Goal
+	for _, sub := range repo.EmailSubscribers {
+		var kinds []EmailKind
+		switch {
+		case sub.Role == EmailMaintainer && (event.Type == EventInvoiceSettled || event.Type == EventOnchainDeposit):
+			if !sub.Digest {
+				kinds = append(kinds, EmailDonation)
+			}
+			if goalReached {
+				kinds = append(kinds, EmailGoalReached)
+			}
+		case sub.Role == EmailMaintainer && event.Type == EventNodeOutage:
+			kinds = append(kinds, EmailNodeOutage)
+		case sub.Role == EmailHunter && event.Type == EventClaimAwarded && strings.EqualFold(sub.Login, event.Hunter):
+			kinds = append(kinds, EmailClaimAwarded)
+		case sub.Role == EmailHunter && event.Type == EventClaimExpired && strings.EqualFold(sub.Login, event.Hunter):
+			kinds = append(kinds, EmailClaimExpired)
+		}
+		for _, kind := range kinds {
+			data.UnsubscribeUrl = srv.unsubscribeUrl(repo, sub)
+			key := fmt.Sprintf("email/%d/%s/%s", event.Seq, kind, emailHash(sub.Address, string(sub.Role), sub.Login))
+			err = srv.enqueueEmail(ctx, key, sub.Address, kind, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runEmailDigest sends the daily digests at the configured hour. The digest
+// cursor starts at the latest event, so the first digest has the donations
+// since the first start.
+func (srv *IssueService) runEmailDigest(ctx context.Context) {
+	_, err := srv.store.GetCursor(ctx, emailDigestCursor)
+	if err == ErrDoesNotExist {
+		var seq uint64
+		seq, err = srv.store.LastEventSeq(ctx)
+		if err == nil {
+			err = srv.store.PutCursor(ctx, emailDigestCursor, seq)
+		}
+	}
+	if err != nil {
+		fmt.Printf("unable to initialize the email digest: %v \n", err)
+		return
+	}
+	var sentOn string
+	for {
+		now := time.Now().UTC()
+		if srv.digestDue(now, sentOn) {
+			err := srv.sendDigests(ctx)
+			if err != nil {
+				fmt.Printf("unable to send email digests: %v \n", err)
+			} else {
+				sentOn = now.Format("2006-01-02")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(emailDigestInterval):
+		}
+	}
+}
+
+// digestDue returns whether it's the digest hour of a day whose digests
+// weren't sent yet.
+func (srv *IssueService) digestDue(now time.Time, sentOn string) bool {
+	now = now.UTC()
+	return now.Hour() == srv.cfg.EmailDigestHour && now.Format("2006-01-02") != sentOn
+}
+
+// sendDigests enqueues a digest of the donations since the last digest for
+// the maintainers of every repository that subscribed to digests.
+func (srv *IssueService) sendDigests(ctx context.Context) error {
+	cursor, err := srv.store.GetCursor(ctx, emailDigestCursor)
+	if err != nil {
+		return err
+	}
+	// donations per repository and issue
+	donations := make(map[string]map[int64]*DigestDonation)
+	last := cursor
+	for {
+		events, err := srv.store.EventsSince(ctx, last, eventBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			last = event.Seq
+			if event.Type != EventInvoiceSettled && event.Type != EventOnchainDeposit {
+				continue
+			}
+			repoName := event.Owner + "/" + event.Repo
+			if donations[repoName] == nil {
+				donations[repoName] = make(map[int64]*DigestDonation)
+			}
+			donation, ok := donations[repoName][event.IssueId]
+			if !ok {
+				donation = &DigestDonation{Number: event.Number, Title: fmt.Sprintf("%s#%d", repoName, event.Number), IssueUrl: event.Url}
+				if issue, err := srv.store.Get(ctx, event.IssueId); err == nil && issue.Title != "" {
+					donation.Title = issue.Title
+				}
+				donations[repoName][event.IssueId] = donation
+			}
+			donation.Amount += event.Amount
+			donation.Count++
+			donation.Bounty = event.Bounty
+		}
+		if len(events) < eventBatchSize {
+			break
+		}
+	}
+	for repoName, issues := range donations {
+		names := strings.Split(repoName, "/")
+		repo, err := srv.repoStore.Get(ctx, names[0], names[1])
+		if err == ErrDoesNotExist {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data := &EmailData{Owner: repo.Owner, Repo: repo.Name}
+		for _, donation := range issues {
+			data.Donations = append(data.Donations, donation)
+			data.Total += donation.Amount
+		}
+		sort.Slice(data.Donations, func(i, j int) bool {
+			return data.Donations[i].Amount > data.Donations[j].Amount
+		})
+		for _, sub := range repo.EmailSubscribers {
+			if sub.Role != EmailMaintainer || !sub.Digest {
+				continue
+			}
+			data.UnsubscribeUrl = srv.unsubscribeUrl(repo, sub)
+			key := fmt.Sprintf("email/digest/%d/%s", last, emailHash(repoName, sub.Address))
+			err = srv.enqueueEmail(ctx, key, sub.Address, EmailDigest, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return srv.store.PutCursor(ctx, emailDigestCursor, last)
+}
+
+// enqueueEmail renders an email and queues it in the outbox.
+func (srv *IssueService) enqueueEmail(ctx context.Context, key string, to string, kind EmailKind, data *EmailData) error {
+	subject, body, err := renderEmail(kind, data)
+	if err != nil {
+		return err
+	}
+	return srv.enqueue(ctx, &OutboxOp{
+		Key:       key,
+		Kind:      OutboxEmail,
+		Recipient: to,
+		Subject:   subject,
+		Body:      body,
+	})
+}
+
+func (srv *IssueService) sendEmail(ctx context.Context, op *OutboxOp) error {
+	if srv.mailer == nil {
+		// emails were disabled since the email was queued
+		return nil
+	}
+	return srv.mailer.Send(op.Recipient, op.Subject, op.Body)
+}
+
+// renderEmail returns the subject and the body of an email.
+func renderEmail(kind EmailKind, data *EmailData) (string, string, error) {
+	text := emailTemplates[kind]
+	if data.UnsubscribeUrl != "" {
+		text += emailFooter
+	}
+	tmpl, err := template.New(string(kind)).Funcs(template.FuncMap{"thousands": formatThousands}).Parse(text)
+	if err != nil {
+		return "", "", err
+	}
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(buf.String(), "\n", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("email template %v has no body", kind)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimLeft(parts[1], "\n"), nil
+}
+
+// emailHash identifies recipients in outbox keys without the address.
+func emailHash(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.Join(parts, "/"))))
+	return hex.EncodeToString(hash[:8])
+}
@@ -0,0 +1,367 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	config "github.com/sputn1ck/github-bounty"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedEmail is an email accepted by a smtpServer.
+type receivedEmail struct {
+	from    string
+	to      []string
+	subject string
+	body    string
+}
+
+// smtpServer is a minimal smtp server that accepts every email.
+type smtpServer struct {
+	listener net.Listener
+	emails   []*receivedEmail
+	sync.Mutex
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(t, conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	email := &receivedEmail{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case cmd == "EHLO" || cmd == "HELO":
+			reply("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			email = &receivedEmail{from: strings.Trim(line[len("MAIL FROM:"):], "<>")}
+			reply("250 ok")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			email.to = append(email.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(data.String()))
+			if err != nil {
+				t.Error(err)
+				reply("554 invalid message")
+				continue
+			}
+			email.subject, err = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			if err != nil {
+				t.Error(err)
+			}
+			body, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+			if err != nil {
+				t.Error(err)
+			}
+			email.body = strings.ReplaceAll(string(body), "\r\n", "\n")
+			s.Lock()
+			s.emails = append(s.emails, email)
+			s.Unlock()
+			reply("250 ok")
+		case cmd == "RSET" || cmd == "NOOP":
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// take returns the emails received since the last call.
+func (s *smtpServer) take() []*receivedEmail {
+	s.Lock()
+	defer s.Unlock()
+	emails := s.emails
+	s.emails = nil
+	return emails
+}
+
+var tokenRegexp = regexp.MustCompile(`[?&]token=([^\s&]+)`)
+
+// linkToken returns the token of the first link in the body that contains
+// the path.
+func linkToken(t *testing.T, body string, path string) string {
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.Contains(line, path) {
+			continue
+		}
+		match := tokenRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	t.Fatalf("no %v link in %q", path, body)
+	return ""
+}
+
+func newEmailTestService(t *testing.T) (*testService, *smtpServer) {
+	server := newSMTPServer(t)
+	ts := newTestService(t, func(cfg *config.Config) {
+		cfg.SmtpAddress = server.listener.Addr().String()
+		cfg.SmtpFrom = "bounty@example.com"
+		cfg.HttpUrl = "https://bounties.example.com"
+	})
+	_, err := ts.RegisterRepo(context.Background(), "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, server
+}
+
+// donate commits a donation to an issue, hands it to the email handler and
+// sends the queued emails.
+func (ts *testService) donate(t *testing.T, issueId int64, sats int64) {
+	ctx := context.Background()
+	issue := ts.issue(t, issueId)
+	issue.Bounty += sats
+	event := newEvent(EventInvoiceSettled, issue)
+	event.Amount = sats
+	err := ts.store.Commit(ctx, issue, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.handleEmailEvent(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.processOutbox(ctx, OutboxEmail)
+}
+
+// subscribe confirms an email subscription through the link of the
+// confirmation email.
+func (ts *testService) subscribe(t *testing.T, server *smtpServer, sub *EmailSubscriber) {
+	ctx := context.Background()
+	err := ts.RequestEmailSubscription(ctx, "owner", "repo", sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.processOutbox(ctx, OutboxEmail)
+	emails := server.take()
+	if len(emails) != 1 || emails[0].to[0] != sub.Address || emails[0].subject != "Confirm your bounty notifications of owner/repo" {
+		t.Fatalf("expected a confirmation email, got %+v", emails)
+	}
+	_, err = ts.ConfirmEmailSubscription(ctx, linkToken(t, emails[0].body, emailPath+"/confirm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmailOptIn(t *testing.T) {
+	ctx := context.Background()
+	ts, server := newEmailTestService(t)
+	ts.addIssue(t, 1)
+
+	err := ts.RequestEmailSubscription(ctx, "owner", "repo", &EmailSubscriber{Address: "Maintainer <maintainer@example.com>", Role: EmailMaintainer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.processOutbox(ctx, OutboxEmail)
+	emails := server.take()
+	if len(emails) != 1 || emails[0].from != "bounty@example.com" || emails[0].to[0] != "maintainer@example.com" {
+		t.Fatalf("expected a confirmation email, got %+v", emails)
+	}
+	token := linkToken(t, emails[0].body, "https://bounties.example.com"+emailPath+"/confirm")
+
+	// nothing is sent before the subscription is confirmed
+	ts.donate(t, 1, 1000)
+	if emails := server.take(); len(emails) != 0 {
+		t.Fatalf("unconfirmed address got %+v", emails)
+	}
+	if _, err := ts.ConfirmEmailSubscription(ctx, token+"x"); err != InvalidEmailTokenError {
+		t.Fatalf("expected tampered token to be rejected, got %v", err)
+	}
+	if err := ts.Unsubscribe(ctx, token); err != InvalidEmailTokenError {
+		t.Fatalf("expected confirmation token to be rejected for unsubscribing, got %v", err)
+	}
+	sub, err := ts.ConfirmEmailSubscription(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Address != "maintainer@example.com" || sub.Role != EmailMaintainer {
+		t.Fatalf("unexpected subscriber %+v", sub)
+	}
+	// confirming twice doesn't subscribe twice
+	_, err = ts.ConfirmEmailSubscription(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := ts.GetRepo(ctx, "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.EmailSubscribers) != 1 {
+		t.Fatalf("expected one subscriber, got %v", len(repo.EmailSubscribers))
+	}
+
+	ts.donate(t, 1, 2000)
+	emails = server.take()
+	if len(emails) != 1 || emails[0].subject != "2,000 sats donated to owner/repo#1" {
+		t.Fatalf("expected a donation email, got %+v", emails)
+	}
+	if !strings.Contains(emails[0].body, "the bounty is now 3,000 sats") {
+		t.Fatalf("unexpected donation email %q", emails[0].body)
+	}
+}
+
+func TestEmailUnsubscribe(t *testing.T) {
+	ctx := context.Background()
+	ts, server := newEmailTestService(t)
+	ts.addIssue(t, 1)
+	ts.subscribe(t, server, &EmailSubscriber{Address: "maintainer@example.com", Role: EmailMaintainer})
+	ts.subscribe(t, server, &EmailSubscriber{Address: "other@example.com", Role: EmailMaintainer})
+
+	ts.donate(t, 1, 1000)
+	emails := server.take()
+	if len(emails) != 2 {
+		t.Fatalf("expected an email per subscriber, got %v", len(emails))
+	}
+	var token string
+	for _, email := range emails {
+		if email.to[0] == "maintainer@example.com" {
+			token = linkToken(t, email.body, "Unsubscribe: https://bounties.example.com"+emailPath+"/unsubscribe")
+		}
+	}
+	err := ts.Unsubscribe(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.donate(t, 1, 1000)
+	emails = server.take()
+	if len(emails) != 1 || emails[0].to[0] != "other@example.com" {
+		t.Fatalf("expected an email to the remaining subscriber only, got %+v", emails)
+	}
+}
+
+func TestEmailDigestHour(t *testing.T) {
+	ts, _ := newEmailTestService(t)
+	ts.cfg.EmailDigestHour = 8
+	day := func(d int, h int, m int) time.Time {
+		return time.Date(2021, 3, d, h, m, 0, 0, time.UTC)
+	}
+	for _, test := range []struct {
+		now    time.Time
+		sentOn string
+		due    bool
+	}{
+		{day(1, 7, 59), "", false},
+		{day(1, 8, 0), "", true},
+		{day(1, 8, 59), "", true},
+		{day(1, 9, 0), "", false},
+		{day(1, 8, 10), "2021-03-01", false},
+		{day(2, 8, 0), "2021-03-01", true},
+		// the hour is in UTC
+		{time.Date(2021, 3, 1, 8, 30, 0, 0, time.FixedZone("CET", 3600)), "", false},
+		{time.Date(2021, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600)), "", true},
+	} {
+		if due := ts.digestDue(test.now, test.sentOn); due != test.due {
+			t.Fatalf("expected due %v at %v after sending on %q, got %v", test.due, test.now, test.sentOn, due)
+		}
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	ctx := context.Background()
+	ts, server := newEmailTestService(t)
+	ts.addIssue(t, 1)
+	ts.addIssue(t, 2)
+	ts.subscribe(t, server, &EmailSubscriber{Address: "digest@example.com", Role: EmailMaintainer, Digest: true})
+	seq, err := ts.store.LastEventSeq(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.store.PutCursor(ctx, emailDigestCursor, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// digest subscribers get no email per donation
+	ts.donate(t, 1, 1000)
+	ts.donate(t, 1, 2000)
+	ts.donate(t, 2, 500)
+	if emails := server.take(); len(emails) != 0 {
+		t.Fatalf("digest subscriber got %+v", emails)
+	}
+
+	err = ts.sendDigests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.processOutbox(ctx, OutboxEmail)
+	emails := server.take()
+	if len(emails) != 1 || emails[0].subject != "3,500 sats donated to owner/repo today" {
+		t.Fatalf("expected a digest, got %+v", emails)
+	}
+	body := emails[0].body
+	first := strings.Index(body, "- 3,000 sats in 2 donations to #1 Fix the bug, now 3,000 sats")
+	second := strings.Index(body, "- 500 sats in 1 donation to #2 Fix the bug, now 500 sats")
+	if first < 0 || second < first || !strings.Contains(body, "Unsubscribe: ") {
+		t.Fatalf("unexpected digest %q", body)
+	}
+
+	// the next digest starts after the donations of this one
+	err = ts.sendDigests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.processOutbox(ctx, OutboxEmail)
+	if emails := server.take(); len(emails) != 0 {
+		t.Fatalf("empty digest was sent: %+v", emails)
+	}
+}
@@ -0,0 +1,389 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventBountyCreated   EventType = "bounty_created"
+	EventBountyReopened  EventType = "bounty_reopened"
+	EventBountyClosed    EventType = "bounty_closed"
+	EventInvoiceCreated  EventType = "invoice_created"
+	EventInvoiceSettled  EventType = "invoice_settled"
+	EventInvoiceCanceled EventType = "invoice_canceled"
+	EventOnchainDeposit  EventType = "onchain_deposit"
+	EventPayout          EventType = "payout"
+	EventRefund          EventType = "donation_refunded"
+	EventClaimCreated    EventType = "claim_created"
+	EventClaimAwarded    EventType = "claim_awarded"
+	EventClaimExpired    EventType = "claim_expired"
+	EventBountyAdjusted  EventType = "bounty_adjusted"
+	EventNodeUnreachable EventType = "node_unreachable"
+	EventNodeReachable   EventType = "node_reachable"
+	EventNodeOutage      EventType = "node_outage"
+
+	eventBufferSize   = 64
+	eventBatchSize    = 100
+	eventPollInterval = time.Minute
+)
+
+// Event is a change of a bounty. Events are committed together with the
+// change and published on the event bus of the IssueService.
+type Event struct {
+	// position in the event log, assigned when the event is committed
+	Seq       uint64    `json:"seq"`
+	Type      EventType `json:"type"`
+	IssueId   int64     `json:"issue_id"`
+	Owner     string    `json:"owner"`
+	Repo      string    `json:"repo"`
+	Number    int64     `json:"number"`
+	Url       string    `json:"url"`
+	Bounty    int64     `json:"bounty"`
+	Amount    int64     `json:"amount,omitempty"`
+	Invoice   string    `json:"invoice,omitempty"`
+	Txid      string    `json:"txid,omitempty"`
+	Pubkey    string    `json:"pubkey,omitempty"`
+	Hunter    string    `json:"hunter,omitempty"`
+	Timestamp int64     `json:"timestamp"`
+}
+
+// EventFilter selects events of a subscription, empty fields match all
+// events.
+type EventFilter struct {
+	// repository as owner/name
+	Repo    string
+	IssueId int64
+	Types   []EventType
+}
+
+func (filter *EventFilter) matches(event *Event) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.Repo != "" && !strings.EqualFold(filter.Repo, event.Owner+"/"+event.Repo) {
+		return false
+	}
+	if filter.IssueId != 0 && filter.IssueId != event.IssueId {
+		return false
+	}
+	if len(filter.Types) == 0 {
+		return true
+	}
+	for _, eventType := range filter.Types {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// EventLog is the persistent log of committed events, see IssueStore.Commit.
+type EventLog interface {
+	EventsSince(ctx context.Context, seq uint64, limit int) ([]*Event, error)
+	LastEventSeq(ctx context.Context) (uint64, error)
+	GetCursor(ctx context.Context, name string) (uint64, error)
+	PutCursor(ctx context.Context, name string, seq uint64) error
+	// PutDeadEvent stores an event a handler gave up on, replacing the
+	// previous record of the same handler and event.
+	PutDeadEvent(context.Context, *DeadEvent) error
+	DeleteDeadEvent(ctx context.Context, handler string, seq uint64) error
+	ListDeadEvents(ctx context.Context) ([]*DeadEvent, error)
+}
+
+// DeadEvent is an event that a handler failed on until its retry policy was
+// exhausted. The handler moves on to the next event, dead events are kept
+// until they are retried successfully.
+type DeadEvent struct {
+	Handler   string `json:"handler"`
+	Event     *Event `json:"event"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	FailedAt  int64  `json:"failed_at"`
+}
+
+// EventHandler processes an event, returning an error makes the bus retry it
+// according to the retry policy of the handler.
+type EventHandler func(ctx context.Context, event *Event) error
+
+// RetryPolicy configures how failed events are retried. A MaxAttempts of 0
+// retries until the event succeeds, which blocks all later events of the
+// handler, so handlers with side effects that can fail for long should
+// bound their attempts.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// EventBus delivers committed events. Subscribers get live events and never
+// block publishing, events are dropped for subscribers that don't keep up.
+// Handlers read the event log from their persisted cursor, so they run
+// asynchronously, retry on failure and catch up after a restart.
+type EventBus struct {
+	log         EventLog
+	subscribers map[int]*eventSubscriber
+	handlers    []*eventHandler
+	nextId      int
+	sync.Mutex
+}
+
+type eventSubscriber struct {
+	filter *EventFilter
+	events chan *Event
+}
+
+type eventHandler struct {
+	name   string
+	filter *EventFilter
+	handle EventHandler
+	policy RetryPolicy
+	wake   chan struct{}
+}
+
+func NewEventBus(log EventLog) *EventBus {
+	return &EventBus{log: log, subscribers: make(map[int]*eventSubscriber)}
+}
+
+// Subscribe returns the events matching the filter and a function that ends
+// the subscription.
+func (bus *EventBus) Subscribe(filter *EventFilter) (<-chan *Event, func()) {
+	bus.Lock()
+	defer bus.Unlock()
+	id := bus.nextId
+	bus.nextId++
+	sub := &eventSubscriber{filter: filter, events: make(chan *Event, eventBufferSize)}
+	bus.subscribers[id] = sub
+	return sub.events, func() {
+		bus.Lock()
+		defer bus.Unlock()
+		delete(bus.subscribers, id)
+	}
+}
+
+// Handle registers a handler under a unique name, handlers have to be
+// registered before the bus is started.
+func (bus *EventBus) Handle(name string, filter *EventFilter, handle EventHandler, policy RetryPolicy) {
+	bus.Lock()
+	defer bus.Unlock()
+	bus.handlers = append(bus.handlers, &eventHandler{
+		name:   name,
+		filter: filter,
+		handle: handle,
+		policy: policy,
+		wake:   make(chan struct{}, 1),
+	})
+}
+
+// Start runs the registered handlers until the context is canceled. Handlers
+// that run for the first time start at the latest event.
+func (bus *EventBus) Start(ctx context.Context) error {
+	bus.Lock()
+	defer bus.Unlock()
+	for _, handler := range bus.handlers {
+		cursor, err := bus.log.GetCursor(ctx, handler.name)
+		if err == ErrDoesNotExist {
+			cursor, err = bus.log.LastEventSeq(ctx)
+			if err == nil {
+				err = bus.log.PutCursor(ctx, handler.name, cursor)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("unable to get cursor of %v: %v", handler.name, err)
+		}
+		go bus.runHandler(ctx, handler, cursor)
+	}
+	return nil
+}
+
+// Publish delivers committed events to the subscribers and wakes the
+// handlers.
+func (bus *EventBus) Publish(events ...*Event) {
+	bus.Lock()
+	defer bus.Unlock()
+	for _, event := range events {
+		for id, sub := range bus.subscribers {
+			if !sub.filter.matches(event) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				fmt.Printf("dropped %v event for slow subscriber %v \n", event.Type, id)
+			}
+		}
+	}
+	for _, handler := range bus.handlers {
+		select {
+		case handler.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (bus *EventBus) runHandler(ctx context.Context, handler *eventHandler, cursor uint64) {
+	for {
+		events, err := bus.log.EventsSince(ctx, cursor, eventBatchSize)
+		if err != nil {
+			fmt.Printf("unable to read events for %v: %v \n", handler.name, err)
+		}
+		for _, event := range events {
+			if handler.filter.matches(event) {
+				attempts, err := bus.deliver(ctx, handler, event)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					bus.deadLetter(ctx, handler, event, attempts, err)
+				}
+			}
+			cursor = event.Seq
+			err = bus.log.PutCursor(ctx, handler.name, cursor)
+			if err != nil {
+				fmt.Printf("unable to store cursor of %v: %v \n", handler.name, err)
+			}
+		}
+		if len(events) == eventBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-handler.wake:
+		case <-time.After(eventPollInterval):
+		}
+	}
+}
+
+// deliver calls the handler until it succeeds, the retry policy is exhausted
+// or the context is canceled. It returns the number of attempts and the last
+// error if the handler didn't succeed.
+func (bus *EventBus) deliver(ctx context.Context, handler *eventHandler, event *Event) (int, error) {
+	backoff := handler.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := handler.handle(ctx, event)
+		if err == nil {
+			return attempt, nil
+		}
+		if handler.policy.MaxAttempts != 0 && attempt >= handler.policy.MaxAttempts {
+			fmt.Printf("giving up on %v event %v for %v after %v attempts: %v \n", event.Type, event.Seq, handler.name, attempt, err)
+			return attempt, err
+		}
+		fmt.Printf("%v failed on %v event %v, retrying in %v: %v \n", handler.name, event.Type, event.Seq, backoff, err)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if handler.policy.MaxBackoff != 0 && backoff > handler.policy.MaxBackoff {
+			backoff = handler.policy.MaxBackoff
+		}
+	}
+}
+
+func (bus *EventBus) deadLetter(ctx context.Context, handler *eventHandler, event *Event, attempts int, handleErr error) {
+	err := bus.log.PutDeadEvent(ctx, &DeadEvent{
+		Handler:   handler.name,
+		Event:     event,
+		Attempts:  attempts,
+		LastError: handleErr.Error(),
+		FailedAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		fmt.Printf("unable to store dead %v event %v of %v: %v \n", event.Type, event.Seq, handler.name, err)
+	}
+}
+
+// RetryDeadEvents hands the dead events to their handlers once more, events
+// that succeed are removed. It returns the events that are still dead.
+func (bus *EventBus) RetryDeadEvents(ctx context.Context) ([]*DeadEvent, error) {
+	dead, err := bus.log.ListDeadEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	handlers := make(map[string]*eventHandler)
+	bus.Lock()
+	for _, handler := range bus.handlers {
+		handlers[handler.name] = handler
+	}
+	bus.Unlock()
+	remaining := []*DeadEvent{}
+	for _, deadEvent := range dead {
+		handler, ok := handlers[deadEvent.Handler]
+		if !ok {
+			remaining = append(remaining, deadEvent)
+			continue
+		}
+		err = handler.handle(ctx, deadEvent.Event)
+		if err == nil {
+			err = bus.log.DeleteDeadEvent(ctx, deadEvent.Handler, deadEvent.Event.Seq)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		deadEvent.Attempts++
+		deadEvent.LastError = err.Error()
+		deadEvent.FailedAt = time.Now().Unix()
+		err = bus.log.PutDeadEvent(ctx, deadEvent)
+		if err != nil {
+			return nil, err
+		}
+		remaining = append(remaining, deadEvent)
+	}
+	return remaining, nil
+}
+
+// ListDeadEvents returns the events that handlers gave up on.
+func (srv *IssueService) ListDeadEvents(ctx context.Context) ([]*DeadEvent, error) {
+	dead, err := srv.store.ListDeadEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dead == nil {
+		dead = []*DeadEvent{}
+	}
+	return dead, nil
+}
+
+// RetryDeadEvents retries the events that handlers gave up on and returns the
+// ones that failed again.
+func (srv *IssueService) RetryDeadEvents(ctx context.Context) ([]*DeadEvent, error) {
+	return srv.events.RetryDeadEvents(ctx)
+}
+
+// SubscribeEvents returns the bounty events matching the filter.
+func (srv *IssueService) SubscribeEvents(filter *EventFilter) (<-chan *Event, func()) {
+	return srv.events.Subscribe(filter)
+}
+
+// commit stores the issue together with its events and publishes them once
+// they are committed.
+func (srv *IssueService) commit(ctx context.Context, issue *BountyIssue, events ...*Event) error {
+	err := srv.store.Commit(ctx, issue, events...)
+	if err != nil {
+		return err
+	}
+	srv.events.Publish(events...)
+	return nil
+}
+
+// newEvent returns an event with the current state of an issue.
+func newEvent(eventType EventType, issue *BountyIssue) *Event {
+	return &Event{
+		Type:      eventType,
+		IssueId:   issue.Id,
+		Owner:     issue.Owner,
+		Repo:      issue.Repo,
+		Number:    issue.Number,
+		Url:       issue.HtmlUrl,
+		Bounty:    issue.Bounty,
+		Timestamp: time.Now().Unix(),
+	}
+}
@@ -0,0 +1,85 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventBusDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+
+	var (
+		mtx     sync.Mutex
+		failing = true
+		handled []uint64
+	)
+	bus := NewEventBus(ts.store)
+	bus.Handle("test", &EventFilter{Types: []EventType{EventBountyAdjusted}}, func(ctx context.Context, event *Event) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if failing && event.Amount == 1 {
+			return fmt.Errorf("unavailable")
+		}
+		handled = append(handled, event.Seq)
+		return nil
+	}, RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	err := bus.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := newEvent(EventBountyAdjusted, issue)
+	first.Amount = 1
+	second := newEvent(EventBountyAdjusted, issue)
+	second.Amount = 2
+	err = ts.store.Commit(ctx, issue, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(first, second)
+
+	// the failing event doesn't block the next one
+	eventually(t, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(handled) == 1 && handled[0] == second.Seq
+	}, "event after the failing one wasn't handled")
+	dead, err := ts.store.ListDeadEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Handler != "test" || dead[0].Event.Seq != first.Seq || dead[0].Attempts != 3 || dead[0].LastError != "unavailable" {
+		t.Fatalf("failing event wasn't dead lettered: %+v", dead)
+	}
+
+	remaining, err := bus.RetryDeadEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].Attempts != 4 {
+		t.Fatalf("expected the event to stay dead, got %+v", remaining)
+	}
+	mtx.Lock()
+	failing = false
+	mtx.Unlock()
+	remaining, err = bus.RetryDeadEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 0 {
+		t.Fatalf("retried event is still dead: %+v", remaining)
+	}
+	dead, err = ts.store.ListDeadEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 0 {
+		t.Fatalf("retried event wasn't removed: %+v", dead)
+	}
+}
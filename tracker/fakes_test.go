@@ -0,0 +1,533 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/coreos/bbolt"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	config "github.com/sputn1ck/github-bounty"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"github.com/sputn1ck/github-bounty/rates"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testNodeUri = "fake://benefactor"
+
+// fakeNode is an in-memory benefactor node with an on-chain wallet. It issues
+// regtest invoices signed with its own key, so they decode like real ones.
+type fakeNode struct {
+	key *btcec.PrivateKey
+
+	invoices map[string]*lightning.Invoice
+	subs     map[string][]chan *lightning.Invoice
+	txs      []*lightning.Transaction
+	sent     []*fakeSend
+	// if set, SendCoins waits until it is closed
+	sendGate chan struct{}
+	// returned by the next call of the named method
+	failures map[string]error
+	sync.Mutex
+}
+
+type fakeSend struct {
+	Address string
+	Sats    int64
+	Label   string
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeNode{
+		key:      key,
+		invoices: make(map[string]*lightning.Invoice),
+		subs:     make(map[string][]chan *lightning.Invoice),
+		failures: make(map[string]error),
+	}
+}
+
+func (n *fakeNode) pubkey() string {
+	return hex.EncodeToString(n.key.PubKey().SerializeCompressed())
+}
+
+// fail makes the next call of the method return err.
+func (n *fakeNode) fail(method string, err error) {
+	n.Lock()
+	defer n.Unlock()
+	n.failures[method] = err
+}
+
+func (n *fakeNode) failure(method string) error {
+	n.Lock()
+	defer n.Unlock()
+	err := n.failures[method]
+	delete(n.failures, method)
+	return err
+}
+
+func (n *fakeNode) CreateInvoice(ctx context.Context, req *lightning.InvoiceRequest) (*lightning.Invoice, error) {
+	if err := n.failure("CreateInvoice"); err != nil {
+		return nil, err
+	}
+	preimage := make([]byte, 32)
+	if _, err := rand.Read(preimage); err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(preimage)
+	opts := []func(*zpay32.Invoice){zpay32.Expiry(time.Hour)}
+	if req.Value > 0 {
+		opts = append(opts, zpay32.Amount(lnwire.MilliSatoshi(req.Value*1000)))
+	}
+	if len(req.DescriptionHash) == 32 {
+		// like backends that hash the description themselves
+		if hash := sha256.Sum256([]byte(req.Description)); !bytes.Equal(hash[:], req.DescriptionHash) {
+			return nil, lightning.DescriptionHashError
+		}
+		var descriptionHash [32]byte
+		copy(descriptionHash[:], req.DescriptionHash)
+		opts = append(opts, zpay32.DescriptionHash(descriptionHash))
+	} else {
+		opts = append(opts, zpay32.Description(req.Memo))
+	}
+	invoice, err := zpay32.NewInvoice(&chaincfg.RegressionNetParams, hash, time.Now(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	payreq, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return btcec.SignCompact(btcec.S256(), n.key, chainhash.HashB(msg), true)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	inv := &lightning.Invoice{
+		PaymentRequest: payreq,
+		PaymentHash:    hash[:],
+		Preimage:       preimage,
+		Value:          req.Value,
+		State:          lightning.InvoiceOpen,
+	}
+	n.Lock()
+	n.invoices[hex.EncodeToString(hash[:])] = inv
+	n.Unlock()
+	copied := *inv
+	return &copied, nil
+}
+
+// settle marks the invoice as paid with the amount, which may differ from the
+// requested one, and notifies its subscribers.
+func (n *fakeNode) settle(payreq string, sats int64) *lightning.Invoice {
+	return n.setState(payreq, lightning.InvoiceSettled, sats)
+}
+
+func (n *fakeNode) cancel(payreq string) *lightning.Invoice {
+	return n.setState(payreq, lightning.InvoiceCanceled, 0)
+}
+
+func (n *fakeNode) setState(payreq string, state lightning.InvoiceState, sats int64) *lightning.Invoice {
+	n.Lock()
+	defer n.Unlock()
+	for hash, inv := range n.invoices {
+		if inv.PaymentRequest != payreq {
+			continue
+		}
+		inv.State = state
+		if state == lightning.InvoiceSettled {
+			inv.Value = sats
+			inv.SettleDate = time.Now().Unix()
+		}
+		for _, sub := range n.subs[hash] {
+			copied := *inv
+			select {
+			case sub <- &copied:
+			default:
+			}
+		}
+		copied := *inv
+		return &copied
+	}
+	return nil
+}
+
+func (n *fakeNode) LookupInvoice(ctx context.Context, paymentHash []byte) (*lightning.Invoice, error) {
+	if err := n.failure("LookupInvoice"); err != nil {
+		return nil, err
+	}
+	n.Lock()
+	defer n.Unlock()
+	inv, ok := n.invoices[hex.EncodeToString(paymentHash)]
+	if !ok {
+		return nil, fmt.Errorf("unable to locate invoice")
+	}
+	copied := *inv
+	return &copied, nil
+}
+
+func (n *fakeNode) ListInvoices(ctx context.Context) ([]*lightning.Invoice, error) {
+	n.Lock()
+	defer n.Unlock()
+	var invoices []*lightning.Invoice
+	for _, inv := range n.invoices {
+		copied := *inv
+		invoices = append(invoices, &copied)
+	}
+	return invoices, nil
+}
+
+func (n *fakeNode) SubscribeInvoice(ctx context.Context, paymentHash []byte) (lightning.InvoiceSubscription, error) {
+	if err := n.failure("SubscribeInvoice"); err != nil {
+		return nil, err
+	}
+	n.Lock()
+	defer n.Unlock()
+	hash := hex.EncodeToString(paymentHash)
+	inv, ok := n.invoices[hash]
+	if !ok {
+		return nil, fmt.Errorf("unable to locate invoice")
+	}
+	updates := make(chan *lightning.Invoice, 8)
+	copied := *inv
+	updates <- &copied
+	n.subs[hash] = append(n.subs[hash], updates)
+	return &fakeSubscription{ctx: ctx, updates: updates}, nil
+}
+
+type fakeSubscription struct {
+	ctx     context.Context
+	updates chan *lightning.Invoice
+}
+
+func (sub *fakeSubscription) Recv() (*lightning.Invoice, error) {
+	select {
+	case <-sub.ctx.Done():
+		return nil, sub.ctx.Err()
+	case inv, ok := <-sub.updates:
+		if !ok {
+			return nil, &net.OpError{Op: "read", Net: "tcp", Err: fmt.Errorf("connection reset by peer")}
+		}
+		return inv, nil
+	}
+}
+
+// dropSubscriptions fails all open invoice subscriptions as if the
+// connection to the node was lost.
+func (n *fakeNode) dropSubscriptions() int {
+	n.Lock()
+	defer n.Unlock()
+	var dropped int
+	for hash, subs := range n.subs {
+		for _, sub := range subs {
+			close(sub)
+			dropped++
+		}
+		delete(n.subs, hash)
+	}
+	return dropped
+}
+
+func (n *fakeNode) PayInvoice(ctx context.Context, payreq string) (*lightning.Payment, error) {
+	return nil, lightning.NotSupportedError
+}
+
+func (n *fakeNode) DecodeInvoice(ctx context.Context, payreq string) (*lightning.PayReq, error) {
+	return lightning.DecodePayReq(payreq)
+}
+
+func (n *fakeNode) GetInfo(ctx context.Context) (*lightning.NodeInfo, error) {
+	if err := n.failure("GetInfo"); err != nil {
+		return nil, err
+	}
+	return &lightning.NodeInfo{Pubkey: n.pubkey(), Alias: "fake"}, nil
+}
+
+func (n *fakeNode) Close() error {
+	return nil
+}
+
+func (n *fakeNode) NewAddress(ctx context.Context) (string, error) {
+	if err := n.failure("NewAddress"); err != nil {
+		return "", err
+	}
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return "", err
+	}
+	pkHash := btcutil.Hash160(key.PubKey().SerializeCompressed())
+	address, err := btcutil.NewAddressWitnessPubKeyHash(pkHash, &chaincfg.RegressionNetParams)
+	if err != nil {
+		return "", err
+	}
+	return address.EncodeAddress(), nil
+}
+
+// deposit adds a wallet transaction paying sats to the address.
+func (n *fakeNode) deposit(t *testing.T, address string, sats int64, confs int32) string {
+	script, err := addressScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Lock()
+	defer n.Unlock()
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(len(n.txs))}, nil, nil))
+	// a change output of another wallet comes first, so the deposit is the
+	// second output
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	tx.AddTxOut(wire.NewTxOut(sats, script))
+	var rawTx bytes.Buffer
+	if err := tx.Serialize(&rawTx); err != nil {
+		t.Fatal(err)
+	}
+	n.txs = append(n.txs, &lightning.Transaction{
+		TxHash:           tx.TxHash().String(),
+		NumConfirmations: confs,
+		RawTx:            rawTx.Bytes(),
+	})
+	return tx.TxHash().String() + ":1"
+}
+
+func (n *fakeNode) GetTransactions(ctx context.Context) ([]*lightning.Transaction, error) {
+	n.Lock()
+	defer n.Unlock()
+	return append([]*lightning.Transaction{}, n.txs...), nil
+}
+
+func (n *fakeNode) SubscribeTransactions(ctx context.Context) (lightning.TransactionSubscription, error) {
+	return &fakeTxSubscription{ctx: ctx}, nil
+}
+
+type fakeTxSubscription struct {
+	ctx context.Context
+}
+
+func (sub *fakeTxSubscription) Recv() (*lightning.Transaction, error) {
+	<-sub.ctx.Done()
+	return nil, sub.ctx.Err()
+}
+
+func (n *fakeNode) SendCoins(ctx context.Context, address string, sats int64, targetConf int32, label string) (string, error) {
+	if err := n.failure("SendCoins"); err != nil {
+		return "", err
+	}
+	n.Lock()
+	gate := n.sendGate
+	n.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	n.Lock()
+	defer n.Unlock()
+	n.sent = append(n.sent, &fakeSend{Address: address, Sats: sats, Label: label})
+	txid := sha256.Sum256([]byte(fmt.Sprintf("%v/%v/%v", address, sats, len(n.sent))))
+	return hex.EncodeToString(txid[:]), nil
+}
+
+// fakeGithub records the comments of the bot instead of posting them.
+type fakeGithub struct {
+	comments map[int64]string
+	nextId   int64
+	// repository files by owner/repo/path
+	files map[string][]byte
+	// returned by the next call of the named method
+	failures map[string]error
+	sync.Mutex
+}
+
+func newFakeGithub() *fakeGithub {
+	return &fakeGithub{comments: make(map[int64]string), nextId: 1, files: make(map[string][]byte), failures: make(map[string]error)}
+}
+
+func (gh *fakeGithub) fail(method string, err error) {
+	gh.Lock()
+	defer gh.Unlock()
+	gh.failures[method] = err
+}
+
+func (gh *fakeGithub) failure(method string) error {
+	err := gh.failures[method]
+	delete(gh.failures, method)
+	return err
+}
+
+func (gh *fakeGithub) AddComment(ctx context.Context, bountyIssue *BountyIssue, body string) (int64, error) {
+	gh.Lock()
+	defer gh.Unlock()
+	if err := gh.failure("AddComment"); err != nil {
+		return 0, err
+	}
+	id := gh.nextId
+	gh.nextId++
+	gh.comments[id] = body
+	return id, nil
+}
+
+func (gh *fakeGithub) EditComment(ctx context.Context, bountyIssue *BountyIssue, body string) error {
+	gh.Lock()
+	defer gh.Unlock()
+	if err := gh.failure("EditComment"); err != nil {
+		return err
+	}
+	gh.comments[bountyIssue.CommentId] = body
+	return nil
+}
+
+func (gh *fakeGithub) FindComment(ctx context.Context, bountyIssue *BountyIssue, marker string) (int64, error) {
+	gh.Lock()
+	defer gh.Unlock()
+	if err := gh.failure("FindComment"); err != nil {
+		return 0, err
+	}
+	for id := int64(1); id < gh.nextId; id++ {
+		if body, ok := gh.comments[id]; ok && strings.Contains(body, marker) {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+func (gh *fakeGithub) AddCommitComment(ctx context.Context, owner string, repo string, sha string, body string) error {
+	return nil
+}
+
+func (gh *fakeGithub) CreateLabel(ctx context.Context, owner string, repo string, name string, color string, description string) error {
+	return nil
+}
+
+func (gh *fakeGithub) GetRepoFile(ctx context.Context, owner string, repo string, path string) ([]byte, error) {
+	gh.Lock()
+	defer gh.Unlock()
+	if err := gh.failure("GetRepoFile"); err != nil {
+		return nil, err
+	}
+	content, ok := gh.files[owner+"/"+repo+"/"+path]
+	if !ok {
+		return nil, ErrDoesNotExist
+	}
+	return content, nil
+}
+
+func (gh *fakeGithub) setFile(path string, content string) {
+	gh.Lock()
+	defer gh.Unlock()
+	gh.files["owner/repo/"+path] = []byte(content)
+}
+
+func (gh *fakeGithub) commentCount() int {
+	gh.Lock()
+	defer gh.Unlock()
+	return len(gh.comments)
+}
+
+// testService is an IssueService on a temporary database whose benefactor
+// node is a fakeNode.
+type testService struct {
+	*IssueService
+	node   *fakeNode
+	github *fakeGithub
+	db     *bbolt.DB
+}
+
+func newTestService(t *testing.T, configure ...func(*config.Config)) *testService {
+	cfg := config.DefaultConfig()
+	cfg.LndConnect = testNodeUri
+	cfg.OnchainConfirmations = 3
+	for _, c := range configure {
+		c(cfg)
+	}
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "bounty.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	issueStore, err := NewBountyIssueStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoStore, err := NewRepositoryStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outboxStore, err := NewPendingOperationStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthStore, err := NewNodeHealthStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optOutStore, err := NewLeaderboardOptOutStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveryStore, err := NewNotificationDeliveryStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledgerStore, err := NewBountyLedgerStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := newFakeNode(t)
+	pool := lightning.NewPool(time.Second, 0)
+	pool.SetDialer(func(ctx context.Context, uri string) (lightning.LightningNode, error) {
+		if uri != testNodeUri {
+			return nil, fmt.Errorf("unknown node %v", uri)
+		}
+		return node, nil
+	})
+	gh := newFakeGithub()
+	srv := NewIssueService(cfg, issueStore, repoStore, healthStore, outboxStore, optOutStore, deliveryStore, ledgerStore, gh, pool, rates.Static{"USD": 50000})
+	return &testService{IssueService: srv, node: node, github: gh, db: db}
+}
+
+// addIssue creates an active bounty on the fake node.
+func (ts *testService) addIssue(t *testing.T, id int64) *BountyIssue {
+	repo, err := ts.LoadRepoFile(context.Background(), "owner", "repo", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue, err := ts.AddBountyIssue(context.Background(), id, fmt.Sprintf("https://api.github.com/repos/owner/repo/issues/%d", id),
+		fmt.Sprintf("https://github.com/owner/repo/issues/%d", id), "Fix the bug", repo, id, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issue
+}
+
+func (ts *testService) issue(t *testing.T, id int64) *BountyIssue {
+	issue, err := ts.store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issue
+}
+
+// eventually fails the test if cond doesn't hold within a few seconds.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
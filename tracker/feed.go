@@ -0,0 +1,320 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	feedPath = "/feed/:format"
+
+	ownerkey = "owner"
+
+	// items kept in memory and items per feed
+	feedMaxItems = 5000
+	feedSize     = 50
+)
+
+type FeedItemKind string
+
+const (
+	FeedNewBounty    FeedItemKind = "new"
+	FeedFundedBounty FeedItemKind = "funded"
+	FeedClosedBounty FeedItemKind = "closed"
+)
+
+// FeedItem announces a new bounty, a bounty crossing an amount threshold or
+// a closed bounty.
+type FeedItem struct {
+	Seq     uint64
+	Kind    FeedItemKind
+	IssueId int64
+	Owner   string
+	Repo    string
+	Number  int64
+	Bounty  int64
+	// threshold that was crossed by funded items
+	Threshold int64
+	Time      time.Time
+}
+
+// feed builds the feed items from the event log, it catches up with the log
+// whenever a feed is requested.
+type feed struct {
+	seq      uint64
+	bounties map[int64]int64
+	items    []*FeedItem
+	sync.Mutex
+}
+
+func newFeed() *feed {
+	return &feed{bounties: make(map[int64]int64)}
+}
+
+// FeedItems returns the latest feed items, newest first. The owner and repo
+// are optional.
+func (srv *IssueService) FeedItems(ctx context.Context, owner string, name string) ([]*FeedItem, error) {
+	srv.feed.Lock()
+	defer srv.feed.Unlock()
+	for {
+		events, err := srv.store.EventsSince(ctx, srv.feed.seq, eventBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, event := range events {
+			srv.addFeedEvent(event)
+			srv.feed.seq = event.Seq
+		}
+	}
+	var items []*FeedItem
+	for i := len(srv.feed.items) - 1; i >= 0 && len(items) < feedSize; i-- {
+		item := srv.feed.items[i]
+		if owner != "" && !strings.EqualFold(item.Owner, owner) {
+			continue
+		}
+		if name != "" && !strings.EqualFold(item.Repo, name) {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (srv *IssueService) addFeedEvent(event *Event) {
+	item := &FeedItem{
+		Seq:     event.Seq,
+		IssueId: event.IssueId,
+		Owner:   event.Owner,
+		Repo:    event.Repo,
+		Number:  event.Number,
+		Bounty:  event.Bounty,
+		Time:    time.Unix(event.Timestamp, 0),
+	}
+	switch event.Type {
+	case EventBountyCreated:
+		item.Kind = FeedNewBounty
+		srv.feed.bounties[event.IssueId] = event.Bounty
+	case EventInvoiceSettled, EventOnchainDeposit:
+		prev := srv.feed.bounties[event.IssueId]
+		srv.feed.bounties[event.IssueId] = event.Bounty
+		// only the highest threshold crossed by a donation is announced
+		for _, threshold := range srv.cfg.FeedThresholds {
+			if prev < threshold && event.Bounty >= threshold && threshold > item.Threshold {
+				item.Threshold = threshold
+			}
+		}
+		if item.Threshold == 0 {
+			return
+		}
+		item.Kind = FeedFundedBounty
+	case EventBountyClosed:
+		item.Kind = FeedClosedBounty
+	default:
+		return
+	}
+	srv.feed.items = append(srv.feed.items, item)
+	if len(srv.feed.items) > feedMaxItems {
+		srv.feed.items = srv.feed.items[len(srv.feed.items)-feedMaxItems:]
+	}
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Author  atomAuthor   `xml:"author"`
+	Links   []atomLink   `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Id         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Updated    string     `xml:"updated"`
+	Link       atomLink   `xml:"link"`
+	Summary    string     `xml:"summary"`
+	Categories []atomTerm `xml:"category"`
+}
+
+type atomTerm struct {
+	Term string `xml:"term,attr"`
+}
+
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageUrl string          `json:"home_page_url,omitempty"`
+	FeedUrl     string          `json:"feed_url"`
+	Items       []*jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	Id            string   `json:"id"`
+	Url           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentText   string   `json:"content_text"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags"`
+}
+
+// feedEntry holds the rendered texts of a feed item.
+type feedEntry struct {
+	id      string
+	title   string
+	summary string
+	url     string
+	time    string
+	kind    string
+}
+
+// handleFeed serves the feed as atom or json feed, for all bounties or the
+// ones of an owner or repository.
+func (wh *WebhookHandler) handleFeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+	owner, name := query.Get(ownerkey), ""
+	title := "Lightning bounties"
+	if repo := query.Get(repokey); repo != "" {
+		names := strings.Split(repo, "/")
+		if len(names) != 2 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, %s must be owner/repo", repokey))
+			return
+		}
+		owner, name = names[0], names[1]
+	}
+	if owner != "" {
+		title += " of " + owner
+		if name != "" {
+			title += "/" + name
+		}
+	}
+	format := ps.ByName("format")
+	if format != "atom" && format != "json" {
+		writeError(w, http.StatusNotFound, "unknown feed format, use atom or json")
+		return
+	}
+	items, err := wh.is.FeedItems(r.Context(), owner, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	feedUrl := wh.cfg.HttpUrl + r.URL.RequestURI()
+	homeUrl := ""
+	if owner != "" {
+		homeUrl = wh.cfg.HttpUrl + boardPath + "/" + owner
+		if name != "" {
+			homeUrl += "/" + name
+		}
+	}
+	entries := make([]*feedEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, wh.feedEntry(r.Context(), item))
+	}
+	if format == "json" {
+		res := &jsonFeed{
+			Version:     "https://jsonfeed.org/version/1.1",
+			Title:       title,
+			HomePageUrl: homeUrl,
+			FeedUrl:     feedUrl,
+			Items:       []*jsonFeedItem{},
+		}
+		for _, entry := range entries {
+			res.Items = append(res.Items, &jsonFeedItem{
+				Id:            entry.id,
+				Url:           entry.url,
+				Title:         entry.title,
+				ContentText:   entry.summary,
+				DatePublished: entry.time,
+				Tags:          []string{entry.kind},
+			})
+		}
+		w.Header().Set("Content-Type", "application/feed+json")
+		err = json.NewEncoder(w).Encode(res)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		}
+		return
+	}
+	res := &atomFeed{
+		Id:      feedUrl,
+		Title:   title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "github-bounty"},
+		Links:   []atomLink{{Href: feedUrl, Rel: "self"}},
+	}
+	if homeUrl != "" {
+		res.Links = append(res.Links, atomLink{Href: homeUrl})
+	}
+	if len(entries) > 0 {
+		res.Updated = entries[0].time
+	}
+	for _, entry := range entries {
+		res.Entries = append(res.Entries, &atomEntry{
+			Id:         entry.id,
+			Title:      entry.title,
+			Updated:    entry.time,
+			Link:       atomLink{Href: entry.url},
+			Summary:    entry.summary,
+			Categories: []atomTerm{{Term: entry.kind}},
+		})
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	err = xml.NewEncoder(w).Encode(res)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+	}
+}
+
+// feedEntry renders a feed item with the current title and link of its
+// issue.
+func (wh *WebhookHandler) feedEntry(ctx context.Context, item *FeedItem) *feedEntry {
+	issue := fmt.Sprintf("%s/%s#%d", item.Owner, item.Repo, item.Number)
+	url := fmt.Sprintf("https://github.com/%s/%s/issues/%d", item.Owner, item.Repo, item.Number)
+	if bountyIssue, err := wh.is.GetBountyIssue(ctx, item.IssueId); err == nil {
+		if bountyIssue.Title != "" {
+			issue = bountyIssue.Title + " (" + issue + ")"
+		}
+		if bountyIssue.HtmlUrl != "" {
+			url = bountyIssue.HtmlUrl
+		}
+	}
+	entry := &feedEntry{
+		id:   fmt.Sprintf("%s/feed/event/%d", wh.cfg.HttpUrl, item.Seq),
+		url:  url,
+		time: item.Time.UTC().Format(time.RFC3339),
+		kind: string(item.Kind),
+	}
+	donate := "Donate at " + wh.is.donateUrl(item.IssueId)
+	switch item.Kind {
+	case FeedNewBounty:
+		entry.title = "New bounty: " + issue
+		entry.summary = fmt.Sprintf("A lightning bounty was created on %s. %s", issue, donate)
+	case FeedFundedBounty:
+		entry.title = fmt.Sprintf("Bounty reached %s sats: %s", formatThousands(item.Threshold), issue)
+		entry.summary = fmt.Sprintf("The bounty on %s reached %s sats. %s", issue, formatThousands(item.Bounty), donate)
+	case FeedClosedBounty:
+		entry.title = "Bounty closed: " + issue
+		entry.summary = fmt.Sprintf("The issue %s was closed with a bounty of %s sats.", issue, formatThousands(item.Bounty))
+	}
+	return entry
+}
@@ -0,0 +1,225 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// feedTestService returns a new bounty that crossed a threshold, a closed
+// bounty and a bounty of another owner.
+func feedTestService(t *testing.T) *testService {
+	ctx := context.Background()
+	ts := newTestService(t)
+	ts.cfg.HttpUrl = "https://bounties.example.com"
+	ts.addIssue(t, 1)
+	ts.creditedInvoice(t, 1, 5000)
+	// crossing two thresholds at once only announces the higher one
+	ts.creditedInvoice(t, 1, 150000)
+	ts.addIssue(t, 2)
+	ts.Lock()
+	issue := ts.issue(t, 2)
+	issue.Title = `Fix <this> & "that"`
+	err := ts.store.Update(ctx, issue)
+	ts.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.CloseIssue(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign := &BountyIssue{Id: 9, Owner: "someone", Repo: "lib", Number: 7, Active: true}
+	err = ts.store.Commit(ctx, foreign, newEvent(EventBountyCreated, foreign))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestFeedItems(t *testing.T) {
+	ctx := context.Background()
+	ts := feedTestService(t)
+	type expectedItem struct {
+		kind      FeedItemKind
+		issueId   int64
+		threshold int64
+	}
+	all := []expectedItem{
+		{FeedNewBounty, 9, 0},
+		{FeedClosedBounty, 2, 0},
+		{FeedNewBounty, 2, 0},
+		{FeedFundedBounty, 1, 100000},
+		{FeedNewBounty, 1, 0},
+	}
+	for _, test := range []struct {
+		owner    string
+		repo     string
+		expected []expectedItem
+	}{
+		{"", "", all},
+		{"OWNER", "", all[1:]},
+		{"owner", "repo", all[1:]},
+		{"owner", "lib", nil},
+		{"someone", "", all[:1]},
+	} {
+		items, err := ts.FeedItems(ctx, test.owner, test.repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != len(test.expected) {
+			t.Fatalf("expected %v items for %v/%v, got %v", len(test.expected), test.owner, test.repo, len(items))
+		}
+		for i, item := range items {
+			want := test.expected[i]
+			if item.Kind != want.kind || item.IssueId != want.issueId || item.Threshold != want.threshold {
+				t.Fatalf("expected item %+v, got %+v", want, item)
+			}
+		}
+	}
+	funded, err := ts.FeedItems(ctx, "owner", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if funded[2].Bounty != 155000 {
+		t.Fatalf("expected the funded item to have the bounty, got %v", funded[2].Bounty)
+	}
+}
+
+func serveFeed(t *testing.T, ts *testService, format string, query string) (int, http.Header, []byte) {
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	rec := serve(func(w http.ResponseWriter, r *http.Request) {
+		wh.handleFeed(w, r, httprouter.Params{{Key: "format", Value: format}})
+	}, "/feed/"+format+query)
+	return rec.Code, rec.Header(), rec.Body.Bytes()
+}
+
+func TestAtomFeed(t *testing.T) {
+	ts := feedTestService(t)
+	code, header, body := serveFeed(t, ts, "atom", "?repo=owner/repo")
+	if code != http.StatusOK || header.Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("unexpected response %v %v", code, header.Get("Content-Type"))
+	}
+	if !strings.HasPrefix(string(body), xml.Header) {
+		t.Fatal("feed has no xml declaration")
+	}
+	feed := &atomFeed{}
+	err := xml.Unmarshal(body, feed)
+	if err != nil {
+		t.Fatalf("invalid atom feed: %v", err)
+	}
+	if feed.XMLName.Space != "http://www.w3.org/2005/Atom" || feed.XMLName.Local != "feed" {
+		t.Fatalf("unexpected root element %v", feed.XMLName)
+	}
+	if feed.Title != "Lightning bounties of owner/repo" || feed.Id != "https://bounties.example.com/feed/atom?repo=owner/repo" ||
+		feed.Author.Name == "" {
+		t.Fatalf("unexpected feed %+v", feed)
+	}
+	if len(feed.Links) != 2 || feed.Links[0].Rel != "self" || feed.Links[0].Href != feed.Id ||
+		feed.Links[1].Href != "https://bounties.example.com/board/owner/repo" {
+		t.Fatalf("unexpected links %+v", feed.Links)
+	}
+	if len(feed.Entries) != 4 || feed.Updated != feed.Entries[0].Updated {
+		t.Fatalf("expected 4 entries and the time of the newest, got %+v", feed)
+	}
+	ids := make(map[string]bool)
+	for _, entry := range feed.Entries {
+		if entry.Id == "" || ids[entry.Id] || entry.Title == "" || entry.Link.Href == "" || len(entry.Categories) != 1 {
+			t.Fatalf("invalid entry %+v", entry)
+		}
+		ids[entry.Id] = true
+		if _, err := time.Parse(time.RFC3339, entry.Updated); err != nil {
+			t.Fatalf("invalid updated time %v", entry.Updated)
+		}
+	}
+	closed := feed.Entries[0]
+	if closed.Title != `Bounty closed: Fix <this> & "that" (owner/repo#2)` || closed.Categories[0].Term != "closed" ||
+		closed.Link.Href != "https://github.com/owner/repo/issues/2" {
+		t.Fatalf("unexpected closed entry %+v", closed)
+	}
+	if !strings.Contains(string(body), "Fix &lt;this&gt; &amp;") {
+		t.Fatal("title wasn't escaped")
+	}
+	if funded := feed.Entries[2]; funded.Title != "Bounty reached 100,000 sats: Fix the bug (owner/repo#1)" ||
+		!strings.Contains(funded.Summary, "reached 155,000 sats") {
+		t.Fatalf("unexpected funded entry %+v", funded)
+	}
+
+	// feeds without entries are valid too
+	_, _, body = serveFeed(t, ts, "atom", "?owner=nobody")
+	feed = &atomFeed{}
+	if err := xml.Unmarshal(body, feed); err != nil || len(feed.Entries) != 0 || feed.Updated == "" {
+		t.Fatalf("invalid empty feed %+v: %v", feed, err)
+	}
+}
+
+func TestJsonFeed(t *testing.T) {
+	ts := feedTestService(t)
+	code, header, body := serveFeed(t, ts, "json", "")
+	if code != http.StatusOK || header.Get("Content-Type") != "application/feed+json" {
+		t.Fatalf("unexpected response %v %v", code, header.Get("Content-Type"))
+	}
+	feed := make(map[string]interface{})
+	err := json.Unmarshal(body, &feed)
+	if err != nil {
+		t.Fatalf("invalid json feed: %v", err)
+	}
+	if feed["version"] != "https://jsonfeed.org/version/1.1" || feed["title"] != "Lightning bounties" ||
+		feed["feed_url"] != "https://bounties.example.com/feed/json" {
+		t.Fatalf("unexpected feed %v", feed)
+	}
+	// without owner there is no board to link to
+	if _, ok := feed["home_page_url"]; ok {
+		t.Fatalf("unexpected home page %v", feed["home_page_url"])
+	}
+	items, ok := feed["items"].([]interface{})
+	if !ok || len(items) != 5 {
+		t.Fatalf("expected 5 items, got %v", feed["items"])
+	}
+	for _, raw := range items {
+		item := raw.(map[string]interface{})
+		for _, key := range []string{"id", "url", "title", "content_text", "date_published"} {
+			if s, ok := item[key].(string); !ok || s == "" {
+				t.Fatalf("item has no %v: %v", key, item)
+			}
+		}
+		if _, err := time.Parse(time.RFC3339, item["date_published"].(string)); err != nil {
+			t.Fatalf("invalid date %v", item["date_published"])
+		}
+		if tags, ok := item["tags"].([]interface{}); !ok || len(tags) != 1 {
+			t.Fatalf("unexpected tags %v", item["tags"])
+		}
+	}
+	if first := items[0].(map[string]interface{}); first["title"] != "New bounty: someone/lib#7" ||
+		first["url"] != "https://github.com/someone/lib/issues/7" {
+		t.Fatalf("unexpected item %v", first)
+	}
+
+	// empty feeds have an empty list of items
+	_, _, body = serveFeed(t, ts, "json", "?owner=nobody")
+	if !strings.Contains(string(body), `"items":[]`) {
+		t.Fatalf("expected an empty item list, got %s", body)
+	}
+}
+
+func TestFeedErrors(t *testing.T) {
+	ts := newTestService(t)
+	for _, test := range []struct {
+		format string
+		query  string
+		code   int
+	}{
+		{"rss", "", http.StatusNotFound},
+		{"atom", "?repo=owner", http.StatusBadRequest},
+		{"json", "?repo=a/b/c", http.StatusBadRequest},
+	} {
+		if code, _, _ := serveFeed(t, ts, test.format, test.query); code != test.code {
+			t.Fatalf("expected %v for %v%v, got %v", test.code, test.format, test.query, code)
+		}
+	}
+}
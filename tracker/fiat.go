@@ -0,0 +1,131 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/rates"
+	"strings"
+	"time"
+)
+
+// Donation is a single donation to a bounty together with the exchange rate
+// at the time it was made.
+type Donation struct {
+	Amount    int64
+	Timestamp int64
+	// amount in the currency of the rate if the donor chose a fiat amount
+	FiatAmount float64
+	Rate       *rates.Rate
+	// optional note the donor left on the donation page
+	Note string
+	// optional github login or nickname of the donor and whether the
+	// donation is hidden from leaderboards
+	Donor   string
+	Private bool
+	// LNURL-pay metadata or NIP-57 zap request the invoice commits to
+	LnurlMetadata string
+	ZapRequest    string
+	// hex encoded preimage of settled invoices
+	Preimage string
+	// receipt signed by the benefactor node once the invoice is settled
+	Receipt *SignedReceipt
+	// unix time the donation was returned to the donor, 0 if it wasn't, and
+	// the optional transaction of the refund
+	RefundedAt int64
+	RefundTxid string
+}
+
+// lnurlDescription returns the description the invoice of the donation
+// commits to with its description hash, empty for donations outside of
+// LNURL-pay.
+func (donation *Donation) lnurlDescription() string {
+	if donation.ZapRequest != "" {
+		return donation.ZapRequest
+	}
+	return donation.LnurlMetadata
+}
+
+// RepoCurrency returns the fiat currency of a repository, the currency of the
+// repository file takes precedence over the one set by the operator.
+func (srv *IssueService) RepoCurrency(ctx context.Context, owner string, name string) string {
+	repo, err := srv.repoStore.Get(ctx, owner, name)
+	if err == nil && repo.File != nil && repo.File.Currency != "" {
+		return repo.File.Currency
+	}
+	if err == nil && repo.Currency != "" {
+		return repo.Currency
+	}
+	return strings.ToUpper(srv.cfg.Currency)
+}
+
+// GetFiatBountyInvoice returns an invoice for a fiat amount converted with the
+// current exchange rate and the amount in satoshis.
+func (srv *IssueService) GetFiatBountyInvoice(ctx context.Context, id int64, amount float64, currency string, donor *DonorInfo) (string, int64, error) {
+	rate, err := srv.rates.Rate(ctx, currency)
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to get exchange rate for %v: %v", currency, err)
+	}
+	sats := rate.ToSats(amount)
+	if sats <= 0 {
+		return "", 0, fmt.Errorf("invalid amount %v %v", amount, rate.Currency)
+	}
+	invoice, err := srv.createBountyInvoice(ctx, id, sats, &Donation{FiatAmount: amount, Rate: rate, Note: donor.Note, Donor: donor.Name, Private: donor.Private})
+	if err != nil {
+		return "", 0, err
+	}
+	return invoice, sats, nil
+}
+
+// SetRepoCurrency sets the fiat currency a repository's bounties are shown in.
+func (srv *IssueService) SetRepoCurrency(ctx context.Context, owner string, name string, currency string) (*Repository, error) {
+	currency = strings.ToUpper(currency)
+	if currency != "" {
+		if _, err := srv.rates.Rate(ctx, currency); err != nil {
+			return nil, fmt.Errorf("unable to get exchange rate for %v: %v", currency, err)
+		}
+	}
+	return srv.updateRepo(ctx, owner, name, func(repo *Repository) {
+		repo.Currency = currency
+	})
+}
+
+// recordDonation completes the donation record of a payment, donations
+// without a rate snapshot get the given current rate.
+func (srv *IssueService) recordDonation(issue *BountyIssue, key string, sats int64, rate *rates.Rate) {
+	if issue.Donations == nil {
+		issue.Donations = make(map[string]*Donation)
+	}
+	donation, ok := issue.Donations[key]
+	if !ok {
+		donation = &Donation{}
+		issue.Donations[key] = donation
+	}
+	donation.Amount = sats
+	donation.Timestamp = time.Now().Unix()
+	if donation.Rate == nil {
+		donation.Rate = rate
+	}
+}
+
+// updateRate refreshes the exchange rate that the bounty comment is shown in.
+func updateRate(issue *BountyIssue, rate *rates.Rate) {
+	if rate != nil {
+		issue.Rate = rate
+	}
+}
+
+// rateSnapshot returns the current rate of the repository currency, or nil if
+// the provider is unavailable or disabled, so that donations don't fail on
+// price apis. Rates are fetched before taking the service lock.
+func (srv *IssueService) rateSnapshot(ctx context.Context, issue *BountyIssue) *rates.Rate {
+	currency := srv.RepoCurrency(ctx, issue.Owner, issue.Repo)
+	rate, err := srv.rates.Rate(ctx, currency)
+	if err == rates.DisabledError {
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("unable to get exchange rate for %v: %v \n", currency, err)
+		return nil
+	}
+	return rate
+}
@@ -0,0 +1,135 @@
+package tracker
+
+import (
+	"context"
+	"github.com/sputn1ck/github-bounty/rates"
+	"testing"
+	"time"
+)
+
+// blockingRates blocks rate requests until they are released.
+type blockingRates struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingRates) Rate(ctx context.Context, currency string) (*rates.Rate, error) {
+	p.called <- struct{}{}
+	<-p.release
+	return &rates.Rate{Currency: currency, Price: 60000, Source: "blocking", Timestamp: time.Now().Unix()}, nil
+}
+
+func TestSettleInvoiceFetchesRateUnlocked(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	payreq, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the donation got its rate when the invoice was created
+	issue = ts.issue(t, issue.Id)
+	issue.Donations[payreq].Rate = nil
+	err = ts.store.Update(ctx, issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &blockingRates{called: make(chan struct{}), release: make(chan struct{})}
+	ts.rates = provider
+	settled := make(chan error)
+	go func() {
+		settled <- ts.SettleInvoice(ctx, issue, payreq, 1000, make([]byte, 32))
+	}()
+	<-provider.called
+
+	// other payments aren't held up by the slow price api
+	locked := make(chan error)
+	go func() {
+		locked <- ts.RemovePayment(ctx, issue, "lnbcrt1other")
+	}()
+	select {
+	case err = <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("rate was fetched under the service lock")
+	}
+
+	close(provider.release)
+	if err = <-settled; err != nil {
+		t.Fatal(err)
+	}
+	issue = ts.issue(t, issue.Id)
+	if issue.Bounty != 1000 || issue.Rate == nil || issue.Rate.Price != 60000 {
+		t.Fatalf("settlement wasn't recorded with the rate: %v sats, rate %+v", issue.Bounty, issue.Rate)
+	}
+	if rate := issue.Donations[payreq].Rate; rate == nil || rate.Price != 60000 {
+		t.Fatalf("donation didn't get the rate: %+v", rate)
+	}
+}
+
+func TestReopenFetchesRateUnlocked(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	issue.Bounty = 5000
+	err := ts.store.Update(ctx, issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.CloseIssue(ctx, issue.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := ts.LoadRepoFile(ctx, "owner", "repo", issue.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &blockingRates{called: make(chan struct{}), release: make(chan struct{})}
+	ts.rates = provider
+	reopened := make(chan error)
+	go func() {
+		_, err := ts.AddBountyIssue(ctx, issue.Id, issue.Url, issue.HtmlUrl, "Fix the bug again", repo, issue.Number, "", 0)
+		reopened <- err
+	}()
+	<-provider.called
+
+	// the award lands while the reopening waits for the rate
+	_, err = ts.AwardClaim(ctx, issue.Id, "hunter", 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(provider.release)
+	if err = <-reopened; err != nil {
+		t.Fatal(err)
+	}
+	issue = ts.issue(t, issue.Id)
+	if !issue.Active || issue.Title != "Fix the bug again" || issue.Rate == nil {
+		t.Fatalf("issue wasn't reopened: %+v", issue)
+	}
+	if awarded(issue) != 2000 {
+		t.Fatal("reopening overwrote the concurrent award")
+	}
+}
+
+func TestRatesDisabled(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	ts.rates = rates.Disabled{}
+	issue := ts.addIssue(t, 1)
+	err := ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue = ts.issue(t, issue.Id)
+	if issue.Bounty != 1000 || issue.Rate != nil || issue.Donations["txid:0"].Rate != nil {
+		t.Fatalf("unexpected bounty %v with rate %+v", issue.Bounty, issue.Rate)
+	}
+	_, _, err = ts.GetFiatBountyInvoice(ctx, issue.Id, 5, "USD", &DonorInfo{})
+	if err == nil {
+		t.Fatal("fiat invoice was created without rates")
+	}
+}
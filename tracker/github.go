@@ -4,22 +4,26 @@ import (
 	"context"
 	"fmt"
 	"github.com/google/go-github/v33/github"
-	"strconv"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 type GithubService struct {
-	baseUrl string
-	client  *github.Client
+	client *github.Client
+	// login of the authenticated user, fetched on first use
+	login string
+	sync.Mutex
 }
 
-func NewGithubService(baseUrl string, client *github.Client) *GithubService {
-	return &GithubService{baseUrl: baseUrl, client: client}
+func NewGithubService(client *github.Client) *GithubService {
+	return &GithubService{client: client}
 }
 
-func (g *GithubService) AddComment(ctx context.Context, bountyIssue *BountyIssue) (int64, error) {
-
+func (g *GithubService) AddComment(ctx context.Context, bountyIssue *BountyIssue, body string) (int64, error) {
 	comment := &github.IssueComment{
-		Body: g.getComment(bountyIssue),
+		Body: &body,
 	}
 	comment, _, err := g.client.Issues.CreateComment(ctx, bountyIssue.Owner, bountyIssue.Repo, int(bountyIssue.Number), comment)
 	if err != nil {
@@ -28,44 +32,131 @@ func (g *GithubService) AddComment(ctx context.Context, bountyIssue *BountyIssue
 	return *comment.ID, nil
 }
 
-func (g *GithubService) UpdateBountyComment(ctx context.Context, bountyIssue *BountyIssue) error {
+func (g *GithubService) EditComment(ctx context.Context, bountyIssue *BountyIssue, body string) error {
 	comment := &github.IssueComment{
-		Body: g.getComment(bountyIssue),
+		Body: &body,
 	}
-	comment, _, err := g.client.Issues.EditComment(ctx, bountyIssue.Owner, bountyIssue.Repo, bountyIssue.CommentId, comment)
+	_, _, err := g.client.Issues.EditComment(ctx, bountyIssue.Owner, bountyIssue.Repo, bountyIssue.CommentId, comment)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (g *GithubService) CloseBountyComment(ctx context.Context, bountyIssue *BountyIssue) error {
-	comment := &github.IssueComment{
-		Body: g.closeComment(bountyIssue),
+// FindComment returns the id of the first comment of the bot on an issue that
+// contains the marker, or 0 if there is none.
+func (g *GithubService) FindComment(ctx context.Context, bountyIssue *BountyIssue, marker string) (int64, error) {
+	login, err := g.getLogin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := g.client.Issues.ListComments(ctx, bountyIssue.Owner, bountyIssue.Repo, int(bountyIssue.Number), opts)
+		if err != nil {
+			return 0, err
+		}
+		for _, comment := range comments {
+			if comment.GetUser().GetLogin() == login && strings.Contains(comment.GetBody(), marker) {
+				return comment.GetID(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return 0, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (g *GithubService) getLogin(ctx context.Context) (string, error) {
+	g.Lock()
+	defer g.Unlock()
+	if g.login != "" {
+		return g.login, nil
 	}
-	comment, _, err := g.client.Issues.EditComment(ctx, bountyIssue.Owner, bountyIssue.Repo, bountyIssue.CommentId, comment)
+	user, _, err := g.client.Users.Get(ctx, "")
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	g.login = user.GetLogin()
+	return g.login, nil
+}
+
+// AddCommitComment comments on a commit of a repository.
+func (g *GithubService) AddCommitComment(ctx context.Context, owner string, repo string, sha string, body string) error {
+	comment := &github.RepositoryComment{
+		Body: &body,
+	}
+	_, _, err := g.client.Repositories.CreateComment(ctx, owner, repo, sha, comment)
+	return err
+}
+
+func (g *GithubService) CreateLabel(ctx context.Context, owner string, repo string, name string, color string, description string) error {
+	label := &github.Label{
+		Name:        &name,
+		Color:       &color,
+		Description: &description,
+	}
+	_, _, err := g.client.Issues.CreateLabel(ctx, owner, repo, label)
+	if isAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// GetRepoFile returns the content of a file on the default branch of a
+// repository.
+func (g *GithubService) GetRepoFile(ctx context.Context, owner string, repo string, path string) ([]byte, error) {
+	file, _, _, err := g.client.Repositories.GetContents(ctx, owner, repo, path, nil)
+	if isNotFound(err) {
+		return nil, ErrDoesNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%v is not a file", path)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
 }
 
-func (gs GithubService) closeComment(bountyIssue *BountyIssue) *string {
-	str := fmt.Sprintf(""+
-		"Issue has been closed"+
-		"\n \n Total bounty for %s was %v", bountyIssue.Pubkey, bountyIssue.Bounty)
-	return &str
+// isNotFound returns whether a request failed because the resource doesn't
+// exist (anymore).
+func isNotFound(err error) bool {
+	e, ok := err.(*github.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusNotFound
 }
 
-func (gs GithubService) getComment(bountyIssue *BountyIssue) *string {
-	str := fmt.Sprintf(""+
-		"Lightning Bounty is active"+
-		"\n \n Benefactor: %s \n \n"+
-		"\n \n Current Bounty is %v from %v payments \n \n"+
-		"Donate Bounty with %s", bountyIssue.Pubkey, bountyIssue.Bounty, bountyIssue.TotalPayments, gs.getUrl(bountyIssue.Id))
-	return &str
+// isAlreadyExists returns whether a resource couldn't be created because it
+// exists already.
+func isAlreadyExists(err error) bool {
+	e, ok := err.(*github.ErrorResponse)
+	if !ok || e.Response == nil || e.Response.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	for _, e := range e.Errors {
+		if e.Code == "already_exists" {
+			return true
+		}
+	}
+	return false
 }
 
-func (gs GithubService) getUrl(id int64) string {
-	return fmt.Sprintf(gs.baseUrl+"/invoice?%s=%s&%s=100", issueidkey, strconv.Itoa(int(id)), amtkey)
+// rateLimitReset returns when a request that failed because of a rate limit
+// can be retried.
+func rateLimitReset(err error) (time.Time, bool) {
+	switch e := err.(type) {
+	case *github.RateLimitError:
+		return e.Rate.Reset.Time, true
+	case *github.AbuseRateLimitError:
+		if e.RetryAfter != nil {
+			return time.Now().Add(*e.RetryAfter), true
+		}
+		return time.Now().Add(time.Minute), true
+	}
+	return time.Time{}, false
 }
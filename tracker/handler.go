@@ -2,41 +2,82 @@ package tracker
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	config "github.com/sputn1ck/github-bounty"
+	"github.com/sputn1ck/github-bounty/lightning"
 	"gopkg.in/go-playground/webhooks.v5/github"
 	"html/template"
+	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	webhookPath     = "/wh"
-	invoicePath     = "/invoiceraw"
-	invoicePagePath = "/invoice"
+	webhookPath       = "/wh"
+	invoicePath       = "/invoiceraw"
+	invoicePagePath   = "/invoice"
+	invoiceStatusPath = "/invoicestatus"
+	receiptPath       = "/receipt"
+	verifyPath        = "/verify"
+	ledgerPath        = "/ledger"
+	payoutPath        = "/payout/onchain"
+	healthPath        = "/health"
+	repoPath          = "/admin/repos/:owner/:repo"
+	outboxPath        = "/admin/outbox"
+	deadOutboxPath    = "/admin/outbox/dead"
+	deadEventsPath    = "/admin/events/dead"
+	awardPath         = "/admin/awards"
+	refundPath        = "/admin/refunds"
+	reconcilePath     = "/admin/reconcile"
 
-	claimPath  = "/claim"
-	amtkey     = "amt"
-	issueidkey = "issue_id"
+	claimPath   = "/claim"
+	amtkey      = "amt"
+	currencykey = "currency"
+	notekey     = "note"
+	donorkey    = "donor"
+	privatekey  = "private"
+	invoicekey  = "invoice"
+	issueidkey  = "issue_id"
+	nodekey     = "node"
+	limitkey    = "limit"
+	sincekey    = "since"
+	fixkey      = "fix"
+
+	sseKeepAlive = time.Second * 30
 )
 
 type WebhookHandler struct {
-	is      *IssueService
-	webhook *github.Webhook
-	tmpl    *template.Template
+	is              *IssueService
+	webhook         *github.Webhook
+	tmpl            *template.Template
+	boardTmpl       *template.Template
+	leaderboardTmpl *template.Template
 
 	ipRange []string
-	cfg *config.Config
+	cfg     *config.Config
 }
 
 func NewWebhookHandler(cfg *config.Config, is *IssueService, ipRange []string) (*WebhookHandler, error) {
-	tmpl, err := template.ParseFiles(filepath.Join(cfg.StaticFilePath, "invoice.html"))
+	funcs := template.FuncMap{"sats": formatSats, "thousands": formatThousands, "age": formatAge, "inc": func(i int) int { return i + 1 }}
+	tmpl, err := template.New("invoice.html").Funcs(funcs).ParseFiles(filepath.Join(cfg.StaticFilePath, "invoice.html"))
+	if err != nil {
+		return nil, err
+	}
+	boardTmpl, err := template.New("board.html").Funcs(funcs).ParseFiles(filepath.Join(cfg.StaticFilePath, "board.html"))
+	if err != nil {
+		return nil, err
+	}
+	leaderboardTmpl, err := template.New("leaderboard.html").Funcs(funcs).ParseFiles(filepath.Join(cfg.StaticFilePath, "leaderboard.html"))
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +85,7 @@ func NewWebhookHandler(cfg *config.Config, is *IssueService, ipRange []string) (
 	if err != nil {
 		return nil, err
 	}
-	return &WebhookHandler{is: is, webhook: webhook, ipRange: ipRange, tmpl: tmpl, cfg: cfg}, nil
+	return &WebhookHandler{is: is, webhook: webhook, ipRange: ipRange, tmpl: tmpl, boardTmpl: boardTmpl, leaderboardTmpl: leaderboardTmpl, cfg: cfg}, nil
 }
 
 func (wh *WebhookHandler) SetupIpaddress(ip string) {
@@ -53,10 +94,87 @@ func (wh *WebhookHandler) SetupIpaddress(ip string) {
 
 type InvoiceResponse struct {
 	Invoice string `json:"invoice"`
+	Amount  int64  `json:"amount"`
+	Bip21   string `json:"bip21,omitempty"`
 }
 
 type InvoicePageData struct {
-	Invoice string
+	IssueId     int64
+	Title       string
+	Url         string
+	Bounty      int64
+	FiatBounty  string
+	Active      bool
+	Invoice     string
+	Amount      int64
+	FiatAmount  float64
+	Currency    string
+	Address     string
+	Bip21       template.URL
+	Unreachable bool
+	// preset amounts of the amount picker
+	Suggested []int64
+	// amount of the tier label of the issue
+	SuggestedAmount int64
+}
+
+type CurrencyRequest struct {
+	Currency string `json:"currency"`
+}
+
+type donationInvoice struct {
+	IssueId    int64
+	Invoice    string
+	Amount     int64
+	FiatAmount float64
+	Currency   string
+}
+
+type HealthResponse struct {
+	Nodes []*NodeHealthResponse `json:"nodes"`
+}
+
+type NodeHealthResponse struct {
+	Pubkey      string  `json:"pubkey"`
+	State       string  `json:"state"`
+	LastSuccess int64   `json:"last_success,omitempty"`
+	DownSince   int64   `json:"down_since,omitempty"`
+	Uptime      float64 `json:"uptime"`
+}
+
+type PayoutRequest struct {
+	IssueId int64  `json:"issue_id"`
+	Address string `json:"address"`
+	Amount  int64  `json:"amount"`
+}
+
+type PayoutResponse struct {
+	Txid string `json:"txid"`
+}
+
+type AwardRequest struct {
+	IssueId int64  `json:"issue_id"`
+	Hunter  string `json:"hunter"`
+	// awards the remaining bounty if 0
+	Amount int64 `json:"amount"`
+}
+
+type RefundRequest struct {
+	IssueId int64 `json:"issue_id"`
+	// payment request or outpoint of the refunded donation
+	Donation string `json:"donation"`
+	// optional transaction of the refund
+	Txid string `json:"txid"`
+}
+
+type EmailSubscribeRequest struct {
+	// repository as owner/name
+	Repo    string    `json:"repo"`
+	Address string    `json:"address"`
+	Role    EmailRole `json:"role"`
+	// github login of hunters
+	Login  string `json:"login"`
+	Digest bool   `json:"digest"`
 }
 
 func (wh *WebhookHandler) StartHandler(address string) error {
@@ -67,77 +185,733 @@ func (wh *WebhookHandler) StartHandler(address string) error {
 	router.GET(invoicePath, wh.handleInvoice)
 
 	router.GET(invoicePagePath, wh.handleInvoicePage)
+	router.GET(invoiceStatusPath, wh.handleInvoiceStatus)
+	router.GET(receiptPath, wh.handleReceipt)
+	router.POST(verifyPath, wh.handleVerify)
+	router.GET(ledgerPath, wh.handleLedger)
+
+	router.GET(eventsPath, wh.handleEvents)
+	router.GET(qrPath, wh.handleQr)
+	router.GET(badgePath, wh.handleBadge)
+
+	router.GET(boardPath+"/:owner", wh.handleBoard)
+	router.GET(boardPath+"/:owner/:repo", wh.handleBoard)
+	router.GET(feedPath, wh.handleFeed)
+	router.GET(leaderboardPath, wh.handleLeaderboard)
+	router.GET(leaderboardPath+"/:owner", wh.handleLeaderboard)
+	router.GET(leaderboardPath+"/:owner/:repo", wh.handleLeaderboard)
+
+	router.GET(wellKnownLnurlPath, wh.handleLnurlPay)
+	router.GET(lnurlPath+"/:name", wh.handleLnurlPay)
+	router.GET(lnurlPath+"/:name/callback", wh.handleLnurlCallback)
+	router.POST(emailPath+"/subscribe", wh.handleEmailSubscribe)
+	router.GET(emailPath+"/confirm", wh.handleEmailConfirm)
+	router.GET(emailPath+"/unsubscribe", wh.handleEmailUnsubscribe)
+	router.POST(emailPath+"/unsubscribe", wh.handleEmailUnsubscribe)
+
+	router.POST(payoutPath, wh.handlePayout)
+
+	router.GET(healthPath, wh.handleHealth)
+
+	router.GET(repoPath, wh.handleGetRepo)
+	router.PUT(repoPath+"/invoice", wh.handleInvoiceSettings)
+	router.PUT(repoPath+"/currency", wh.handleRepoCurrency)
+	router.PUT(repoPath+"/templates", wh.handleCommentTemplates)
+	router.POST(repoPath+"/templates/preview", wh.handlePreviewComments)
+	router.PUT(repoPath+"/notifiers", wh.handleNotifiers)
+	router.GET(repoPath+"/deliveries", wh.handleDeliveries)
+	router.GET(outboxPath, wh.handleOutbox)
+	router.GET(deadOutboxPath, wh.handleDeadOutbox)
+	router.POST(deadOutboxPath, wh.handleReviveOutbox)
+	router.GET(deadEventsPath, wh.handleDeadEvents)
+	router.POST(deadEventsPath, wh.handleRetryDeadEvents)
+	router.GET(reconcilePath, wh.handleGetReconciliation)
+	router.POST(reconcilePath, wh.handleReconcile)
+	router.POST(awardPath, wh.handleAward)
+	router.POST(refundPath, wh.handleRefund)
 
 	router.ServeFiles("/static/*filepath", http.Dir(wh.cfg.StaticFilePath))
 	return http.ListenAndServe(address, router)
 }
 func (wh *WebhookHandler) handleInvoice(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	invoice, err := wh.getInvoice(r)
+	if err == NodeUnreachableError {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	res := &InvoiceResponse{Invoice: invoice.Invoice, Amount: invoice.Amount}
+	bountyIssue, err := wh.is.GetBountyIssue(r.Context(), invoice.IssueId)
+	if err == nil && bountyIssue.OnchainAddress != "" {
+		res.Bip21 = bip21Uri(bountyIssue.OnchainAddress, invoice.Amount, invoice.Invoice)
+	}
+	writeOkResponse(w, res)
+}
+
+// handleInvoicePage renders the donation page of a bounty. Without an amount
+// the page only shows the amount picker, which requests invoices from the
+// invoice endpoint.
+func (wh *WebhookHandler) handleInvoicePage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	issueId, err := strconv.Atoi(r.URL.Query().Get(issueidkey))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, require %s", issueidkey))
+		return
+	}
+	bountyIssue, err := wh.is.GetBountyIssue(r.Context(), int64(issueId))
+	if err == ErrDoesNotExist {
+		writeError(w, http.StatusNotFound, "bounty not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	data := InvoicePageData{
+		IssueId:     bountyIssue.Id,
+		Title:       bountyIssue.Title,
+		Url:         bountyIssue.HtmlUrl,
+		Bounty:      bountyIssue.Bounty,
+		Active:      bountyIssue.Active,
+		Unreachable: bountyIssue.NodeUnreachableSince != 0,
+		Address:     bountyIssue.OnchainAddress,
+		Currency:    wh.is.RepoCurrency(r.Context(), bountyIssue.Owner, bountyIssue.Repo),
+	}
+	if data.Address == "" && bountyIssue.Active {
+		data.Address, err = wh.is.AssignOnchainAddress(r.Context(), bountyIssue.Id)
+		if err != nil && err != errNoOnchainWallet {
+			log.Printf("unable to assign on-chain address to %v: %v", bountyIssue.Id, err)
+		}
+	}
+	repo, err := wh.is.GetRepo(r.Context(), bountyIssue.Owner, bountyIssue.Repo)
+	if err != nil && err != ErrDoesNotExist {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	data.Suggested = repo.SuggestedAmounts()
+	if tierMode(repo) == TierSuggest {
+		data.SuggestedAmount = bountyIssue.Tier
+	}
+	if bountyIssue.Rate != nil {
+		data.FiatBounty = fmt.Sprintf("%.2f %s", bountyIssue.Rate.ToFiat(bountyIssue.Bounty), bountyIssue.Rate.Currency)
+	}
+	status := http.StatusOK
+	if r.URL.Query().Get(amtkey) != "" && bountyIssue.Active {
+		invoice, err := wh.getInvoice(r)
+		switch {
+		case err == NodeUnreachableError:
+			status = http.StatusServiceUnavailable
+			data.Unreachable = true
+		case err != nil:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+			return
+		default:
+			data.Unreachable = false
+			data.Invoice = invoice.Invoice
+			data.Amount = invoice.Amount
+			data.FiatAmount = invoice.FiatAmount
+			if invoice.Currency != "" {
+				data.Currency = invoice.Currency
+			}
+			if data.Address != "" {
+				data.Bip21 = template.URL(bip21Uri(data.Address, invoice.Amount, invoice.Invoice))
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err = wh.tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("unable to render invoice page %v", err)
+	}
+}
+
+// handleInvoiceStatus streams the updates of a donation invoice as server
+// sent events until it is paid or expired.
+func (wh *WebhookHandler) handleInvoiceStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	query := r.URL.Query()
-	if len(query) != 2 {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, require %s and %s", amtkey, issueidkey))
+	issueId, err := strconv.Atoi(query.Get(issueidkey))
+	if err != nil || query.Get(invoicekey) == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, require %s and %s", issueidkey, invoicekey))
 		return
 	}
-	amt := query.Get(amtkey)
-	issueId := query.Get(issueidkey)
-	if amt == "" || issueId == "" {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, require %s and %s", amtkey, issueidkey))
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
 		return
 	}
-	amtInt, err := strconv.Atoi(amt)
+	updates, cancel, err := wh.is.SubscribeInvoice(r.Context(), int64(issueId), query.Get(invoicekey))
 	if err != nil {
 		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
 		return
 	}
-	issueIdInt, err := strconv.Atoi(issueId)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+		case update := <-updates:
+			data, err := json.Marshal(update)
+			if err != nil {
+				log.Printf("unable to marshal invoice update %v", err)
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", update.State, data)
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// handleReceipt downloads the signed receipt of a paid donation invoice.
+func (wh *WebhookHandler) handleReceipt(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	issueId, err := strconv.Atoi(query.Get(issueidkey))
+	if err != nil || query.Get(invoicekey) == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, require %s and %s", issueidkey, invoicekey))
+		return
+	}
+	receipt, err := wh.is.Receipt(r.Context(), int64(issueId), query.Get(invoicekey))
+	if err == ErrDoesNotExist || err == UnknownInvoiceError {
+		writeError(w, http.StatusNotFound, "receipt not found")
+		return
+	}
 	if err != nil {
 		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
 		return
 	}
-	invoice, err := wh.is.GetBountyInvoice(r.Context(), int64(issueIdInt), int64(amtInt))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"bounty-%d-receipt.json\"", issueId))
+	writeOkResponse(w, receipt)
+}
+
+// handleVerify checks a receipt against the benefactor node of its bounty.
+func (wh *WebhookHandler) handleVerify(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	receipt := &SignedReceipt{}
+	err := json.NewDecoder(r.Body).Decode(receipt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	res, err := wh.is.VerifyReceipt(r.Context(), receipt)
+	if err == ErrDoesNotExist {
+		writeError(w, http.StatusNotFound, "bounty not found")
+		return
+	}
 	if err != nil {
 		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
 		return
 	}
-	writeOkResponse(w, &InvoiceResponse{Invoice: invoice})
+	writeOkResponse(w, res)
 }
 
-func (wh *WebhookHandler) handleInvoicePage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	invoice, err := wh.getInvoice(r)
+// handleLedger returns a page of the ledger, verifiers request the entries
+// after the last sequence number they have seen.
+func (wh *WebhookHandler) handleLedger(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	query := r.URL.Query()
+	var since uint64
+	if s := query.Get(sincekey); s != "" {
+		var err error
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s", sincekey))
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(query.Get(limitkey))
+	res, err := wh.is.Ledger(r.Context(), since, limit)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v",err))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
 		return
 	}
-	data := InvoicePageData{Invoice: invoice}
-	err = wh.tmpl.Execute(w, data)
+	writeOkResponse(w, res)
+}
+
+func (wh *WebhookHandler) handlePayout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	req := &PayoutRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	txid, err := wh.is.PayoutOnchain(r.Context(), req.IssueId, req.Address, req.Amount)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
 		return
 	}
+	writeOkResponse(w, &PayoutResponse{Txid: txid})
+}
+
+// handleLnurlPay answers the first request of LNURL-pay for a bounty id or
+// the lightning address of zaps.
+func (wh *WebhookHandler) handleLnurlPay(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// wallets may request it from the browser
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	res, err := wh.is.LnurlPayRequest(r.Context(), ps.ByName("name"))
+	if err != nil {
+		writeLnurlError(w, err)
+		return
+	}
+	writeOkResponse(w, res)
 }
 
-func (wh *WebhookHandler) getInvoice(r *http.Request) (string, error) {
+// handleLnurlCallback returns the invoice of an LNURL-pay payment or a zap.
+func (wh *WebhookHandler) handleLnurlCallback(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 	query := r.URL.Query()
-	if len(query) != 2 {
-		return "", fmt.Errorf("invalid input, require %s and %s", amtkey, issueidkey)
+	msats, err := strconv.ParseInt(query.Get(amountkey), 10, 64)
+	if err != nil {
+		writeLnurlError(w, fmt.Errorf("invalid %s", amountkey))
+		return
+	}
+	payreq, err := wh.is.LnurlInvoice(r.Context(), ps.ByName("name"), msats, query.Get(commentkey), query.Get(nostrkey))
+	if err != nil {
+		writeLnurlError(w, err)
+		return
+	}
+	writeOkResponse(w, map[string]interface{}{"pr": payreq, "routes": []string{}})
+}
+
+// writeLnurlError writes an error in the format of the LNURL spec.
+func writeLnurlError(w http.ResponseWriter, err error) {
+	if err == ErrDoesNotExist {
+		err = fmt.Errorf("bounty not found")
+	}
+	writeOkResponse(w, map[string]string{"status": "ERROR", "reason": err.Error()})
+}
+
+// handleEmailSubscribe sends a confirmation link for the email notifications
+// of a repository.
+func (wh *WebhookHandler) handleEmailSubscribe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := &EmailSubscribeRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	names := strings.Split(req.Repo, "/")
+	if len(names) != 2 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, %s must be owner/repo", repokey))
+		return
+	}
+	sub := &EmailSubscriber{Address: req.Address, Role: req.Role, Login: req.Login, Digest: req.Digest}
+	err = wh.is.RequestEmailSubscription(r.Context(), names[0], names[1], sub)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, map[string]string{"status": "confirmation sent to " + sub.Address})
+}
+
+func (wh *WebhookHandler) handleEmailConfirm(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	sub, err := wh.is.ConfirmEmailSubscription(r.Context(), r.URL.Query().Get(tokenkey))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, sub)
+}
+
+func (wh *WebhookHandler) handleEmailUnsubscribe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	err := wh.is.Unsubscribe(r.Context(), r.URL.Query().Get(tokenkey))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, map[string]string{"status": "unsubscribed"})
+}
+
+func (wh *WebhookHandler) handleAward(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	req := &AwardRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	claim, err := wh.is.AwardClaim(r.Context(), req.IssueId, req.Hunter, req.Amount)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, claim)
+}
+
+func (wh *WebhookHandler) handleRefund(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	req := &RefundRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	donation, err := wh.is.RefundDonation(r.Context(), req.IssueId, req.Donation, req.Txid)
+	if err == ErrDoesNotExist {
+		writeError(w, http.StatusNotFound, "donation not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, donation)
+}
+
+func (wh *WebhookHandler) handleHealth(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	statuses, err := wh.is.NodeStatuses(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	res := &HealthResponse{Nodes: []*NodeHealthResponse{}}
+	for pubkey, status := range statuses {
+		nodeHealth := &NodeHealthResponse{Pubkey: pubkey, State: string(status.State), Uptime: 1}
+		if !status.LastSuccess.IsZero() {
+			nodeHealth.LastSuccess = status.LastSuccess.Unix()
+		}
+		health, err := wh.is.GetNodeHealth(r.Context(), pubkey)
+		if err == nil {
+			nodeHealth.DownSince = health.DownSince
+			nodeHealth.Uptime = health.Uptime()
+		}
+		res.Nodes = append(res.Nodes, nodeHealth)
 	}
+	sort.Slice(res.Nodes, func(i, j int) bool {
+		return res.Nodes[i].Pubkey < res.Nodes[j].Pubkey
+	})
+	writeOkResponse(w, res)
+}
+
+func (wh *WebhookHandler) handleGetRepo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	repo, err := wh.is.GetRepo(r.Context(), ps.ByName("owner"), ps.ByName("repo"))
+	if err == ErrDoesNotExist {
+		writeError(w, http.StatusNotFound, "repository not registered")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, repo)
+}
+
+func (wh *WebhookHandler) handleInvoiceSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	settings := &InvoiceSettings{}
+	err := json.NewDecoder(r.Body).Decode(settings)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	repo, err := wh.is.UpdateInvoiceSettings(r.Context(), ps.ByName("owner"), ps.ByName("repo"), settings)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, repo)
+}
+
+func (wh *WebhookHandler) handleRepoCurrency(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	req := &CurrencyRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	repo, err := wh.is.SetRepoCurrency(r.Context(), ps.ByName("owner"), ps.ByName("repo"), req.Currency)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, repo)
+}
+
+func (wh *WebhookHandler) handleCommentTemplates(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	templates := &CommentTemplates{}
+	err := json.NewDecoder(r.Body).Decode(templates)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	repo, err := wh.is.SetCommentTemplates(r.Context(), ps.ByName("owner"), ps.ByName("repo"), templates)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, repo)
+}
+
+// handlePreviewComments renders the posted templates, or the current ones if
+// the body is empty, with the issue given as issue_id or sample data.
+func (wh *WebhookHandler) handlePreviewComments(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	templates := &CommentTemplates{}
+	err := json.NewDecoder(r.Body).Decode(templates)
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	var issueId int64
+	if id := r.URL.Query().Get(issueidkey); id != "" {
+		issueId, err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+			return
+		}
+	}
+	previews, err := wh.is.PreviewComments(r.Context(), ps.ByName("owner"), ps.ByName("repo"), templates, issueId)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, previews)
+}
+
+func (wh *WebhookHandler) handleNotifiers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var notifiers []*Notifier
+	err := json.NewDecoder(r.Body).Decode(&notifiers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	repo, err := wh.is.SetNotifiers(r.Context(), ps.ByName("owner"), ps.ByName("repo"), notifiers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, repo)
+}
+
+// handleDeliveries returns the latest notification deliveries of a
+// repository, limited with limit.
+func (wh *WebhookHandler) handleDeliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	limit := 100
+	if l := r.URL.Query().Get(limitkey); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, %s must be a positive number", limitkey))
+			return
+		}
+	}
+	deliveries, err := wh.is.ListDeliveries(r.Context(), ps.ByName("owner"), ps.ByName("repo"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*Delivery{}
+	}
+	writeOkResponse(w, deliveries)
+}
+
+func (wh *WebhookHandler) handleDeadEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	dead, err := wh.is.ListDeadEvents(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, dead)
+}
+
+func (wh *WebhookHandler) handleRetryDeadEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	dead, err := wh.is.RetryDeadEvents(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, dead)
+}
+
+func (wh *WebhookHandler) handleOutbox(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	ops, err := wh.is.ListOutbox(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	if ops == nil {
+		ops = []*OutboxOp{}
+	}
+	writeOkResponse(w, ops)
+}
+
+func (wh *WebhookHandler) handleDeadOutbox(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	ops, err := wh.is.ListDeadOutbox(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, ops)
+}
+
+// handleReviveOutbox queues the dead operation with the given key again, or
+// all of them without a key, and returns the remaining dead operations.
+func (wh *WebhookHandler) handleReviveOutbox(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	err := wh.is.ReviveOutbox(r.Context(), r.URL.Query().Get("key"))
+	if err == ErrDoesNotExist {
+		writeError(w, http.StatusNotFound, "no such operation")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	ops, err := wh.is.ListDeadOutbox(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, ops)
+}
+
+// handleGetReconciliation returns the report of the latest reconciliation.
+func (wh *WebhookHandler) handleGetReconciliation(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	report := wh.is.LastReconciliation()
+	if report == nil {
+		writeError(w, http.StatusNotFound, "no reconciliation ran yet")
+		return
+	}
+	writeOkResponse(w, report)
+}
+
+// handleReconcile runs a reconciliation, discrepancies are only fixed with
+// fix=true.
+func (wh *WebhookHandler) handleReconcile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !wh.checkAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	fix, _ := strconv.ParseBool(r.URL.Query().Get(fixkey))
+	report, err := wh.is.Reconcile(r.Context(), fix)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	writeOkResponse(w, report)
+}
+
+// checkAdmin checks the bearer token of admin requests.
+func (wh *WebhookHandler) checkAdmin(r *http.Request) bool {
+	if wh.cfg.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(wh.cfg.AdminToken)) == 1
+}
+
+// getInvoice creates an invoice from the query, the amount is in satoshis or
+// in the fiat currency given with currencykey.
+func (wh *WebhookHandler) getInvoice(r *http.Request) (*donationInvoice, error) {
+	query := r.URL.Query()
 	amt := query.Get(amtkey)
 	issueId := query.Get(issueidkey)
 	if amt == "" || issueId == "" {
-		return "", fmt.Errorf("invalid input, require %s and %s", amtkey, issueidkey)
+		return nil, fmt.Errorf("invalid input, require %s and %s", amtkey, issueidkey)
 	}
-	amtInt, err := strconv.Atoi(amt)
+	issueIdInt, err := strconv.Atoi(issueId)
 	if err != nil {
-		return "", fmt.Errorf("something went wrong %v", err)
+		return nil, fmt.Errorf("something went wrong %v", err)
 	}
-	issueIdInt, err := strconv.Atoi(issueId)
+	res := &donationInvoice{IssueId: int64(issueIdInt), Currency: strings.ToUpper(query.Get(currencykey))}
+	donor := &DonorInfo{Note: query.Get(notekey), Name: query.Get(donorkey), Private: query.Get(privatekey) != ""}
+	if res.Currency != "" {
+		res.FiatAmount, err = strconv.ParseFloat(amt, 64)
+		if err != nil {
+			return nil, fmt.Errorf("something went wrong %v", err)
+		}
+		if !(res.FiatAmount > 0) || math.IsInf(res.FiatAmount, 0) {
+			return nil, AmountError
+		}
+		res.Invoice, res.Amount, err = wh.is.GetFiatBountyInvoice(r.Context(), res.IssueId, res.FiatAmount, res.Currency, donor)
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	amtInt, err := strconv.Atoi(amt)
 	if err != nil {
-		return "", fmt.Errorf("something went wrong %v", err)
+		return nil, fmt.Errorf("something went wrong %v", err)
+	}
+	if amtInt <= 0 {
+		return nil, AmountError
 	}
-	invoice, err := wh.is.GetBountyInvoice(r.Context(), int64(issueIdInt), int64(amtInt))
+	res.Amount = int64(amtInt)
+	res.Invoice, err = wh.is.GetBountyInvoice(r.Context(), res.IssueId, res.Amount, donor)
 	if err != nil {
-		return "", fmt.Errorf("something went wrong %v", err)
+		return nil, err
 	}
-	return invoice, nil
+	return res, nil
 }
 
 func writeOkResponse(w http.ResponseWriter, res interface{}) {
@@ -154,17 +928,40 @@ func writeError(w http.ResponseWriter, statuscode int, msg string) {
 	w.Write([]byte(msg))
 }
 
+// checkNodeUri only lets connection uris from webhook urls connect to the
+// configured hosts, as anyone can point a webhook at the bot.
+func (wh *WebhookHandler) checkNodeUri(uri string) error {
+	host, err := lightning.BackendHost(uri)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range wh.cfg.NodeHosts {
+		if strings.EqualFold(host, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("node host %v is not allowed", host)
+}
+
 func (wh *WebhookHandler) handleWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	okay, err := wh.checkIps(r)
 	if err != nil || !okay {
 		return
 	}
+	query := r.URL.Query()
 	lndConnectString := ps.ByName("lndconnect")
 	if lndConnectString != "" {
-		query := r.URL.Query()
 		lndConnectString = "lndconnect://" + lndConnectString + "?cert=" + query.Get("cert") + "&macaroon=" + query.Get("macaroon")
+	} else if nodeUri := query.Get(nodekey); nodeUri != "" {
+		// other lightning backends pass their full connection uri
+		if err := wh.checkNodeUri(nodeUri); err != nil {
+			log.Printf("Rejected webhook node %v", err)
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		lndConnectString = nodeUri
 	}
-	payload, err := wh.webhook.Parse(r, github.IssuesEvent, github.LabelEvent)
+	payload, err := wh.webhook.Parse(r, github.IssuesEvent, github.IssueCommentEvent, github.LabelEvent, github.PushEvent)
 	if err != nil {
 		if err == github.ErrEventNotFound {
 			// ok event wasn;t one of the ones asked to be parsed
@@ -183,17 +980,55 @@ func (wh *WebhookHandler) handleWebhook(w http.ResponseWriter, r *http.Request,
 		if issue.Action != "labeled" && issue.Action != "reopened" {
 			return
 		}
-		if !hasBountyLabel(issue) {
+		names := strings.Split(issue.Repository.FullName, "/")
+		repo, err := wh.is.LoadRepoFile(context.Background(), names[0], issue.Repository.Name, issue.Issue.Number)
+		if err != nil {
+			log.Printf("Error loading repository %v", err)
 			return
 		}
-		names := strings.Split(issue.Repository.FullName, "/")
-		bi, err := wh.is.AddBountyIssue(context.Background(), issue.Issue.ID, issue.Issue.URL, names[0], issue.Repository.Name, issue.Issue.Number, lndConnectString)
+		labels := make([]string, 0, len(issue.Issue.Labels))
+		for _, label := range issue.Issue.Labels {
+			labels = append(labels, label.Name)
+		}
+		isBounty, tier := wh.is.MatchBountyLabels(repo, labels)
+		if !isBounty {
+			return
+		}
+		bi, err := wh.is.AddBountyIssue(context.Background(), issue.Issue.ID, issue.Issue.URL, issue.Issue.HTMLURL, issue.Issue.Title, repo, issue.Issue.Number, lndConnectString, tier)
 
 		if err != nil {
 			log.Printf("Error adding bounty issue %v", err)
 			return
 		}
 		log.Printf("Successfully added bounty issue %v", bi)
+	case github.IssueCommentPayload:
+		comment := payload.(github.IssueCommentPayload)
+		switch {
+		case comment.Action == "deleted":
+			err = wh.is.CommentDeleted(context.Background(), comment.Issue.ID, comment.Comment.ID)
+		case comment.Action == "edited" && comment.Sender.Login != comment.Comment.User.Login:
+			err = wh.is.CommentEdited(context.Background(), comment.Issue.ID, comment.Comment.ID)
+		case comment.Action == "created":
+			err = wh.is.HandleCommentCommand(context.Background(), comment.Issue.ID, comment.Comment.User.Login, comment.Comment.AuthorAssociation, comment.Comment.Body)
+		}
+		if err != nil {
+			log.Printf("Error handling comment event %v", err)
+		}
+	case github.PushPayload:
+		push := payload.(github.PushPayload)
+		if push.Ref != "refs/heads/"+push.Repository.DefaultBranch {
+			return
+		}
+		for _, commit := range push.Commits {
+			if !isRepoFile(commit.Added, commit.Modified, commit.Removed) {
+				continue
+			}
+			err = wh.is.RepoFilePushed(context.Background(), push.Repository.Owner.Login, push.Repository.Name, push.After)
+			if err != nil {
+				log.Printf("Error refreshing repository file %v", err)
+			}
+			return
+		}
 	case github.LabelPayload:
 		label := payload.(github.LabelPayload)
 		fmt.Printf("%v", label)
@@ -202,13 +1037,16 @@ func (wh *WebhookHandler) handleWebhook(w http.ResponseWriter, r *http.Request,
 		fmt.Printf("%v", label)
 	}
 }
-func hasBountyLabel(issuePayload github.IssuesPayload) bool {
-	for _, v := range issuePayload.Issue.Labels {
-		if v.Name == "bounty" {
-			return true
-		}
+
+// formatSats shortens round amounts of the amount picker, like 10k sats.
+func formatSats(sats int64) string {
+	switch {
+	case sats >= 1000000 && sats%1000000 == 0:
+		return strconv.FormatInt(sats/1000000, 10) + "M"
+	case sats >= 1000 && sats%1000 == 0:
+		return strconv.FormatInt(sats/1000, 10) + "k"
 	}
-	return false
+	return strconv.FormatInt(sats, 10)
 }
 
 func (wh *WebhookHandler) checkIps(r *http.Request) (bool, error) {
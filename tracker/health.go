@@ -0,0 +1,215 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"time"
+)
+
+var (
+	NodeUnreachableError = fmt.Errorf("benefactor node is currently unreachable")
+
+	healthProbeTimeout = time.Second * 10
+	maxHealthProbes    = 288
+)
+
+type HealthProbe struct {
+	Timestamp int64
+	Ok        bool
+	Error     string
+}
+
+// NodeHealth holds the probe history of a benefactor node.
+type NodeHealth struct {
+	Pubkey    string
+	Probes    []*HealthProbe
+	DownSince int64
+	// whether the maintainers have been told about the current outage
+	OutageNotified bool
+}
+
+type HealthStore interface {
+	Put(context.Context, *NodeHealth) error
+	Get(context.Context, string) (*NodeHealth, error)
+}
+
+// Uptime returns the share of successful probes in the history.
+func (health *NodeHealth) Uptime() float64 {
+	if len(health.Probes) == 0 {
+		return 1
+	}
+	var ok int
+	for _, probe := range health.Probes {
+		if probe.Ok {
+			ok++
+		}
+	}
+	return float64(ok) / float64(len(health.Probes))
+}
+
+// StartHealthMonitor probes all benefactor nodes of active bounties in the
+// configured interval until the context is canceled. An interval of 0
+// disables the monitor.
+func (srv *IssueService) StartHealthMonitor(ctx context.Context) {
+	if srv.cfg.HealthCheckInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(srv.cfg.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			err := srv.checkNodeHealth(ctx)
+			if err != nil {
+				fmt.Printf("error checking node health: %v \n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// GetNodeHealth returns the probe history of a benefactor node.
+func (srv *IssueService) GetNodeHealth(ctx context.Context, pubkey string) (*NodeHealth, error) {
+	return srv.healthStore.Get(ctx, pubkey)
+}
+
+func (srv *IssueService) checkNodeHealth(ctx context.Context) error {
+	bountyIssues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	nodeIssues := make(map[string][]*BountyIssue)
+	for _, bountyIssue := range bountyIssues {
+		if !bountyIssue.Active || bountyIssue.Pubkey == "" {
+			continue
+		}
+		nodeIssues[bountyIssue.Pubkey] = append(nodeIssues[bountyIssue.Pubkey], bountyIssue)
+	}
+	for pubkey, issues := range nodeIssues {
+		probeErr := srv.probeNode(ctx, issues[0].LndConnect)
+		err = srv.recordProbe(ctx, pubkey, issues, probeErr)
+		if err != nil {
+			fmt.Printf("error recording health of %v: %v \n", pubkey, err)
+		}
+	}
+	return nil
+}
+
+func (srv *IssueService) probeNode(ctx context.Context, lndConnect string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	node, release, err := srv.pool.Get(ctx, lndConnect)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = node.GetInfo(ctx)
+	if err != nil {
+		srv.pool.ReportFailure(lndConnect, err)
+		return err
+	}
+	srv.pool.ReportSuccess(lndConnect)
+	return nil
+}
+
+// reportNodeError makes the pool reconnect to a node after a call failed
+// because the node couldn't be reached. Calls that ran out of their own
+// context aren't counted against the node.
+func (srv *IssueService) reportNodeError(ctx context.Context, lndConnect string, err error) {
+	if ctx.Err() != nil || !lightning.IsConnectionError(err) {
+		return
+	}
+	srv.pool.ReportFailure(lndConnect, err)
+}
+
+func (srv *IssueService) recordProbe(ctx context.Context, pubkey string, issues []*BountyIssue, probeErr error) error {
+	health, err := srv.healthStore.Get(ctx, pubkey)
+	if err == ErrDoesNotExist {
+		health = &NodeHealth{Pubkey: pubkey}
+	} else if err != nil {
+		return err
+	}
+	now := time.Now()
+	probe := &HealthProbe{Timestamp: now.Unix(), Ok: probeErr == nil}
+	if probeErr != nil {
+		probe.Error = probeErr.Error()
+	}
+	health.Probes = append(health.Probes, probe)
+	if len(health.Probes) > maxHealthProbes {
+		health.Probes = health.Probes[len(health.Probes)-maxHealthProbes:]
+	}
+
+	switch {
+	case probeErr == nil && health.DownSince != 0:
+		fmt.Printf("node %v is reachable again \n", pubkey)
+		health.DownSince = 0
+		health.OutageNotified = false
+		srv.setNodeUnreachable(ctx, issues, 0)
+	case probeErr != nil && health.DownSince == 0:
+		fmt.Printf("node %v is unreachable: %v \n", pubkey, probeErr)
+		health.DownSince = now.Unix()
+		srv.setNodeUnreachable(ctx, issues, health.DownSince)
+	}
+	if health.DownSince != 0 && !health.OutageNotified &&
+		now.Sub(time.Unix(health.DownSince, 0)) >= srv.cfg.OutageNotifyAfter {
+		srv.notifyOutage(ctx, issues)
+		health.OutageNotified = true
+	}
+	return srv.healthStore.Put(ctx, health)
+}
+
+// setNodeUnreachable marks the bounties of a node as unreachable, so the
+// comments show a notice.
+func (srv *IssueService) setNodeUnreachable(ctx context.Context, issues []*BountyIssue, since int64) {
+	srv.Lock()
+	defer srv.Unlock()
+	eventType := EventNodeUnreachable
+	if since == 0 {
+		eventType = EventNodeReachable
+	}
+	for _, issue := range issues {
+		bountyIssue, err := srv.store.Get(ctx, issue.Id)
+		if err != nil {
+			fmt.Printf("unable to get bounty issue %v: %v \n", issue.Id, err)
+			continue
+		}
+		bountyIssue.NodeUnreachableSince = since
+		event := newEvent(eventType, bountyIssue)
+		event.Pubkey = bountyIssue.Pubkey
+		err = srv.commit(ctx, bountyIssue, event)
+		if err != nil {
+			fmt.Printf("unable to update bounty issue %v: %v \n", issue.Id, err)
+		}
+	}
+}
+
+// notifyOutage tells the maintainers of every affected repository about a
+// sustained outage, once per repository.
+func (srv *IssueService) notifyOutage(ctx context.Context, issues []*BountyIssue) {
+	srv.Lock()
+	defer srv.Unlock()
+	notified := make(map[string]bool)
+	for _, issue := range issues {
+		repo := issue.Owner + "/" + issue.Repo
+		if notified[repo] {
+			continue
+		}
+		bountyIssue, err := srv.store.Get(ctx, issue.Id)
+		if err != nil {
+			fmt.Printf("unable to get bounty issue %v: %v \n", issue.Id, err)
+			continue
+		}
+		event := newEvent(EventNodeOutage, bountyIssue)
+		event.Pubkey = bountyIssue.Pubkey
+		err = srv.commit(ctx, bountyIssue, event)
+		if err != nil {
+			fmt.Printf("unable to notify outage on %v: %v \n", issue.Url, err)
+			continue
+		}
+		notified[repo] = true
+	}
+}
@@ -0,0 +1,62 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	config "github.com/sputn1ck/github-bounty"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReportNodeError(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+
+	// rejected calls keep the connection
+	ts.node.fail("CreateInvoice", fmt.Errorf("amount too large"))
+	_, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err == nil {
+		t.Fatal("failed invoice creation succeeded")
+	}
+	if status := ts.pool.Status(testNodeUri); status.Failures != 0 {
+		t.Fatalf("rejected call was reported as failure: %+v", status)
+	}
+
+	ts.node.fail("CreateInvoice", &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")})
+	_, err = ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err == nil {
+		t.Fatal("failed invoice creation succeeded")
+	}
+	if status := ts.pool.Status(testNodeUri); status.Failures != 1 || status.LastError == "" {
+		t.Fatalf("unreachable node wasn't reported: %+v", status)
+	}
+	// the pool backs off before reconnecting
+	_, err = ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err != NodeUnreachableError {
+		t.Fatalf("expected unreachable node, got %v", err)
+	}
+}
+
+func TestStartHealthMonitor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, interval := range []time.Duration{0, -time.Second} {
+		ts := newTestService(t, func(cfg *config.Config) { cfg.HealthCheckInterval = interval })
+		issue := ts.addIssue(t, 1)
+		ts.StartHealthMonitor(ctx)
+		time.Sleep(time.Millisecond * 50)
+		if _, err := ts.GetNodeHealth(ctx, issue.Pubkey); err != ErrDoesNotExist {
+			t.Fatalf("node was probed with interval %v: %v", interval, err)
+		}
+	}
+
+	ts := newTestService(t, func(cfg *config.Config) { cfg.HealthCheckInterval = time.Hour })
+	issue := ts.addIssue(t, 1)
+	ts.StartHealthMonitor(ctx)
+	eventually(t, func() bool {
+		health, err := ts.GetNodeHealth(ctx, issue.Pubkey)
+		return err == nil && len(health.Probes) == 1 && health.Probes[0].Ok
+	}, "node wasn't probed")
+}
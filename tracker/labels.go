@@ -0,0 +1,174 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	config "github.com/sputn1ck/github-bounty"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// tiers that can be used with a bounty label like bounty:small, amounts
+	// like bounty:500k can be used without defining a tier
+	DefaultLabelTiers = map[string]int64{
+		"small":  10000,
+		"medium": 100000,
+		"large":  1000000,
+	}
+
+	bountyLabelColor = "f7931a"
+	// colors of tier labels by order of magnitude, starting below 10k sats
+	tierLabelColors = []string{"fef2c0", "fbca04", "ff9f1c", "d93f0b", "b60205"}
+)
+
+type TierMode string
+
+const (
+	// the tier amount is suggested on the donation page
+	TierSuggest TierMode = "suggest"
+	// the maintainers pledge the tier amount in addition to donations
+	TierPledge TierMode = "pledge"
+)
+
+// bountyLabels returns the labels that mark issues of a repository as bounties.
+func (srv *IssueService) bountyLabels(repo *Repository) []string {
+	if repo != nil && repo.File != nil && len(repo.File.Labels) > 0 {
+		return repo.File.Labels
+	}
+	if len(srv.cfg.BountyLabels) > 0 {
+		return srv.cfg.BountyLabels
+	}
+	return config.DefaultBountyLabels
+}
+
+// labelTiers returns the named tiers of a repository.
+func labelTiers(repo *Repository) map[string]int64 {
+	if repo != nil && repo.File != nil && len(repo.File.Tiers) > 0 {
+		return repo.File.Tiers
+	}
+	return DefaultLabelTiers
+}
+
+// MatchBountyLabels returns whether one of the labels of an issue marks it as
+// bounty and the amount of the highest tier label, 0 if it has none.
+func (srv *IssueService) MatchBountyLabels(repo *Repository, labels []string) (bool, int64) {
+	var isBounty bool
+	var tier int64
+	for _, label := range labels {
+		for _, bountyLabel := range srv.bountyLabels(repo) {
+			if label == bountyLabel {
+				isBounty = true
+				continue
+			}
+			if !strings.HasPrefix(label, bountyLabel+":") {
+				continue
+			}
+			amount, err := parseTier(repo, strings.TrimPrefix(label, bountyLabel+":"))
+			if err != nil {
+				fmt.Printf("ignoring label %v: %v \n", label, err)
+				continue
+			}
+			isBounty = true
+			if amount > tier {
+				tier = amount
+			}
+		}
+	}
+	return isBounty, tier
+}
+
+// parseTier returns the amount of a named tier or of a positive amount like
+// 500k or 2M.
+func parseTier(repo *Repository, tier string) (int64, error) {
+	if amount, ok := labelTiers(repo)[tier]; ok {
+		return amount, nil
+	}
+	number := tier
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(tier, "k"), strings.HasSuffix(tier, "K"):
+		multiplier = 1000
+	case strings.HasSuffix(tier, "m"), strings.HasSuffix(tier, "M"):
+		multiplier = 1000000
+	}
+	if multiplier != 1 {
+		number = tier[:len(tier)-1]
+	}
+	// signs are rejected, ParseInt would accept them
+	if number == "" || number[0] < '0' || number[0] > '9' {
+		return 0, fmt.Errorf("unknown tier %v", tier)
+	}
+	amount, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || amount <= 0 || amount > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("unknown tier %v", tier)
+	}
+	return amount * multiplier, nil
+}
+
+// tierMode returns how tier amounts are used in a repository.
+func tierMode(repo *Repository) TierMode {
+	if repo != nil && repo.File != nil && repo.File.TierMode != "" {
+		return repo.File.TierMode
+	}
+	return TierSuggest
+}
+
+// tierLabelColor returns the color of a tier by its order of magnitude, so
+// the same amount has the same color in every repository.
+func tierLabelColor(amount int64) string {
+	i := 0
+	for limit := int64(10000); amount >= limit && i < len(tierLabelColors)-1; limit *= 10 {
+		i++
+	}
+	return tierLabelColors[i]
+}
+
+// enqueueCreateLabels schedules the creation of the bounty labels of a
+// repository.
+func (srv *IssueService) enqueueCreateLabels(ctx context.Context, repo *Repository) error {
+	if !srv.cfg.CreateLabels {
+		return nil
+	}
+	return srv.enqueue(ctx, &OutboxOp{
+		Key:   "labels/" + repo.FullName(),
+		Kind:  OutboxCreateLabels,
+		Owner: repo.Owner,
+		Repo:  repo.Name,
+	})
+}
+
+// createLabels creates the bounty labels and the labels of the named tiers
+// that don't exist yet.
+func (srv *IssueService) createLabels(ctx context.Context, op *OutboxOp) error {
+	repo, err := srv.repoStore.Get(ctx, op.Owner, op.Repo)
+	if err != nil {
+		return err
+	}
+	labels := srv.bountyLabels(repo)
+	for _, label := range labels {
+		err = srv.ghClient.CreateLabel(ctx, repo.Owner, repo.Name, label, bountyLabelColor, "Lightning bounty")
+		if err != nil {
+			return err
+		}
+	}
+	tiers := labelTiers(repo)
+	names := make([]string, 0, len(tiers))
+	for name := range tiers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return tiers[names[i]] < tiers[names[j]]
+	})
+	for _, name := range names {
+		amount := tiers[name]
+		err = srv.ghClient.CreateLabel(ctx, repo.Owner, repo.Name, labels[0]+":"+name, tierLabelColor(amount), fmt.Sprintf("Lightning bounty of %v sats", amount))
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("created bounty labels in %v \n", repo.FullName())
+	return nil
+}
@@ -0,0 +1,54 @@
+package tracker
+
+import (
+	"testing"
+)
+
+func TestParseTier(t *testing.T) {
+	repo := &Repository{File: &RepoFile{Tiers: map[string]int64{"tiny": 100, "1k": 500}}}
+	tests := []struct {
+		tier   string
+		amount int64
+		ok     bool
+	}{
+		{"tiny", 100, true},
+		// named tiers take precedence over amounts
+		{"1k", 500, true},
+		{"500", 500, true},
+		{"500k", 500000, true},
+		{"500K", 500000, true},
+		{"2m", 2000000, true},
+		{"2M", 2000000, true},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"9223372036854775k", 9223372036854775000, true},
+		{"9223372036854775808", 0, false},
+		{"9223372036854776k", 0, false},
+		{"9223372036855M", 0, false},
+		{"0", 0, false},
+		{"0k", 0, false},
+		{"-5", 0, false},
+		{"-5k", 0, false},
+		{"+5k", 0, false},
+		{"k", 0, false},
+		{"", 0, false},
+		{"1.5k", 0, false},
+		{"5g", 0, false},
+		{"5kk", 0, false},
+		{"small", 0, false},
+	}
+	for _, test := range tests {
+		amount, err := parseTier(repo, test.tier)
+		if test.ok && (err != nil || amount != test.amount) {
+			t.Errorf("expected tier %q to be %v, got %v, %v", test.tier, test.amount, amount, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("expected tier %q to be rejected, got %v", test.tier, amount)
+		}
+	}
+
+	// the default tiers are used without configured ones
+	amount, err := parseTier(nil, "small")
+	if err != nil || amount != DefaultLabelTiers["small"] {
+		t.Fatalf("expected the default small tier, got %v, %v", amount, err)
+	}
+}
@@ -0,0 +1,160 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	leaderboardPath = "/leaderboard"
+
+	leaderboardSize = 100
+)
+
+// Leaderboard ranks the donors and hunters of a repository, an owner or of
+// all repositories.
+type Leaderboard struct {
+	Owner   string              `json:"owner,omitempty"`
+	Repo    string              `json:"repo,omitempty"`
+	Donors  []*LeaderboardEntry `json:"donors"`
+	Hunters []*LeaderboardEntry `json:"hunters"`
+	// donations without a name or hidden by their donors
+	AnonymousTotal int64 `json:"anonymous_total"`
+}
+
+type LeaderboardEntry struct {
+	Name string `json:"name"`
+	// total donated or awarded in sats
+	Amount int64 `json:"amount"`
+	// number of donations or awards
+	Count int `json:"count"`
+	// number of distinct bounties
+	Bounties int `json:"bounties"`
+}
+
+// Leaderboard returns the leaderboards of a repository or an owner, or the
+// global ones if both are empty. Repositories and people that opted out are
+// left out.
+func (srv *IssueService) Leaderboard(ctx context.Context, owner string, name string) (*Leaderboard, error) {
+	var bountyIssues []*BountyIssue
+	var err error
+	if owner == "" {
+		bountyIssues, err = srv.store.ListAll(ctx)
+	} else {
+		bountyIssues, err = srv.ListBounties(ctx, owner, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	optOuts, err := srv.optOutStore.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hidden := make(map[string]bool)
+	for _, login := range optOuts {
+		hidden[strings.ToLower(login)] = true
+	}
+	board := &Leaderboard{Owner: owner, Repo: name}
+	donors := newLeaderboardRanking()
+	hunters := newLeaderboardRanking()
+	hiddenRepos := make(map[string]bool)
+	for _, bountyIssue := range bountyIssues {
+		repoName := bountyIssue.Owner + "/" + bountyIssue.Repo
+		hide, ok := hiddenRepos[repoName]
+		if !ok {
+			hide = srv.repoFile(ctx, bountyIssue.Owner, bountyIssue.Repo).HideLeaderboard
+			hiddenRepos[repoName] = hide
+		}
+		if hide {
+			continue
+		}
+		for _, donation := range bountyIssue.Donations {
+			if donation.Timestamp == 0 || donation.RefundedAt != 0 {
+				// invoice that isn't paid yet or refunded donation
+				continue
+			}
+			if donation.Donor == "" || donation.Private || hidden[strings.ToLower(donation.Donor)] {
+				board.AnonymousTotal += donation.Amount
+				continue
+			}
+			donors.add(donation.Donor, bountyIssue.Id, donation.Amount)
+		}
+		for _, claim := range bountyIssue.Claims {
+			if claim.Awarded == 0 || hidden[strings.ToLower(claim.Hunter)] {
+				continue
+			}
+			hunters.add(claim.Hunter, bountyIssue.Id, claim.Awarded)
+		}
+	}
+	board.Donors = donors.ranked()
+	board.Hunters = hunters.ranked()
+	return board, nil
+}
+
+// leaderboardRanking sums the amounts of names case insensitively.
+type leaderboardRanking struct {
+	entries  map[string]*LeaderboardEntry
+	bounties map[string]map[int64]bool
+}
+
+func newLeaderboardRanking() *leaderboardRanking {
+	return &leaderboardRanking{
+		entries:  make(map[string]*LeaderboardEntry),
+		bounties: make(map[string]map[int64]bool),
+	}
+}
+
+func (ranking *leaderboardRanking) add(name string, issueId int64, sats int64) {
+	key := strings.ToLower(name)
+	entry, ok := ranking.entries[key]
+	if !ok {
+		entry = &LeaderboardEntry{Name: name}
+		ranking.entries[key] = entry
+		ranking.bounties[key] = make(map[int64]bool)
+	}
+	entry.Amount += sats
+	entry.Count++
+	ranking.bounties[key][issueId] = true
+	entry.Bounties = len(ranking.bounties[key])
+}
+
+func (ranking *leaderboardRanking) ranked() []*LeaderboardEntry {
+	entries := make([]*LeaderboardEntry, 0, len(ranking.entries))
+	for _, entry := range ranking.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Amount != entries[j].Amount {
+			return entries[i].Amount > entries[j].Amount
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if len(entries) > leaderboardSize {
+		entries = entries[:leaderboardSize]
+	}
+	return entries
+}
+
+// handleLeaderboard renders the leaderboards as page, or as json with
+// format=json.
+func (wh *WebhookHandler) handleLeaderboard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	board, err := wh.is.Leaderboard(r.Context(), ps.ByName("owner"), ps.ByName("repo"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	if r.URL.Query().Get(formatkey) == "json" {
+		writeOkResponse(w, board)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = wh.leaderboardTmpl.Execute(w, board)
+	if err != nil {
+		log.Printf("unable to render leaderboard %v", err)
+	}
+}
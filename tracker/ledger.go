@@ -0,0 +1,487 @@
+package tracker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LedgerEntryType string
+
+const (
+	// balance of a bounty when the ledger was started
+	LedgerOpening LedgerEntryType = "opening"
+	// settled lightning donation
+	LedgerDonation LedgerEntryType = "donation"
+	// credited on-chain donation
+	LedgerDeposit LedgerEntryType = "deposit"
+	// on-chain payout of a bounty
+	LedgerPayout LedgerEntryType = "payout"
+	// correction of the total by a reconciliation, the amount is negative if
+	// the total was too high
+	LedgerAdjustment LedgerEntryType = "adjustment"
+	// amount of a bounty awarded to a hunter
+	LedgerAward LedgerEntryType = "award"
+	// donation returned to its donor, the amount is removed from the bounty
+	LedgerRefund LedgerEntryType = "refund"
+
+	ledgerCursor = "ledger"
+	// sequence of the last event whose awards are covered by opening entries,
+	// awards were added to a ledger that was running already
+	ledgerAwardsCursor = "ledger_awards"
+	// upper limit of entries per ledger request
+	maxLedgerEntries = 1000
+)
+
+var (
+	ledgerEvents = []EventType{
+		EventInvoiceSettled,
+		EventOnchainDeposit,
+		EventPayout,
+		EventBountyAdjusted,
+		EventClaimAwarded,
+		EventRefund,
+	}
+)
+
+// LedgerEntry is an entry of the append-only ledger. Every entry commits to
+// its predecessor with PrevHash, so changing or removing an entry breaks the
+// hashes of all following ones.
+type LedgerEntry struct {
+	Seq     uint64          `json:"seq"`
+	Type    LedgerEntryType `json:"type"`
+	IssueId int64           `json:"issue_id"`
+	Amount  int64           `json:"amount"`
+	// hex encoded payment hash and preimage of lightning donations
+	PaymentHash string `json:"payment_hash,omitempty"`
+	Preimage    string `json:"preimage,omitempty"`
+	// transaction of on-chain donations, payouts and refunds
+	Txid string `json:"txid,omitempty"`
+	// github login of the hunter of awards
+	Hunter    string `json:"hunter,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	// hash of the previous entry, empty for the first entry
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// LedgerAnchor is a publication of the ledger head outside of the bot.
+type LedgerAnchor struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	// id of the nostr note with the head
+	NostrEventId string `json:"nostr_event_id"`
+	Timestamp    int64  `json:"timestamp"`
+}
+
+type LedgerStore interface {
+	// Append adds the entry to the end of the chain and sets its sequence and
+	// hashes. Entries with a key that was appended before are skipped, the
+	// stored entry is returned instead.
+	Append(ctx context.Context, key string, entry *LedgerEntry) (*LedgerEntry, error)
+	// List returns the entries after the sequence number, oldest first
+	List(ctx context.Context, since uint64, limit int) ([]*LedgerEntry, error)
+	// Head returns the latest entry or ErrDoesNotExist if the ledger is empty
+	Head(ctx context.Context) (*LedgerEntry, error)
+	AddAnchor(context.Context, *LedgerAnchor) error
+	ListAnchors(ctx context.Context) ([]*LedgerAnchor, error)
+}
+
+// LedgerTotals are the amounts of a bounty in sats, Donated doesn't include
+// refunded donations.
+type LedgerTotals struct {
+	Donated  int64 `json:"donated"`
+	PaidOut  int64 `json:"paid_out"`
+	Awarded  int64 `json:"awarded"`
+	Refunded int64 `json:"refunded"`
+}
+
+type LedgerResponse struct {
+	Entries []*LedgerEntry  `json:"entries"`
+	Head    *LedgerEntry    `json:"head,omitempty"`
+	Anchors []*LedgerAnchor `json:"anchors"`
+	// totals of the bounties as shown in their comments, verifiers compare
+	// them with the totals they recompute from the entries
+	Bounties map[int64]*LedgerTotals `json:"bounties"`
+}
+
+// ComputeHash returns the hash of the entry, the hex encoded sha256 of its
+// fields joined by "|" in the order seq, type, issue id, amount, payment hash,
+// preimage, txid, timestamp and previous hash. The hunter of awards is
+// appended last, so entries without one keep their hashes.
+func (entry *LedgerEntry) ComputeHash() string {
+	data := fmt.Sprintf("%d|%s|%d|%d|%s|%s|%s|%d|%s",
+		entry.Seq, entry.Type, entry.IssueId, entry.Amount, entry.PaymentHash,
+		entry.Preimage, entry.Txid, entry.Timestamp, entry.PrevHash)
+	if entry.Hunter != "" {
+		data += "|" + entry.Hunter
+	}
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
+
+// LedgerVerifier checks the entries of a ledger in order and recomputes the
+// totals of the bounties.
+type LedgerVerifier struct {
+	Head   *LedgerEntry
+	Totals map[int64]*LedgerTotals
+}
+
+func NewLedgerVerifier() *LedgerVerifier {
+	return &LedgerVerifier{Totals: make(map[int64]*LedgerTotals)}
+}
+
+// Add verifies the next entry of the ledger.
+func (verifier *LedgerVerifier) Add(entry *LedgerEntry) error {
+	var prevSeq uint64
+	var prevHash string
+	if verifier.Head != nil {
+		prevSeq = verifier.Head.Seq
+		prevHash = verifier.Head.Hash
+	}
+	if entry.Seq != prevSeq+1 {
+		return fmt.Errorf("entry %v follows entry %v", entry.Seq, prevSeq)
+	}
+	if entry.PrevHash != prevHash {
+		return fmt.Errorf("entry %v doesn't reference the hash of entry %v", entry.Seq, prevSeq)
+	}
+	if entry.ComputeHash() != entry.Hash {
+		return fmt.Errorf("hash of entry %v doesn't match its content", entry.Seq)
+	}
+	if entry.Amount <= 0 && (entry.Type != LedgerAdjustment || entry.Amount == 0) {
+		return fmt.Errorf("invalid amount %v of entry %v", entry.Amount, entry.Seq)
+	}
+	if entry.Preimage != "" {
+		preimage, err := hex.DecodeString(entry.Preimage)
+		if err != nil {
+			return fmt.Errorf("invalid preimage of entry %v", entry.Seq)
+		}
+		hash := sha256.Sum256(preimage)
+		if hex.EncodeToString(hash[:]) != entry.PaymentHash {
+			return fmt.Errorf("preimage of entry %v doesn't match its payment hash", entry.Seq)
+		}
+	}
+	totals, ok := verifier.Totals[entry.IssueId]
+	if !ok {
+		totals = &LedgerTotals{}
+		verifier.Totals[entry.IssueId] = totals
+	}
+	switch entry.Type {
+	case LedgerOpening, LedgerDonation, LedgerDeposit, LedgerAdjustment:
+		totals.Donated += entry.Amount
+	case LedgerPayout:
+		totals.PaidOut += entry.Amount
+	case LedgerAward:
+		if entry.Hunter == "" {
+			return fmt.Errorf("award entry %v has no hunter", entry.Seq)
+		}
+		totals.Awarded += entry.Amount
+	case LedgerRefund:
+		totals.Donated -= entry.Amount
+		totals.Refunded += entry.Amount
+	default:
+		return fmt.Errorf("unknown type %v of entry %v", entry.Type, entry.Seq)
+	}
+	verifier.Head = entry
+	return nil
+}
+
+// Ledger returns the entries after the sequence number together with the
+// anchors and current totals of the bounties.
+func (srv *IssueService) Ledger(ctx context.Context, since uint64, limit int) (*LedgerResponse, error) {
+	if limit <= 0 || limit > maxLedgerEntries {
+		limit = maxLedgerEntries
+	}
+	entries, err := srv.ledgerStore.List(ctx, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := &LedgerResponse{Entries: entries, Bounties: make(map[int64]*LedgerTotals)}
+	res.Head, err = srv.ledgerStore.Head(ctx)
+	if err != nil && err != ErrDoesNotExist {
+		return nil, err
+	}
+	res.Anchors, err = srv.ledgerStore.ListAnchors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	issues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, issue := range issues {
+		if issue.LedgerHash == "" {
+			continue
+		}
+		res.Bounties[issue.Id] = &LedgerTotals{
+			Donated:  issue.Bounty,
+			PaidOut:  paidOut(issue),
+			Awarded:  awarded(issue),
+			Refunded: refunded(issue),
+		}
+	}
+	return res, nil
+}
+
+// startLedger opens the ledger with the balances of existing bounties the
+// first time it runs, so later entries add up to the totals of the comments.
+func (srv *IssueService) startLedger(ctx context.Context) error {
+	srv.Lock()
+	defer srv.Unlock()
+	_, err := srv.store.GetCursor(ctx, ledgerCursor)
+	if err == ErrDoesNotExist {
+		err = srv.openLedger(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = srv.store.GetCursor(ctx, ledgerAwardsCursor)
+	if err == ErrDoesNotExist {
+		err = srv.openLedgerAwards(ctx)
+	}
+	return err
+}
+
+func (srv *IssueService) openLedger(ctx context.Context) error {
+	issues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		id := strconv.FormatInt(issue.Id, 10)
+		if issue.Bounty > 0 {
+			err = srv.appendLedger(ctx, issue, "opening/"+id, &LedgerEntry{
+				Type:   LedgerOpening,
+				Amount: issue.Bounty,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, payout := range sentPayouts(issue) {
+			err = srv.appendLedger(ctx, issue, "opening/"+id+"/"+payout.Txid, &LedgerEntry{
+				Type:      LedgerPayout,
+				Amount:    payout.Amount,
+				Txid:      payout.Txid,
+				Timestamp: payout.Timestamp,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if issue.LedgerHash != "" {
+			err = srv.store.Update(ctx, issue)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	seq, err := srv.store.LastEventSeq(ctx)
+	if err != nil {
+		return err
+	}
+	return srv.store.PutCursor(ctx, ledgerCursor, seq)
+}
+
+// openLedgerAwards adds opening entries for the awards of existing bounties,
+// the ledger handler skips award events up to the stored cursor.
+func (srv *IssueService) openLedgerAwards(ctx context.Context) error {
+	issues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		id := strconv.FormatInt(issue.Id, 10)
+		changed := false
+		for _, claim := range issue.Claims {
+			if claim.Awarded <= 0 {
+				continue
+			}
+			err = srv.appendLedger(ctx, issue, "opening/"+id+"/award/"+strings.ToLower(claim.Hunter), &LedgerEntry{
+				Type:      LedgerAward,
+				Amount:    claim.Awarded,
+				Hunter:    claim.Hunter,
+				Timestamp: claim.AwardedAt,
+			})
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+		if changed {
+			err = srv.store.Update(ctx, issue)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	seq, err := srv.store.LastEventSeq(ctx)
+	if err != nil {
+		return err
+	}
+	return srv.store.PutCursor(ctx, ledgerAwardsCursor, seq)
+}
+
+// appendLedger appends an entry of the issue and remembers the hash of the
+// entry on the issue, the caller stores the issue.
+func (srv *IssueService) appendLedger(ctx context.Context, issue *BountyIssue, key string, entry *LedgerEntry) error {
+	entry.IssueId = issue.Id
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().Unix()
+	}
+	entry, err := srv.ledgerStore.Append(ctx, key, entry)
+	if err != nil {
+		return err
+	}
+	if entry.Seq > issue.LedgerSeq {
+		issue.LedgerSeq = entry.Seq
+		issue.LedgerHash = entry.Hash
+	}
+	return nil
+}
+
+// handleLedgerEvent appends donations, payouts, awards and refunds to the ledger and updates
+// the bounty comment, which shows the hash of the latest entry of the bounty.
+func (srv *IssueService) handleLedgerEvent(ctx context.Context, event *Event) error {
+	srv.Lock()
+	issue, err := srv.store.Get(ctx, event.IssueId)
+	if err == ErrDoesNotExist {
+		srv.Unlock()
+		fmt.Printf("skipping ledger entry of deleted issue %v \n", event.IssueId)
+		return nil
+	}
+	if err != nil {
+		srv.Unlock()
+		return err
+	}
+	entry := &LedgerEntry{Amount: event.Amount, Txid: event.Txid, Timestamp: event.Timestamp}
+	switch event.Type {
+	case EventInvoiceSettled:
+		hash, err := paymentHash(issue, event.Invoice)
+		if err != nil {
+			srv.Unlock()
+			return err
+		}
+		entry.Type = LedgerDonation
+		entry.PaymentHash = hex.EncodeToString(hash)
+		if donation := issue.Donations[event.Invoice]; donation != nil {
+			entry.Preimage = donation.Preimage
+		}
+	case EventOnchainDeposit:
+		entry.Type = LedgerDeposit
+	case EventPayout:
+		entry.Type = LedgerPayout
+	case EventBountyAdjusted:
+		entry.Type = LedgerAdjustment
+	case EventClaimAwarded:
+		opened, err := srv.store.GetCursor(ctx, ledgerAwardsCursor)
+		if err != nil && err != ErrDoesNotExist {
+			srv.Unlock()
+			return err
+		}
+		if event.Seq <= opened {
+			// covered by an opening entry
+			srv.Unlock()
+			return nil
+		}
+		entry.Type = LedgerAward
+		entry.Hunter = event.Hunter
+	case EventRefund:
+		entry.Type = LedgerRefund
+		if event.Invoice != "" {
+			hash, err := paymentHash(issue, event.Invoice)
+			if err != nil {
+				srv.Unlock()
+				return err
+			}
+			entry.PaymentHash = hex.EncodeToString(hash)
+		}
+	}
+	err = srv.appendLedger(ctx, issue, "event/"+strconv.FormatUint(event.Seq, 10), entry)
+	if err == nil {
+		err = srv.store.Update(ctx, issue)
+	}
+	srv.Unlock()
+	if err != nil {
+		return err
+	}
+	return srv.enqueueComment(ctx, event.IssueId)
+}
+
+func (srv *IssueService) ledgerUrl() string {
+	return srv.cfg.HttpUrl + ledgerPath
+}
+
+// runLedgerAnchor periodically publishes the ledger head on nostr if it
+// changed since the last anchor.
+func (srv *IssueService) runLedgerAnchor(ctx context.Context) {
+	for {
+		err := srv.anchorLedger(ctx)
+		if err != nil {
+			fmt.Printf("unable to anchor the ledger: %v \n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(srv.cfg.LedgerAnchorInterval):
+		}
+	}
+}
+
+func (srv *IssueService) anchorLedger(ctx context.Context) error {
+	head, err := srv.ledgerStore.Head(ctx)
+	if err == ErrDoesNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	anchors, err := srv.ledgerStore.ListAnchors(ctx)
+	if err != nil {
+		return err
+	}
+	if len(anchors) > 0 && anchors[len(anchors)-1].Seq >= head.Seq {
+		return nil
+	}
+	return srv.enqueue(ctx, &OutboxOp{Key: "ledger/anchor", Kind: OutboxLedgerAnchor, Seq: head.Seq})
+}
+
+// publishLedgerAnchor publishes the hash of a ledger entry as nostr note.
+func (srv *IssueService) publishLedgerAnchor(ctx context.Context, op *OutboxOp) error {
+	if srv.nostrKey == nil {
+		return nil
+	}
+	entries, err := srv.ledgerStore.List(ctx, op.Seq-1, 1)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return ErrDoesNotExist
+	}
+	head := entries[0]
+	content := fmt.Sprintf("Bounty ledger entry %d has hash %s\n\n%s", head.Seq, head.Hash, srv.ledgerUrl())
+	ev := nostr.NewEvent(KindNote, content,
+		nostr.Tag{"r", srv.ledgerUrl()},
+		nostr.Tag{"t", "bountyledger"},
+	)
+	err = ev.Sign(srv.nostrKey)
+	if err != nil {
+		return err
+	}
+	err = srv.relays.Publish(ctx, ev)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("anchored ledger entry %v in nostr event %v \n", head.Seq, ev.Id)
+	return srv.ledgerStore.AddAnchor(ctx, &LedgerAnchor{
+		Seq:          head.Seq,
+		Hash:         head.Hash,
+		NostrEventId: ev.Id,
+		Timestamp:    ev.CreatedAt,
+	})
+}
@@ -0,0 +1,310 @@
+package tracker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLedger returns a valid chain of a donation, a payout and an award.
+func testLedger() []*LedgerEntry {
+	preimage := make([]byte, 32)
+	preimage[0] = 1
+	hash := sha256.Sum256(preimage)
+	entries := []*LedgerEntry{
+		{Type: LedgerDonation, IssueId: 1, Amount: 5000, PaymentHash: hex.EncodeToString(hash[:]), Preimage: hex.EncodeToString(preimage)},
+		{Type: LedgerPayout, IssueId: 1, Amount: 1000, Txid: "txid"},
+		{Type: LedgerAward, IssueId: 1, Amount: 2000, Hunter: "hunter"},
+	}
+	var prevHash string
+	for i, entry := range entries {
+		entry.Seq = uint64(i + 1)
+		entry.Timestamp = int64(1600000000 + i)
+		entry.PrevHash = prevHash
+		entry.Hash = entry.ComputeHash()
+		prevHash = entry.Hash
+	}
+	return entries
+}
+
+func verifyLedger(entries []*LedgerEntry) (*LedgerVerifier, error) {
+	verifier := NewLedgerVerifier()
+	for _, entry := range entries {
+		err := verifier.Add(entry)
+		if err != nil {
+			return verifier, err
+		}
+	}
+	return verifier, nil
+}
+
+func TestLedgerVerifier(t *testing.T) {
+	verifier, err := verifyLedger(testLedger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := LedgerTotals{Donated: 5000, PaidOut: 1000, Awarded: 2000}
+	if *verifier.Totals[1] != expected {
+		t.Fatalf("expected totals %+v, got %+v", expected, verifier.Totals[1])
+	}
+
+	tests := []struct {
+		name   string
+		change func(entries []*LedgerEntry) []*LedgerEntry
+		err    string
+	}{
+		{
+			name: "tampered amount",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				entries[0].Amount = 50000
+				return entries
+			},
+			err: "hash of entry 1 doesn't match",
+		},
+		{
+			name: "rehashed entry breaks its successor",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				entries[0].Amount = 50000
+				entries[0].Hash = entries[0].ComputeHash()
+				return entries
+			},
+			err: "entry 2 doesn't reference the hash of entry 1",
+		},
+		{
+			name: "dropped entry",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				return append(entries[:1], entries[2:]...)
+			},
+			err: "entry 3 follows entry 1",
+		},
+		{
+			name: "reordered entries",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries
+			},
+			err: "entry 3 follows entry 1",
+		},
+		{
+			name: "preimage of another payment",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				entries[0].Preimage = hex.EncodeToString(make([]byte, 32))
+				entries[0].Hash = entries[0].ComputeHash()
+				return entries[:1]
+			},
+			err: "preimage of entry 1 doesn't match its payment hash",
+		},
+		{
+			name: "unknown type",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				entries[1].Type = "withdrawal"
+				entries[1].Hash = entries[1].ComputeHash()
+				return entries[:2]
+			},
+			err: "unknown type withdrawal of entry 2",
+		},
+		{
+			name: "award without hunter",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				entries[2].Hunter = ""
+				entries[2].Hash = entries[2].ComputeHash()
+				return entries
+			},
+			err: "award entry 3 has no hunter",
+		},
+		{
+			name: "negative payout",
+			change: func(entries []*LedgerEntry) []*LedgerEntry {
+				entries[1].Amount = -1000
+				entries[1].Hash = entries[1].ComputeHash()
+				return entries[:2]
+			},
+			err: "invalid amount -1000 of entry 2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := verifyLedger(test.change(testLedger()))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLedgerRefund(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	err := ts.startLedger(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := ts.addIssue(t, 1)
+	var payreqs []string
+	for _, sats := range []int64{1000, 3000} {
+		payreq, err := ts.GetBountyInvoice(ctx, issue.Id, sats, &DonorInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		inv := ts.node.settle(payreq, sats)
+		err = ts.SettleInvoice(ctx, ts.issue(t, issue.Id), payreq, sats, inv.Preimage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payreqs = append(payreqs, payreq)
+	}
+	_, err = ts.AwardClaim(ctx, issue.Id, "hunter", 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the part of the bounty that wasn't awarded can be refunded
+	if _, err := ts.RefundDonation(ctx, issue.Id, payreqs[1], ""); err == nil {
+		t.Fatal("expected the refund of awarded sats to fail")
+	}
+	if _, err := ts.RefundDonation(ctx, issue.Id, "unknown", ""); err != ErrDoesNotExist {
+		t.Fatalf("expected unknown donation, got %v", err)
+	}
+	donation, err := ts.RefundDonation(ctx, issue.Id, payreqs[0], "refundtx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if donation.RefundedAt == 0 || donation.RefundTxid != "refundtx" {
+		t.Fatalf("refund wasn't recorded on the donation %+v", donation)
+	}
+	if _, err := ts.RefundDonation(ctx, issue.Id, payreqs[0], ""); err != AlreadyRefundedError {
+		t.Fatalf("expected a second refund to fail, got %v", err)
+	}
+	if bounty := ts.issue(t, issue.Id).Bounty; bounty != 3000 {
+		t.Fatalf("expected the refund to leave 3000 sats, got %v", bounty)
+	}
+	filter := &EventFilter{Types: ledgerEvents}
+	for _, event := range lastEvents(t, ts, 0) {
+		if !filter.matches(event) {
+			continue
+		}
+		err = ts.handleLedgerEvent(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := ts.Ledger(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := verifyLedger(res.Entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund := res.Entries[len(res.Entries)-1]
+	hash, err := paymentHash(ts.issue(t, issue.Id), payreqs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Type != LedgerRefund || refund.Amount != 1000 || refund.Txid != "refundtx" ||
+		refund.PaymentHash != hex.EncodeToString(hash) {
+		t.Fatalf("unexpected refund entry %+v", refund)
+	}
+	expected := LedgerTotals{Donated: 3000, Awarded: 2000, Refunded: 1000}
+	if *verifier.Totals[1] != expected || *res.Bounties[1] != expected {
+		t.Fatalf("expected totals %+v, got %+v and bounty %+v", expected, verifier.Totals[1], res.Bounties[1])
+	}
+
+	// refunds don't show up as totals mismatch
+	if discrepancy := ts.reconcileTotals(ctx, ts.issue(t, issue.Id), nil, false); discrepancy != nil {
+		t.Fatalf("unexpected discrepancy %+v", discrepancy)
+	}
+}
+
+func TestLedgerHandlerBlocksOnFailure(t *testing.T) {
+	if ledgerRetryPolicy.MaxAttempts != 0 {
+		t.Fatal("ledger events must be retried until they are appended")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestService(t)
+	err := ts.startLedger(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := ts.addIssue(t, 1)
+
+	// the payment hash of the donation can't be found, so the entry can't
+	// be appended
+	broken := newEvent(EventInvoiceSettled, issue)
+	broken.Invoice = "lnbcrt1broken"
+	broken.Amount = 1000
+	later := newEvent(EventOnchainDeposit, issue)
+	later.Amount = 2000
+	later.Txid = "txid"
+	err = ts.store.Commit(ctx, issue, broken, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mtx sync.Mutex
+	attempts := 0
+	policy := ledgerRetryPolicy
+	policy.Backoff, policy.MaxBackoff = time.Millisecond, time.Millisecond
+	bus := NewEventBus(ts.store)
+	bus.Handle(ledgerCursor, &EventFilter{Types: ledgerEvents}, func(ctx context.Context, event *Event) error {
+		mtx.Lock()
+		attempts++
+		mtx.Unlock()
+		return ts.handleLedgerEvent(ctx, event)
+	}, policy)
+	err = bus.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(broken, later)
+
+	eventually(t, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return attempts > outboxRetryPolicy.MaxAttempts*2
+	}, "ledger event wasn't retried")
+	cursor, err := ts.store.GetCursor(ctx, ledgerCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor >= broken.Seq {
+		t.Fatalf("ledger cursor moved past the failing event to %v", cursor)
+	}
+	dead, err := ts.store.ListDeadEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 0 {
+		t.Fatalf("ledger event was dead lettered: %+v", dead)
+	}
+	if _, err := ts.ledgerStore.Head(ctx); err != ErrDoesNotExist {
+		t.Fatalf("expected no entries, got %v", err)
+	}
+
+	// once the donation can be resolved both entries are appended in order
+	ts.Lock()
+	issue = ts.issue(t, issue.Id)
+	issue.PaymentHashes[broken.Invoice] = strings.Repeat("00", 32)
+	err = ts.store.Update(ctx, issue)
+	ts.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		cursor, err := ts.store.GetCursor(ctx, ledgerCursor)
+		return err == nil && cursor >= later.Seq
+	}, "ledger didn't catch up")
+	entries, err := ts.ledgerStore.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Type != LedgerDonation || entries[1].Type != LedgerDeposit {
+		t.Fatalf("expected the donation before the deposit, got %+v", entries)
+	}
+}
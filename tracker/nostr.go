@@ -0,0 +1,406 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// bounties are published as NIP-99 listings, a parameterized replaceable
+	// event that is updated as the bounty changes
+	KindBounty     = 30402
+	KindMetadata   = 0
+	KindNote       = 1
+	KindZapRequest = 9734
+	KindZapReceipt = 9735
+
+	lnurlPath          = "/lnurlp"
+	wellKnownLnurlPath = "/.well-known/lnurlp/:name"
+
+	amountkey  = "amount"
+	nostrkey   = "nostr"
+	commentkey = "comment"
+
+	// lightning address name of zaps on bounty events, the bounty is taken
+	// from the zap request
+	nostrLnurlName = "bounties"
+	// in msats
+	lnurlMaxSendable = int64(100000000000)
+)
+
+var (
+	NostrDisabledError = fmt.Errorf("nostr is disabled")
+
+	// events that change the published bounty event or may be zaps
+	nostrEvents = []EventType{
+		EventBountyCreated,
+		EventBountyReopened,
+		EventBountyClosed,
+		EventInvoiceSettled,
+		EventOnchainDeposit,
+		EventPayout,
+	}
+)
+
+// LnurlPayResponse is the first response of LNURL-pay (LUD-06), extended with
+// the nostr fields of NIP-57.
+type LnurlPayResponse struct {
+	Tag            string `json:"tag"`
+	Callback       string `json:"callback"`
+	MinSendable    int64  `json:"minSendable"`
+	MaxSendable    int64  `json:"maxSendable"`
+	Metadata       string `json:"metadata"`
+	CommentAllowed int    `json:"commentAllowed"`
+	AllowsNostr    bool   `json:"allowsNostr,omitempty"`
+	NostrPubkey    string `json:"nostrPubkey,omitempty"`
+}
+
+// SetNostr enables publishing bounties with the key and accepting zaps, it
+// has to be called before the event handlers are started.
+func (srv *IssueService) SetNostr(key *nostr.PrivateKey, publisher nostr.Publisher) {
+	srv.nostrKey = key
+	srv.relays = publisher
+}
+
+// NostrPubkey returns the hex encoded public key of the bot, empty if nostr
+// is disabled.
+func (srv *IssueService) NostrPubkey() string {
+	if srv.nostrKey == nil {
+		return ""
+	}
+	return srv.nostrKey.PublicKey()
+}
+
+func (srv *IssueService) handleNostrEvent(ctx context.Context, event *Event) error {
+	err := srv.enqueue(ctx, &OutboxOp{
+		Key:     "nostr/" + strconv.FormatInt(event.IssueId, 10),
+		Kind:    OutboxNostrBounty,
+		IssueId: event.IssueId,
+	})
+	if err != nil || event.Type != EventInvoiceSettled {
+		return err
+	}
+	issue, err := srv.store.Get(ctx, event.IssueId)
+	if err != nil {
+		return err
+	}
+	if donation := issue.Donations[event.Invoice]; donation == nil || donation.ZapRequest == "" {
+		return nil
+	}
+	return srv.enqueue(ctx, &OutboxOp{
+		Key:     "zap/" + issue.PaymentHashes[event.Invoice],
+		Kind:    OutboxZapReceipt,
+		IssueId: event.IssueId,
+		Invoice: event.Invoice,
+	})
+}
+
+// publishBounty publishes the current state of a bounty as replaceable
+// event.
+func (srv *IssueService) publishBounty(ctx context.Context, issueId int64) error {
+	if srv.nostrKey == nil {
+		return nil
+	}
+	issue, err := srv.store.Get(ctx, issueId)
+	if err != nil {
+		return err
+	}
+	issueUrl := issue.HtmlUrl
+	if issueUrl == "" {
+		issueUrl = issue.Url
+	}
+	name := fmt.Sprintf("%s/%s#%d", issue.Owner, issue.Repo, issue.Number)
+	title := issue.Title
+	if title == "" {
+		title = name
+	}
+	status := "active"
+	content := fmt.Sprintf("%s\n\n%s sats bounty on %s\n%s\n\nZap this event or donate at %s",
+		title, formatThousands(issue.Bounty), name, issueUrl, srv.donateUrl(issue.Id))
+	if !issue.Active {
+		status = "closed"
+		content = fmt.Sprintf("%s\n\nThe bounty on %s was closed with %s sats.\n%s", title, name, formatThousands(issue.Bounty), issueUrl)
+	}
+	ev := nostr.NewEvent(KindBounty, content,
+		nostr.Tag{"d", strconv.FormatInt(issue.Id, 10)},
+		nostr.Tag{"title", title},
+		nostr.Tag{"summary", name},
+		nostr.Tag{"price", strconv.FormatInt(issue.Bounty, 10), "SATS"},
+		nostr.Tag{"status", status},
+		nostr.Tag{"r", issueUrl},
+		nostr.Tag{"t", "bounty"},
+		nostr.Tag{"t", "lightning"},
+	)
+	if issue.CreatedAt != 0 {
+		ev.Tags = append(ev.Tags, nostr.Tag{"published_at", strconv.FormatInt(issue.CreatedAt, 10)})
+	}
+	err = ev.Sign(srv.nostrKey)
+	if err != nil {
+		return err
+	}
+	err = srv.relays.Publish(ctx, ev)
+	if err != nil {
+		return err
+	}
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err = srv.store.Get(ctx, issueId)
+	if err != nil {
+		return err
+	}
+	issue.NostrEventId = ev.Id
+	return srv.store.Update(ctx, issue)
+}
+
+// publishProfile publishes the profile of the bot with its lightning address,
+// so clients can zap the bounty events.
+func (srv *IssueService) publishProfile(ctx context.Context) error {
+	if srv.nostrKey == nil {
+		return nil
+	}
+	profile := map[string]string{
+		"name":    "GitHub bounties",
+		"about":   "Lightning bounties on GitHub issues, zap a bounty to fund it.",
+		"website": srv.cfg.HttpUrl,
+	}
+	if u, err := url.Parse(srv.cfg.HttpUrl); err == nil && u.Host != "" {
+		profile["lud16"] = nostrLnurlName + "@" + u.Host
+	}
+	content, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	ev := nostr.NewEvent(KindMetadata, string(content))
+	err = ev.Sign(srv.nostrKey)
+	if err != nil {
+		return err
+	}
+	return srv.relays.Publish(ctx, ev)
+}
+
+// publishZapReceipt publishes the zap receipt of a settled zap to the relays
+// of the zap request and the configured relays. The zap request is untrusted,
+// so its relays have to be public wss urls.
+func (srv *IssueService) publishZapReceipt(ctx context.Context, op *OutboxOp) error {
+	if srv.nostrKey == nil {
+		return nil
+	}
+	issue, err := srv.store.Get(ctx, op.IssueId)
+	if err != nil {
+		return err
+	}
+	donation := issue.Donations[op.Invoice]
+	if donation == nil || donation.ZapRequest == "" {
+		return nil
+	}
+	req := &nostr.Event{}
+	err = json.Unmarshal([]byte(donation.ZapRequest), req)
+	if err != nil {
+		return err
+	}
+	var tags []nostr.Tag
+	var relays []string
+	for _, tag := range req.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "p", "e", "a":
+			tags = append(tags, nostr.Tag{tag[0], tag[1]})
+		case "relays":
+			for _, relay := range tag[1:] {
+				if nostr.IsPublicRelayUrl(relay) {
+					relays = append(relays, relay)
+				}
+			}
+		}
+	}
+	tags = append(tags,
+		nostr.Tag{"P", req.Pubkey},
+		nostr.Tag{"bolt11", op.Invoice},
+		nostr.Tag{"description", donation.ZapRequest},
+	)
+	ev := nostr.NewEvent(KindZapReceipt, "", tags...)
+	if donation.Timestamp != 0 {
+		ev.CreatedAt = donation.Timestamp
+	}
+	err = ev.Sign(srv.nostrKey)
+	if err != nil {
+		return err
+	}
+	return srv.relays.Publish(ctx, ev, relays...)
+}
+
+// LnurlPayRequest returns the LNURL-pay parameters of a bounty, or of zaps on
+// any bounty event for the name of the bot's lightning address.
+func (srv *IssueService) LnurlPayRequest(ctx context.Context, name string) (*LnurlPayResponse, error) {
+	res := &LnurlPayResponse{
+		Tag:            "payRequest",
+		Callback:       srv.cfg.HttpUrl + lnurlPath + "/" + url.PathEscape(name) + "/callback",
+		MinSendable:    1000,
+		MaxSendable:    lnurlMaxSendable,
+		CommentAllowed: maxNoteLength,
+		AllowsNostr:    srv.nostrKey != nil,
+		NostrPubkey:    srv.NostrPubkey(),
+	}
+	if name == nostrLnurlName {
+		if srv.nostrKey == nil {
+			return nil, NostrDisabledError
+		}
+		res.Metadata = lnurlMetadata("Zap a lightning bounty")
+		return res, nil
+	}
+	issue, err := srv.lnurlBounty(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	file := srv.repoFile(ctx, issue.Owner, issue.Repo)
+	if file.MinDonation > 0 {
+		res.MinSendable = file.MinDonation * 1000
+	}
+	if file.MaxDonation > 0 {
+		res.MaxSendable = file.MaxDonation * 1000
+	}
+	res.Metadata = lnurlMetadata(bountyDescription(issue))
+	return res, nil
+}
+
+// LnurlInvoice creates the invoice of an LNURL-pay callback. Zaps commit to
+// the zap request, other payments to the metadata of the bounty.
+func (srv *IssueService) LnurlInvoice(ctx context.Context, name string, msats int64, comment string, zapRequest string) (string, error) {
+	if msats <= 0 || msats%1000 != 0 {
+		return "", fmt.Errorf("amount must be a positive number of whole sats")
+	}
+	donation := &Donation{Note: comment}
+	var id int64
+	if name != nostrLnurlName {
+		issue, err := srv.lnurlBounty(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		id = issue.Id
+		donation.LnurlMetadata = lnurlMetadata(bountyDescription(issue))
+	}
+	if zapRequest != "" {
+		req, zapId, err := srv.parseZapRequest(ctx, zapRequest, msats)
+		if err != nil {
+			return "", err
+		}
+		if id != 0 && zapId != 0 && zapId != id {
+			return "", fmt.Errorf("zap request is for another bounty")
+		}
+		if id == 0 {
+			id = zapId
+		}
+		donation.ZapRequest = zapRequest
+		donation.Note = req.Content
+	}
+	if id == 0 && zapRequest == "" {
+		return "", fmt.Errorf("payments to %v require a zap request", nostrLnurlName)
+	}
+	if id == 0 {
+		return "", fmt.Errorf("zap request doesn't reference a bounty")
+	}
+	return srv.createBountyInvoice(ctx, id, msats/1000, donation)
+}
+
+// parseZapRequest validates a zap request as described in NIP-57 and returns
+// the bounty it zaps, 0 if it references none.
+func (srv *IssueService) parseZapRequest(ctx context.Context, raw string, msats int64) (*nostr.Event, int64, error) {
+	if srv.nostrKey == nil {
+		return nil, 0, NostrDisabledError
+	}
+	req := &nostr.Event{}
+	err := json.Unmarshal([]byte(raw), req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid zap request: %v", err)
+	}
+	if req.Kind != KindZapRequest {
+		return nil, 0, fmt.Errorf("invalid zap request kind %v", req.Kind)
+	}
+	err = req.Verify()
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid zap request: %v", err)
+	}
+	if len(req.Tags) == 0 {
+		return nil, 0, fmt.Errorf("zap request has no tags")
+	}
+	counts := make(map[string]int)
+	for _, tag := range req.Tags {
+		if len(tag) > 1 {
+			counts[tag[0]]++
+		}
+	}
+	if counts["p"] != 1 || req.TagValue("p") != srv.nostrKey.PublicKey() {
+		return nil, 0, fmt.Errorf("zap request must have a single p tag of %v", srv.nostrKey.PublicKey())
+	}
+	if counts["e"] > 1 || counts["a"] > 1 || counts["P"] > 1 {
+		return nil, 0, fmt.Errorf("zap request must have at most one e, a and P tag")
+	}
+	if amount := req.TagValue("amount"); amount != "" && amount != strconv.FormatInt(msats, 10) {
+		return nil, 0, fmt.Errorf("zap request amount %v doesn't match %v", amount, msats)
+	}
+	if len(req.Content) > maxNoteLength {
+		return nil, 0, NoteTooLongError
+	}
+	if a := req.TagValue("a"); a != "" {
+		parts := strings.SplitN(a, ":", 3)
+		if len(parts) != 3 {
+			return nil, 0, fmt.Errorf("invalid event coordinate %v", a)
+		}
+		if parts[0] != strconv.Itoa(KindBounty) || parts[1] != srv.nostrKey.PublicKey() {
+			return req, 0, nil
+		}
+		id, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid event coordinate %v", a)
+		}
+		return req, id, nil
+	}
+	if e := req.TagValue("e"); e != "" {
+		bountyIssues, err := srv.store.ListAll(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, issue := range bountyIssues {
+			if issue.NostrEventId == e {
+				return req, issue.Id, nil
+			}
+		}
+	}
+	return req, 0, nil
+}
+
+// lnurlBounty returns the active bounty of an LNURL-pay name.
+func (srv *IssueService) lnurlBounty(ctx context.Context, name string) (*BountyIssue, error) {
+	id, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		return nil, ErrDoesNotExist
+	}
+	issue, err := srv.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !issue.Active {
+		return nil, InactiveError
+	}
+	return issue, nil
+}
+
+func bountyDescription(issue *BountyIssue) string {
+	description := fmt.Sprintf("Bounty on %s/%s#%d", issue.Owner, issue.Repo, issue.Number)
+	if issue.Title != "" {
+		description += " " + issue.Title
+	}
+	return description
+}
+
+func lnurlMetadata(description string) string {
+	metadata, _ := json.Marshal([][]string{{"text/plain", description}})
+	return string(metadata)
+}
@@ -0,0 +1,203 @@
+package tracker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"sync"
+	"testing"
+)
+
+// fakePublisher records the events published to the relays.
+type fakePublisher struct {
+	events []*nostr.Event
+	extra  [][]string
+	sync.Mutex
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, ev *nostr.Event, extra ...string) error {
+	p.Lock()
+	defer p.Unlock()
+	p.events = append(p.events, ev)
+	p.extra = append(p.extra, extra)
+	return nil
+}
+
+func newNostrTestService(t *testing.T) (*testService, *nostr.PrivateKey) {
+	ts := newTestService(t)
+	key, err := nostr.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.SetNostr(key, &fakePublisher{})
+	sender, err := nostr.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, sender
+}
+
+// signedEvent returns the json of an event signed by the key.
+func signedEvent(t *testing.T, key *nostr.PrivateKey, kind int, content string, tags ...nostr.Tag) string {
+	ev := nostr.NewEvent(kind, content, tags...)
+	err := ev.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestParseZapRequest(t *testing.T) {
+	ctx := context.Background()
+	ts, sender := newNostrTestService(t)
+	ts.addIssue(t, 1)
+	bot := ts.NostrPubkey()
+	p := nostr.Tag{"p", bot}
+	a := nostr.Tag{"a", fmt.Sprintf("%d:%s:1", KindBounty, bot)}
+	amount := nostr.Tag{"amount", "21000"}
+	relays := nostr.Tag{"relays", "wss://relay.example.com"}
+
+	for _, test := range []struct {
+		name    string
+		request string
+		id      int64
+		valid   bool
+	}{
+		{"valid", signedEvent(t, sender, KindZapRequest, "great work", p, a, amount, relays), 1, true},
+		{"without amount", signedEvent(t, sender, KindZapRequest, "", p, a), 1, true},
+		{"without bounty", signedEvent(t, sender, KindZapRequest, "", p, amount), 0, true},
+		{"bounty of another pubkey", signedEvent(t, sender, KindZapRequest, "", p, nostr.Tag{"a", fmt.Sprintf("%d:%s:1", KindBounty, sender.PublicKey())}), 0, true},
+		{"note", signedEvent(t, sender, KindNote, "", p, a, amount), 0, false},
+		{"zap receipt", signedEvent(t, sender, KindZapReceipt, "", p, a, amount), 0, false},
+		{"other amount", signedEvent(t, sender, KindZapRequest, "", p, a, nostr.Tag{"amount", "20000"}), 0, false},
+		{"amount in sats", signedEvent(t, sender, KindZapRequest, "", p, a, nostr.Tag{"amount", "21"}), 0, false},
+		{"without p", signedEvent(t, sender, KindZapRequest, "", a, amount), 0, false},
+		{"p of another pubkey", signedEvent(t, sender, KindZapRequest, "", nostr.Tag{"p", sender.PublicKey()}, a, amount), 0, false},
+		{"two p", signedEvent(t, sender, KindZapRequest, "", p, nostr.Tag{"p", sender.PublicKey()}, a, amount), 0, false},
+		{"two a", signedEvent(t, sender, KindZapRequest, "", p, a, a, amount), 0, false},
+		{"without tags", signedEvent(t, sender, KindZapRequest, ""), 0, false},
+		{"invalid json", "{", 0, false},
+	} {
+		req, id, err := ts.parseZapRequest(ctx, test.request, 21000)
+		if test.valid != (err == nil) {
+			t.Fatalf("%v: expected valid %v, got %v", test.name, test.valid, err)
+		}
+		if err == nil && (id != test.id || req.Pubkey != sender.PublicKey()) {
+			t.Fatalf("%v: expected bounty %v, got %v", test.name, test.id, id)
+		}
+	}
+
+	// the signature covers the content
+	ev := &nostr.Event{}
+	err := json.Unmarshal([]byte(signedEvent(t, sender, KindZapRequest, "great work", p, a, amount)), ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev.Content = "changed"
+	tampered, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ts.parseZapRequest(ctx, string(tampered), 21000); err == nil {
+		t.Fatal("tampered zap request was accepted")
+	}
+}
+
+func TestLnurlZapInvoice(t *testing.T) {
+	ctx := context.Background()
+	ts, sender := newNostrTestService(t)
+	ts.addIssue(t, 1)
+	bot := ts.NostrPubkey()
+	zap := signedEvent(t, sender, KindZapRequest, "great work",
+		nostr.Tag{"p", bot},
+		nostr.Tag{"a", fmt.Sprintf("%d:%s:1", KindBounty, bot)},
+		nostr.Tag{"amount", "21000"},
+	)
+
+	payreq, err := ts.LnurlInvoice(ctx, nostrLnurlName, 21000, "", zap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := zpay32.Decode(payreq, &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the invoice commits to the exact zap request
+	if hash := sha256.Sum256([]byte(zap)); inv.DescriptionHash == nil || *inv.DescriptionHash != hash {
+		t.Fatal("invoice doesn't commit to the zap request")
+	}
+	if inv.MilliSat == nil || int64(*inv.MilliSat) != 21000 {
+		t.Fatalf("unexpected invoice amount %v", inv.MilliSat)
+	}
+	donation := ts.issue(t, 1).Donations[payreq]
+	if donation == nil || donation.ZapRequest != zap || donation.Note != "great work" {
+		t.Fatalf("zap wasn't stored with the donation: %+v", donation)
+	}
+
+	// the amount of the zap request has to match the invoice
+	if _, err := ts.LnurlInvoice(ctx, nostrLnurlName, 42000, "", zap); err == nil {
+		t.Fatal("zap request for another amount was accepted")
+	}
+
+	// zaps on a bounty's lightning address may reference foreign events
+	foreign := signedEvent(t, sender, KindZapRequest, "",
+		nostr.Tag{"p", bot},
+		nostr.Tag{"a", fmt.Sprintf("%d:%s:1", KindBounty, sender.PublicKey())},
+	)
+	if _, err := ts.LnurlInvoice(ctx, "1", 1000, "", foreign); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.LnurlInvoice(ctx, nostrLnurlName, 1000, "", foreign); err == nil {
+		t.Fatal("zap without bounty was accepted")
+	}
+
+	// donations to a bounty's lightning address commit to its metadata
+	payreq, err = ts.LnurlInvoice(ctx, "1", 5000, "thanks", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err = zpay32.Decode(payreq, &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := lnurlMetadata(bountyDescription(ts.issue(t, 1)))
+	if hash := sha256.Sum256([]byte(metadata)); inv.DescriptionHash == nil || *inv.DescriptionHash != hash {
+		t.Fatal("invoice doesn't commit to the lnurl metadata")
+	}
+}
+
+func TestZapReceiptRelays(t *testing.T) {
+	ctx := context.Background()
+	ts, sender := newNostrTestService(t)
+	ts.addIssue(t, 1)
+	bot := ts.NostrPubkey()
+	zap := signedEvent(t, sender, KindZapRequest, "",
+		nostr.Tag{"p", bot},
+		nostr.Tag{"a", fmt.Sprintf("%d:%s:1", KindBounty, bot)},
+		nostr.Tag{"relays", "wss://relay.example.com", "ws://relay.example.com", "wss://localhost:7000",
+			"wss://127.0.0.1", "wss://10.0.0.1", "wss://192.168.1.1", "wss://[::1]", "wss://169.254.169.254",
+			"wss://[fd00::1]", "wss://0.0.0.0", "http://relay.example.com"},
+	)
+	payreq, err := ts.LnurlInvoice(ctx, nostrLnurlName, 1000, "", zap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.publishZapReceipt(ctx, &OutboxOp{Kind: OutboxZapReceipt, IssueId: 1, Invoice: payreq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := ts.relays.(*fakePublisher)
+	publisher.Lock()
+	defer publisher.Unlock()
+	if len(publisher.extra) != 1 || len(publisher.extra[0]) != 1 || publisher.extra[0][0] != "wss://relay.example.com" {
+		t.Fatalf("expected only the public relay, got %v", publisher.extra)
+	}
+}
@@ -0,0 +1,324 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type NotifierKind string
+
+const (
+	NotifierSlack   NotifierKind = "slack"
+	NotifierDiscord NotifierKind = "discord"
+	NotifierMatrix  NotifierKind = "matrix"
+	NotifierWebhook NotifierKind = "webhook"
+
+	// headers of generic webhooks
+	notifyEventHeader     = "X-Bounty-Event"
+	notifyDeliveryHeader  = "X-Bounty-Delivery"
+	notifySignatureHeader = "X-Bounty-Signature"
+
+	deliveryLogSize = 1000
+)
+
+var (
+	// events sent to notifiers that don't choose their events
+	DefaultNotifyEvents = []EventType{
+		EventBountyCreated,
+		EventInvoiceSettled,
+		EventOnchainDeposit,
+		EventBountyClosed,
+		EventClaimAwarded,
+	}
+)
+
+// Notifier is a destination for notifications about the bounties of a
+// repository, an incoming webhook of slack or discord, a matrix room or a
+// generic webhook that receives the events as json signed with its secret.
+type Notifier struct {
+	// derived from the kind and url if empty
+	Id   string       `json:"id"`
+	Kind NotifierKind `json:"kind"`
+	// webhook url, or the homeserver url for matrix
+	Url string `json:"url"`
+	// room id and access token of the matrix user
+	Room  string `json:"room,omitempty"`
+	Token string `json:"token,omitempty"`
+	// hmac-sha256 key of generic webhooks
+	Secret string      `json:"secret,omitempty"`
+	Events []EventType `json:"events,omitempty"`
+}
+
+// Delivery is an attempt to deliver an event to a notifier.
+type Delivery struct {
+	Owner     string       `json:"owner"`
+	Repo      string       `json:"repo"`
+	Notifier  string       `json:"notifier"`
+	Kind      NotifierKind `json:"kind"`
+	Event     EventType    `json:"event"`
+	EventSeq  uint64       `json:"event_seq"`
+	IssueId   int64        `json:"issue_id"`
+	Attempt   int          `json:"attempt"`
+	Status    int          `json:"status,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Timestamp int64        `json:"timestamp"`
+}
+
+type DeliveryStore interface {
+	// Add appends a delivery, the log keeps the latest deliveries only
+	Add(context.Context, *Delivery) error
+	// List returns the latest deliveries of a repository, newest first
+	List(ctx context.Context, owner string, name string, limit int) ([]*Delivery, error)
+}
+
+// Validate checks the destination and sets the id of the notifier.
+func (notifier *Notifier) Validate() error {
+	u, err := url.Parse(notifier.Url)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid notifier url %v", notifier.Url)
+	}
+	switch notifier.Kind {
+	case NotifierSlack, NotifierDiscord:
+	case NotifierMatrix:
+		if notifier.Room == "" || notifier.Token == "" {
+			return fmt.Errorf("matrix notifiers require a room and a token")
+		}
+	case NotifierWebhook:
+		if notifier.Secret == "" {
+			return fmt.Errorf("webhook notifiers require a secret")
+		}
+	default:
+		return fmt.Errorf("unknown notifier kind %v, use slack, discord, matrix or webhook", notifier.Kind)
+	}
+	if notifier.Id == "" {
+		hash := sha256.Sum256([]byte(string(notifier.Kind) + notifier.Url + notifier.Room))
+		notifier.Id = hex.EncodeToString(hash[:4])
+	}
+	return nil
+}
+
+func (notifier *Notifier) subscribed(eventType EventType) bool {
+	events := notifier.Events
+	if len(events) == 0 {
+		events = DefaultNotifyEvents
+	}
+	for _, subscribed := range events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNotifiers replaces the notifiers of a repository.
+func (srv *IssueService) SetNotifiers(ctx context.Context, owner string, name string, notifiers []*Notifier) (*Repository, error) {
+	ids := make(map[string]bool)
+	for _, notifier := range notifiers {
+		err := notifier.Validate()
+		if err != nil {
+			return nil, err
+		}
+		if ids[notifier.Id] {
+			return nil, fmt.Errorf("duplicate notifier %v", notifier.Id)
+		}
+		ids[notifier.Id] = true
+	}
+	return srv.updateRepo(ctx, owner, name, func(repo *Repository) {
+		repo.Notifiers = notifiers
+	})
+}
+
+// ListDeliveries returns the latest notification deliveries of a repository.
+func (srv *IssueService) ListDeliveries(ctx context.Context, owner string, name string, limit int) ([]*Delivery, error) {
+	return srv.deliveryStore.List(ctx, owner, name, limit)
+}
+
+// handleNotifyEvent enqueues a delivery for every notifier of the repository
+// that subscribed to the event, so a failing destination doesn't hold back
+// the others.
+func (srv *IssueService) handleNotifyEvent(ctx context.Context, event *Event) error {
+	if event.Owner == "" {
+		return nil
+	}
+	repo, err := srv.repoStore.Get(ctx, event.Owner, event.Repo)
+	if err == ErrDoesNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, notifier := range repo.Notifiers {
+		if !notifier.subscribed(event.Type) {
+			continue
+		}
+		err = srv.enqueue(ctx, &OutboxOp{
+			Key:      fmt.Sprintf("notify/%s/%d", notifier.Id, event.Seq),
+			Kind:     OutboxNotify,
+			IssueId:  event.IssueId,
+			Owner:    event.Owner,
+			Repo:     event.Repo,
+			Notifier: notifier.Id,
+			Seq:      event.Seq,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify delivers an event to a notifier and records the attempt in the
+// delivery log. Destinations that reject the notification aren't retried.
+func (srv *IssueService) notify(ctx context.Context, op *OutboxOp) error {
+	repo, err := srv.repoStore.Get(ctx, op.Owner, op.Repo)
+	if err != nil {
+		return err
+	}
+	var notifier *Notifier
+	for _, n := range repo.Notifiers {
+		if n.Id == op.Notifier {
+			notifier = n
+		}
+	}
+	if notifier == nil {
+		// removed since the event
+		return nil
+	}
+	events, err := srv.store.EventsSince(ctx, op.Seq-1, 1)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 || events[0].Seq != op.Seq {
+		return fmt.Errorf("event %v not found", op.Seq)
+	}
+	event := events[0]
+	delivery := &Delivery{
+		Owner:     op.Owner,
+		Repo:      op.Repo,
+		Notifier:  notifier.Id,
+		Kind:      notifier.Kind,
+		Event:     event.Type,
+		EventSeq:  event.Seq,
+		IssueId:   event.IssueId,
+		Attempt:   op.Attempts + 1,
+		Timestamp: time.Now().Unix(),
+	}
+	status, err := srv.sendNotification(ctx, notifier, op.Key, event)
+	delivery.Status = status
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	logErr := srv.deliveryStore.Add(ctx, delivery)
+	if logErr != nil {
+		fmt.Printf("unable to log delivery of %v: %v \n", op.Key, logErr)
+	}
+	if err != nil && status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+		fmt.Printf("notifier %v of %v rejected event %v: %v \n", notifier.Id, repo.FullName(), event.Seq, err)
+		return nil
+	}
+	return err
+}
+
+// sendNotification posts the event in the format of the notifier and
+// returns the http status.
+func (srv *IssueService) sendNotification(ctx context.Context, notifier *Notifier, deliveryId string, event *Event) (int, error) {
+	method, target := http.MethodPost, notifier.Url
+	header := make(http.Header)
+	var body interface{}
+	switch notifier.Kind {
+	case NotifierSlack:
+		body = map[string]string{"text": srv.notificationText(ctx, event, true)}
+	case NotifierDiscord:
+		body = map[string]string{"content": srv.notificationText(ctx, event, true)}
+	case NotifierMatrix:
+		// the transaction id makes retries idempotent
+		method = http.MethodPut
+		target = fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+			strings.TrimSuffix(notifier.Url, "/"), url.PathEscape(notifier.Room), url.PathEscape("bounty-"+strings.ReplaceAll(deliveryId, "/", "-")))
+		header.Set("Authorization", "Bearer "+notifier.Token)
+		body = map[string]string{"msgtype": "m.notice", "body": srv.notificationText(ctx, event, false)}
+	case NotifierWebhook:
+		body = event
+	default:
+		return 0, fmt.Errorf("unknown notifier kind %v", notifier.Kind)
+	}
+	jData, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	if notifier.Kind == NotifierWebhook {
+		header.Set(notifyEventHeader, string(event.Type))
+		header.Set(notifyDeliveryHeader, deliveryId)
+		header.Set(notifySignatureHeader, "sha256="+signNotification(notifier.Secret, jData))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(jData))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	res, err := srv.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return res.StatusCode, fmt.Errorf("unexpected status %v: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res.StatusCode, nil
+}
+
+// signNotification returns the hex encoded hmac-sha256 of a webhook body.
+func signNotification(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notificationText describes an event for chat messages, with a markdown
+// link if the format supports it.
+func (srv *IssueService) notificationText(ctx context.Context, event *Event, markdown bool) string {
+	issue := fmt.Sprintf("%s/%s#%d", event.Owner, event.Repo, event.Number)
+	if bountyIssue, err := srv.store.Get(ctx, event.IssueId); err == nil && bountyIssue.Title != "" {
+		issue += " " + bountyIssue.Title
+	}
+	if event.Url != "" {
+		if markdown {
+			issue = fmt.Sprintf("[%s](%s)", issue, event.Url)
+		} else {
+			issue += " " + event.Url
+		}
+	}
+	bounty := formatThousands(event.Bounty) + " sats"
+	switch event.Type {
+	case EventBountyCreated:
+		return fmt.Sprintf("⚡ New bounty on %s, donate at %s", issue, srv.donateUrl(event.IssueId))
+	case EventBountyReopened:
+		return fmt.Sprintf("⚡ The bounty on %s was reopened with %s", issue, bounty)
+	case EventInvoiceSettled, EventOnchainDeposit:
+		return fmt.Sprintf("⚡ %s sats were donated to %s, the bounty is now %s", formatThousands(event.Amount), issue, bounty)
+	case EventBountyClosed:
+		return fmt.Sprintf("⚡ %s was closed with a bounty of %s", issue, bounty)
+	case EventClaimAwarded:
+		return fmt.Sprintf("⚡ %s sats of the bounty on %s were awarded to @%s", formatThousands(event.Amount), issue, event.Hunter)
+	case EventPayout:
+		return fmt.Sprintf("⚡ %s sats of the bounty on %s were paid out on-chain in %s", formatThousands(event.Amount), issue, event.Txid)
+	case EventRefund:
+		return fmt.Sprintf("⚡ %s sats of the bounty on %s were refunded, the bounty is now %s", formatThousands(event.Amount), issue, bounty)
+	case EventNodeOutage:
+		return fmt.Sprintf("⚡ The lightning node of %s is unreachable, donations are paused", issue)
+	}
+	return fmt.Sprintf("⚡ %s on %s, the bounty is %s", event.Type, issue, bounty)
+}
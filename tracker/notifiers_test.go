@@ -0,0 +1,281 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notifyRequest is a request received by a notifierServer.
+type notifyRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// notifierServer records the notifications it receives and answers with the
+// queued status codes, 200 once they ran out.
+type notifierServer struct {
+	*httptest.Server
+	requests []*notifyRequest
+	statuses []int
+	sync.Mutex
+}
+
+func newNotifierServer(t *testing.T, statuses ...int) *notifierServer {
+	s := &notifierServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		s.Lock()
+		defer s.Unlock()
+		s.requests = append(s.requests, &notifyRequest{method: r.Method, path: r.URL.EscapedPath(), header: r.Header, body: body})
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *notifierServer) received(path string) []*notifyRequest {
+	s.Lock()
+	defer s.Unlock()
+	var requests []*notifyRequest
+	for _, r := range s.requests {
+		if strings.HasPrefix(r.path, path) {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// notifyEvents hands the events after the sequence number to the notify
+// handler and runs the notification queue once.
+func notifyEvents(t *testing.T, ts *testService, since uint64) {
+	ctx := context.Background()
+	events, err := ts.store.EventsSince(ctx, since, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		err = ts.handleNotifyEvent(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.processOutbox(ctx, OutboxNotify)
+}
+
+func TestNotifierPayloads(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	ts.cfg.HttpUrl = "https://bounties.example.com"
+	server := newNotifierServer(t)
+	_, err := ts.SetNotifiers(ctx, "owner", "repo", []*Notifier{
+		{Kind: NotifierSlack, Url: server.URL + "/slack"},
+		{Kind: NotifierDiscord, Url: server.URL + "/discord"},
+		{Kind: NotifierMatrix, Url: server.URL + "/matrix/", Room: "!room:example.com", Token: "token"},
+		{Kind: NotifierWebhook, Url: server.URL + "/webhook", Secret: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.addIssue(t, 1)
+	notifyEvents(t, ts, 0)
+
+	link := "[owner/repo#1 Fix the bug](https://github.com/owner/repo/issues/1)"
+	text := "⚡ New bounty on " + link + ", donate at https://bounties.example.com" + invoicePagePath + "?" + issueidkey + "=1"
+	slack := server.received("/slack")
+	if len(slack) != 1 || slack[0].method != http.MethodPost || slack[0].header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected slack requests %+v", slack)
+	}
+	var message map[string]string
+	if err := json.Unmarshal(slack[0].body, &message); err != nil || len(message) != 1 || message["text"] != text {
+		t.Fatalf("unexpected slack message %s", slack[0].body)
+	}
+
+	discord := server.received("/discord")
+	if len(discord) != 1 {
+		t.Fatalf("expected a discord message, got %v", len(discord))
+	}
+	message = nil
+	if err := json.Unmarshal(discord[0].body, &message); err != nil || len(message) != 1 || message["content"] != text {
+		t.Fatalf("unexpected discord message %s", discord[0].body)
+	}
+
+	matrix := server.received("/matrix")
+	if len(matrix) != 1 || matrix[0].method != http.MethodPut {
+		t.Fatalf("unexpected matrix requests %+v", matrix)
+	}
+	if !strings.HasPrefix(matrix[0].path, "/matrix/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/bounty-notify-") {
+		t.Fatalf("unexpected matrix path %v", matrix[0].path)
+	}
+	if auth := matrix[0].header.Get("Authorization"); auth != "Bearer token" {
+		t.Fatalf("unexpected matrix authorization %v", auth)
+	}
+	message = nil
+	if err := json.Unmarshal(matrix[0].body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message["msgtype"] != "m.notice" || strings.Contains(message["body"], "](") ||
+		!strings.Contains(message["body"], "owner/repo#1 Fix the bug https://github.com/owner/repo/issues/1") {
+		t.Fatalf("unexpected matrix message %s", matrix[0].body)
+	}
+
+	webhook := server.received("/webhook")
+	if len(webhook) != 1 {
+		t.Fatalf("expected a webhook delivery, got %v", len(webhook))
+	}
+	header := webhook[0].header
+	if header.Get(notifyEventHeader) != string(EventBountyCreated) || !strings.HasPrefix(header.Get(notifyDeliveryHeader), "notify/") {
+		t.Fatalf("unexpected webhook headers %v", header)
+	}
+	if sig := header.Get(notifySignatureHeader); sig != "sha256="+signNotification("secret", webhook[0].body) {
+		t.Fatalf("invalid webhook signature %v", sig)
+	}
+	event := &Event{}
+	if err := json.Unmarshal(webhook[0].body, event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventBountyCreated || event.IssueId != 1 || event.Seq == 0 {
+		t.Fatalf("unexpected webhook event %+v", event)
+	}
+
+	deliveries, err := ts.ListDeliveries(ctx, "owner", "repo", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 4 {
+		t.Fatalf("expected 4 deliveries, got %v", len(deliveries))
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != http.StatusOK || delivery.Error != "" || delivery.Attempt != 1 {
+			t.Fatalf("unexpected delivery %+v", delivery)
+		}
+	}
+	pending, err := ts.ListOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range pending {
+		if op.Kind == OutboxNotify {
+			t.Fatalf("notification is still pending: %+v", op)
+		}
+	}
+}
+
+func TestNotifierRetry(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	server := newNotifierServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	_, err := ts.SetNotifiers(ctx, "owner", "repo", []*Notifier{
+		{Kind: NotifierWebhook, Url: server.URL + "/webhook", Secret: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.addIssue(t, 1)
+	notifyEvents(t, ts, 0)
+
+	// retry the failed delivery until it succeeds
+	for attempt := 1; attempt <= 2; attempt++ {
+		pending, err := ts.ListOutbox(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var op *OutboxOp
+		for _, p := range pending {
+			if p.Kind == OutboxNotify {
+				op = p
+			}
+		}
+		if op == nil || op.Attempts != attempt || op.LastError == "" {
+			t.Fatalf("expected a pending retry after attempt %v, got %+v", attempt, op)
+		}
+		if op.NextAttempt <= time.Now().Unix() {
+			t.Fatalf("retry isn't backed off: %v", op.NextAttempt)
+		}
+		op.NextAttempt = time.Now().Unix()
+		err = ts.outboxStore.Reschedule(ctx, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.processOutbox(ctx, OutboxNotify)
+	}
+	pending, err := ts.ListOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range pending {
+		if op.Kind == OutboxNotify {
+			t.Fatalf("delivered notification is still pending: %+v", op)
+		}
+	}
+
+	requests := server.received("/webhook")
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 attempts, got %v", len(requests))
+	}
+	for _, r := range requests[1:] {
+		if r.header.Get(notifyDeliveryHeader) != requests[0].header.Get(notifyDeliveryHeader) {
+			t.Fatal("retry has another delivery id")
+		}
+	}
+	deliveries, err := ts.ListDeliveries(ctx, "owner", "repo", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusServiceUnavailable}
+	if len(deliveries) != len(statuses) {
+		t.Fatalf("expected %v deliveries, got %v", len(statuses), len(deliveries))
+	}
+	for i, delivery := range deliveries {
+		if delivery.Status != statuses[i] || delivery.Attempt != len(statuses)-i {
+			t.Fatalf("unexpected delivery %+v", delivery)
+		}
+	}
+}
+
+func TestNotifierRejected(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	server := newNotifierServer(t, http.StatusGone)
+	_, err := ts.SetNotifiers(ctx, "owner", "repo", []*Notifier{
+		{Kind: NotifierSlack, Url: server.URL + "/slack"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.addIssue(t, 1)
+	notifyEvents(t, ts, 0)
+
+	// client errors aren't retried
+	pending, err := ts.ListOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range pending {
+		if op.Kind == OutboxNotify {
+			t.Fatalf("rejected notification is pending: %+v", op)
+		}
+	}
+	deliveries, err := ts.ListDeliveries(ctx, "owner", "repo", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != http.StatusGone || deliveries[0].Error == "" {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+}
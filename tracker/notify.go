@@ -0,0 +1,68 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	InvoicePaid    = "paid"
+	InvoiceExpired = "expired"
+)
+
+var UnknownInvoiceError = fmt.Errorf("unknown invoice")
+
+// InvoiceUpdate is sent to the donation page when the state of its invoice
+// changes.
+type InvoiceUpdate struct {
+	Invoice string `json:"invoice"`
+	State   string `json:"state"`
+	Bounty  int64  `json:"bounty"`
+}
+
+// SubscribeInvoice returns the final update of a donation invoice, invoices
+// that are already paid get their update immediately.
+func (srv *IssueService) SubscribeInvoice(ctx context.Context, id int64, payreq string) (<-chan *InvoiceUpdate, func(), error) {
+	events, cancelEvents := srv.events.Subscribe(&EventFilter{
+		IssueId: id,
+		Types:   []EventType{EventInvoiceSettled, EventInvoiceCanceled},
+	})
+	issue, err := srv.store.Get(ctx, id)
+	if err != nil {
+		cancelEvents()
+		return nil, nil, err
+	}
+	paid, ok := issue.Payments[payreq]
+	if !ok {
+		cancelEvents()
+		return nil, nil, UnknownInvoiceError
+	}
+	updates := make(chan *InvoiceUpdate, 1)
+	if paid {
+		updates <- &InvoiceUpdate{Invoice: payreq, State: InvoicePaid, Bounty: issue.Bounty}
+		return updates, cancelEvents, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case event := <-events:
+				if event.Invoice != payreq {
+					continue
+				}
+				update := &InvoiceUpdate{Invoice: payreq, State: InvoicePaid, Bounty: event.Bounty}
+				if event.Type == EventInvoiceCanceled {
+					update.State = InvoiceExpired
+				}
+				updates <- update
+				return
+			}
+		}
+	}()
+	return updates, func() {
+		cancelEvents()
+		close(done)
+	}, nil
+}
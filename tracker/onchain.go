@@ -0,0 +1,460 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	OnchainPayoutsDisabledError = fmt.Errorf("on-chain payouts are disabled")
+	EscrowError                 = fmt.Errorf("bounty is held in escrow until the issue is closed")
+	errNoWatchedIssues          = fmt.Errorf("no active bounty issues with on-chain addresses")
+	errNoOnchainWallet          = fmt.Errorf("lightning backend has no on-chain wallet")
+
+	onchainPollInterval  = time.Minute
+	onchainRetryInterval = time.Second * 30
+	payoutRetryInterval  = time.Second * 30
+
+	addressParams = []*chaincfg.Params{
+		&chaincfg.MainNetParams,
+		&chaincfg.TestNet3Params,
+		&chaincfg.RegressionNetParams,
+		&chaincfg.SimNetParams,
+	}
+)
+
+// StartOnchainWatchers starts watching the wallets of all benefactor nodes
+// that have active bounty issues with an on-chain address.
+func (srv *IssueService) StartOnchainWatchers(ctx context.Context) error {
+	bountyIssues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, bountyIssue := range bountyIssues {
+		if !bountyIssue.Active || bountyIssue.OnchainAddress == "" {
+			continue
+		}
+		srv.watchOnchain(bountyIssue.LndConnect)
+	}
+	return nil
+}
+
+// AssignOnchainAddress returns the on-chain address of an active bounty. Bounties
+// that were created before on-chain donations, or while the wallet of their
+// node was unavailable, get an address of the benefactor node.
+func (srv *IssueService) AssignOnchainAddress(ctx context.Context, id int64) (string, error) {
+	issue, err := srv.store.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if issue.OnchainAddress != "" || !issue.Active {
+		return issue.OnchainAddress, nil
+	}
+	node, release, err := srv.pool.Get(ctx, issue.LndConnect)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to lightning node %v", err)
+	}
+	defer release()
+	wallet, ok := node.(lightning.OnchainWallet)
+	if !ok {
+		return "", errNoOnchainWallet
+	}
+	address, err := wallet.NewAddress(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err = srv.store.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if issue.OnchainAddress != "" {
+		// assigned by a concurrent request
+		return issue.OnchainAddress, nil
+	}
+	fmt.Printf("assigned on-chain address %v to %v \n", address, issue)
+	issue.OnchainAddress = address
+	err = srv.store.Update(ctx, issue)
+	if err != nil {
+		return "", err
+	}
+	srv.watchOnchain(issue.LndConnect)
+	return address, nil
+}
+
+// CreditOnchainDeposit adds a confirmed on-chain deposit to the bounty. Outpoints
+// that have already been credited are ignored.
+func (srv *IssueService) CreditOnchainDeposit(ctx context.Context, id int64, outpoint string, sats int64) error {
+	issue, err := srv.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	rate := srv.rateSnapshot(ctx, issue)
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err = srv.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if issue.OnchainDeposits == nil {
+		issue.OnchainDeposits = make(map[string]int64)
+	}
+	if _, ok := issue.OnchainDeposits[outpoint]; ok {
+		return nil
+	}
+	fmt.Printf("credited on-chain deposit %v on %v \n", outpoint, issue)
+	issue.OnchainDeposits[outpoint] = sats
+	issue.Bounty += sats
+	issue.TotalPayments += 1
+	srv.recordDonation(issue, outpoint, sats, rate)
+	updateRate(issue, rate)
+	event := newEvent(EventOnchainDeposit, issue)
+	event.Amount = sats
+	event.Txid = strings.Split(outpoint, ":")[0]
+	return srv.commit(ctx, issue, event)
+}
+
+// PayoutOnchain pays out part of a bounty on-chain through the wallet of the
+// benefactor node. This is meant for amounts that are too large to be routed
+// over lightning.
+//
+// The payout is reserved under the service lock, so concurrent payouts and
+// refunds can't spend the same sats, and sent without holding it. Once a
+// transaction was sent the payout is never released again: if it can't be
+// recorded, recording is retried in the background.
+func (srv *IssueService) PayoutOnchain(ctx context.Context, id int64, address string, sats int64) (string, error) {
+	if !srv.cfg.AllowOnchainPayouts {
+		return "", OnchainPayoutsDisabledError
+	}
+	if _, err := addressScript(address); err != nil {
+		return "", err
+	}
+	issue, err := srv.store.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	file := srv.repoFile(ctx, issue.Owner, issue.Repo)
+	if file.Payouts != nil {
+		if file.Payouts.DisableOnchain {
+			return "", OnchainPayoutsDisabledError
+		}
+		if sats < file.Payouts.MinAmount {
+			return "", fmt.Errorf("payouts must be at least %v sats", file.Payouts.MinAmount)
+		}
+	}
+	issue, payout, err := srv.reservePayout(ctx, id, address, sats, file)
+	if err != nil {
+		return "", err
+	}
+	txid, err := srv.sendPayout(ctx, issue, payout)
+	if err != nil {
+		srv.releasePayout(id, payout)
+		return "", err
+	}
+	fmt.Printf("paid out %v on-chain in %v on %v \n", sats, txid, issue)
+	err = srv.recordPayout(ctx, id, payout, txid)
+	if err != nil {
+		fmt.Printf("unable to record payout %v of %v sats on %v, retrying: %v \n", txid, sats, issue, err)
+		go srv.retryRecordPayout(id, payout, txid)
+	}
+	return txid, nil
+}
+
+// reservePayout stores a payout without transaction, which counts against the
+// remaining bounty until it is recorded or released.
+func (srv *IssueService) reservePayout(ctx context.Context, id int64, address string, sats int64, file *RepoFile) (*BountyIssue, *Payout, error) {
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err := srv.store.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	remaining := issue.Bounty - paidOut(issue) - reservedPayouts(issue)
+	if sats <= 0 || sats > remaining {
+		return nil, nil, fmt.Errorf("invalid payout amount %v, remaining bounty is %v", sats, remaining)
+	}
+	if file.Escrow == EscrowUntilClosed && issue.Active {
+		return nil, nil, EscrowError
+	}
+	payout := &Payout{
+		Amount:    sats,
+		Address:   address,
+		Timestamp: time.Now().Unix(),
+	}
+	issue.Payouts = append(issue.Payouts, payout)
+	err = srv.store.Update(ctx, issue)
+	if err != nil {
+		return nil, nil, err
+	}
+	return issue, payout, nil
+}
+
+func (srv *IssueService) sendPayout(ctx context.Context, issue *BountyIssue, payout *Payout) (string, error) {
+	node, release, err := srv.pool.Get(ctx, issue.LndConnect)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to lightning node %v", err)
+	}
+	defer release()
+	wallet, ok := node.(lightning.OnchainWallet)
+	if !ok {
+		return "", errNoOnchainWallet
+	}
+	return wallet.SendCoins(ctx, payout.Address, payout.Amount, int32(srv.cfg.OnchainConfirmations), fmt.Sprintf("Bounty payout for %s", issue.Url))
+}
+
+// releasePayout removes the reservation of a payout that wasn't sent. If that
+// fails the sats stay reserved, which is safe but needs to be fixed by hand.
+func (srv *IssueService) releasePayout(id int64, payout *Payout) {
+	ctx := context.Background()
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err := srv.store.Get(ctx, id)
+	if err == nil {
+		for i, reserved := range issue.Payouts {
+			if reserved.Txid == "" && samePayout(reserved, payout) {
+				issue.Payouts = append(issue.Payouts[:i], issue.Payouts[i+1:]...)
+				err = srv.store.Update(ctx, issue)
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("unable to release reserved payout of %v sats on issue %v: %v \n", payout.Amount, id, err)
+	}
+}
+
+// recordPayout sets the transaction of a reserved payout and commits the
+// payout event.
+func (srv *IssueService) recordPayout(ctx context.Context, id int64, payout *Payout, txid string) error {
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err := srv.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	var recorded *Payout
+	for _, reserved := range issue.Payouts {
+		if reserved.Txid == "" && samePayout(reserved, payout) {
+			recorded = reserved
+			break
+		}
+	}
+	if recorded == nil {
+		// the reservation is gone, the payout was sent nonetheless
+		recorded = &Payout{Amount: payout.Amount, Address: payout.Address, Timestamp: payout.Timestamp}
+		issue.Payouts = append(issue.Payouts, recorded)
+	}
+	recorded.Txid = txid
+	event := newEvent(EventPayout, issue)
+	event.Amount = recorded.Amount
+	event.Txid = txid
+	return srv.commit(ctx, issue, event)
+}
+
+func (srv *IssueService) retryRecordPayout(id int64, payout *Payout, txid string) {
+	for {
+		time.Sleep(payoutRetryInterval)
+		err := srv.recordPayout(context.Background(), id, payout, txid)
+		if err == nil {
+			return
+		}
+		fmt.Printf("unable to record payout %v of %v sats on issue %v, retrying: %v \n", txid, payout.Amount, id, err)
+	}
+}
+
+func samePayout(a, b *Payout) bool {
+	return a.Amount == b.Amount && a.Address == b.Address && a.Timestamp == b.Timestamp
+}
+
+// watchOnchain starts a wallet watcher for the node, if there isn't one already.
+func (srv *IssueService) watchOnchain(lndConnect string) {
+	srv.watcherMtx.Lock()
+	defer srv.watcherMtx.Unlock()
+	if srv.onchainWatchers[lndConnect] {
+		return
+	}
+	srv.onchainWatchers[lndConnect] = true
+	go srv.runOnchainWatcher(lndConnect)
+}
+
+func (srv *IssueService) runOnchainWatcher(lndConnect string) {
+	for {
+		err := srv.subscribeOnchain(lndConnect)
+		if err == errNoWatchedIssues || err == errNoOnchainWallet {
+			srv.watcherMtx.Lock()
+			delete(srv.onchainWatchers, lndConnect)
+			srv.watcherMtx.Unlock()
+			return
+		}
+		fmt.Printf("on-chain watcher stopped: %v \n", err)
+		time.Sleep(onchainRetryInterval)
+	}
+}
+
+// subscribeOnchain credits confirmed deposits whenever the wallet of the node
+// sees a transaction. As lnd only notifies about the first confirmation, the
+// wallet is additionally polled until the confirmation target is reached.
+func (srv *IssueService) subscribeOnchain(lndConnect string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, release, err := srv.pool.Get(ctx, lndConnect)
+	if err != nil {
+		return fmt.Errorf("unable to connect to lightning node %v", err)
+	}
+	defer release()
+	wallet, ok := node.(lightning.OnchainWallet)
+	if !ok {
+		return errNoOnchainWallet
+	}
+	txSub, err := wallet.SubscribeTransactions(ctx)
+	if err != nil {
+		srv.reportNodeError(ctx, lndConnect, err)
+		return err
+	}
+	txChan := make(chan error)
+	go func() {
+		for {
+			_, err := txSub.Recv()
+			select {
+			case txChan <- err:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(onchainPollInterval)
+	defer ticker.Stop()
+	for {
+		err = srv.creditOnchainDeposits(ctx, lndConnect, wallet)
+		if err != nil {
+			return err
+		}
+		select {
+		case err = <-txChan:
+			if err != nil {
+				srv.reportNodeError(ctx, lndConnect, err)
+				return err
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func (srv *IssueService) creditOnchainDeposits(ctx context.Context, lndConnect string, wallet lightning.OnchainWallet) error {
+	bountyIssues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	var active bool
+	scripts := make(map[string]int64)
+	for _, bountyIssue := range bountyIssues {
+		if bountyIssue.LndConnect != lndConnect || bountyIssue.OnchainAddress == "" {
+			continue
+		}
+		script, err := addressScript(bountyIssue.OnchainAddress)
+		if err != nil {
+			fmt.Printf("invalid on-chain address on %v: %v \n", bountyIssue, err)
+			continue
+		}
+		scripts[hex.EncodeToString(script)] = bountyIssue.Id
+		active = active || bountyIssue.Active
+	}
+	if !active {
+		return errNoWatchedIssues
+	}
+	txs, err := wallet.GetTransactions(ctx)
+	if err != nil {
+		srv.reportNodeError(ctx, lndConnect, err)
+		return err
+	}
+	for _, tx := range txs {
+		if tx.NumConfirmations < int32(srv.cfg.OnchainConfirmations) {
+			continue
+		}
+		msgTx := &wire.MsgTx{}
+		err = msgTx.Deserialize(bytes.NewReader(tx.RawTx))
+		if err != nil {
+			return err
+		}
+		for i, txOut := range msgTx.TxOut {
+			id, ok := scripts[hex.EncodeToString(txOut.PkScript)]
+			if !ok {
+				continue
+			}
+			outpoint := tx.TxHash + ":" + strconv.Itoa(i)
+			err = srv.CreditOnchainDeposit(ctx, id, outpoint, txOut.Value)
+			if err != nil {
+				fmt.Printf("unable to credit on-chain deposit %v: %v \n", outpoint, err)
+			}
+		}
+	}
+	return nil
+}
+
+// addressScript returns the output script of an address of any known network.
+func addressScript(address string) ([]byte, error) {
+	for _, params := range addressParams {
+		addr, err := btcutil.DecodeAddress(address, params)
+		if err != nil || !addr.IsForNet(params) {
+			continue
+		}
+		return txscript.PayToAddrScript(addr)
+	}
+	return nil, fmt.Errorf("invalid address %v", address)
+}
+
+// bip21Uri returns a bitcoin uri for the address, with the invoice as
+// lightning fallback.
+func bip21Uri(address string, sats int64, invoice string) string {
+	uri := "bitcoin:" + address + "?amount=" + strconv.FormatFloat(btcutil.Amount(sats).ToBTC(), 'f', -1, 64)
+	if invoice != "" {
+		uri += "&lightning=" + invoice
+	}
+	return uri
+}
+
+// paidOut returns the amount of the sent payouts of an issue.
+func paidOut(issue *BountyIssue) int64 {
+	var sats int64
+	for _, payout := range sentPayouts(issue) {
+		sats += payout.Amount
+	}
+	return sats
+}
+
+// reservedPayouts returns the amount of payouts that are being sent.
+func reservedPayouts(issue *BountyIssue) int64 {
+	var sats int64
+	for _, payout := range issue.Payouts {
+		if payout.Txid == "" {
+			sats += payout.Amount
+		}
+	}
+	return sats
+}
+
+// sentPayouts returns the payouts of an issue that have a transaction.
+func sentPayouts(issue *BountyIssue) []*Payout {
+	var payouts []*Payout
+	for _, payout := range issue.Payouts {
+		if payout.Txid != "" {
+			payouts = append(payouts, payout)
+		}
+	}
+	return payouts
+}
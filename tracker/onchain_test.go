@@ -0,0 +1,350 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	config "github.com/sputn1ck/github-bounty"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreditOnchainDeposit(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	if issue.OnchainAddress == "" {
+		t.Fatal("bounty has no on-chain address")
+	}
+
+	err := ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 50000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the watcher sees the same outpoint again on every poll
+	err = ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 50000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue = ts.issue(t, issue.Id)
+	if issue.Bounty != 50000 || issue.TotalPayments != 1 {
+		t.Fatalf("expected 50000 sats in 1 payment, got %v sats in %v payments", issue.Bounty, issue.TotalPayments)
+	}
+	donation := issue.Donations["txid:0"]
+	if donation == nil || donation.Amount != 50000 || donation.Rate == nil {
+		t.Fatalf("deposit wasn't recorded as donation: %+v", donation)
+	}
+	events, err := ts.store.EventsSince(ctx, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deposits int
+	for _, event := range events {
+		if event.Type != EventOnchainDeposit {
+			continue
+		}
+		deposits++
+		if event.Amount != 50000 || event.Txid != "txid" || event.Bounty != 50000 {
+			t.Fatalf("unexpected deposit event %+v", event)
+		}
+	}
+	if deposits != 1 {
+		t.Fatalf("expected 1 deposit event, got %v", deposits)
+	}
+}
+
+func TestCreditOnchainDepositsConfirmations(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	other := ts.addIssue(t, 2)
+
+	outpoint := ts.node.deposit(t, issue.OnchainAddress, 20000, 1)
+	err := ts.creditOnchainDeposits(ctx, testNodeUri, ts.node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounty := ts.issue(t, issue.Id).Bounty; bounty != 0 {
+		t.Fatalf("unconfirmed deposit was credited, bounty is %v", bounty)
+	}
+
+	ts.node.Lock()
+	ts.node.txs[0].NumConfirmations = 3
+	ts.node.Unlock()
+	err = ts.creditOnchainDeposits(ctx, testNodeUri, ts.node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue = ts.issue(t, issue.Id)
+	if issue.Bounty != 20000 {
+		t.Fatalf("expected confirmed deposit of 20000 sats, bounty is %v", issue.Bounty)
+	}
+	if _, ok := issue.OnchainDeposits[outpoint]; !ok {
+		t.Fatalf("deposit wasn't credited to output %v: %v", outpoint, issue.OnchainDeposits)
+	}
+	if bounty := ts.issue(t, other.Id).Bounty; bounty != 0 {
+		t.Fatalf("deposit was credited to another bounty with %v sats", bounty)
+	}
+}
+
+func TestPayoutOnchain(t *testing.T) {
+	ctx := context.Background()
+	address, err := newFakeNode(t).NewAddress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	err = ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.PayoutOnchain(ctx, issue.Id, address, 1000)
+	if err != OnchainPayoutsDisabledError {
+		t.Fatalf("expected payouts to be disabled, got %v", err)
+	}
+
+	ts = newTestService(t, func(cfg *config.Config) { cfg.AllowOnchainPayouts = true })
+	issue = ts.addIssue(t, 1)
+	err = ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		address string
+		sats    int64
+	}{
+		{"not an address", 1000},
+		{address, 0},
+		{address, -1},
+		{address, 100001},
+	} {
+		_, err = ts.PayoutOnchain(ctx, issue.Id, test.address, test.sats)
+		if err == nil {
+			t.Fatalf("payout of %v sats to %v succeeded", test.sats, test.address)
+		}
+	}
+
+	txid, err := ts.PayoutOnchain(ctx, issue.Id, address, 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue = ts.issue(t, issue.Id)
+	if len(issue.Payouts) != 1 || issue.Payouts[0].Txid != txid || issue.Payouts[0].Amount != 60000 {
+		t.Fatalf("payout wasn't recorded: %+v", issue.Payouts)
+	}
+	if len(ts.node.sent) != 1 || ts.node.sent[0].Address != address || ts.node.sent[0].Sats != 60000 {
+		t.Fatalf("node didn't send the payout: %+v", ts.node.sent)
+	}
+	// only 40000 sats remain
+	_, err = ts.PayoutOnchain(ctx, issue.Id, address, 40001)
+	if err == nil {
+		t.Fatal("payout exceeding the remaining bounty succeeded")
+	}
+
+	// failed transactions aren't recorded
+	ts.node.fail("SendCoins", fmt.Errorf("insufficient funds"))
+	_, err = ts.PayoutOnchain(ctx, issue.Id, address, 40000)
+	if err == nil {
+		t.Fatal("failed payout succeeded")
+	}
+	if payouts := ts.issue(t, issue.Id).Payouts; len(payouts) != 1 {
+		t.Fatalf("failed payout was recorded: %+v", payouts)
+	}
+}
+
+func TestPayoutOnchainEscrow(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t, func(cfg *config.Config) { cfg.AllowOnchainPayouts = true })
+	issue := ts.addIssue(t, 1)
+	err := ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := ts.repoStore.Get(ctx, "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.File = &RepoFile{Escrow: EscrowUntilClosed}
+	err = ts.repoStore.Put(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := ts.node.NewAddress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.PayoutOnchain(ctx, issue.Id, address, 1000)
+	if err != EscrowError {
+		t.Fatalf("expected escrow error, got %v", err)
+	}
+	err = ts.CloseIssue(ctx, issue.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.PayoutOnchain(ctx, issue.Id, address, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAssignOnchainAddress(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	// the wallet is unavailable when the bounty is created
+	ts.node.fail("NewAddress", fmt.Errorf("wallet locked"))
+	issue := ts.addIssue(t, 1)
+	if issue.OnchainAddress != "" {
+		t.Fatalf("expected no address, got %v", issue.OnchainAddress)
+	}
+
+	address, err := ts.AssignOnchainAddress(ctx, issue.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address == "" || ts.issue(t, issue.Id).OnchainAddress != address {
+		t.Fatalf("address %v wasn't stored", address)
+	}
+	again, err := ts.AssignOnchainAddress(ctx, issue.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != address {
+		t.Fatalf("bounty got a second address %v", again)
+	}
+}
+
+func TestPayoutOnchainSendsUnlocked(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t, func(cfg *config.Config) { cfg.AllowOnchainPayouts = true })
+	issue := ts.addIssue(t, 1)
+	err := ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := ts.node.NewAddress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := make(chan struct{})
+	ts.node.Lock()
+	ts.node.sendGate = gate
+	ts.node.Unlock()
+
+	type result struct {
+		txid string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		txid, err := ts.PayoutOnchain(ctx, issue.Id, address, 60000)
+		done <- result{txid, err}
+	}()
+	eventually(t, func() bool {
+		return reservedPayouts(ts.issue(t, issue.Id)) == 60000
+	}, "payout wasn't reserved")
+
+	// the service isn't blocked by the transaction
+	locked := make(chan struct{})
+	go func() {
+		ts.Lock()
+		ts.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("service is locked while the payout is sent")
+	}
+	// the reserved sats can't be paid out or refunded again
+	_, err = ts.PayoutOnchain(ctx, issue.Id, address, 40001)
+	if err == nil {
+		t.Fatal("payout of reserved sats succeeded")
+	}
+	_, err = ts.RefundDonation(ctx, issue.Id, "txid:0", "")
+	if err == nil {
+		t.Fatal("refund of reserved sats succeeded")
+	}
+	if data := ts.commentData(ts.issue(t, issue.Id), nil); len(data.Payouts) != 0 || data.PaidOut != 0 {
+		t.Fatalf("reserved payout is shown as paid out: %+v", data.Payouts)
+	}
+
+	close(gate)
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	issue = ts.issue(t, issue.Id)
+	if reservedPayouts(issue) != 0 || paidOut(issue) != 60000 || issue.Payouts[0].Txid != res.txid {
+		t.Fatalf("payout wasn't recorded: %+v", issue.Payouts)
+	}
+}
+
+// failingCommitStore fails the next commits.
+type failingCommitStore struct {
+	IssueStore
+	failures int
+	sync.Mutex
+}
+
+func (s *failingCommitStore) Commit(ctx context.Context, issue *BountyIssue, events ...*Event) error {
+	s.Lock()
+	if s.failures > 0 {
+		s.failures--
+		s.Unlock()
+		return fmt.Errorf("disk full")
+	}
+	s.Unlock()
+	return s.IssueStore.Commit(ctx, issue, events...)
+}
+
+func TestPayoutOnchainRecordRetry(t *testing.T) {
+	interval := payoutRetryInterval
+	payoutRetryInterval = time.Millisecond * 10
+	defer func() { payoutRetryInterval = interval }()
+
+	ctx := context.Background()
+	ts := newTestService(t, func(cfg *config.Config) { cfg.AllowOnchainPayouts = true })
+	store := &failingCommitStore{IssueStore: ts.store}
+	ts.IssueService.store = store
+	issue := ts.addIssue(t, 1)
+	err := ts.CreditOnchainDeposit(ctx, issue.Id, "txid:0", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := ts.node.NewAddress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Lock()
+	store.failures = 3
+	store.Unlock()
+
+	// the payout was sent, so it succeeds although it can't be recorded yet
+	txid, err := ts.PayoutOnchain(ctx, issue.Id, address, 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.PayoutOnchain(ctx, issue.Id, address, 40001)
+	if err == nil {
+		t.Fatal("payout of unrecorded sats succeeded")
+	}
+	eventually(t, func() bool {
+		ts.Lock()
+		defer ts.Unlock()
+		issue, err := ts.store.Get(ctx, issue.Id)
+		return err == nil && paidOut(issue) == 60000
+	}, "payout wasn't recorded")
+	issue = ts.issue(t, issue.Id)
+	if len(issue.Payouts) != 1 || issue.Payouts[0].Txid != txid || reservedPayouts(issue) != 0 {
+		t.Fatalf("unexpected payouts %+v", issue.Payouts)
+	}
+	events := lastEvents(t, ts, 0)
+	if last := events[len(events)-1]; last.Type != EventPayout || last.Txid != txid {
+		t.Fatalf("expected the payout event, got %+v", last)
+	}
+	if len(ts.node.sent) != 1 {
+		t.Fatalf("payout was sent %v times", len(ts.node.sent))
+	}
+}
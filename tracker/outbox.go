@@ -0,0 +1,410 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type OutboxKind string
+
+const (
+	// brings the bounty comment up to date, creating it if necessary
+	OutboxSyncComment OutboxKind = "sync_comment"
+	// tells the maintainers about a node outage
+	OutboxOutageComment OutboxKind = "outage_comment"
+	// warns about an invalid repository file on an issue or commit
+	OutboxFileWarning OutboxKind = "file_warning"
+	// creates the bounty labels of a repository
+	OutboxCreateLabels OutboxKind = "create_labels"
+	// delivers an event to a notifier of a repository, see Notifier
+	OutboxNotify OutboxKind = "notify"
+	// sends an email notification
+	OutboxEmail OutboxKind = "email"
+	// publishes the current state of a bounty to the nostr relays
+	OutboxNostrBounty OutboxKind = "nostr_bounty"
+	// publishes the nostr profile of the bot
+	OutboxNostrProfile OutboxKind = "nostr_profile"
+	// publishes the receipt of a settled zap
+	OutboxZapReceipt OutboxKind = "zap_receipt"
+	// signs the receipt of a settled donation with the benefactor node
+	OutboxReceipt OutboxKind = "receipt"
+	// publishes the head of the ledger on nostr
+	OutboxLedgerAnchor OutboxKind = "ledger_anchor"
+
+	outboxBackoff      = time.Second * 5
+	outboxMaxBackoff   = time.Hour
+	outboxMaxAttempts  = 20
+	outboxPollInterval = time.Minute
+)
+
+// OutboxOp is a pending GitHub operation. Operations with the same key are
+// coalesced, so a comment is edited once no matter how many updates piled up
+// while GitHub was unavailable.
+type OutboxOp struct {
+	Key     string
+	Kind    OutboxKind
+	IssueId int64
+	// target of operations that aren't bound to a bounty issue
+	Owner  string
+	Repo   string
+	Number int64
+	Commit string
+	// notifier and event of notifications, ledger entry of anchors
+	Notifier string
+	Seq      uint64
+	// rendered email notifications
+	Recipient string
+	Subject   string
+	Body      string
+	// payment request of zap and donation receipts
+	Invoice string
+	// incremented whenever the operation is enqueued again
+	Version     int64
+	Attempts    int
+	NextAttempt int64
+	LastError   string
+	CreatedAt   int64
+}
+
+type OutboxStore interface {
+	// Enqueue adds the operation or bumps the version of a pending operation
+	// with the same key.
+	Enqueue(context.Context, *OutboxOp) error
+	// Complete removes the operation if it wasn't enqueued again since the
+	// given version.
+	Complete(ctx context.Context, key string, version int64) error
+	// Reschedule stores the attempts and next attempt of an operation.
+	Reschedule(context.Context, *OutboxOp) error
+	Delete(ctx context.Context, key string) error
+	ListAll(ctx context.Context) ([]*OutboxOp, error)
+	// DeadLetter moves an operation that was given up on out of the outbox.
+	DeadLetter(context.Context, *OutboxOp) error
+	// Revive moves a dead operation back into the outbox, due at the given
+	// time.
+	Revive(ctx context.Context, key string, now int64) error
+	ListDead(ctx context.Context) ([]*OutboxOp, error)
+}
+
+// outboxKinds are the kinds of operations, each is executed by its own
+// worker so slow operations of one kind don't hold up the others.
+var outboxKinds = []OutboxKind{
+	OutboxSyncComment, OutboxOutageComment, OutboxFileWarning, OutboxCreateLabels, OutboxNotify, OutboxEmail,
+	OutboxNostrBounty, OutboxNostrProfile, OutboxZapReceipt, OutboxReceipt, OutboxLedgerAnchor,
+}
+
+// outbox holds the state of the outbox workers.
+type outbox struct {
+	wake        map[OutboxKind]chan struct{}
+	pausedUntil time.Time
+	sync.Mutex
+}
+
+func newOutbox() *outbox {
+	wake := make(map[OutboxKind]chan struct{})
+	for _, kind := range outboxKinds {
+		wake[kind] = make(chan struct{}, 1)
+	}
+	return &outbox{wake: wake}
+}
+
+// enqueueComment schedules a sync of the bounty comment of an issue.
+func (srv *IssueService) enqueueComment(ctx context.Context, issueId int64) error {
+	return srv.enqueue(ctx, &OutboxOp{
+		Key:     "comment/" + strconv.FormatInt(issueId, 10),
+		Kind:    OutboxSyncComment,
+		IssueId: issueId,
+	})
+}
+
+func (srv *IssueService) enqueue(ctx context.Context, op *OutboxOp) error {
+	now := time.Now().Unix()
+	op.CreatedAt = now
+	op.NextAttempt = now
+	err := srv.outboxStore.Enqueue(ctx, op)
+	if err != nil {
+		return err
+	}
+	srv.outbox.notify(op.Kind)
+	return nil
+}
+
+// notify wakes the worker of a kind of operations.
+func (o *outbox) notify(kind OutboxKind) {
+	select {
+	case o.wake[kind] <- struct{}{}:
+	default:
+	}
+}
+
+// ListOutbox returns the pending GitHub operations.
+func (srv *IssueService) ListOutbox(ctx context.Context) ([]*OutboxOp, error) {
+	ops, err := srv.outboxStore.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].NextAttempt < ops[j].NextAttempt
+	})
+	return ops, nil
+}
+
+// ListDeadOutbox returns the operations that were given up on.
+func (srv *IssueService) ListDeadOutbox(ctx context.Context) ([]*OutboxOp, error) {
+	ops, err := srv.outboxStore.ListDead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ops == nil {
+		ops = []*OutboxOp{}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].CreatedAt < ops[j].CreatedAt
+	})
+	return ops, nil
+}
+
+// ReviveOutbox queues dead operations again, all of them if no key is given.
+func (srv *IssueService) ReviveOutbox(ctx context.Context, key string) error {
+	keys := []string{key}
+	if key == "" {
+		ops, err := srv.outboxStore.ListDead(ctx)
+		if err != nil {
+			return err
+		}
+		keys = keys[:0]
+		for _, op := range ops {
+			keys = append(keys, op.Key)
+		}
+	}
+	for _, key := range keys {
+		err := srv.outboxStore.Revive(ctx, key, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+	}
+	for _, kind := range outboxKinds {
+		srv.outbox.notify(kind)
+	}
+	return nil
+}
+
+// runOutbox starts a worker per kind of operations that executes due
+// operations until the context is canceled.
+func (srv *IssueService) runOutbox(ctx context.Context) {
+	for _, kind := range outboxKinds {
+		go srv.runOutboxWorker(ctx, kind)
+	}
+}
+
+func (srv *IssueService) runOutboxWorker(ctx context.Context, kind OutboxKind) {
+	for {
+		wait := srv.processOutbox(ctx, kind)
+		select {
+		case <-ctx.Done():
+			return
+		case <-srv.outbox.wake[kind]:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// processOutbox executes all due operations of a kind and returns the time
+// until the next one is due.
+func (srv *IssueService) processOutbox(ctx context.Context, kind OutboxKind) time.Duration {
+	wait := outboxPollInterval
+	ops, err := srv.ListOutbox(ctx)
+	if err != nil {
+		fmt.Printf("unable to list outbox: %v \n", err)
+		return wait
+	}
+	for _, op := range ops {
+		if ctx.Err() != nil {
+			return wait
+		}
+		if op.Kind != kind {
+			continue
+		}
+		srv.outbox.Lock()
+		pausedUntil := srv.outbox.pausedUntil
+		srv.outbox.Unlock()
+		if until := time.Until(pausedUntil); until > 0 && isGithubOp(op.Kind) {
+			if until < wait {
+				wait = until
+			}
+			continue
+		}
+		if until := time.Until(time.Unix(op.NextAttempt, 0)); until > 0 {
+			if until < wait {
+				wait = until
+			}
+			continue
+		}
+		err := srv.executeOutboxOp(ctx, op)
+		if err == nil {
+			err = srv.outboxStore.Complete(ctx, op.Key, op.Version)
+			if err != nil {
+				fmt.Printf("unable to complete outbox operation %v: %v \n", op.Key, err)
+			}
+			continue
+		}
+		srv.rescheduleOutboxOp(ctx, op, err)
+	}
+	return wait
+}
+
+func (srv *IssueService) rescheduleOutboxOp(ctx context.Context, op *OutboxOp, opErr error) {
+	op.Attempts++
+	op.LastError = opErr.Error()
+	if op.Attempts >= outboxMaxAttempts {
+		fmt.Printf("giving up on outbox operation %v after %v attempts: %v \n", op.Key, op.Attempts, opErr)
+		err := srv.outboxStore.DeadLetter(ctx, op)
+		if err != nil {
+			fmt.Printf("unable to dead letter outbox operation %v: %v \n", op.Key, err)
+		}
+		return
+	}
+	backoff := outboxBackoff << uint(op.Attempts-1)
+	if backoff > outboxMaxBackoff || backoff <= 0 {
+		backoff = outboxMaxBackoff
+	}
+	next := time.Now().Add(backoff)
+	if reset, ok := rateLimitReset(opErr); ok {
+		// every operation hits the same rate limit, so the whole outbox
+		// waits for the reset
+		next = reset
+		srv.outbox.Lock()
+		srv.outbox.pausedUntil = reset
+		srv.outbox.Unlock()
+		fmt.Printf("github rate limit reached, pausing outbox until %v \n", reset)
+	}
+	op.NextAttempt = next.Unix()
+	fmt.Printf("outbox operation %v failed, retrying at %v: %v \n", op.Key, next, opErr)
+	err := srv.outboxStore.Reschedule(ctx, op)
+	if err != nil {
+		fmt.Printf("unable to reschedule outbox operation %v: %v \n", op.Key, err)
+	}
+}
+
+func (srv *IssueService) executeOutboxOp(ctx context.Context, op *OutboxOp) error {
+	switch op.Kind {
+	case OutboxSyncComment:
+		return srv.syncComment(ctx, op.IssueId)
+	case OutboxOutageComment:
+		return srv.outageComment(ctx, op.IssueId)
+	case OutboxFileWarning:
+		return srv.fileWarning(ctx, op)
+	case OutboxCreateLabels:
+		return srv.createLabels(ctx, op)
+	case OutboxNotify:
+		return srv.notify(ctx, op)
+	case OutboxEmail:
+		return srv.sendEmail(ctx, op)
+	case OutboxNostrBounty:
+		return srv.publishBounty(ctx, op.IssueId)
+	case OutboxNostrProfile:
+		return srv.publishProfile(ctx)
+	case OutboxZapReceipt:
+		return srv.publishZapReceipt(ctx, op)
+	case OutboxReceipt:
+		return srv.signReceipt(ctx, op)
+	case OutboxLedgerAnchor:
+		return srv.publishLedgerAnchor(ctx, op)
+	default:
+		return fmt.Errorf("unknown outbox operation %v", op.Kind)
+	}
+}
+
+// isGithubOp returns whether an operation counts against the github rate
+// limit.
+func isGithubOp(kind OutboxKind) bool {
+	switch kind {
+	case OutboxNotify, OutboxEmail, OutboxNostrBounty, OutboxNostrProfile, OutboxZapReceipt, OutboxReceipt, OutboxLedgerAnchor:
+		return false
+	}
+	return true
+}
+
+// syncComment brings the bounty comment up to date with the current state of
+// the issue, so retries never show outdated amounts. Comments that were
+// deleted are posted again.
+func (srv *IssueService) syncComment(ctx context.Context, issueId int64) error {
+	issue, err := srv.store.Get(ctx, issueId)
+	if err != nil {
+		return err
+	}
+	kind := CommentActive
+	if !issue.Active {
+		kind = CommentClosed
+	}
+	body, err := srv.renderComment(ctx, issue, kind)
+	if err != nil {
+		return err
+	}
+	marker := commentMarker(issueId)
+	body += "\n\n" + marker
+	if issue.CommentId == 0 {
+		// an earlier attempt may have posted the comment without storing its
+		// id, edit that one instead of posting a duplicate
+		commentId, err := srv.ghClient.FindComment(ctx, issue, marker)
+		if err != nil {
+			return err
+		}
+		if commentId != 0 {
+			err = srv.setCommentId(ctx, issueId, commentId)
+			if err != nil {
+				return err
+			}
+			issue.CommentId = commentId
+		}
+	}
+	if issue.CommentId != 0 {
+		err = srv.ghClient.EditComment(ctx, issue, body)
+		if !isNotFound(err) {
+			return err
+		}
+		fmt.Printf("bounty comment %v on %v was deleted, posting it again \n", issue.CommentId, issue.Url)
+	}
+	commentId, err := srv.ghClient.AddComment(ctx, issue, body)
+	if err != nil {
+		return err
+	}
+	return srv.setCommentId(ctx, issueId, commentId)
+}
+
+// commentMarker identifies the bounty comment of an issue, it's hidden when
+// GitHub renders the comment.
+func commentMarker(issueId int64) string {
+	return fmt.Sprintf("<!-- github-bounty:%v -->", issueId)
+}
+
+func (srv *IssueService) setCommentId(ctx context.Context, issueId int64, commentId int64) error {
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err := srv.store.Get(ctx, issueId)
+	if err != nil {
+		return err
+	}
+	issue.CommentId = commentId
+	return srv.store.Update(ctx, issue)
+}
+
+func (srv *IssueService) outageComment(ctx context.Context, issueId int64) error {
+	issue, err := srv.store.Get(ctx, issueId)
+	if err != nil {
+		return err
+	}
+	if issue.NodeUnreachableSince == 0 {
+		// the node came back before the notification went out
+		return nil
+	}
+	body, err := srv.renderComment(ctx, issue, CommentOutage)
+	if err != nil {
+		return err
+	}
+	_, err = srv.ghClient.AddComment(ctx, issue, body)
+	return err
+}
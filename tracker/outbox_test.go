@@ -0,0 +1,130 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSyncCommentFindsPostedComment(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	if issue.CommentId != 0 {
+		t.Fatalf("comment was posted without the outbox: %v", issue.CommentId)
+	}
+
+	// an earlier attempt posted the comment but couldn't store its id
+	commentId, err := ts.github.AddComment(ctx, issue, "stale\n\n"+commentMarker(issue.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.syncComment(ctx, issue.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := ts.github.commentCount(); count != 1 {
+		t.Fatalf("expected the comment to be edited, got %v comments", count)
+	}
+	if id := ts.issue(t, issue.Id).CommentId; id != commentId {
+		t.Fatalf("expected comment id %v, got %v", commentId, id)
+	}
+	ts.github.Lock()
+	body := ts.github.comments[commentId]
+	ts.github.Unlock()
+	if strings.HasPrefix(body, "stale") || !strings.Contains(body, commentMarker(issue.Id)) {
+		t.Fatalf("comment wasn't brought up to date: %v", body)
+	}
+
+	// comments of other issues aren't picked up
+	other := ts.addIssue(t, 2)
+	err = ts.syncComment(ctx, other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := ts.github.commentCount(); count != 2 {
+		t.Fatalf("expected a new comment, got %v comments", count)
+	}
+	if id := ts.issue(t, other.Id).CommentId; id == commentId || id == 0 {
+		t.Fatalf("unexpected comment id %v", id)
+	}
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+
+	// the issue doesn't exist yet, so syncing its comment fails
+	err := ts.outboxStore.Enqueue(ctx, &OutboxOp{
+		Key:      "comment/1",
+		Kind:     OutboxSyncComment,
+		IssueId:  1,
+		Attempts: outboxMaxAttempts - 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.processOutbox(ctx, OutboxSyncComment)
+	pending, err := ts.ListOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("operation is still pending: %+v", pending[0])
+	}
+	dead, err := ts.ListDeadOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Key != "comment/1" || dead[0].Attempts != outboxMaxAttempts || dead[0].LastError == "" {
+		t.Fatalf("operation wasn't dead lettered: %+v", dead)
+	}
+
+	if err := ts.ReviveOutbox(ctx, "comment/2"); err != ErrDoesNotExist {
+		t.Fatalf("expected unknown operation, got %v", err)
+	}
+	ts.addIssue(t, 1)
+	err = ts.ReviveOutbox(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, err = ts.ListDeadOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = ts.ListOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 0 || len(pending) != 1 || pending[0].Attempts != 0 {
+		t.Fatalf("operation wasn't revived: dead %+v, pending %+v", dead, pending)
+	}
+	ts.processOutbox(ctx, OutboxSyncComment)
+	if ts.issue(t, 1).CommentId == 0 {
+		t.Fatal("revived operation wasn't executed")
+	}
+}
+
+func TestOutboxKindQueues(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	ts.addIssue(t, 1)
+	err := ts.enqueueComment(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another kind's worker leaves the comment alone
+	ts.processOutbox(ctx, OutboxEmail)
+	if ts.issue(t, 1).CommentId != 0 {
+		t.Fatal("comment was synced by the email worker")
+	}
+	ts.processOutbox(ctx, OutboxSyncComment)
+	if ts.issue(t, 1).CommentId == 0 {
+		t.Fatal("comment wasn't synced")
+	}
+	for _, kind := range outboxKinds {
+		if _, ok := ts.outbox.wake[kind]; !ok {
+			t.Fatalf("no queue for %v", kind)
+		}
+	}
+}
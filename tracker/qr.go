@@ -0,0 +1,105 @@
+package tracker
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/skip2/go-qrcode"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	qrPath = "/qr"
+
+	formatkey = "format"
+	sizekey   = "size"
+
+	qrSize    = 256
+	qrMinSize = 64
+	qrMaxSize = 1024
+)
+
+// handleQr renders a QR code as png or svg, so comments can embed it as an
+// image. The code holds the donation page of a bounty, or one of its invoices
+// if the invoice is given.
+func (wh *WebhookHandler) handleQr(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	issueId, err := strconv.ParseInt(query.Get(issueidkey), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input, require %s", issueidkey))
+		return
+	}
+	size := qrSize
+	if query.Get(sizekey) != "" {
+		size, err = strconv.Atoi(query.Get(sizekey))
+		if err != nil || size < qrMinSize || size > qrMaxSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s, must be between %d and %d", sizekey, qrMinSize, qrMaxSize))
+			return
+		}
+	}
+	bountyIssue, err := wh.is.GetBountyIssue(r.Context(), issueId)
+	if err == ErrDoesNotExist {
+		writeError(w, http.StatusNotFound, "bounty not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	content := wh.is.donateUrl(issueId)
+	if invoice := query.Get(invoicekey); invoice != "" {
+		// only invoices of the bounty are rendered, the endpoint is no
+		// general purpose QR code generator
+		if _, ok := bountyIssue.PaymentHashes[invoice]; !ok {
+			writeError(w, http.StatusNotFound, "invoice not found")
+			return
+		}
+		content = "lightning:" + strings.ToUpper(invoice)
+	}
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	switch query.Get(formatkey) {
+	case "", "png":
+		png, err := code.PNG(size)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("something went wrong %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(qrSvg(code.Bitmap(), size))
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s, use png or svg", formatkey))
+	}
+}
+
+// qrSvg renders the modules of a QR code as svg, each row is a single path
+// so the image stays small.
+func qrSvg(bitmap [][]bool, size int) []byte {
+	svg := &bytes.Buffer{}
+	fmt.Fprintf(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(svg, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(svg, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	svg.WriteString(`"/></svg>`)
+	return svg.Bytes()
+}
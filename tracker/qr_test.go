@@ -0,0 +1,95 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestQrSvg(t *testing.T) {
+	bitmap := [][]bool{
+		{true, true, false},
+		{false, true, false},
+		{true, false, true},
+	}
+	svg := string(qrSvg(bitmap, 90))
+	if !strings.Contains(svg, `width="90" height="90" viewBox="0 0 3 3"`) {
+		t.Fatalf("unexpected size of %v", svg)
+	}
+	// runs of dark modules are joined
+	if !strings.Contains(svg, `d="M0 0h2v1h-2zM1 1h1v1h-1zM0 2h1v1h-1zM2 2h1v1h-1z"`) {
+		t.Fatalf("unexpected path in %v", svg)
+	}
+	if err := xml.Unmarshal([]byte(svg), new(interface{})); err != nil {
+		t.Fatalf("invalid svg: %v", err)
+	}
+}
+
+func TestHandleQr(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	handle := func(w http.ResponseWriter, r *http.Request) { wh.handleQr(w, r, nil) }
+	issue := ts.addIssue(t, 1)
+	payreq, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		query string
+		size  int
+	}{
+		{"?issue_id=1", qrSize},
+		{"?issue_id=1&format=png&size=64", 64},
+		{"?issue_id=1&size=1024&invoice=" + payreq, 1024},
+	} {
+		rec := serve(handle, qrPath+test.query)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("unexpected response %v %v for %v", rec.Code, rec.Header().Get("Content-Type"), test.query)
+		}
+		img, err := png.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("invalid png for %v: %v", test.query, err)
+		}
+		if bounds := img.Bounds(); bounds.Dx() != test.size || bounds.Dy() != test.size {
+			t.Fatalf("expected a %v px png for %v, got %v", test.size, test.query, bounds)
+		}
+	}
+
+	rec := serve(handle, qrPath+"?issue_id=1&format=svg&size=128")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("unexpected response %v %v", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), new(interface{})); err != nil {
+		t.Fatalf("invalid svg: %v", err)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte(`width="128" height="128"`)) {
+		t.Fatalf("svg doesn't have the requested size: %s", rec.Body.Bytes()[:120])
+	}
+
+	// donation page and invoice get different codes
+	page := serve(handle, qrPath+"?issue_id=1&format=svg").Body.String()
+	invoice := serve(handle, qrPath+"?issue_id=1&format=svg&invoice="+payreq).Body.String()
+	if page == invoice {
+		t.Fatal("invoice code is the code of the donation page")
+	}
+
+	for query, code := range map[string]int{
+		"":                            http.StatusBadRequest,
+		"?issue_id=abc":               http.StatusBadRequest,
+		"?issue_id=1&size=63":         http.StatusBadRequest,
+		"?issue_id=1&size=1025":       http.StatusBadRequest,
+		"?issue_id=1&format=gif":      http.StatusBadRequest,
+		"?issue_id=2":                 http.StatusNotFound,
+		"?issue_id=1&invoice=lnbc1xx": http.StatusNotFound,
+	} {
+		if rec := serve(handle, qrPath+query); rec.Code != code {
+			t.Fatalf("expected %v for %v, got %v", code, query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package tracker
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/lightning"
+)
+
+var (
+	ReceiptsUnsupportedError = fmt.Errorf("benefactor node can't sign receipts")
+	UnpaidInvoiceError       = fmt.Errorf("invoice is not paid")
+)
+
+// Receipt is the proof of a donation, signed by the benefactor node.
+type Receipt struct {
+	BountyId    int64  `json:"bounty_id"`
+	IssueUrl    string `json:"issue_url"`
+	Amount      int64  `json:"amount"`
+	PaymentHash string `json:"payment_hash"`
+	Preimage    string `json:"preimage"`
+	Timestamp   int64  `json:"timestamp"`
+	Pubkey      string `json:"pubkey"`
+}
+
+// SignedReceipt holds the exact message that was signed, so receipts can be
+// verified without depending on how they are serialized.
+type SignedReceipt struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+type ReceiptVerification struct {
+	Valid   bool     `json:"valid"`
+	Receipt *Receipt `json:"receipt,omitempty"`
+	// pubkey that signed the receipt
+	Signer string `json:"signer,omitempty"`
+	// whether the payment hash belongs to a paid invoice of the bounty
+	Paid  bool   `json:"paid"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleReceiptEvent signs the receipt of settled invoices in the background,
+// so the node isn't asked while the donor waits for the download.
+func (srv *IssueService) handleReceiptEvent(ctx context.Context, event *Event) error {
+	issue, err := srv.store.Get(ctx, event.IssueId)
+	if err != nil {
+		return err
+	}
+	hash, err := paymentHash(issue, event.Invoice)
+	if err != nil {
+		return err
+	}
+	return srv.enqueue(ctx, &OutboxOp{
+		Key:     "receipt/" + hex.EncodeToString(hash),
+		Kind:    OutboxReceipt,
+		IssueId: event.IssueId,
+		Invoice: event.Invoice,
+	})
+}
+
+func (srv *IssueService) signReceipt(ctx context.Context, op *OutboxOp) error {
+	_, err := srv.Receipt(ctx, op.IssueId, op.Invoice)
+	if err == ReceiptsUnsupportedError {
+		return nil
+	}
+	return err
+}
+
+// Receipt returns the signed receipt of a paid invoice, signing it first if
+// necessary.
+func (srv *IssueService) Receipt(ctx context.Context, issueId int64, payreqString string) (*SignedReceipt, error) {
+	issue, err := srv.store.Get(ctx, issueId)
+	if err != nil {
+		return nil, err
+	}
+	paid, ok := issue.Payments[payreqString]
+	if !ok {
+		return nil, UnknownInvoiceError
+	}
+	if !paid {
+		return nil, UnpaidInvoiceError
+	}
+	if donation := issue.Donations[payreqString]; donation != nil && donation.Receipt != nil {
+		return donation.Receipt, nil
+	}
+	hash, err := paymentHash(issue, payreqString)
+	if err != nil {
+		return nil, err
+	}
+	node, release, err := srv.pool.Get(ctx, issue.LndConnect)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to lightning node %v", err)
+	}
+	defer release()
+	signer, ok := node.(lightning.MessageSigner)
+	if !ok {
+		return nil, ReceiptsUnsupportedError
+	}
+	invoice, err := node.LookupInvoice(ctx, hash)
+	if err != nil {
+		srv.reportNodeError(ctx, issue.LndConnect, err)
+		return nil, err
+	}
+	receipt := &Receipt{
+		BountyId:    issue.Id,
+		IssueUrl:    issue.HtmlUrl,
+		Amount:      invoice.Value,
+		PaymentHash: hex.EncodeToString(hash),
+		Preimage:    hex.EncodeToString(invoice.Preimage),
+		Timestamp:   invoice.SettleDate,
+		Pubkey:      issue.Pubkey,
+	}
+	if receipt.IssueUrl == "" {
+		receipt.IssueUrl = issue.Url
+	}
+	if donation := issue.Donations[payreqString]; donation != nil {
+		receipt.Amount = donation.Amount
+		if receipt.Timestamp == 0 {
+			receipt.Timestamp = donation.Timestamp
+		}
+	}
+	msg, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.SignMessage(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	signed := &SignedReceipt{Message: string(msg), Signature: signature}
+
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err = srv.store.Get(ctx, issueId)
+	if err != nil {
+		return nil, err
+	}
+	if issue.Donations == nil {
+		issue.Donations = make(map[string]*Donation)
+	}
+	donation, ok := issue.Donations[payreqString]
+	if !ok {
+		donation = &Donation{}
+		issue.Donations[payreqString] = donation
+	}
+	donation.Receipt = signed
+	err = srv.store.Update(ctx, issue)
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// VerifyReceipt checks that a receipt was signed by the benefactor node of its
+// bounty. The node verifies the signature if it is reachable, otherwise the
+// signer is recovered locally.
+func (srv *IssueService) VerifyReceipt(ctx context.Context, signed *SignedReceipt) (*ReceiptVerification, error) {
+	receipt := &Receipt{}
+	err := json.Unmarshal([]byte(signed.Message), receipt)
+	if err != nil {
+		return &ReceiptVerification{Error: fmt.Sprintf("invalid receipt %v", err)}, nil
+	}
+	res := &ReceiptVerification{Receipt: receipt}
+	issue, err := srv.store.Get(ctx, receipt.BountyId)
+	if err != nil {
+		return nil, err
+	}
+	res.Signer, err = srv.verifyMessage(ctx, issue, []byte(signed.Message), signed.Signature)
+	if err != nil {
+		res.Error = fmt.Sprintf("invalid signature %v", err)
+		return res, nil
+	}
+	switch {
+	case res.Signer != issue.Pubkey:
+		res.Error = "receipt was not signed by the benefactor node of the bounty"
+	case receipt.Pubkey != issue.Pubkey:
+		res.Error = "receipt names another benefactor node"
+	default:
+		res.Valid = true
+	}
+	for payreq, paid := range issue.Payments {
+		hash, err := paymentHash(issue, payreq)
+		if err == nil && hex.EncodeToString(hash) == receipt.PaymentHash {
+			res.Paid = paid
+			break
+		}
+	}
+	return res, nil
+}
+
+func (srv *IssueService) verifyMessage(ctx context.Context, issue *BountyIssue, msg []byte, signature string) (string, error) {
+	node, release, err := srv.pool.Get(ctx, issue.LndConnect)
+	if err != nil {
+		return lightning.RecoverMessagePubkey(msg, signature)
+	}
+	defer release()
+	signer, ok := node.(lightning.MessageSigner)
+	if !ok {
+		return lightning.RecoverMessagePubkey(msg, signature)
+	}
+	return signer.VerifyMessage(ctx, msg, signature)
+}
@@ -0,0 +1,172 @@
+package tracker
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"strings"
+	"testing"
+)
+
+// signingNode is a fakeNode that signs messages like lnd's signmessage.
+type signingNode struct {
+	*fakeNode
+}
+
+func (n *signingNode) SignMessage(ctx context.Context, msg []byte) (string, error) {
+	digest := chainhash.DoubleHashB(append([]byte("Lightning Signed Message:"), msg...))
+	sig, err := btcec.SignCompact(btcec.S256(), n.key, digest, true)
+	if err != nil {
+		return "", err
+	}
+	return encodeZbase32(sig), nil
+}
+
+func (n *signingNode) VerifyMessage(ctx context.Context, msg []byte, signature string) (string, error) {
+	return lightning.RecoverMessagePubkey(msg, signature)
+}
+
+func encodeZbase32(b []byte) string {
+	const alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+	var res strings.Builder
+	var buffer, bits uint
+	for _, c := range b {
+		buffer = buffer<<8 | uint(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			res.WriteByte(alphabet[buffer>>bits&31])
+		}
+	}
+	if bits > 0 {
+		res.WriteByte(alphabet[buffer<<(5-bits)&31])
+	}
+	return res.String()
+}
+
+func TestReceiptRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	ts.pool.SetDialer(func(ctx context.Context, uri string) (lightning.LightningNode, error) {
+		if uri != testNodeUri {
+			return nil, fmt.Errorf("unknown node %v", uri)
+		}
+		return &signingNode{ts.node}, nil
+	})
+	issue := ts.addIssue(t, 1)
+	payreq, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Receipt(ctx, issue.Id, payreq); err != UnpaidInvoiceError {
+		t.Fatalf("expected unpaid invoice, got %v", err)
+	}
+	inv := ts.node.settle(payreq, 1000)
+	err = ts.SettleInvoice(ctx, ts.issue(t, issue.Id), payreq, 1000, inv.Preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the receipt is signed in the background after the settlement
+	event := newEvent(EventInvoiceSettled, ts.issue(t, issue.Id))
+	event.Invoice = payreq
+	err = ts.handleReceiptEvent(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.processOutbox(ctx, OutboxReceipt)
+	donation := ts.issue(t, issue.Id).Donations[payreq]
+	if donation == nil || donation.Receipt == nil {
+		t.Fatal("receipt wasn't stored")
+	}
+	signed, err := ts.Receipt(ctx, issue.Id, payreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *signed != *donation.Receipt {
+		t.Fatal("stored receipt wasn't reused")
+	}
+
+	receipt := &Receipt{}
+	err = json.Unmarshal([]byte(signed.Message), receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey := hex.EncodeToString(ts.node.key.PubKey().SerializeCompressed())
+	if receipt.BountyId != issue.Id || receipt.Amount != 1000 || receipt.Pubkey != pubkey ||
+		receipt.Preimage != hex.EncodeToString(inv.Preimage) || receipt.PaymentHash != hex.EncodeToString(inv.PaymentHash) {
+		t.Fatalf("unexpected receipt %+v", receipt)
+	}
+
+	res, err := ts.VerifyReceipt(ctx, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || !res.Paid || res.Signer != pubkey || res.Error != "" {
+		t.Fatalf("receipt isn't valid: %+v", res)
+	}
+
+	// receipts verify without the node
+	offline := ts.issue(t, issue.Id)
+	offline.LndConnect = "lndconnect://offline:10009"
+	signer, err := ts.verifyMessage(ctx, offline, []byte(signed.Message), signed.Signature)
+	if err != nil || signer != pubkey {
+		t.Fatalf("expected signer %v without the node, got %v: %v", pubkey, signer, err)
+	}
+
+	// changing the receipt invalidates it
+	tampered := *receipt
+	tampered.Amount = 1000000
+	msg, err := json.Marshal(&tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = ts.VerifyReceipt(ctx, &SignedReceipt{Message: string(msg), Signature: signed.Signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Signer == pubkey {
+		t.Fatalf("tampered receipt is valid: %+v", res)
+	}
+
+	// receipts signed by another node are rejected
+	other := &signingNode{newFakeNode(t)}
+	forged, err := other.SignMessage(ctx, []byte(signed.Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = ts.VerifyReceipt(ctx, &SignedReceipt{Message: signed.Message, Signature: forged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Error == "" {
+		t.Fatalf("receipt of another node is valid: %+v", res)
+	}
+}
+
+func TestReceiptsUnsupported(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	payreq, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := ts.node.settle(payreq, 1000)
+	err = ts.SettleInvoice(ctx, ts.issue(t, issue.Id), payreq, 1000, inv.Preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Receipt(ctx, issue.Id, payreq); err != ReceiptsUnsupportedError {
+		t.Fatalf("expected unsupported receipts, got %v", err)
+	}
+	// the outbox doesn't retry nodes that can't sign
+	err = ts.signReceipt(ctx, &OutboxOp{IssueId: issue.Id, Invoice: payreq})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,334 @@
+package tracker
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"sync"
+	"time"
+)
+
+type DiscrepancyKind string
+
+const (
+	// the node settled an invoice that is stored as unpaid
+	DiscrepancyMissedSettlement DiscrepancyKind = "missed_settlement"
+	// the node canceled an invoice that is stored as unpaid
+	DiscrepancyCanceled DiscrepancyKind = "canceled"
+	// an invoice that is stored as paid isn't settled on the node
+	DiscrepancyNotSettled DiscrepancyKind = "not_settled"
+	// an invoice that is stored as paid doesn't exist on the node
+	DiscrepancyMissingInvoice DiscrepancyKind = "missing_invoice"
+	// the node received another amount than the one credited
+	DiscrepancyAmount DiscrepancyKind = "amount_mismatch"
+	// Bounty or TotalPayments don't match the stored payments
+	DiscrepancyTotals DiscrepancyKind = "totals_mismatch"
+	// a payment that is stored as paid has no donation record and the node
+	// doesn't know its amount either, so the totals can't be checked
+	DiscrepancyUnverifiable DiscrepancyKind = "unverifiable"
+)
+
+// Discrepancy is a difference between the store and a benefactor node. Stored
+// and Expected are amounts in sats, Expected is the amount of the invoice on
+// the node or, for totals, the sum of the credited payments.
+type Discrepancy struct {
+	IssueId     int64           `json:"issue_id"`
+	Url         string          `json:"url"`
+	Kind        DiscrepancyKind `json:"kind"`
+	Invoice     string          `json:"invoice,omitempty"`
+	PaymentHash string          `json:"payment_hash,omitempty"`
+	Stored      int64           `json:"stored"`
+	Expected    int64           `json:"expected"`
+	// number of payments of totals mismatches
+	StoredPayments   int  `json:"stored_payments,omitempty"`
+	ExpectedPayments int  `json:"expected_payments,omitempty"`
+	Fixed            bool `json:"fixed"`
+	// error of the fix
+	Error string `json:"error,omitempty"`
+}
+
+type NodeReconciliation struct {
+	Pubkey   string `json:"pubkey"`
+	Issues   int    `json:"issues"`
+	Invoices int    `json:"invoices"`
+	// whether the invoices were looked up one by one because the backend
+	// can't list them
+	Lookup bool   `json:"lookup"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	StartedAt     int64                 `json:"started_at"`
+	FinishedAt    int64                 `json:"finished_at"`
+	Fix           bool                  `json:"fix"`
+	Nodes         []*NodeReconciliation `json:"nodes"`
+	Discrepancies []*Discrepancy        `json:"discrepancies"`
+}
+
+// reconciler serializes reconciliations and holds the latest report.
+type reconciler struct {
+	report *ReconcileReport
+	sync.Mutex
+}
+
+// StartReconciliation periodically reconciles the store with the benefactor
+// nodes, fixing discrepancies if configured.
+func (srv *IssueService) StartReconciliation(ctx context.Context) {
+	if srv.cfg.ReconcileInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(srv.cfg.ReconcileInterval):
+			}
+			_, err := srv.Reconcile(ctx, srv.cfg.ReconcileFix)
+			if err != nil {
+				fmt.Printf("unable to reconcile payments: %v \n", err)
+			}
+		}
+	}()
+}
+
+// LastReconciliation returns the report of the latest reconciliation, nil if
+// none ran yet.
+func (srv *IssueService) LastReconciliation() *ReconcileReport {
+	srv.reconciler.Lock()
+	defer srv.reconciler.Unlock()
+	return srv.reconciler.report
+}
+
+// Reconcile compares the stored payments of every bounty with the invoices of
+// its benefactor node. With fix, missed settlements are credited, canceled
+// invoices removed and totals corrected, invoices that are stored as paid but
+// aren't settled on the node are only reported.
+func (srv *IssueService) Reconcile(ctx context.Context, fix bool) (*ReconcileReport, error) {
+	srv.reconciler.Lock()
+	defer srv.reconciler.Unlock()
+	issues, err := srv.store.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &ReconcileReport{StartedAt: time.Now().Unix(), Fix: fix, Nodes: []*NodeReconciliation{}, Discrepancies: []*Discrepancy{}}
+	byNode := make(map[string][]*BountyIssue)
+	var nodes []string
+	for _, issue := range issues {
+		if len(issue.Payments) == 0 && issue.Bounty == 0 {
+			continue
+		}
+		if _, ok := byNode[issue.LndConnect]; !ok {
+			nodes = append(nodes, issue.LndConnect)
+		}
+		byNode[issue.LndConnect] = append(byNode[issue.LndConnect], issue)
+	}
+	for _, lndConnect := range nodes {
+		nodeIssues := byNode[lndConnect]
+		res := &NodeReconciliation{Pubkey: nodeIssues[0].Pubkey, Issues: len(nodeIssues)}
+		report.Nodes = append(report.Nodes, res)
+		err := srv.reconcileNode(ctx, lndConnect, nodeIssues, fix, res, report)
+		if err != nil {
+			res.Error = err.Error()
+		}
+	}
+	report.FinishedAt = time.Now().Unix()
+	fmt.Printf("reconciled %v nodes, found %v discrepancies \n", len(report.Nodes), len(report.Discrepancies))
+	srv.reconciler.report = report
+	return report, nil
+}
+
+func (srv *IssueService) reconcileNode(ctx context.Context, lndConnect string, issues []*BountyIssue, fix bool, res *NodeReconciliation, report *ReconcileReport) error {
+	node, release, err := srv.pool.Get(ctx, lndConnect)
+	if err != nil {
+		return fmt.Errorf("unable to connect to lightning node %v", err)
+	}
+	defer release()
+	invoices := make(map[string]*lightning.Invoice)
+	lister, ok := node.(lightning.InvoiceLister)
+	if ok {
+		list, err := lister.ListInvoices(ctx)
+		if err != nil {
+			srv.reportNodeError(ctx, lndConnect, err)
+			return err
+		}
+		for _, invoice := range list {
+			invoices[hex.EncodeToString(invoice.PaymentHash)] = invoice
+		}
+		res.Invoices = len(list)
+	} else {
+		res.Lookup = true
+		for _, issue := range issues {
+			for payreq := range issue.Payments {
+				hash, err := paymentHash(issue, payreq)
+				if err != nil {
+					continue
+				}
+				invoice, err := node.LookupInvoice(ctx, hash)
+				if lightning.IsConnectionError(err) {
+					srv.reportNodeError(ctx, lndConnect, err)
+					return err
+				}
+				if err != nil {
+					// backends don't tell missing invoices apart from other
+					// errors, so lookups can't report missing invoices
+					continue
+				}
+				invoices[hex.EncodeToString(hash)] = invoice
+				res.Invoices++
+			}
+		}
+	}
+	for _, issue := range issues {
+		report.Discrepancies = append(report.Discrepancies, srv.reconcileIssue(ctx, issue, invoices, res.Lookup, fix)...)
+	}
+	return nil
+}
+
+// reconcileIssue compares the payments of an issue with the invoices of its
+// node by payment hash.
+func (srv *IssueService) reconcileIssue(ctx context.Context, issue *BountyIssue, invoices map[string]*lightning.Invoice, lookup bool, fix bool) []*Discrepancy {
+	var discrepancies []*Discrepancy
+	add := func(kind DiscrepancyKind, payreq string, hash string, stored int64, expected int64) *Discrepancy {
+		discrepancy := &Discrepancy{
+			IssueId:     issue.Id,
+			Url:         issue.HtmlUrl,
+			Kind:        kind,
+			Invoice:     payreq,
+			PaymentHash: hash,
+			Stored:      stored,
+			Expected:    expected,
+		}
+		discrepancies = append(discrepancies, discrepancy)
+		return discrepancy
+	}
+	applyFix := func(discrepancy *Discrepancy, err error) {
+		if err != nil {
+			discrepancy.Error = err.Error()
+			return
+		}
+		discrepancy.Fixed = true
+	}
+	// amounts the node received for paid payments without donation record
+	received := make(map[string]int64)
+	for payreq, paid := range issue.Payments {
+		hashBytes, err := paymentHash(issue, payreq)
+		if err != nil {
+			continue
+		}
+		hash := hex.EncodeToString(hashBytes)
+		var stored int64
+		donation := issue.Donations[payreq]
+		if donation != nil {
+			stored = donation.Amount
+		}
+		invoice, ok := invoices[hash]
+		switch {
+		case !ok:
+			if paid && !lookup {
+				add(DiscrepancyMissingInvoice, payreq, hash, stored, 0)
+			}
+		case invoice.State == lightning.InvoiceSettled && !paid:
+			discrepancy := add(DiscrepancyMissedSettlement, payreq, hash, 0, invoice.Value)
+			if fix {
+				applyFix(discrepancy, srv.SettleInvoice(ctx, issue, payreq, invoice.Value, invoice.Preimage))
+			}
+		case invoice.State == lightning.InvoiceCanceled && !paid:
+			discrepancy := add(DiscrepancyCanceled, payreq, hash, 0, invoice.Value)
+			if fix {
+				applyFix(discrepancy, srv.RemovePayment(ctx, issue, payreq))
+			}
+		case invoice.State != lightning.InvoiceSettled && paid:
+			add(DiscrepancyNotSettled, payreq, hash, stored, 0)
+		case paid && donation == nil:
+			// credited before donations were recorded
+			received[payreq] = invoice.Value
+		case paid && invoice.Value != stored:
+			add(DiscrepancyAmount, payreq, hash, stored, invoice.Value)
+		}
+	}
+	discrepancy := srv.reconcileTotals(ctx, issue, received, fix)
+	if discrepancy != nil {
+		discrepancies = append(discrepancies, discrepancy)
+	}
+	return discrepancies
+}
+
+// reconcileTotals recomputes Bounty and TotalPayments from the credited
+// payments and deposits of the issue, which catches double counting. Refunded
+// donations count as payments but not towards the bounty. Payments without
+// donation record count with the amount the node received, if it is unknown
+// the totals are reported as unverifiable.
+func (srv *IssueService) reconcileTotals(ctx context.Context, issue *BountyIssue, received map[string]int64, fix bool) *Discrepancy {
+	srv.Lock()
+	defer srv.Unlock()
+	// fixes of the payments changed the issue
+	issue, err := srv.store.Get(ctx, issue.Id)
+	if err != nil {
+		return nil
+	}
+	var bounty int64
+	var payments int
+	for payreq, paid := range issue.Payments {
+		if !paid {
+			continue
+		}
+		donation := issue.Donations[payreq]
+		if donation == nil {
+			sats, ok := received[payreq]
+			if !ok {
+				return &Discrepancy{
+					IssueId:        issue.Id,
+					Url:            issue.HtmlUrl,
+					Kind:           DiscrepancyUnverifiable,
+					Invoice:        payreq,
+					Stored:         issue.Bounty,
+					StoredPayments: issue.TotalPayments,
+				}
+			}
+			bounty += sats
+			payments++
+			continue
+		}
+		if donation.RefundedAt == 0 {
+			bounty += donation.Amount
+		}
+		payments++
+	}
+	for outpoint, sats := range issue.OnchainDeposits {
+		if donation := issue.Donations[outpoint]; donation == nil || donation.RefundedAt == 0 {
+			bounty += sats
+		}
+		payments++
+	}
+	if issue.Bounty == bounty && issue.TotalPayments == payments {
+		return nil
+	}
+	discrepancy := &Discrepancy{
+		IssueId:          issue.Id,
+		Url:              issue.HtmlUrl,
+		Kind:             DiscrepancyTotals,
+		Stored:           issue.Bounty,
+		Expected:         bounty,
+		StoredPayments:   issue.TotalPayments,
+		ExpectedPayments: payments,
+	}
+	if !fix {
+		return discrepancy
+	}
+	fmt.Printf("correcting totals of %v from %v sats in %v payments to %v sats in %v payments \n",
+		issue.Url, issue.Bounty, issue.TotalPayments, bounty, payments)
+	delta := bounty - issue.Bounty
+	issue.Bounty = bounty
+	issue.TotalPayments = payments
+	event := newEvent(EventBountyAdjusted, issue)
+	event.Amount = delta
+	err = srv.commit(ctx, issue, event)
+	if err != nil {
+		discrepancy.Error = err.Error()
+		return discrepancy
+	}
+	discrepancy.Fixed = true
+	return discrepancy
+}
@@ -0,0 +1,145 @@
+package tracker
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// unwatchedInvoice creates an invoice whose settlement the bot doesn't notice,
+// as if it was down while the invoice was paid.
+func (ts *testService) unwatchedInvoice(t *testing.T, issueId int64, sats int64) string {
+	ts.node.fail("SubscribeInvoice", fmt.Errorf("not listening"))
+	payreq, err := ts.GetBountyInvoice(context.Background(), issueId, sats, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		ts.node.Lock()
+		defer ts.node.Unlock()
+		return ts.node.failures["SubscribeInvoice"] == nil
+	}, "listener didn't subscribe")
+	return payreq
+}
+
+// creditedInvoice creates an invoice and credits it with the paid amount.
+func (ts *testService) creditedInvoice(t *testing.T, issueId int64, sats int64) string {
+	payreq := ts.unwatchedInvoice(t, issueId, sats)
+	inv := ts.node.settle(payreq, sats)
+	err := ts.SettleInvoice(context.Background(), ts.issue(t, issueId), payreq, sats, inv.Preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payreq
+}
+
+func TestListenPaymentOverpaid(t *testing.T) {
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	payreq, err := ts.GetBountyInvoice(context.Background(), issue.Id, 1000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.node.settle(payreq, 1500)
+	eventually(t, func() bool {
+		return ts.issue(t, issue.Id).Payments[payreq]
+	}, "invoice wasn't credited")
+	issue = ts.issue(t, issue.Id)
+	if issue.Bounty != 1500 || issue.Donations[payreq].Amount != 1500 {
+		t.Fatalf("expected the received 1500 sats to be credited, got %v", issue.Bounty)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+
+	// paid more than requested while the bot wasn't listening
+	ts.addIssue(t, 1)
+	missed := ts.unwatchedInvoice(t, 1, 1000)
+	ts.node.settle(missed, 1500)
+
+	// credited without amount
+	ts.addIssue(t, 2)
+	zero := ts.creditedInvoice(t, 2, 1000)
+	issue := ts.issue(t, 2)
+	issue.Donations[zero].Amount = 0
+	err := ts.store.Update(ctx, issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// credited before donations were recorded, the node knows the amount
+	ts.addIssue(t, 3)
+	legacy := ts.creditedInvoice(t, 3, 2000)
+	issue = ts.issue(t, 3)
+	delete(issue.Donations, legacy)
+	err = ts.store.Update(ctx, issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// credited before donations were recorded and gone from the node
+	ts.addIssue(t, 4)
+	lost := ts.creditedInvoice(t, 4, 3000)
+	issue = ts.issue(t, 4)
+	delete(issue.Donations, lost)
+	err = ts.store.Update(ctx, issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := paymentHash(issue, lost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.node.Lock()
+	delete(ts.node.invoices, hex.EncodeToString(hash))
+	ts.node.Unlock()
+
+	report, err := ts.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[int64][]*Discrepancy)
+	for _, discrepancy := range report.Discrepancies {
+		found[discrepancy.IssueId] = append(found[discrepancy.IssueId], discrepancy)
+	}
+	expected := map[int64][]Discrepancy{
+		1: {{Kind: DiscrepancyMissedSettlement, Invoice: missed, Stored: 0, Expected: 1500}},
+		2: {
+			{Kind: DiscrepancyAmount, Invoice: zero, Stored: 0, Expected: 1000},
+			{Kind: DiscrepancyTotals, Stored: 1000, Expected: 0},
+		},
+		4: {
+			{Kind: DiscrepancyMissingInvoice, Invoice: lost, Stored: 0, Expected: 0},
+			{Kind: DiscrepancyUnverifiable, Invoice: lost, Stored: 3000},
+		},
+	}
+	for id := int64(1); id <= 4; id++ {
+		if len(found[id]) != len(expected[id]) {
+			t.Fatalf("expected %v discrepancies of issue %v, got %+v", len(expected[id]), id, found[id])
+		}
+		for i, discrepancy := range found[id] {
+			want := expected[id][i]
+			if discrepancy.Kind != want.Kind || discrepancy.Invoice != want.Invoice ||
+				discrepancy.Stored != want.Stored || discrepancy.Expected != want.Expected {
+				t.Fatalf("expected discrepancy %+v of issue %v, got %+v", want, id, discrepancy)
+			}
+		}
+	}
+
+	// the missed settlement is credited with the received amount
+	_, err = ts.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue = ts.issue(t, 1)
+	if !issue.Payments[missed] || issue.Bounty != 1500 {
+		t.Fatalf("expected the missed 1500 sats to be credited, got %v", issue.Bounty)
+	}
+	// unverifiable totals aren't corrected
+	if bounty := ts.issue(t, 4).Bounty; bounty != 3000 {
+		t.Fatalf("expected the unverifiable bounty to stay at 3000, got %v", bounty)
+	}
+}
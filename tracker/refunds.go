@@ -0,0 +1,67 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var (
+	AlreadyRefundedError = fmt.Errorf("donation was refunded already")
+)
+
+// RefundDonation records that a donation was returned to its donor outside of
+// the bot and removes its amount from the bounty. The donation is the payment
+// request of a lightning donation or the outpoint of an on-chain deposit, txid
+// is the optional transaction of the refund.
+func (srv *IssueService) RefundDonation(ctx context.Context, issueId int64, key string, txid string) (*Donation, error) {
+	srv.Lock()
+	defer srv.Unlock()
+	issue, err := srv.store.Get(ctx, issueId)
+	if err != nil {
+		return nil, err
+	}
+	donation := issue.Donations[key]
+	if donation == nil || (!issue.Payments[key] && issue.OnchainDeposits[key] == 0) {
+		return nil, ErrDoesNotExist
+	}
+	if donation.RefundedAt != 0 {
+		return nil, AlreadyRefundedError
+	}
+	// sats that were paid out, are being paid out or were awarded can't be
+	// refunded anymore
+	spent := paidOut(issue) + reservedPayouts(issue)
+	if awarded(issue) > spent {
+		spent = awarded(issue)
+	}
+	remaining := issue.Bounty - spent
+	if donation.Amount > remaining {
+		return nil, fmt.Errorf("unable to refund %v sats, remaining bounty is %v", donation.Amount, remaining)
+	}
+	fmt.Printf("refunded %v sats of %v on %v \n", donation.Amount, key, issue)
+	donation.RefundedAt = time.Now().Unix()
+	donation.RefundTxid = txid
+	issue.Bounty -= donation.Amount
+	event := newEvent(EventRefund, issue)
+	event.Amount = donation.Amount
+	event.Txid = txid
+	if _, ok := issue.Payments[key]; ok {
+		event.Invoice = key
+	}
+	err = srv.commit(ctx, issue, event)
+	if err != nil {
+		return nil, err
+	}
+	return donation, nil
+}
+
+// refunded returns the refunded amount of the donations of an issue.
+func refunded(issue *BountyIssue) int64 {
+	var sats int64
+	for _, donation := range issue.Donations {
+		if donation.RefundedAt != 0 {
+			sats += donation.Amount
+		}
+	}
+	return sats
+}
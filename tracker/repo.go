@@ -0,0 +1,203 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"text/template"
+	"time"
+)
+
+var (
+	DefaultMemoTemplate  = "Add bounty on {{.Id}}"
+	DefaultInvoiceExpiry = int64(600)
+)
+
+// Repository is the registration of a repository that uses the bot, holding
+// its settings.
+type Repository struct {
+	Owner        string
+	Name         string
+	RegisteredAt int64
+	Invoice      *InvoiceSettings
+	// fiat currency the bounties are shown in, the configured default if empty
+	Currency string
+	// comment templates set by the operator
+	Templates *CommentTemplates
+	// configuration read from the repository, nil if it has none
+	File          *RepoFile
+	FileFetchedAt int64
+	// validation error of the current file, the last valid one stays in use
+	FileError string
+	// destinations of notifications about the bounties
+	Notifiers []*Notifier
+	// confirmed recipients of email notifications
+	EmailSubscribers []*EmailSubscriber
+}
+
+type InvoiceSettings struct {
+	// expiry of donation invoices in seconds
+	Expiry int64 `json:"expiry"`
+	// add route hints for private channels of the benefactor node
+	RouteHints bool `json:"route_hints"`
+	// text/template for the invoice memo, see MemoData
+	MemoTemplate string `json:"memo_template"`
+	// commit to the memo with a description hash instead of including it
+	DescriptionHash bool `json:"description_hash"`
+}
+
+// MemoData is passed to the memo template of a repository.
+type MemoData struct {
+	Id     int64
+	Number int64
+	Title  string
+	Url    string
+	Owner  string
+	Repo   string
+}
+
+type RepoStore interface {
+	Put(context.Context, *Repository) error
+	Get(ctx context.Context, owner string, name string) (*Repository, error)
+	ListAll(ctx context.Context) ([]*Repository, error)
+}
+
+func (repo *Repository) FullName() string {
+	return repo.Owner + "/" + repo.Name
+}
+
+// Validate checks the settings and parses the memo template.
+func (settings *InvoiceSettings) Validate() error {
+	if settings.Expiry < 0 {
+		return fmt.Errorf("invalid expiry %v", settings.Expiry)
+	}
+	if settings.MemoTemplate != "" {
+		_, err := template.New("memo").Parse(settings.MemoTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid memo template: %v", err)
+		}
+	}
+	return nil
+}
+
+// RegisterRepo returns the registration of a repository, creating it if the
+// repository is new.
+func (srv *IssueService) RegisterRepo(ctx context.Context, owner string, name string) (*Repository, error) {
+	srv.repoMtx.Lock()
+	defer srv.repoMtx.Unlock()
+	return srv.registerRepo(ctx, owner, name)
+}
+
+// updateRepo registers a repository and stores the change of its
+// registration. Changes are serialized, so that concurrent changes of
+// different fields don't overwrite each other.
+func (srv *IssueService) updateRepo(ctx context.Context, owner string, name string, change func(repo *Repository)) (*Repository, error) {
+	srv.repoMtx.Lock()
+	defer srv.repoMtx.Unlock()
+	repo, err := srv.registerRepo(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	change(repo)
+	err = srv.repoStore.Put(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// registerRepo is RegisterRepo for callers that hold repoMtx.
+func (srv *IssueService) registerRepo(ctx context.Context, owner string, name string) (*Repository, error) {
+	repo, err := srv.repoStore.Get(ctx, owner, name)
+	if err == nil {
+		return repo, nil
+	}
+	if err != ErrDoesNotExist {
+		return nil, err
+	}
+	repo = &Repository{
+		Owner:        owner,
+		Name:         name,
+		RegisteredAt: time.Now().Unix(),
+		Invoice:      &InvoiceSettings{},
+	}
+	err = srv.repoStore.Put(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("registered repository %v \n", repo.FullName())
+	err = srv.enqueueCreateLabels(ctx, repo)
+	if err != nil {
+		fmt.Printf("unable to enqueue label creation: %v \n", err)
+	}
+	return repo, nil
+}
+
+func (srv *IssueService) GetRepo(ctx context.Context, owner string, name string) (*Repository, error) {
+	return srv.repoStore.Get(ctx, owner, name)
+}
+
+// UpdateInvoiceSettings replaces the invoice settings of a repository.
+func (srv *IssueService) UpdateInvoiceSettings(ctx context.Context, owner string, name string, settings *InvoiceSettings) (*Repository, error) {
+	err := settings.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return srv.updateRepo(ctx, owner, name, func(repo *Repository) {
+		repo.Invoice = settings
+	})
+}
+
+// invoiceRequest builds an invoice request for a donation with the invoice
+// settings of the repository.
+func (srv *IssueService) invoiceRequest(ctx context.Context, issue *BountyIssue, sats int64) (*lightning.InvoiceRequest, error) {
+	settings := &InvoiceSettings{}
+	repo, err := srv.repoStore.Get(ctx, issue.Owner, issue.Repo)
+	if err != nil && err != ErrDoesNotExist {
+		return nil, err
+	}
+	if repo != nil && repo.Invoice != nil {
+		settings = repo.Invoice
+	}
+	memoTemplate := settings.MemoTemplate
+	if memoTemplate == "" {
+		memoTemplate = DefaultMemoTemplate
+	}
+	tmpl, err := template.New("memo").Parse(memoTemplate)
+	if err != nil {
+		return nil, err
+	}
+	url := issue.HtmlUrl
+	if url == "" {
+		url = issue.Url
+	}
+	memo := &bytes.Buffer{}
+	err = tmpl.Execute(memo, &MemoData{
+		Id:     issue.Id,
+		Number: issue.Number,
+		Title:  issue.Title,
+		Url:    url,
+		Owner:  issue.Owner,
+		Repo:   issue.Repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req := &lightning.InvoiceRequest{
+		Memo:       memo.String(),
+		Value:      sats,
+		Expiry:     settings.Expiry,
+		RouteHints: settings.RouteHints,
+	}
+	if req.Expiry == 0 {
+		req.Expiry = DefaultInvoiceExpiry
+	}
+	if settings.DescriptionHash {
+		descriptionHash := sha256.Sum256(memo.Bytes())
+		req.DescriptionHash = descriptionHash[:]
+		req.Description = memo.String()
+	}
+	return req, nil
+}
@@ -0,0 +1,293 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// paths the configuration of a repository is read from, the first file
+	// that exists is used
+	RepoFilePaths = []string{".github/bounty.yml", ".bounty.toml"}
+
+	DefaultSuggestedAmounts = []int64{1000, 10000, 100000}
+
+	currencyRegexp = regexp.MustCompile("^[A-Z]{3}$")
+)
+
+type EscrowMode string
+
+const (
+	// donations can be paid out at any time
+	EscrowDirect EscrowMode = "direct"
+	// donations are held by the benefactor node until the issue is closed
+	EscrowUntilClosed EscrowMode = "until_closed"
+)
+
+// RepoFile is the configuration a repository keeps in one of RepoFilePaths.
+type RepoFile struct {
+	// labels that mark an issue as bounty, the configured ones if empty
+	Labels []string `json:"labels,omitempty" yaml:"labels" toml:"labels"`
+	// named tiers of tier labels, DefaultLabelTiers if empty
+	Tiers    map[string]int64 `json:"tiers,omitempty" yaml:"tiers" toml:"tiers"`
+	TierMode TierMode         `json:"tier_mode,omitempty" yaml:"tier_mode" toml:"tier_mode"`
+	// limits of a single donation in sats, 0 for no limit
+	MinDonation int64 `json:"min_donation,omitempty" yaml:"min_donation" toml:"min_donation"`
+	MaxDonation int64 `json:"max_donation,omitempty" yaml:"max_donation" toml:"max_donation"`
+	// amounts in sats offered on the donation page
+	SuggestedAmounts []int64 `json:"suggested_amounts,omitempty" yaml:"suggested_amounts" toml:"suggested_amounts"`
+	// fiat currency, takes precedence over the one set by the operator
+	Currency string       `json:"currency,omitempty" yaml:"currency" toml:"currency"`
+	Escrow   EscrowMode   `json:"escrow,omitempty" yaml:"escrow" toml:"escrow"`
+	Payouts  *PayoutRules `json:"payouts,omitempty" yaml:"payouts" toml:"payouts"`
+	// language of the default comments, see CommentLanguages
+	Language string `json:"language,omitempty" yaml:"language" toml:"language"`
+	// goal of bounties in sats
+	Goal int64 `json:"goal,omitempty" yaml:"goal" toml:"goal"`
+	// leave the repository out of the leaderboards
+	HideLeaderboard bool              `json:"hide_leaderboard,omitempty" yaml:"hide_leaderboard" toml:"hide_leaderboard"`
+	Templates       *CommentTemplates `json:"templates,omitempty" yaml:"templates" toml:"templates"`
+}
+
+type PayoutRules struct {
+	// disallow on-chain payouts even if the operator allows them
+	DisableOnchain bool `json:"disable_onchain,omitempty" yaml:"disable_onchain" toml:"disable_onchain"`
+	// smallest on-chain payout in sats
+	MinAmount int64 `json:"min_amount,omitempty" yaml:"min_amount" toml:"min_amount"`
+}
+
+// Validate checks the limits, the modes and the comment templates of the file.
+func (file *RepoFile) Validate() error {
+	for _, label := range file.Labels {
+		if strings.TrimSpace(label) == "" {
+			return fmt.Errorf("empty label")
+		}
+	}
+	for name, amount := range file.Tiers {
+		if name == "" || amount <= 0 {
+			return fmt.Errorf("invalid tier %v: %v", name, amount)
+		}
+	}
+	switch file.TierMode {
+	case "", TierSuggest, TierPledge:
+	default:
+		return fmt.Errorf("unknown tier mode %v, use %v or %v", file.TierMode, TierSuggest, TierPledge)
+	}
+	if file.MinDonation < 0 || file.MaxDonation < 0 {
+		return fmt.Errorf("invalid donation limits %v-%v", file.MinDonation, file.MaxDonation)
+	}
+	if file.MaxDonation != 0 && file.MinDonation > file.MaxDonation {
+		return fmt.Errorf("min_donation %v is above max_donation %v", file.MinDonation, file.MaxDonation)
+	}
+	for _, amount := range file.SuggestedAmounts {
+		if err := file.checkDonation(amount); err != nil || amount <= 0 {
+			return fmt.Errorf("invalid suggested amount %v", amount)
+		}
+	}
+	if file.Currency != "" && !currencyRegexp.MatchString(file.Currency) {
+		return fmt.Errorf("invalid currency %v", file.Currency)
+	}
+	switch file.Escrow {
+	case "", EscrowDirect, EscrowUntilClosed:
+	default:
+		return fmt.Errorf("unknown escrow mode %v, use %v or %v", file.Escrow, EscrowDirect, EscrowUntilClosed)
+	}
+	if file.Payouts != nil && file.Payouts.MinAmount < 0 {
+		return fmt.Errorf("invalid payout min_amount %v", file.Payouts.MinAmount)
+	}
+	if file.Language != "" && CommentLanguages[file.Language] == nil {
+		return fmt.Errorf("unsupported language %v", file.Language)
+	}
+	if file.Goal < 0 {
+		return fmt.Errorf("invalid goal %v", file.Goal)
+	}
+	if file.Templates != nil {
+		return file.Templates.Validate()
+	}
+	return nil
+}
+
+// checkDonation checks a donation amount against the limits of the file.
+func (file *RepoFile) checkDonation(sats int64) error {
+	if sats < file.MinDonation {
+		return fmt.Errorf("donations must be at least %v sats", file.MinDonation)
+	}
+	if file.MaxDonation != 0 && sats > file.MaxDonation {
+		return fmt.Errorf("donations must be at most %v sats", file.MaxDonation)
+	}
+	return nil
+}
+
+// parseRepoFile decodes a repository file by its extension, unknown keys are
+// rejected so that typos don't go unnoticed.
+func parseRepoFile(path string, content []byte) (*RepoFile, error) {
+	file := &RepoFile{}
+	if strings.HasSuffix(path, ".toml") {
+		md, err := toml.Decode(string(content), file)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown keys %v", undecoded)
+		}
+	} else {
+		err := yaml.UnmarshalStrict(content, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file.Currency = strings.ToUpper(file.Currency)
+	return file, file.Validate()
+}
+
+// SuggestedAmounts returns the amounts offered on the donation page.
+func (repo *Repository) SuggestedAmounts() []int64 {
+	if repo != nil && repo.File != nil && len(repo.File.SuggestedAmounts) > 0 {
+		return repo.File.SuggestedAmounts
+	}
+	return DefaultSuggestedAmounts
+}
+
+// repoFile returns the configuration of a repository, which is empty if the
+// repository has none.
+func (srv *IssueService) repoFile(ctx context.Context, owner string, name string) *RepoFile {
+	repo, err := srv.repoStore.Get(ctx, owner, name)
+	if err == nil && repo.File != nil {
+		return repo.File
+	}
+	return &RepoFile{}
+}
+
+// RefreshRepoFile reads the configuration file of a repository. If the file
+// is invalid the last valid configuration stays in use and the error is kept
+// in FileError.
+func (srv *IssueService) RefreshRepoFile(ctx context.Context, owner string, name string) (*Repository, error) {
+	var path string
+	var content []byte
+	for _, p := range RepoFilePaths {
+		c, err := srv.ghClient.GetRepoFile(ctx, owner, name, p)
+		if err == ErrDoesNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		path, content = p, c
+		break
+	}
+	var file *RepoFile
+	var fileErr error
+	if path != "" {
+		file, fileErr = parseRepoFile(path, content)
+	}
+	return srv.updateRepo(ctx, owner, name, func(repo *Repository) {
+		repo.FileFetchedAt = time.Now().Unix()
+		repo.FileError = ""
+		if fileErr != nil {
+			repo.FileError = fmt.Sprintf("invalid %v: %v", path, fileErr)
+			fmt.Printf("%v in %v \n", repo.FileError, repo.FullName())
+		} else {
+			repo.File = file
+		}
+	})
+}
+
+// LoadRepoFile returns the registration of a repository, reading its
+// configuration file again once the cached one is older than the cache
+// duration. A new validation error is reported on the issue.
+func (srv *IssueService) LoadRepoFile(ctx context.Context, owner string, name string, number int64) (*Repository, error) {
+	repo, err := srv.repoStore.Get(ctx, owner, name)
+	if err != nil && err != ErrDoesNotExist {
+		return nil, err
+	}
+	var prevError string
+	if repo != nil {
+		if time.Since(time.Unix(repo.FileFetchedAt, 0)) < srv.cfg.RepoFileCacheDuration {
+			return repo, nil
+		}
+		prevError = repo.FileError
+	}
+	refreshed, err := srv.RefreshRepoFile(ctx, owner, name)
+	if err != nil {
+		fmt.Printf("unable to refresh repository file of %v/%v: %v \n", owner, name, err)
+		if repo != nil {
+			return repo, nil
+		}
+		return srv.RegisterRepo(ctx, owner, name)
+	}
+	if refreshed.FileError != "" && refreshed.FileError != prevError {
+		err = srv.enqueueFileWarning(ctx, refreshed, number, "")
+		if err != nil {
+			fmt.Printf("unable to enqueue file warning: %v \n", err)
+		}
+	}
+	return refreshed, nil
+}
+
+// RepoFilePushed reads the configuration file again after a push changed it
+// and warns on the commit if it is invalid.
+func (srv *IssueService) RepoFilePushed(ctx context.Context, owner string, name string, commit string) error {
+	repo, err := srv.RefreshRepoFile(ctx, owner, name)
+	if err != nil {
+		return err
+	}
+	if repo.FileError == "" {
+		// labels or tiers may have changed
+		return srv.enqueueCreateLabels(ctx, repo)
+	}
+	return srv.enqueueFileWarning(ctx, repo, 0, commit)
+}
+
+// isRepoFile returns whether one of the paths is a repository file.
+func isRepoFile(paths ...[]string) bool {
+	for _, list := range paths {
+		for _, path := range list {
+			for _, repoFilePath := range RepoFilePaths {
+				if path == repoFilePath {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// enqueueFileWarning schedules a warning about an invalid repository file,
+// either on an issue or on a commit.
+func (srv *IssueService) enqueueFileWarning(ctx context.Context, repo *Repository, number int64, commit string) error {
+	target := commit
+	if commit == "" {
+		target = fmt.Sprintf("%d", number)
+	}
+	return srv.enqueue(ctx, &OutboxOp{
+		Key:    "file_warning/" + repo.FullName() + "/" + target,
+		Kind:   OutboxFileWarning,
+		Owner:  repo.Owner,
+		Repo:   repo.Name,
+		Number: number,
+		Commit: commit,
+	})
+}
+
+func (srv *IssueService) fileWarning(ctx context.Context, op *OutboxOp) error {
+	repo, err := srv.repoStore.Get(ctx, op.Owner, op.Repo)
+	if err != nil {
+		return err
+	}
+	if repo.FileError == "" {
+		// fixed before the warning went out
+		return nil
+	}
+	body := fmt.Sprintf(":warning: The bounty configuration of this repository is invalid, "+
+		"the last valid configuration stays in use.\n\n```\n%v\n```", repo.FileError)
+	if op.Commit != "" {
+		return srv.ghClient.AddCommitComment(ctx, op.Owner, op.Repo, op.Commit, body)
+	}
+	_, err = srv.ghClient.AddComment(ctx, &BountyIssue{Owner: op.Owner, Repo: op.Repo, Number: op.Number}, body)
+	return err
+}
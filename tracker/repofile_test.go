@@ -0,0 +1,240 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	config "github.com/sputn1ck/github-bounty"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseRepoFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		file    *RepoFile
+		err     string
+	}{
+		{
+			name: "yaml",
+			path: ".github/bounty.yml",
+			content: `
+labels: [bounty, "help wanted"]
+tiers:
+  small: 10000
+tier_mode: pledge
+min_donation: 100
+max_donation: 1000000
+suggested_amounts: [1000, 5000]
+currency: eur
+escrow: until_closed
+payouts:
+  disable_onchain: true
+  min_amount: 50000
+language: de
+goal: 2000000
+hide_leaderboard: true
+`,
+			file: &RepoFile{
+				Labels:           []string{"bounty", "help wanted"},
+				Tiers:            map[string]int64{"small": 10000},
+				TierMode:         TierPledge,
+				MinDonation:      100,
+				MaxDonation:      1000000,
+				SuggestedAmounts: []int64{1000, 5000},
+				Currency:         "EUR",
+				Escrow:           EscrowUntilClosed,
+				Payouts:          &PayoutRules{DisableOnchain: true, MinAmount: 50000},
+				Language:         "de",
+				Goal:             2000000,
+				HideLeaderboard:  true,
+			},
+		},
+		{
+			name: "toml",
+			path: ".bounty.toml",
+			content: `
+labels = ["bounty"]
+currency = "USD"
+escrow = "direct"
+
+[payouts]
+min_amount = 1000
+`,
+			file: &RepoFile{
+				Labels:   []string{"bounty"},
+				Currency: "USD",
+				Escrow:   EscrowDirect,
+				Payouts:  &PayoutRules{MinAmount: 1000},
+			},
+		},
+		{name: "empty", path: ".github/bounty.yml", content: "", file: &RepoFile{}},
+		{name: "unknown yaml key", path: ".github/bounty.yml", content: "lables: [bounty]", err: "lables"},
+		{name: "unknown toml key", path: ".bounty.toml", content: "lables = [\"bounty\"]", err: "unknown keys"},
+		{name: "unknown nested key", path: ".bounty.toml", content: "[payouts]\nminimum = 1", err: "unknown keys"},
+		{name: "malformed yaml", path: ".github/bounty.yml", content: "labels: [bounty", err: "yaml"},
+		{name: "malformed toml", path: ".bounty.toml", content: "labels = ", err: "expected value"},
+		{name: "wrong type", path: ".github/bounty.yml", content: "min_donation: lots", err: "unmarshal"},
+		{name: "empty label", path: ".github/bounty.yml", content: "labels: [\" \"]", err: "empty label"},
+		{name: "invalid tier", path: ".github/bounty.yml", content: "tiers: {small: 0}", err: "invalid tier"},
+		{name: "tier mode", path: ".github/bounty.yml", content: "tier_mode: always", err: "unknown tier mode"},
+		{name: "negative limit", path: ".github/bounty.yml", content: "min_donation: -1", err: "invalid donation limits"},
+		{name: "inverted limits", path: ".github/bounty.yml", content: "min_donation: 10\nmax_donation: 5", err: "above max_donation"},
+		{name: "suggested amount", path: ".github/bounty.yml", content: "max_donation: 100\nsuggested_amounts: [1000]", err: "invalid suggested amount"},
+		{name: "currency", path: ".github/bounty.yml", content: "currency: euro", err: "invalid currency"},
+		{name: "escrow", path: ".github/bounty.yml", content: "escrow: forever", err: "unknown escrow mode"},
+		{name: "payout minimum", path: ".bounty.toml", content: "[payouts]\nmin_amount = -1", err: "invalid payout min_amount"},
+		{name: "language", path: ".github/bounty.yml", content: "language: xx", err: "unsupported language"},
+		{name: "goal", path: ".github/bounty.yml", content: "goal: -5", err: "invalid goal"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := parseRepoFile(test.path, []byte(test.content))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(file, test.file) {
+				t.Fatalf("expected %+v, got %+v", test.file, file)
+			}
+		})
+	}
+}
+
+func TestLoadRepoFile(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		files map[string]string
+		fail  error
+		file  *RepoFile
+		err   string
+	}{
+		{name: "missing file", file: nil},
+		{
+			name:  "yaml takes precedence",
+			files: map[string]string{".github/bounty.yml": "goal: 1000", ".bounty.toml": "goal = 2000"},
+			file:  &RepoFile{Goal: 1000},
+		},
+		{name: "toml", files: map[string]string{".bounty.toml": "goal = 2000"}, file: &RepoFile{Goal: 2000}},
+		{name: "invalid file", files: map[string]string{".bounty.toml": "goal = -1"}, err: "invalid .bounty.toml: invalid goal -1"},
+		{name: "github error", fail: fmt.Errorf("rate limited"), file: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := newTestService(t, func(cfg *config.Config) { cfg.RepoFileCacheDuration = 0 })
+			for path, content := range test.files {
+				ts.github.setFile(path, content)
+			}
+			if test.fail != nil {
+				ts.github.fail("GetRepoFile", test.fail)
+			}
+			repo, err := ts.LoadRepoFile(ctx, "owner", "repo", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(repo.File, test.file) || repo.FileError != test.err {
+				t.Fatalf("expected file %+v and error %q, got %+v and %q", test.file, test.err, repo.File, repo.FileError)
+			}
+		})
+	}
+}
+
+func TestLoadRepoFileKeepsValidFile(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t, func(cfg *config.Config) { cfg.RepoFileCacheDuration = 0 })
+	ts.github.setFile(".github/bounty.yml", "goal: 1000")
+	repo, err := ts.LoadRepoFile(ctx, "owner", "repo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.File == nil || repo.File.Goal != 1000 {
+		t.Fatalf("file wasn't loaded: %+v", repo.File)
+	}
+
+	// an invalid change keeps the last valid file in use and is reported once
+	ts.github.setFile(".github/bounty.yml", "goal: -1")
+	for i := 0; i < 2; i++ {
+		repo, err = ts.LoadRepoFile(ctx, "owner", "repo", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repo.File.Goal != 1000 || repo.FileError == "" {
+			t.Fatalf("expected the valid file and an error, got %+v and %q", repo.File, repo.FileError)
+		}
+	}
+	ops, err := ts.outboxStore.ListAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := 0
+	for _, op := range ops {
+		if op.Kind == OutboxFileWarning {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Fatalf("expected one file warning, got %v", warnings)
+	}
+
+	// cached files aren't read again
+	ts.cfg.RepoFileCacheDuration = config.DefaultRepoFileCacheDuration
+	ts.github.setFile(".github/bounty.yml", "goal: 3000")
+	repo, err = ts.LoadRepoFile(ctx, "owner", "repo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.File.Goal != 1000 {
+		t.Fatalf("cached file was read again: %+v", repo.File)
+	}
+}
+
+func TestRepoUpdatesDontOverwriteEachOther(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	ts.github.setFile(".github/bounty.yml", "goal: 1000")
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for _, update := range []func() error{
+		func() error {
+			_, err := ts.RefreshRepoFile(ctx, "owner", "repo")
+			return err
+		},
+		func() error {
+			_, err := ts.SetRepoCurrency(ctx, "owner", "repo", "USD")
+			return err
+		},
+		func() error {
+			_, err := ts.UpdateInvoiceSettings(ctx, "owner", "repo", &InvoiceSettings{Expiry: 600})
+			return err
+		},
+	} {
+		wg.Add(1)
+		go func(update func() error) {
+			defer wg.Done()
+			errs <- update()
+		}(update)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := ts.GetRepo(ctx, "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.File == nil || repo.File.Goal != 1000 || repo.Currency != "USD" || repo.Invoice.Expiry != 600 {
+		t.Fatalf("concurrent updates were lost: %+v", repo)
+	}
+}
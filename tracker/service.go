@@ -2,25 +2,36 @@ package tracker
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"github.com/lightningnetwork/lnd/lnrpc"
-	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	config "github.com/sputn1ck/github-bounty"
-	"github.com/sputn1ck/github-bounty/lnd"
-	"google.golang.org/grpc"
+	"github.com/sputn1ck/github-bounty/lightning"
+	"github.com/sputn1ck/github-bounty/nostr"
+	"github.com/sputn1ck/github-bounty/rates"
+	"net/http"
 	"sync"
 	"time"
 )
 
 var (
-	InactiveError = fmt.Errorf("Issue is not active")
+	InactiveError    = fmt.Errorf("Issue is not active")
+	NoteTooLongError = fmt.Errorf("donor note is too long")
+	AmountError      = fmt.Errorf("amount must be positive")
+
+	maxNoteLength = 280
+	NoNodeError   = fmt.Errorf("no lightning node given and no default node configured")
+
+	// delay before a listener resubscribes after losing its node
+	listenRetryDelay = time.Second * 5
 )
 
 type BountyIssue struct {
 	Id            int64
 	Bounty        int64
 	Url           string
+	HtmlUrl       string
+	Title         string
 	Active        bool
 	Owner         string
 	Repo          string
@@ -28,102 +39,211 @@ type BountyIssue struct {
 	CommentId     int64
 	Pubkey        string
 	TotalPayments int
-	LndConnect    string
+	// connection uri of the benefactor node, see lightning.Connect
+	LndConnect string
 	// map that matches rhash and whether they are paid
 	Payments map[string]bool
+	// map that matches payment requests and their hex encoded payment hash
+	PaymentHashes map[string]string
+	// on-chain fallback address of the benefactor node
+	OnchainAddress string
+	// map that matches outpoints and the amount credited from them
+	OnchainDeposits map[string]int64
+	Payouts         []*Payout
+	// unix time since the benefactor node is unreachable, 0 if it is online
+	NodeUnreachableSince int64
+	// map that matches payment requests and outpoints to their donation
+	Donations map[string]*Donation
+	// latest exchange rate of the repository currency
+	Rate *rates.Rate
+	// amount of the highest tier label, 0 if the issue has none
+	Tier int64
+	// unix times of the creation and the last closing of the bounty, 0 if
+	// unknown or open
+	CreatedAt int64
+	ClosedAt  int64
+	Claims    []*Claim
+	// id of the latest nostr event of the bounty
+	NostrEventId string
+	// sequence number and hash of the latest ledger entry of the bounty
+	LedgerSeq  uint64
+	LedgerHash string
+}
+
+type Payout struct {
+	Amount    int64
+	Address   string
+	Txid      string
+	Timestamp int64
 }
 
 type GithubCommenter interface {
-	AddComment(ctx context.Context, bountyIssue *BountyIssue) (int64, error)
-	UpdateBountyComment(ctx context.Context, bountyIssue *BountyIssue) error
-	CloseBountyComment(ctx context.Context, bountyIssue *BountyIssue) error
+	AddComment(ctx context.Context, bountyIssue *BountyIssue, body string) (int64, error)
+	EditComment(ctx context.Context, bountyIssue *BountyIssue, body string) error
+	// FindComment returns the id of the bot's comment on an issue that
+	// contains the marker, or 0 if there is none
+	FindComment(ctx context.Context, bountyIssue *BountyIssue, marker string) (int64, error)
+	AddCommitComment(ctx context.Context, owner string, repo string, sha string, body string) error
+	// CreateLabel creates a label, existing labels are left untouched
+	CreateLabel(ctx context.Context, owner string, repo string, name string, color string, description string) error
+	GetRepoFile(ctx context.Context, owner string, repo string, path string) ([]byte, error)
 }
 
 type IssueStore interface {
+	EventLog
 	Add(context.Context, *BountyIssue) error
 	Update(context.Context, *BountyIssue) error
+	// Commit stores the issue together with its events in a single transaction
+	Commit(ctx context.Context, issue *BountyIssue, events ...*Event) error
 	Get(context.Context, int64) (*BountyIssue, error)
 	Delete(context.Context, int64) error
 	ListAll(ctx context.Context) ([]*BountyIssue, error)
 }
 
 type IssueService struct {
-	cfg       *config.Config
-	store     IssueStore
-	ghClient  GithubCommenter
-	lndClient lnrpc.LightningClient
+	cfg         *config.Config
+	store       IssueStore
+	repoStore   RepoStore
+	healthStore HealthStore
+	outboxStore OutboxStore
+	optOutStore OptOutStore
+	// log of notification deliveries
+	deliveryStore DeliveryStore
+	ledgerStore   LedgerStore
+	reconciler    reconciler
+	ghClient      GithubCommenter
+	pool          *lightning.Pool
+	rates         rates.Provider
+	httpClient    *http.Client
+	// nil if emails are disabled
+	mailer Mailer
+	// nil if nostr is disabled, see SetNostr
+	nostrKey *nostr.PrivateKey
+	relays   nostr.Publisher
 	sync.Mutex
+
+	events *EventBus
+	outbox *outbox
+	feed   *feed
+
+	// lndconnect strings of the nodes whose wallets are being watched
+	onchainWatchers map[string]bool
+	watcherMtx      sync.Mutex
+
+	// serializes changes of repositories, see updateRepo
+	repoMtx sync.Mutex
 }
 
-func NewIssueService(cfg *config.Config, store IssueStore, ghClient GithubCommenter, lndClient lnrpc.LightningClient) *IssueService {
-	srv := &IssueService{cfg: cfg, store: store, ghClient: ghClient, lndClient: lndClient}
+func NewIssueService(cfg *config.Config, store IssueStore, repoStore RepoStore, healthStore HealthStore, outboxStore OutboxStore, optOutStore OptOutStore, deliveryStore DeliveryStore, ledgerStore LedgerStore, ghClient GithubCommenter, pool *lightning.Pool, rateProvider rates.Provider) *IssueService {
+	srv := &IssueService{cfg: cfg, store: store, repoStore: repoStore, healthStore: healthStore, outboxStore: outboxStore, optOutStore: optOutStore, deliveryStore: deliveryStore, ledgerStore: ledgerStore, ghClient: ghClient, pool: pool, rates: rateProvider, httpClient: &http.Client{Timeout: cfg.NotifyTimeout}, events: NewEventBus(store), outbox: newOutbox(), feed: newFeed(), onchainWatchers: make(map[string]bool)}
+	if cfg.SmtpAddress != "" {
+		srv.mailer = NewSMTPMailer(cfg.SmtpAddress, cfg.SmtpUser, cfg.SmtpPassword, cfg.SmtpFrom)
+	}
 
 	return srv
 }
 
-func (srv *IssueService) AddBountyIssue(ctx context.Context, id int64, link string, owner string, repo string, number int64, lndconnect string) (*BountyIssue, error) {
-	var bountyIssue *BountyIssue
+// AddBountyIssue creates or reopens the bounty of an issue. The repository is
+// the one the caller loaded with LoadRepoFile to match the labels of the issue.
+func (srv *IssueService) AddBountyIssue(ctx context.Context, id int64, link string, htmlUrl string, title string, repo *Repository, number int64, lndconnect string, tier int64) (*BountyIssue, error) {
+	// the rate and the invoice of new bounties are fetched before taking the
+	// lock, which is held while the issue is read and committed
+	rate := srv.rateSnapshot(ctx, &BountyIssue{Owner: repo.Owner, Repo: repo.Name})
 	existingIssue, err := srv.store.Get(ctx, id)
 	if err != nil && err != ErrDoesNotExist {
 		return nil, err
 	}
-	if existingIssue != nil {
-		existingIssue.Active = true
-		bountyIssue = existingIssue
-	} else {
-		if lndconnect == "" {
-			lndconnect = srv.cfg.LndConnect
-		}
-		bountyIssue = &BountyIssue{
-			Id:         id,
-			Bounty:     0,
-			Url:        link,
-			Active:     true,
-			Owner:      owner,
-			Repo:       repo,
-			Number:     number,
-			LndConnect: lndconnect,
-			Payments:   make(map[string]bool),
-		}
-
-		clientconn, err := lnd.ConnectFromLndConnectWithTimeout(ctx, bountyIssue.LndConnect, time.Second*5)
-		if err != nil {
-			return nil, fmt.Errorf("unable to connect to remote lnd %v", err)
-		}
-		defer clientconn.Close()
-		remoteLndClient := lnrpc.NewLightningClient(clientconn)
-		inv, err := remoteLndClient.AddInvoice(ctx, &lnrpc.Invoice{})
-		if err != nil {
-			return nil, fmt.Errorf("unable to connect to get invoice from remote lnd %v", err)
-		}
-		payreq, err := srv.lndClient.DecodePayReq(ctx, &lnrpc.PayReqString{PayReq: inv.PaymentRequest})
-		if err != nil {
-			return nil, fmt.Errorf("unable to decode invoice %v", err)
-		}
-		bountyIssue.Pubkey = payreq.Destination
-		err = srv.store.Add(ctx, bountyIssue)
-		if err != nil {
-			return nil, err
-		}
-		commentId, err := srv.ghClient.AddComment(ctx, bountyIssue)
+	var created *BountyIssue
+	if existingIssue == nil {
+		created, err = srv.newBountyIssue(ctx, id, link, htmlUrl, title, repo.Owner, repo.Name, number, lndconnect, tier)
 		if err != nil {
 			return nil, err
 		}
-		bountyIssue.CommentId = commentId
 	}
 
-	err = srv.store.Update(ctx, bountyIssue)
-	if err != nil {
+	srv.Lock()
+	defer srv.Unlock()
+	eventType := EventBountyReopened
+	bountyIssue, err := srv.store.Get(ctx, id)
+	switch {
+	case err == ErrDoesNotExist && created != nil:
+		eventType = EventBountyCreated
+		bountyIssue = created
+	case err != nil:
 		return nil, err
+	default:
+		bountyIssue.Active = true
+		bountyIssue.HtmlUrl = htmlUrl
+		bountyIssue.Title = title
+		bountyIssue.Tier = tier
+		bountyIssue.ClosedAt = 0
 	}
-	err = srv.ghClient.UpdateBountyComment(ctx, bountyIssue)
+	updateRate(bountyIssue, rate)
+	err = srv.commit(ctx, bountyIssue, newEvent(eventType, bountyIssue))
 	if err != nil {
 		return nil, err
 	}
+	if bountyIssue.OnchainAddress != "" {
+		srv.watchOnchain(bountyIssue.LndConnect)
+	}
 
 	return bountyIssue, nil
 }
 
+// newBountyIssue returns a bounty for the issue with the pubkey and on-chain
+// address of its benefactor node, the caller commits it.
+func (srv *IssueService) newBountyIssue(ctx context.Context, id int64, link string, htmlUrl string, title string, owner string, repo string, number int64, lndconnect string, tier int64) (*BountyIssue, error) {
+	if lndconnect == "" {
+		lndconnect = srv.cfg.LndConnect
+	}
+	if lndconnect == "" {
+		return nil, NoNodeError
+	}
+	bountyIssue := &BountyIssue{
+		Id:            id,
+		Bounty:        0,
+		Url:           link,
+		HtmlUrl:       htmlUrl,
+		Title:         title,
+		Active:        true,
+		Owner:         owner,
+		Repo:          repo,
+		Number:        number,
+		Tier:          tier,
+		CreatedAt:     time.Now().Unix(),
+		LndConnect:    lndconnect,
+		Payments:      make(map[string]bool),
+		PaymentHashes: make(map[string]string),
+	}
+
+	remoteNode, release, err := srv.pool.Get(ctx, bountyIssue.LndConnect)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to remote node %v", err)
+	}
+	defer release()
+	invoice, err := srv.invoiceRequest(ctx, bountyIssue, 0)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := remoteNode.CreateInvoice(ctx, invoice)
+	if err != nil {
+		srv.reportNodeError(ctx, bountyIssue.LndConnect, err)
+		return nil, fmt.Errorf("unable to connect to get invoice from remote node %v", err)
+	}
+	payreq, err := lightning.DecodePayReq(inv.PaymentRequest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode invoice %v", err)
+	}
+	bountyIssue.Pubkey = payreq.Destination
+	if wallet, ok := remoteNode.(lightning.OnchainWallet); ok {
+		bountyIssue.OnchainAddress, err = wallet.NewAddress(ctx)
+		if err != nil {
+			fmt.Printf("unable to get on-chain address for %v: %v \n", bountyIssue.Id, err)
+		}
+	}
+	return bountyIssue, nil
+}
+
 func (srv *IssueService) CloseIssue(ctx context.Context, id int64) error {
 	srv.Lock()
 	defer srv.Unlock()
@@ -135,18 +255,49 @@ func (srv *IssueService) CloseIssue(ctx context.Context, id int64) error {
 		return err
 	}
 	bountyIssue.Active = false
-	err = srv.store.Update(ctx, bountyIssue)
+	bountyIssue.ClosedAt = time.Now().Unix()
+	return srv.commit(ctx, bountyIssue, newEvent(EventBountyClosed, bountyIssue))
+}
+
+func (srv *IssueService) GetBountyIssue(ctx context.Context, id int64) (*BountyIssue, error) {
+	return srv.store.Get(ctx, id)
+}
+
+// NodeStatuses returns the connectivity state of every benefactor node, keyed
+// by its pubkey.
+func (srv *IssueService) NodeStatuses(ctx context.Context) (map[string]lightning.NodeStatus, error) {
+	bountyIssues, err := srv.store.ListAll(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = srv.ghClient.CloseBountyComment(ctx, bountyIssue)
-	if err != nil {
-		return err
+	statuses := make(map[string]lightning.NodeStatus)
+	for _, bountyIssue := range bountyIssues {
+		if _, ok := statuses[bountyIssue.Pubkey]; ok {
+			continue
+		}
+		statuses[bountyIssue.Pubkey] = srv.pool.Status(bountyIssue.LndConnect)
 	}
-	return nil
+	return statuses, nil
 }
 
-func (srv *IssueService) GetBountyInvoice(ctx context.Context, id, sats int64) (string, error) {
+func (srv *IssueService) GetBountyInvoice(ctx context.Context, id, sats int64, donor *DonorInfo) (string, error) {
+	return srv.createBountyInvoice(ctx, id, sats, &Donation{Note: donor.Note, Donor: donor.Name, Private: donor.Private})
+}
+
+// createBountyInvoice creates a donation invoice, if the donation has no rate
+// the snapshot is taken in the currency of the repository.
+func (srv *IssueService) createBountyInvoice(ctx context.Context, id, sats int64, donation *Donation) (string, error) {
+	if sats <= 0 {
+		return "", AmountError
+	}
+	if len(donation.Note) > maxNoteLength {
+		return "", NoteTooLongError
+	}
+	donor := &DonorInfo{Note: donation.Note, Name: donation.Donor, Private: donation.Private}
+	if err := donor.validate(); err != nil {
+		return "", err
+	}
+	donation.Donor = donor.Name
 	bountyIssue, err := srv.store.Get(ctx, id)
 	if err != nil {
 		return "", err
@@ -154,100 +305,166 @@ func (srv *IssueService) GetBountyInvoice(ctx context.Context, id, sats int64) (
 	if !bountyIssue.Active {
 		return "", InactiveError
 	}
-	clientconn, err := lnd.ConnectFromLndConnectWithTimeout(ctx, bountyIssue.LndConnect, time.Second*5)
+	err = srv.repoFile(ctx, bountyIssue.Owner, bountyIssue.Repo).checkDonation(sats)
+	if err != nil {
+		return "", err
+	}
+	node, release, err := srv.pool.Get(ctx, bountyIssue.LndConnect)
+	if err != nil {
+		fmt.Printf("unable to connect to lightning node of %v: %v \n", id, err)
+		return "", NodeUnreachableError
+	}
+	defer release()
+	invoice, err := srv.invoiceRequest(ctx, bountyIssue, sats)
 	if err != nil {
-		return "", fmt.Errorf("unable to connect to lnd %v", err)
+		return "", err
 	}
-	defer clientconn.Close()
-	lndClient := lnrpc.NewLightningClient(clientconn)
-	expiry := 600
-	invoice := &lnrpc.Invoice{
-		Memo:   fmt.Sprintf("Add bounty on %s", id),
-		Value:  sats,
-		Expiry: int64(expiry),
+	if description := donation.lnurlDescription(); description != "" {
+		descriptionHash := sha256.Sum256([]byte(description))
+		invoice.DescriptionHash = descriptionHash[:]
+		invoice.Description = description
 	}
-	inv, err := lndClient.AddInvoice(ctx, invoice)
+	inv, err := node.CreateInvoice(ctx, invoice)
+	if err != nil {
+		srv.reportNodeError(ctx, bountyIssue.LndConnect, err)
+		return "", err
+	}
+	if donation.Rate == nil {
+		donation.Rate = srv.rateSnapshot(ctx, bountyIssue)
+	}
+
+	srv.Lock()
+	defer srv.Unlock()
+	// the issue may have changed while the invoice was created
+	bountyIssue, err = srv.store.Get(ctx, id)
 	if err != nil {
 		return "", err
 	}
 	bountyIssue.Payments[inv.PaymentRequest] = false
-	err = srv.store.Update(ctx, bountyIssue)
+	if bountyIssue.PaymentHashes == nil {
+		bountyIssue.PaymentHashes = make(map[string]string)
+	}
+	bountyIssue.PaymentHashes[inv.PaymentRequest] = hex.EncodeToString(inv.PaymentHash)
+	if bountyIssue.Donations == nil {
+		bountyIssue.Donations = make(map[string]*Donation)
+	}
+	bountyIssue.Donations[inv.PaymentRequest] = donation
+	event := newEvent(EventInvoiceCreated, bountyIssue)
+	event.Amount = sats
+	event.Invoice = inv.PaymentRequest
+	err = srv.commit(ctx, bountyIssue, event)
 	if err != nil {
 		return "", err
 	}
 
-	go srv.ListenPayment(bountyIssue, inv.RHash, inv.PaymentRequest, invoice.Value)
+	go srv.ListenPayment(bountyIssue, inv.PaymentHash, inv.PaymentRequest, invoice.Value)
 
 	return inv.PaymentRequest, nil
 }
 
 func (srv *IssueService) ListenPayment(issue *BountyIssue, rHash []byte, payreqString string, sats int64) {
 	fmt.Printf("started listening on payment invoice %v on %v \n", payreqString, issue)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(120))
+	ctx, cancel := context.WithTimeout(context.Background(), listenTimeout(payreqString))
 	defer cancel()
-	clientconn, err := lnd.ConnectFromLndConnectWithTimeout(ctx, issue.LndConnect, time.Second*10)
-	if err != nil {
-		fmt.Printf("unable to connect to lnd %v", err)
-		return
-	}
-	defer clientconn.Close()
-	invoicesClient := invoicesrpc.NewInvoicesClient(clientconn)
-	invoicesSub, err := invoicesClient.SubscribeSingleInvoice(ctx, &invoicesrpc.SubscribeSingleInvoiceRequest{
-		RHash: rHash,
-	})
 	for {
+		if !srv.listenPayment(ctx, issue, rHash, payreqString, sats) {
+			return
+		}
+		// the node was unreachable, resubscribe once the pool reconnected
 		select {
 		case <-ctx.Done():
 			return
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+// listenPayment waits for the invoice to be settled or canceled and returns
+// whether it should be retried because the node couldn't be reached.
+func (srv *IssueService) listenPayment(ctx context.Context, issue *BountyIssue, rHash []byte, payreqString string, sats int64) bool {
+	node, release, err := srv.pool.Get(ctx, issue.LndConnect)
+	if err != nil {
+		fmt.Printf("unable to connect to lightning node %v \n", err)
+		return ctx.Err() == nil
+	}
+	defer release()
+	invoicesSub, err := node.SubscribeInvoice(ctx, rHash)
+	if err != nil {
+		srv.reportNodeError(ctx, issue.LndConnect, err)
+		fmt.Printf("unable to subscribe to invoice %v \n", err)
+		return lightning.IsConnectionError(err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return false
 		default:
 			inv, err := invoicesSub.Recv()
 			if err != nil {
-				fmt.Printf("unable to receive invoice %v", err)
-				return
+				srv.reportNodeError(ctx, issue.LndConnect, err)
+				fmt.Printf("unable to receive invoice %v \n", err)
+				return lightning.IsConnectionError(err)
 			}
-			if inv.State == lnrpc.Invoice_SETTLED {
-				err = srv.SettleInvoice(ctx, issue, payreqString, sats)
+			if inv.State == lightning.InvoiceSettled {
+				if inv.Value > 0 {
+					// credit the received amount, donors can pay more than requested
+					sats = inv.Value
+				}
+				err = srv.SettleInvoice(ctx, issue, payreqString, sats, inv.Preimage)
 				if err != nil {
 					fmt.Printf("unable to settle invoice %v", err)
-					return
 				}
-			} else if inv.State == lnrpc.Invoice_CANCELED {
+				return false
+			} else if inv.State == lightning.InvoiceCanceled {
 				err = srv.RemovePayment(ctx, issue, payreqString)
 				if err != nil {
 					fmt.Printf("unable to settle invoice %v", err)
-					return
 				}
+				return false
 			}
 		}
 	}
 }
-func (srv *IssueService) SettleInvoice(ctx context.Context, issue *BountyIssue, payreqString string, sats int64) error {
+
+func (srv *IssueService) SettleInvoice(ctx context.Context, issue *BountyIssue, payreqString string, sats int64, preimage []byte) error {
+	rate := srv.rateSnapshot(ctx, issue)
 	srv.Lock()
 	defer srv.Unlock()
-	fmt.Printf("settled invoice %v on %v \n", payreqString, issue)
-	issue.Bounty += sats
-	issue.TotalPayments += 1
-	issue.Payments[payreqString] = true
-	err := srv.store.Update(ctx, issue)
+	issue, err := srv.store.Get(ctx, issue.Id)
 	if err != nil {
 		return err
 	}
-	err = srv.ghClient.UpdateBountyComment(ctx, issue)
-	if err != nil {
-		return err
+	if issue.Payments[payreqString] {
+		return nil
 	}
-	return nil
+	fmt.Printf("settled invoice %v on %v \n", payreqString, issue)
+	issue.Bounty += sats
+	issue.TotalPayments += 1
+	issue.Payments[payreqString] = true
+	srv.recordDonation(issue, payreqString, sats, rate)
+	issue.Donations[payreqString].Preimage = hex.EncodeToString(preimage)
+	updateRate(issue, rate)
+	event := newEvent(EventInvoiceSettled, issue)
+	event.Amount = sats
+	event.Invoice = payreqString
+	return srv.commit(ctx, issue, event)
 }
 func (srv *IssueService) RemovePayment(ctx context.Context, issue *BountyIssue, payreqString string) error {
 	srv.Lock()
 	defer srv.Unlock()
-	delete(issue.Payments, payreqString)
-	fmt.Printf("removed invoice %v on %v \n", payreqString, issue)
-	err := srv.store.Update(ctx, issue)
+	issue, err := srv.store.Get(ctx, issue.Id)
 	if err != nil {
 		return err
 	}
-	return nil
+	if paid, ok := issue.Payments[payreqString]; !ok || paid {
+		return nil
+	}
+	delete(issue.Payments, payreqString)
+	delete(issue.Donations, payreqString)
+	fmt.Printf("removed invoice %v on %v \n", payreqString, issue)
+	event := newEvent(EventInvoiceCanceled, issue)
+	event.Invoice = payreqString
+	return srv.commit(ctx, issue, event)
 }
 
 func (srv *IssueService) RecoverPayments(ctx context.Context) error {
@@ -263,51 +480,48 @@ func (srv *IssueService) RecoverPayments(ctx context.Context) error {
 	}
 	return nil
 }
-func (srv *IssueService) checkPayment(ctx context.Context, lndClient lnrpc.LightningClient, issue *BountyIssue, payreqString string) error {
-	payreq, err := srv.lndClient.DecodePayReq(ctx, &lnrpc.PayReqString{PayReq: payreqString})
+func (srv *IssueService) checkPayment(ctx context.Context, node lightning.LightningNode, issue *BountyIssue, payreqString string) error {
+	rHashBytes, err := paymentHash(issue, payreqString)
 	if err != nil {
 		return err
 	}
-	rHashBytes, err := hex.DecodeString(payreq.PaymentHash)
-	if err != nil {
-		return err
-	}
-	invoice, err := lndClient.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: rHashBytes})
+	invoice, err := node.LookupInvoice(ctx, rHashBytes)
 	if err != nil {
+		srv.reportNodeError(ctx, issue.LndConnect, err)
 		return err
 	}
 	switch invoice.State {
-	case lnrpc.Invoice_SETTLED:
-		err = srv.SettleInvoice(ctx, issue, payreqString, invoice.Value)
+	case lightning.InvoiceSettled:
+		err = srv.SettleInvoice(ctx, issue, payreqString, invoice.Value, invoice.Preimage)
 		if err != nil {
 			return err
 		}
 		return nil
 
-	case lnrpc.Invoice_CANCELED:
+	case lightning.InvoiceCanceled:
 		err = srv.RemovePayment(ctx, issue, payreqString)
 		if err != nil {
 			return err
 		}
 		return nil
-	case lnrpc.Invoice_OPEN:
+	case lightning.InvoiceOpen:
 		fallthrough
-	case lnrpc.Invoice_ACCEPTED:
+	case lightning.InvoiceAccepted:
 		go srv.ListenPayment(issue, rHashBytes, payreqString, invoice.Value)
 	}
 	return nil
 }
 func (srv *IssueService) handleBountyIssueRecovery(ctx context.Context, issue *BountyIssue) error {
-	cc, err := clientConnFromIssue(ctx, issue)
+	node, release, err := srv.pool.Get(ctx, issue.LndConnect)
 	if err != nil {
 		return err
 	}
-	defer cc.Close()
+	defer release()
 	for payreqString, v := range issue.Payments {
 		if v {
 			continue
 		}
-		err = srv.checkPayment(ctx, lnrpc.NewLightningClient(cc), issue, payreqString)
+		err = srv.checkPayment(ctx, node, issue, payreqString)
 		if err != nil {
 			fmt.Printf("error checking payment ond %s:  %v \n", payreqString, err)
 		}
@@ -315,10 +529,26 @@ func (srv *IssueService) handleBountyIssueRecovery(ctx context.Context, issue *B
 	return nil
 }
 
-func clientConnFromIssue(ctx context.Context, issue *BountyIssue) (*grpc.ClientConn, error) {
-	clientconn, err := lnd.ConnectFromLndConnectWithTimeout(ctx, issue.LndConnect, time.Second*10)
+// listenTimeout returns the time until the invoice expires, invoices are
+// watched a minute longer so late settlements are still seen.
+func listenTimeout(payreqString string) time.Duration {
+	payreq, err := lightning.DecodePayReq(payreqString)
+	if err != nil {
+		return time.Second * 120
+	}
+	expiry := time.Unix(payreq.Timestamp+payreq.Expiry, 0)
+	return time.Until(expiry) + time.Minute
+}
+
+// paymentHash returns the payment hash stored at invoice creation, invoices
+// created before hashes were stored are decoded.
+func paymentHash(issue *BountyIssue, payreqString string) ([]byte, error) {
+	if hash, ok := issue.PaymentHashes[payreqString]; ok {
+		return hex.DecodeString(hash)
+	}
+	payreq, err := lightning.DecodePayReq(payreqString)
 	if err != nil {
 		return nil, err
 	}
-	return clientconn, err
+	return payreq.PaymentHash, nil
 }
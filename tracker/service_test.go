@@ -0,0 +1,96 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBountyInvoiceAmount(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	for _, sats := range []int64{0, -1000} {
+		_, err := ts.GetBountyInvoice(ctx, issue.Id, sats, &DonorInfo{})
+		if err != AmountError {
+			t.Fatalf("expected amount error for %v sats, got %v", sats, err)
+		}
+	}
+	payreq, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ts.issue(t, issue.Id).Payments[payreq]; !ok {
+		t.Fatal("invoice wasn't stored")
+	}
+}
+
+func TestHandleInvoiceAmount(t *testing.T) {
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	for _, test := range []struct {
+		query  string
+		status int
+	}{
+		{"amt=1000", http.StatusOK},
+		{"amt=0", http.StatusBadRequest},
+		{"amt=-1000", http.StatusBadRequest},
+		{"amt=abc", http.StatusBadRequest},
+		{"amt=5&currency=usd", http.StatusOK},
+		{"amt=0&currency=usd", http.StatusBadRequest},
+		{"amt=-5&currency=usd", http.StatusBadRequest},
+		{"amt=NaN&currency=usd", http.StatusBadRequest},
+		{"amt=Inf&currency=usd", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%v?issue_id=%v&%v", invoicePath, issue.Id, test.query), nil)
+		w := httptest.NewRecorder()
+		wh.handleInvoice(w, r, nil)
+		if w.Code != test.status {
+			t.Fatalf("expected status %v for %v, got %v: %s", test.status, test.query, w.Code, w.Body)
+		}
+	}
+	if payments := len(ts.issue(t, issue.Id).Payments); payments != 2 {
+		t.Fatalf("expected 2 invoices, got %v", payments)
+	}
+}
+
+func TestListenPaymentResubscribes(t *testing.T) {
+	defer func(delay time.Duration) {
+		listenRetryDelay = delay
+	}(listenRetryDelay)
+	listenRetryDelay = time.Millisecond * 50
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	var payreqs []string
+	for i := 0; i < 2; i++ {
+		payreq, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payreqs = append(payreqs, payreq)
+	}
+	eventually(t, func() bool {
+		ts.node.Lock()
+		defer ts.node.Unlock()
+		return len(ts.node.subs) == 2
+	}, "listeners didn't subscribe")
+
+	// the connection drops under both listeners and the invoices are paid
+	// while they reconnect
+	ts.node.dropSubscriptions()
+	for _, payreq := range payreqs {
+		ts.node.settle(payreq, 1000)
+	}
+	eventually(t, func() bool {
+		issue := ts.issue(t, issue.Id)
+		return issue.Payments[payreqs[0]] && issue.Payments[payreqs[1]]
+	}, "listeners didn't resubscribe after losing the node")
+	if bounty := ts.issue(t, issue.Id).Bounty; bounty != 2000 {
+		t.Fatalf("expected both donations to be credited, got %v", bounty)
+	}
+}
@@ -1,15 +1,22 @@
 package tracker
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"github.com/coreos/bbolt"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	bountyIssuesBucket = []byte("bounty_issues")
+	eventsBucket       = []byte("events")
+	eventCursorsBucket = []byte("event_cursors")
+	deadEventsBucket   = []byte("dead_events")
 	ErrDoesNotExist    = fmt.Errorf("does not exist")
 )
 
@@ -66,6 +73,203 @@ func (store *BountyIssueStore) Update(ctx context.Context, issue *BountyIssue) e
 	return tx.Commit()
 }
 
+// Commit stores the issue and appends its events to the event log in a single
+// transaction, the events get their sequence numbers assigned.
+func (store *BountyIssueStore) Commit(ctx context.Context, issue *BountyIssue, events ...*Event) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(bountyIssuesBucket)
+	eb := tx.Bucket(eventsBucket)
+	if b == nil || eb == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData, err := json.Marshal(issue)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(strconv.Itoa(int(issue.Id))), jData); err != nil {
+		return err
+	}
+	for _, event := range events {
+		seq, err := eb.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.Seq = seq
+		jData, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := eb.Put(seqKey(seq), jData); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		for _, event := range events {
+			event.Seq = 0
+		}
+		return err
+	}
+	return nil
+}
+
+// EventsSince returns up to limit events with a sequence number greater than
+// seq.
+func (store *BountyIssueStore) EventsSince(ctx context.Context, seq uint64, limit int) ([]*Event, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(eventsBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	var events []*Event
+	c := b.Cursor()
+	for k, v := c.Seek(seqKey(seq + 1)); k != nil && len(events) < limit; k, v = c.Next() {
+		event := &Event{}
+		if err := json.Unmarshal(v, event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// LastEventSeq returns the sequence number of the latest event.
+func (store *BountyIssueStore) LastEventSeq(ctx context.Context) (uint64, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(eventsBucket)
+	if b == nil {
+		return 0, fmt.Errorf("bucket nil")
+	}
+	return b.Sequence(), nil
+}
+
+// GetCursor returns the sequence number of the last event a handler
+// processed.
+func (store *BountyIssueStore) GetCursor(ctx context.Context, name string) (uint64, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(eventCursorsBucket)
+	if b == nil {
+		return 0, fmt.Errorf("bucket nil")
+	}
+	seq := b.Get([]byte(name))
+	if seq == nil {
+		return 0, ErrDoesNotExist
+	}
+	return binary.BigEndian.Uint64(seq), nil
+}
+
+func (store *BountyIssueStore) PutCursor(ctx context.Context, name string, seq uint64) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(eventCursorsBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	if err := b.Put([]byte(name), seqKey(seq)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *BountyIssueStore) PutDeadEvent(ctx context.Context, deadEvent *DeadEvent) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(deadEventsBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData, err := json.Marshal(deadEvent)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(deadEventKey(deadEvent.Handler, deadEvent.Event.Seq), jData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *BountyIssueStore) DeleteDeadEvent(ctx context.Context, handler string, seq uint64) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(deadEventsBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	if err := b.Delete(deadEventKey(handler, seq)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListDeadEvents returns the dead events ordered by handler and sequence
+// number.
+func (store *BountyIssueStore) ListDeadEvents(ctx context.Context) ([]*DeadEvent, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(deadEventsBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	var dead []*DeadEvent
+	err = b.ForEach(func(k, v []byte) error {
+		deadEvent := &DeadEvent{}
+		if err := json.Unmarshal(v, deadEvent); err != nil {
+			return err
+		}
+		dead = append(dead, deadEvent)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dead, nil
+}
+
+func deadEventKey(handler string, seq uint64) []byte {
+	return append([]byte(handler+"/"), seqKey(seq)...)
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
 func (store *BountyIssueStore) Get(ctx context.Context, id int64) (*BountyIssue, error) {
 	tx, err := store.db.Begin(false)
 	if err != nil {
@@ -141,12 +345,778 @@ func NewBountyIssueStore(db *bbolt.DB) (*BountyIssueStore, error) {
 		return nil, err
 	}
 	defer tx.Rollback()
-	_, err = tx.CreateBucketIfNotExists(bountyIssuesBucket)
+	for _, bucket := range [][]byte{bountyIssuesBucket, eventsBucket, eventCursorsBucket, deadEventsBucket} {
+		_, err = tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &BountyIssueStore{db: db}, nil
+}
+
+var (
+	nodeHealthBucket = []byte("node_health")
+)
+
+type NodeHealthStore struct {
+	db *bbolt.DB
+}
+
+func (store *NodeHealthStore) Put(ctx context.Context, health *NodeHealth) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(nodeHealthBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData, err := json.Marshal(health)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(health.Pubkey), jData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *NodeHealthStore) Get(ctx context.Context, pubkey string) (*NodeHealth, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(nodeHealthBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+
+	jData := b.Get([]byte(pubkey))
+	if jData == nil {
+		return nil, ErrDoesNotExist
+	}
+
+	health := &NodeHealth{}
+	if err := json.Unmarshal(jData, health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+func NewNodeHealthStore(db *bbolt.DB) (*NodeHealthStore, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.CreateBucketIfNotExists(nodeHealthBucket)
 	if err != nil {
 		return nil, err
 	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
-	return &BountyIssueStore{db: db}, nil
+	return &NodeHealthStore{db: db}, nil
+}
+
+var (
+	reposBucket = []byte("repos")
+)
+
+type RepositoryStore struct {
+	db *bbolt.DB
+}
+
+func (store *RepositoryStore) Put(ctx context.Context, repo *Repository) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(reposBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData, err := json.Marshal(repo)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(repo.FullName()), jData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *RepositoryStore) Get(ctx context.Context, owner string, name string) (*Repository, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(reposBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+
+	jData := b.Get([]byte(owner + "/" + name))
+	if jData == nil {
+		return nil, ErrDoesNotExist
+	}
+
+	repo := &Repository{}
+	if err := json.Unmarshal(jData, repo); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+func (store *RepositoryStore) ListAll(ctx context.Context) ([]*Repository, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(reposBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	var repos []*Repository
+	err = b.ForEach(func(k, v []byte) error {
+		repo := &Repository{}
+		if err := json.Unmarshal(v, repo); err != nil {
+			return err
+		}
+		repos = append(repos, repo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+func NewRepositoryStore(db *bbolt.DB) (*RepositoryStore, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.CreateBucketIfNotExists(reposBucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &RepositoryStore{db: db}, nil
+}
+
+var (
+	// keeps its original name, so pending operations survive upgrades
+	outboxBucket     = []byte("github_outbox")
+	deadOutboxBucket = []byte("dead_outbox")
+)
+
+// PendingOperationStore holds the operations of the outbox by key, and the
+// operations that were given up on in a separate bucket.
+type PendingOperationStore struct {
+	db *bbolt.DB
+}
+
+func (store *PendingOperationStore) Enqueue(ctx context.Context, op *OutboxOp) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(outboxBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	if jData := b.Get([]byte(op.Key)); jData != nil {
+		pending := &OutboxOp{}
+		if err := json.Unmarshal(jData, pending); err != nil {
+			return err
+		}
+		pending.Version++
+		op = pending
+	}
+	jData, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(op.Key), jData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PendingOperationStore) Complete(ctx context.Context, key string, version int64) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(outboxBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData := b.Get([]byte(key))
+	if jData == nil {
+		return nil
+	}
+	op := &OutboxOp{}
+	if err := json.Unmarshal(jData, op); err != nil {
+		return err
+	}
+	if op.Version != version {
+		// enqueued again while it was executed
+		return nil
+	}
+	if err := b.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PendingOperationStore) Reschedule(ctx context.Context, op *OutboxOp) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(outboxBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData := b.Get([]byte(op.Key))
+	if jData == nil {
+		return ErrDoesNotExist
+	}
+	pending := &OutboxOp{}
+	if err := json.Unmarshal(jData, pending); err != nil {
+		return err
+	}
+	pending.Attempts = op.Attempts
+	pending.NextAttempt = op.NextAttempt
+	pending.LastError = op.LastError
+	jData, err = json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(op.Key), jData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PendingOperationStore) Delete(ctx context.Context, key string) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(outboxBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	if err := b.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PendingOperationStore) ListAll(ctx context.Context) ([]*OutboxOp, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(outboxBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	var ops []*OutboxOp
+	err = b.ForEach(func(k, v []byte) error {
+		op := &OutboxOp{}
+		if err := json.Unmarshal(v, op); err != nil {
+			return err
+		}
+		ops = append(ops, op)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// DeadLetter moves an operation out of the outbox into the dead operations.
+func (store *PendingOperationStore) DeadLetter(ctx context.Context, op *OutboxOp) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(outboxBucket)
+	dead := tx.Bucket(deadOutboxBucket)
+	if b == nil || dead == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if err := dead.Put([]byte(op.Key), jData); err != nil {
+		return err
+	}
+	if err := b.Delete([]byte(op.Key)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Revive moves a dead operation back into the outbox with its attempts reset.
+// A pending operation with the same key is left as is, as it supersedes the
+// dead one.
+func (store *PendingOperationStore) Revive(ctx context.Context, key string, now int64) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(outboxBucket)
+	dead := tx.Bucket(deadOutboxBucket)
+	if b == nil || dead == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData := dead.Get([]byte(key))
+	if jData == nil {
+		return ErrDoesNotExist
+	}
+	if b.Get([]byte(key)) == nil {
+		op := &OutboxOp{}
+		if err := json.Unmarshal(jData, op); err != nil {
+			return err
+		}
+		op.Attempts = 0
+		op.NextAttempt = now
+		op.LastError = ""
+		jData, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(key), jData); err != nil {
+			return err
+		}
+	}
+	if err := dead.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PendingOperationStore) ListDead(ctx context.Context) ([]*OutboxOp, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(deadOutboxBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	var ops []*OutboxOp
+	err = b.ForEach(func(k, v []byte) error {
+		op := &OutboxOp{}
+		if err := json.Unmarshal(v, op); err != nil {
+			return err
+		}
+		ops = append(ops, op)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func NewPendingOperationStore(db *bbolt.DB) (*PendingOperationStore, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.CreateBucketIfNotExists(outboxBucket)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.CreateBucketIfNotExists(deadOutboxBucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &PendingOperationStore{db: db}, nil
+}
+
+var (
+	optOutsBucket = []byte("leaderboard_opt_outs")
+)
+
+// LeaderboardOptOutStore holds the lowercased logins and donor names that are
+// hidden from leaderboards, keyed by the name with the opt out time as value.
+type LeaderboardOptOutStore struct {
+	db *bbolt.DB
+}
+
+func (store *LeaderboardOptOutStore) Put(ctx context.Context, login string) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(optOutsBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData, err := json.Marshal(time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(login), jData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *LeaderboardOptOutStore) Delete(ctx context.Context, login string) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(optOutsBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	if err := b.Delete([]byte(login)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *LeaderboardOptOutStore) ListAll(ctx context.Context) ([]string, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(optOutsBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	var logins []string
+	err = b.ForEach(func(k, v []byte) error {
+		logins = append(logins, string(k))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logins, nil
+}
+
+func NewLeaderboardOptOutStore(db *bbolt.DB) (*LeaderboardOptOutStore, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.CreateBucketIfNotExists(optOutsBucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &LeaderboardOptOutStore{db: db}, nil
+}
+
+var (
+	deliveriesBucket = []byte("notification_deliveries")
+)
+
+// NotificationDeliveryStore is the log of notification deliveries, keyed by a
+// sequence number. The oldest deliveries are dropped once the log is full.
+type NotificationDeliveryStore struct {
+	db *bbolt.DB
+}
+
+func (store *NotificationDeliveryStore) Add(ctx context.Context, delivery *Delivery) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(deliveriesBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	jData, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(seqKey(seq), jData); err != nil {
+		return err
+	}
+	if seq > deliveryLogSize {
+		oldest := seqKey(seq - deliveryLogSize + 1)
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *NotificationDeliveryStore) List(ctx context.Context, owner string, name string, limit int) ([]*Delivery, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(deliveriesBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	var deliveries []*Delivery
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil && len(deliveries) < limit; k, v = c.Prev() {
+		delivery := &Delivery{}
+		if err := json.Unmarshal(v, delivery); err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(delivery.Owner, owner) || !strings.EqualFold(delivery.Repo, name) {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func NewNotificationDeliveryStore(db *bbolt.DB) (*NotificationDeliveryStore, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.CreateBucketIfNotExists(deliveriesBucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &NotificationDeliveryStore{db: db}, nil
+}
+
+var (
+	ledgerBucket        = []byte("ledger")
+	ledgerKeysBucket    = []byte("ledger_keys")
+	ledgerAnchorsBucket = []byte("ledger_anchors")
+)
+
+// BountyLedgerStore is the hash-chained ledger, entries are keyed by their sequence
+// number and the keys of appended entries are indexed to skip duplicates.
+type BountyLedgerStore struct {
+	db *bbolt.DB
+}
+
+func (store *BountyLedgerStore) Append(ctx context.Context, key string, entry *LedgerEntry) (*LedgerEntry, error) {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(ledgerBucket)
+	keys := tx.Bucket(ledgerKeysBucket)
+	if b == nil || keys == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	if seq := keys.Get([]byte(key)); seq != nil {
+		existing := &LedgerEntry{}
+		if err := json.Unmarshal(b.Get(seq), existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	entry.PrevHash = ""
+	if _, v := b.Cursor().Last(); v != nil {
+		head := &LedgerEntry{}
+		if err := json.Unmarshal(v, head); err != nil {
+			return nil, err
+		}
+		entry.PrevHash = head.Hash
+	}
+	entry.Seq, err = b.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	entry.Hash = entry.ComputeHash()
+	jData, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Put(seqKey(entry.Seq), jData); err != nil {
+		return nil, err
+	}
+	if err := keys.Put([]byte(key), seqKey(entry.Seq)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (store *BountyLedgerStore) List(ctx context.Context, since uint64, limit int) ([]*LedgerEntry, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(ledgerBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	entries := []*LedgerEntry{}
+	c := b.Cursor()
+	for k, v := c.Seek(seqKey(since + 1)); k != nil && len(entries) < limit; k, v = c.Next() {
+		entry := &LedgerEntry{}
+		if err := json.Unmarshal(v, entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (store *BountyLedgerStore) Head(ctx context.Context) (*LedgerEntry, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(ledgerBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	_, v := b.Cursor().Last()
+	if v == nil {
+		return nil, ErrDoesNotExist
+	}
+	entry := &LedgerEntry{}
+	if err := json.Unmarshal(v, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (store *BountyLedgerStore) AddAnchor(ctx context.Context, anchor *LedgerAnchor) error {
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(ledgerAnchorsBucket)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+	jData, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(seqKey(anchor.Seq), jData); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *BountyLedgerStore) ListAnchors(ctx context.Context) ([]*LedgerAnchor, error) {
+	tx, err := store.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(ledgerAnchorsBucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+	anchors := []*LedgerAnchor{}
+	err = b.ForEach(func(k, v []byte) error {
+		anchor := &LedgerAnchor{}
+		if err := json.Unmarshal(v, anchor); err != nil {
+			return err
+		}
+		anchors = append(anchors, anchor)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return anchors, nil
+}
+
+func NewBountyLedgerStore(db *bbolt.DB) (*BountyLedgerStore, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, bucket := range [][]byte{ledgerBucket, ledgerKeysBucket, ledgerAnchorsBucket} {
+		_, err = tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &BountyLedgerStore{db: db}, nil
 }
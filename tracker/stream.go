@@ -0,0 +1,151 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventsPath = "/events"
+	repokey    = "repo"
+	typeskey   = "types"
+
+	wsWriteTimeout = time.Second * 10
+)
+
+// handleEvents streams bounty events as server sent events, or over a
+// websocket if the client requests an upgrade. Events can be filtered with
+// the repo, issue_id and types query parameters. Payment requests are left
+// out, as anyone could pay them once they are public.
+func (wh *WebhookHandler) handleEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid input %v", err))
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		wh.streamWebsocket(w, r, filter)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	events, cancel := wh.is.SubscribeEvents(filter)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			data, err := json.Marshal(publicEvent(event))
+			if err != nil {
+				log.Printf("unable to marshal event %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (wh *WebhookHandler) streamWebsocket(w http.ResponseWriter, r *http.Request, filter *EventFilter) {
+	upgrader := websocket.Upgrader{CheckOrigin: wh.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error
+		return
+	}
+	defer conn.Close()
+	events, cancel := wh.is.SubscribeEvents(filter)
+	defer cancel()
+
+	// the client doesn't send anything, reading is only needed to notice
+	// when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case event := <-events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteJSON(publicEvent(event))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// checkOrigin only lets browsers open websockets from the pages of the bot,
+// clients that don't send an origin aren't browsers and are allowed.
+func (wh *WebhookHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originUrl, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(originUrl.Host, r.Host) {
+		return true
+	}
+	httpUrl, err := url.Parse(wh.cfg.HttpUrl)
+	return err == nil && strings.EqualFold(originUrl.Scheme, httpUrl.Scheme) && strings.EqualFold(originUrl.Host, httpUrl.Host)
+}
+
+// publicEvent returns a copy of the event without its payment request.
+func publicEvent(event *Event) *Event {
+	public := *event
+	public.Invoice = ""
+	return &public
+}
+
+func parseEventFilter(r *http.Request) (*EventFilter, error) {
+	query := r.URL.Query()
+	filter := &EventFilter{Repo: query.Get(repokey)}
+	if issueId := query.Get(issueidkey); issueId != "" {
+		id, err := strconv.ParseInt(issueId, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		filter.IssueId = id
+	}
+	if types := query.Get(typeskey); types != "" {
+		for _, eventType := range strings.Split(types, ",") {
+			filter.Types = append(filter.Types, EventType(strings.TrimSpace(eventType)))
+		}
+	}
+	return filter, nil
+}
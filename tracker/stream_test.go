@@ -0,0 +1,267 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	ts := newTestService(t)
+	ts.cfg.HttpUrl = "https://bounties.example.com"
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	for _, test := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://bounties.example.com", true},
+		{"http://localhost:8123", true},
+		{"http://bounties.example.com", false},
+		{"https://evil.example.com", false},
+		{"null", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8123/events", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if allowed := wh.checkOrigin(r); allowed != test.allowed {
+			t.Fatalf("expected %v for origin %v, got %v", test.allowed, test.origin, allowed)
+		}
+	}
+}
+
+func TestEventStreamHidesInvoices(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wh.handleEvents(w, r, nil)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?types=invoice_created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, err = ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		event := &Event{}
+		err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != EventInvoiceCreated || event.Amount != 0 && event.Invoice != "" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		if event.Invoice != "" {
+			t.Fatalf("payment request was streamed: %v", event.Invoice)
+		}
+		break
+	}
+
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+	_, resp, err = websocket.DefaultDialer.Dial(wsUrl, http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("websocket from another origin was accepted: %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl+"?types=invoice_created", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the subscription starts after the upgrade
+	time.Sleep(time.Millisecond * 50)
+	_, err = ts.GetBountyInvoice(ctx, issue.Id, 2000, &DonorInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &Event{}
+	err = conn.ReadJSON(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventInvoiceCreated || event.Amount != 2000 || event.Invoice != "" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+// eventServer serves the event stream of a test service.
+func eventServer(t *testing.T, ts *testService) *httptest.Server {
+	wh := &WebhookHandler{is: ts.IssueService, cfg: ts.cfg}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wh.handleEvents(w, r, nil)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (ts *testService) subscriberCount() int {
+	ts.events.Lock()
+	defer ts.events.Unlock()
+	return len(ts.events.subscribers)
+}
+
+// streamTestEvents returns events of which only the last matches the filter
+// owner/repo, issue 2 and invoice_settled.
+func streamTestEvents() []*Event {
+	events := []*Event{
+		{Type: EventInvoiceSettled, IssueId: 2, Owner: "other", Repo: "repo"},
+		{Type: EventInvoiceSettled, IssueId: 1, Owner: "owner", Repo: "repo"},
+		{Type: EventInvoiceCreated, IssueId: 2, Owner: "owner", Repo: "repo"},
+		{Type: EventInvoiceSettled, IssueId: 2, Owner: "Owner", Repo: "Repo", Amount: 1000},
+	}
+	for i, event := range events {
+		event.Seq = uint64(i + 1)
+		event.Invoice = "lnbcrt1invoice"
+	}
+	return events
+}
+
+const streamTestQuery = "?repo=owner/repo&issue_id=2&types=invoice_settled,%20payout"
+
+func TestParseEventFilter(t *testing.T) {
+	for _, test := range []struct {
+		query  string
+		filter *EventFilter
+		ok     bool
+	}{
+		{"", &EventFilter{}, true},
+		{streamTestQuery, &EventFilter{Repo: "owner/repo", IssueId: 2, Types: []EventType{EventInvoiceSettled, EventPayout}}, true},
+		{"?issue_id=abc", nil, false},
+	} {
+		filter, err := parseEventFilter(httptest.NewRequest(http.MethodGet, "/events"+test.query, nil))
+		if (err == nil) != test.ok {
+			t.Fatalf("unexpected error for %q: %v", test.query, err)
+		}
+		if test.ok && (filter.Repo != test.filter.Repo || filter.IssueId != test.filter.IssueId ||
+			strings.Join(eventTypes(filter.Types), ",") != strings.Join(eventTypes(test.filter.Types), ",")) {
+			t.Fatalf("expected filter %+v for %q, got %+v", test.filter, test.query, filter)
+		}
+	}
+
+	server := eventServer(t, newTestService(t))
+	resp, err := http.Get(server.URL + "?issue_id=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid filter to be rejected, got %v", resp.Status)
+	}
+}
+
+func eventTypes(types []EventType) []string {
+	var names []string
+	for _, eventType := range types {
+		names = append(names, string(eventType))
+	}
+	return names
+}
+
+func TestPublicEvent(t *testing.T) {
+	event := &Event{Seq: 1, Type: EventInvoiceSettled, IssueId: 1, Amount: 1000, Invoice: "lnbcrt1invoice", Txid: "txid"}
+	public := publicEvent(event)
+	if public.Invoice != "" {
+		t.Fatalf("payment request wasn't removed: %+v", public)
+	}
+	if event.Invoice != "lnbcrt1invoice" {
+		t.Fatal("the published event was changed")
+	}
+	public.Invoice = event.Invoice
+	if *public != *event {
+		t.Fatalf("expected the other fields to be kept, got %+v", public)
+	}
+}
+
+func TestEventStreamFilters(t *testing.T) {
+	ts := newTestService(t)
+	server := eventServer(t, ts)
+	resp, err := http.Get(server.URL + streamTestQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %v", resp.Header.Get("Content-Type"))
+	}
+	eventually(t, func() bool { return ts.subscriberCount() == 1 }, "stream didn't subscribe")
+	ts.events.Publish(streamTestEvents()...)
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "event: invoice_settled" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("unexpected event %q", lines)
+	}
+	event := &Event{}
+	err = json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Seq != 4 || event.Amount != 1000 || event.Invoice != "" {
+		t.Fatalf("expected only the matching event without invoice, got %+v", event)
+	}
+
+	// the subscription ends with the request
+	resp.Body.Close()
+	eventually(t, func() bool { return ts.subscriberCount() == 0 }, "stream wasn't unsubscribed")
+}
+
+func TestEventWebsocketFilters(t *testing.T) {
+	ts := newTestService(t)
+	server := eventServer(t, ts)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+streamTestQuery, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return ts.subscriberCount() == 1 }, "websocket didn't subscribe")
+	events := streamTestEvents()
+	ts.events.Publish(events...)
+	// a second matching event shows that nothing came in between
+	last := *events[len(events)-1]
+	last.Seq = 5
+	ts.events.Publish(&last)
+
+	for _, seq := range []uint64{4, 5} {
+		event := &Event{}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		err = conn.ReadJSON(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Seq != seq || event.Invoice != "" {
+			t.Fatalf("expected matching event %v without invoice, got %+v", seq, event)
+		}
+	}
+
+	// the subscription ends when the client goes away
+	conn.Close()
+	eventually(t, func() bool { return ts.subscriberCount() == 0 }, "websocket wasn't unsubscribed")
+}
@@ -0,0 +1,79 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// events that change what the bounty comment shows
+	commentEvents = []EventType{
+		EventBountyCreated,
+		EventBountyReopened,
+		EventBountyClosed,
+		EventInvoiceSettled,
+		EventOnchainDeposit,
+		EventPayout,
+		EventRefund,
+		EventBountyAdjusted,
+		EventNodeUnreachable,
+		EventNodeReachable,
+	}
+
+	// events are dead lettered after about eight minutes, so a failing
+	// handler doesn't hold up the events behind it
+	outboxRetryPolicy = RetryPolicy{MaxAttempts: 14, Backoff: time.Second, MaxBackoff: time.Minute}
+	// ledger entries are retried until they are appended, a skipped event
+	// would leave a money movement out of the ledger or append it out of
+	// order later
+	ledgerRetryPolicy = RetryPolicy{Backoff: time.Second, MaxBackoff: time.Minute}
+)
+
+// StartEventHandlers registers the handlers for the side effects of bounty
+// events, starts the event bus and the worker of the GitHub outbox.
+func (srv *IssueService) StartEventHandlers(ctx context.Context) error {
+	srv.events.Handle("github_comments", &EventFilter{Types: commentEvents}, srv.handleCommentEvent, outboxRetryPolicy)
+	srv.events.Handle("github_outages", &EventFilter{Types: []EventType{EventNodeOutage}}, srv.handleOutageEvent, outboxRetryPolicy)
+	srv.events.Handle("notifications", nil, srv.handleNotifyEvent, outboxRetryPolicy)
+	srv.events.Handle("emails", &EventFilter{Types: emailEvents}, srv.handleEmailEvent, outboxRetryPolicy)
+	srv.events.Handle("receipts", &EventFilter{Types: []EventType{EventInvoiceSettled}}, srv.handleReceiptEvent, outboxRetryPolicy)
+	// the ledger opens with the existing balances before its handler starts
+	err := srv.startLedger(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start the ledger: %v", err)
+	}
+	srv.events.Handle(ledgerCursor, &EventFilter{Types: ledgerEvents}, srv.handleLedgerEvent, ledgerRetryPolicy)
+	if srv.nostrKey != nil {
+		srv.events.Handle("nostr", &EventFilter{Types: nostrEvents}, srv.handleNostrEvent, outboxRetryPolicy)
+		err = srv.enqueue(ctx, &OutboxOp{Key: "nostr/profile", Kind: OutboxNostrProfile})
+		if err != nil {
+			return err
+		}
+	}
+	err = srv.events.Start(ctx)
+	if err != nil {
+		return err
+	}
+	go srv.runOutbox(ctx)
+	if srv.mailer != nil {
+		go srv.runEmailDigest(ctx)
+	}
+	if srv.nostrKey != nil && srv.cfg.LedgerAnchorInterval > 0 {
+		go srv.runLedgerAnchor(ctx)
+	}
+	return nil
+}
+
+func (srv *IssueService) handleCommentEvent(ctx context.Context, event *Event) error {
+	return srv.enqueueComment(ctx, event.IssueId)
+}
+
+func (srv *IssueService) handleOutageEvent(ctx context.Context, event *Event) error {
+	return srv.enqueue(ctx, &OutboxOp{
+		Key:     "outage/" + strconv.FormatUint(event.Seq, 10),
+		Kind:    OutboxOutageComment,
+		IssueId: event.IssueId,
+	})
+}
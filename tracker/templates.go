@@ -0,0 +1,464 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// markdown and html that is removed from donor notes, with # so notes
+	// don't reference issues
+	noteMarkup = strings.NewReplacer("`", "", "*", "", "_", "", "~", "", "[", "", "]", "", "<", "", ">", "", "|", "", "#", "", "\\", "")
+	// mentions in donor notes would notify the mentioned users
+	noteMentionRegexp = regexp.MustCompile(`@+([[:alnum:]])`)
+)
+
+type CommentKind string
+
+const (
+	// the comment of an active bounty
+	CommentActive CommentKind = "active"
+	// the comment after the issue was closed
+	CommentClosed CommentKind = "closed"
+	// the notification about a node outage
+	CommentOutage CommentKind = "outage"
+)
+
+var DefaultCommentTemplates = &CommentTemplates{
+	Active: `Lightning Bounty is active ![bounty]({{.BadgeUrl}})
+
+Benefactor: {{.Benefactor}}
+
+Current Bounty is {{.Bounty}} sats{{if .Fiat}} ({{.Fiat}}){{end}} from {{.Payments}} payments
+{{- if .Goal}}, {{.GoalPercent}}% of the {{.Goal}} sats goal{{end}}
+{{- if .Pledged}}
+
+The maintainers pledged another {{.Pledged}} sats
+{{- end}}
+
+Donate Bounty with {{.DonateUrl}}
+
+[![QR code]({{.QrUrl}})]({{.DonateUrl}})
+{{- if .Unreachable}}
+
+:warning: The benefactor node is currently unreachable, donations are not possible right now.
+{{- end}}
+{{- if .OnchainAddress}}
+
+On-chain donations: {{.OnchainAddress}}
+{{- end}}
+{{- range .Payouts}}
+
+Paid out {{.Amount}} on-chain in {{.Txid}}
+{{- end}}
+{{- if .LedgerHash}}
+
+Ledger entry: [{{.LedgerHash}}]({{.LedgerUrl}})
+{{- end}}`,
+	Closed: `Issue has been closed
+
+Total bounty for {{.Benefactor}} was {{.Bounty}} sats{{if .Fiat}} ({{.Fiat}}){{end}}`,
+	Outage: `@{{.Owner}} the lightning node {{.Benefactor}} of this bounty is unreachable since {{.UnreachableSince}}, ` +
+		`donations can't be received until it is back online.`,
+}
+
+// CommentLanguages are the default templates by language, repositories choose
+// one with the language of their repository file.
+var CommentLanguages = map[string]*CommentTemplates{
+	"en": DefaultCommentTemplates,
+	"de": {
+		Active: `Lightning Bounty ist aktiv ![bounty]({{.BadgeUrl}})
+
+Benefactor: {{.Benefactor}}
+
+Die aktuelle Bounty beträgt {{.Bounty}} sats{{if .Fiat}} ({{.Fiat}}){{end}} aus {{.Payments}} Zahlungen
+{{- if .Goal}}, {{.GoalPercent}}% des Ziels von {{.Goal}} sats{{end}}
+{{- if .Pledged}}
+
+Die Maintainer haben weitere {{.Pledged}} sats zugesagt
+{{- end}}
+
+Spende an die Bounty mit {{.DonateUrl}}
+
+[![QR Code]({{.QrUrl}})]({{.DonateUrl}})
+{{- if .Unreachable}}
+
+:warning: Die Node des Benefactors ist derzeit nicht erreichbar, Spenden sind gerade nicht möglich.
+{{- end}}
+{{- if .OnchainAddress}}
+
+On-chain Spenden: {{.OnchainAddress}}
+{{- end}}
+{{- range .Payouts}}
+
+{{.Amount}} sats on-chain ausgezahlt in {{.Txid}}
+{{- end}}
+{{- if .LedgerHash}}
+
+Ledger-Eintrag: [{{.LedgerHash}}]({{.LedgerUrl}})
+{{- end}}`,
+		Closed: `Das Issue wurde geschlossen
+
+Die gesamte Bounty für {{.Benefactor}} betrug {{.Bounty}} sats{{if .Fiat}} ({{.Fiat}}){{end}}`,
+		Outage: `@{{.Owner}} die Lightning Node {{.Benefactor}} dieser Bounty ist seit {{.UnreachableSince}} nicht erreichbar, ` +
+			`Spenden können erst wieder empfangen werden, wenn sie online ist.`,
+	},
+	"es": {
+		Active: `Lightning Bounty activa ![bounty]({{.BadgeUrl}})
+
+Benefactor: {{.Benefactor}}
+
+La recompensa actual es de {{.Bounty}} sats{{if .Fiat}} ({{.Fiat}}){{end}} de {{.Payments}} pagos
+{{- if .Goal}}, {{.GoalPercent}}% de la meta de {{.Goal}} sats{{end}}
+{{- if .Pledged}}
+
+Los maintainers prometieron otros {{.Pledged}} sats
+{{- end}}
+
+Dona a la recompensa en {{.DonateUrl}}
+
+[![Código QR]({{.QrUrl}})]({{.DonateUrl}})
+{{- if .Unreachable}}
+
+:warning: El nodo del benefactor no está disponible en este momento, no es posible donar.
+{{- end}}
+{{- if .OnchainAddress}}
+
+Donaciones on-chain: {{.OnchainAddress}}
+{{- end}}
+{{- range .Payouts}}
+
+Se pagaron {{.Amount}} sats on-chain en {{.Txid}}
+{{- end}}
+{{- if .LedgerHash}}
+
+Entrada del registro: [{{.LedgerHash}}]({{.LedgerUrl}})
+{{- end}}`,
+		Closed: `El issue ha sido cerrado
+
+La recompensa total para {{.Benefactor}} fue de {{.Bounty}} sats{{if .Fiat}} ({{.Fiat}}){{end}}`,
+		Outage: `@{{.Owner}} el nodo lightning {{.Benefactor}} de esta recompensa no está disponible desde {{.UnreachableSince}}, ` +
+			`no se pueden recibir donaciones hasta que vuelva a estar en línea.`,
+	},
+}
+
+// CommentTemplates are text/templates for the comments of the bot, they are
+// executed with CommentData. Empty templates fall back to the defaults.
+type CommentTemplates struct {
+	Active string `json:"active,omitempty" yaml:"active" toml:"active"`
+	Closed string `json:"closed,omitempty" yaml:"closed" toml:"closed"`
+	Outage string `json:"outage,omitempty" yaml:"outage" toml:"outage"`
+}
+
+// CommentData is the data model of comment templates.
+type CommentData struct {
+	Id     int64
+	Number int64
+	Title  string
+	Owner  string
+	Repo   string
+	Active bool
+	// pubkey of the benefactor node
+	Benefactor string
+	// total of all donations in sats
+	Bounty int64
+	// total in the currency of the repository like "12.34 USD", empty if no
+	// exchange rate is available
+	Fiat     string
+	Currency string
+	// number of donations
+	Payments int
+	// settled donations, newest first
+	Donors []*DonorData
+	// goal of the bounty in sats and how much of it is reached, 0 if the
+	// repository has no goal
+	Goal        int64
+	GoalPercent int64
+	// amount of the tier label of the issue, either suggested to donors or
+	// pledged by the maintainers depending on the tier mode
+	Suggested int64
+	Pledged   int64
+	Payouts   []*Payout
+	PaidOut   int64
+	// link to the issue, the donation page, a QR code image of it and a
+	// badge image with the live total
+	IssueUrl       string
+	DonateUrl      string
+	QrUrl          string
+	BadgeUrl       string
+	OnchainAddress string
+	Unreachable    bool
+	// formatted time since when the node is unreachable
+	UnreachableSince string
+	// hash of the latest ledger entry of the bounty and the url of the ledger
+	LedgerHash string
+	LedgerUrl  string
+}
+
+type DonorData struct {
+	Amount  int64
+	Fiat    string
+	Note    string
+	Onchain bool
+	Time    time.Time
+}
+
+func (templates *CommentTemplates) get(kind CommentKind) string {
+	if templates == nil {
+		return ""
+	}
+	switch kind {
+	case CommentActive:
+		return templates.Active
+	case CommentClosed:
+		return templates.Closed
+	case CommentOutage:
+		return templates.Outage
+	}
+	return ""
+}
+
+// Validate parses the templates and executes them with sample data, so
+// references to unknown fields are caught when templates are loaded.
+func (templates *CommentTemplates) Validate() error {
+	for _, kind := range []CommentKind{CommentActive, CommentClosed, CommentOutage} {
+		text := templates.get(kind)
+		if text == "" {
+			continue
+		}
+		_, err := renderTemplate(text, sampleCommentData())
+		if err != nil {
+			return fmt.Errorf("invalid %v template: %v", kind, err)
+		}
+	}
+	return nil
+}
+
+// defaultTemplates returns the default templates in the language of the
+// repository.
+func defaultTemplates(repo *Repository) *CommentTemplates {
+	if repo != nil && repo.File != nil {
+		if templates, ok := CommentLanguages[repo.File.Language]; ok {
+			return templates
+		}
+	}
+	return DefaultCommentTemplates
+}
+
+// commentTemplate returns the template of a repository, overrides of the
+// repository file take precedence over the ones stored with the
+// registration.
+func commentTemplate(repo *Repository, kind CommentKind) string {
+	if repo != nil {
+		if repo.File != nil {
+			if text := repo.File.Templates.get(kind); text != "" {
+				return text
+			}
+		}
+		if text := repo.Templates.get(kind); text != "" {
+			return text
+		}
+	}
+	return defaultTemplates(repo).get(kind)
+}
+
+// renderComment renders a comment of an issue with the templates of its
+// repository, falling back to the default if the override fails.
+func (srv *IssueService) renderComment(ctx context.Context, issue *BountyIssue, kind CommentKind) (string, error) {
+	repo, err := srv.repoStore.Get(ctx, issue.Owner, issue.Repo)
+	if err != nil && err != ErrDoesNotExist {
+		return "", err
+	}
+	data := srv.commentData(issue, repo)
+	body, err := renderTemplate(commentTemplate(repo, kind), data)
+	if err != nil {
+		fmt.Printf("unable to render %v comment of %v, using default: %v \n", kind, issue.Url, err)
+		return renderTemplate(defaultTemplates(repo).get(kind), data)
+	}
+	return body, nil
+}
+
+// PreviewComments renders the given templates, or the ones of the repository
+// if nil, for an issue or sample data.
+func (srv *IssueService) PreviewComments(ctx context.Context, owner string, name string, templates *CommentTemplates, issueId int64) (map[CommentKind]string, error) {
+	repo, err := srv.repoStore.Get(ctx, owner, name)
+	if err != nil && err != ErrDoesNotExist {
+		return nil, err
+	}
+	data := sampleCommentData()
+	if issueId != 0 {
+		issue, err := srv.store.Get(ctx, issueId)
+		if err != nil {
+			return nil, err
+		}
+		data = srv.commentData(issue, repo)
+	}
+	previews := make(map[CommentKind]string)
+	for _, kind := range []CommentKind{CommentActive, CommentClosed, CommentOutage} {
+		text := templates.get(kind)
+		if text == "" {
+			text = commentTemplate(repo, kind)
+		}
+		previews[kind], err = renderTemplate(text, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v template: %v", kind, err)
+		}
+	}
+	return previews, nil
+}
+
+// SetCommentTemplates stores comment templates with the registration of a
+// repository.
+func (srv *IssueService) SetCommentTemplates(ctx context.Context, owner string, name string, templates *CommentTemplates) (*Repository, error) {
+	err := templates.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return srv.updateRepo(ctx, owner, name, func(repo *Repository) {
+		repo.Templates = templates
+	})
+}
+
+func (srv *IssueService) commentData(issue *BountyIssue, repo *Repository) *CommentData {
+	data := &CommentData{
+		Id:             issue.Id,
+		Number:         issue.Number,
+		Title:          issue.Title,
+		Owner:          issue.Owner,
+		Repo:           issue.Repo,
+		Active:         issue.Active,
+		Benefactor:     issue.Pubkey,
+		Bounty:         issue.Bounty,
+		Payments:       issue.TotalPayments,
+		Payouts:        sentPayouts(issue),
+		PaidOut:        paidOut(issue),
+		IssueUrl:       issue.HtmlUrl,
+		DonateUrl:      srv.donateUrl(issue.Id),
+		QrUrl:          srv.qrUrl(issue.Id),
+		BadgeUrl:       srv.badgeUrl(issue.Id),
+		OnchainAddress: issue.OnchainAddress,
+		Unreachable:    issue.NodeUnreachableSince != 0,
+		LedgerHash:     issue.LedgerHash,
+		LedgerUrl:      srv.ledgerUrl(),
+	}
+	if data.IssueUrl == "" {
+		data.IssueUrl = issue.Url
+	}
+	if issue.NodeUnreachableSince != 0 {
+		data.UnreachableSince = time.Unix(issue.NodeUnreachableSince, 0).UTC().Format(time.RFC1123)
+	}
+	if issue.Rate != nil {
+		data.Currency = issue.Rate.Currency
+		data.Fiat = fmt.Sprintf("%.2f %s", issue.Rate.ToFiat(issue.Bounty), issue.Rate.Currency)
+	}
+	if tierMode(repo) == TierPledge {
+		data.Pledged = issue.Tier
+	} else {
+		data.Suggested = issue.Tier
+	}
+	if repo != nil && repo.File != nil && repo.File.Goal > 0 {
+		data.Goal = repo.File.Goal
+		data.GoalPercent = issue.Bounty * 100 / repo.File.Goal
+	}
+	for key, donation := range issue.Donations {
+		if donation.Timestamp == 0 || donation.RefundedAt != 0 {
+			// invoice that isn't paid yet or refunded donation
+			continue
+		}
+		donor := &DonorData{
+			Amount:  donation.Amount,
+			Note:    commentNote(donation.Note),
+			Onchain: issue.OnchainDeposits[key] != 0,
+			Time:    time.Unix(donation.Timestamp, 0),
+		}
+		if donation.Rate != nil {
+			donor.Fiat = fmt.Sprintf("%.2f %s", donation.Rate.ToFiat(donation.Amount), donation.Rate.Currency)
+		}
+		data.Donors = append(data.Donors, donor)
+	}
+	sort.Slice(data.Donors, func(i, j int) bool {
+		return data.Donors[i].Time.After(data.Donors[j].Time)
+	})
+	return data
+}
+
+// commentNote returns a donor note as a single line of plain text, as notes
+// are untrusted input of the donation page or zaps.
+func commentNote(note string) string {
+	note = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, note)
+	note = noteMarkup.Replace(note)
+	note = noteMentionRegexp.ReplaceAllString(note, "$1")
+	note = strings.Join(strings.Fields(note), " ")
+	if utf8.RuneCountInString(note) > maxNoteLength {
+		note = string([]rune(note)[:maxNoteLength]) + "…"
+	}
+	return note
+}
+
+func (srv *IssueService) donateUrl(id int64) string {
+	return fmt.Sprintf(srv.cfg.HttpUrl+"%s?%s=%s", invoicePagePath, issueidkey, strconv.FormatInt(id, 10))
+}
+
+func (srv *IssueService) qrUrl(id int64) string {
+	return fmt.Sprintf(srv.cfg.HttpUrl+"%s?%s=%s", qrPath, issueidkey, strconv.FormatInt(id, 10))
+}
+
+func (srv *IssueService) badgeUrl(id int64) string {
+	return fmt.Sprintf(srv.cfg.HttpUrl+"%s?%s=%s", badgePath, issueidkey, strconv.FormatInt(id, 10))
+}
+
+func renderTemplate(text string, data *CommentData) (string, error) {
+	tmpl, err := template.New("comment").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	body := &bytes.Buffer{}
+	err = tmpl.Execute(body, data)
+	if err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+func sampleCommentData() *CommentData {
+	return &CommentData{
+		Id:          1,
+		Number:      1,
+		Title:       "Sample issue",
+		Owner:       "owner",
+		Repo:        "repo",
+		Active:      true,
+		Benefactor:  "02aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		Bounty:      21000,
+		Fiat:        "12.34 USD",
+		Currency:    "USD",
+		Payments:    2,
+		Donors:      []*DonorData{{Amount: 20000, Fiat: "11.75 USD", Note: "thanks!", Time: time.Unix(0, 0)}, {Amount: 1000, Time: time.Unix(0, 0)}},
+		Goal:        100000,
+		GoalPercent: 21,
+		Suggested:   10000,
+		Pledged:     10000,
+		Payouts:     []*Payout{{Amount: 1000, Address: "bc1q", Txid: "txid", Timestamp: 0}},
+		PaidOut:     1000,
+		IssueUrl:    "https://github.com/owner/repo/issues/1",
+		DonateUrl:   "https://example.com/invoice?issue_id=1",
+		QrUrl:       "https://example.com/qr?issue_id=1",
+		BadgeUrl:    "https://example.com/badge?issue_id=1",
+		LedgerHash:  "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		LedgerUrl:   "https://example.com/ledger",
+	}
+}
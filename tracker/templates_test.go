@@ -0,0 +1,171 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDefaultTemplates(t *testing.T) {
+	expected := map[string]map[CommentKind]string{
+		"en": {
+			CommentActive: "Lightning Bounty is active",
+			CommentClosed: "Issue has been closed",
+			CommentOutage: "@owner the lightning node",
+		},
+		"de": {
+			CommentActive: "Lightning Bounty ist aktiv",
+			CommentClosed: "Das Issue wurde geschlossen",
+			CommentOutage: "@owner die Lightning Node",
+		},
+		"es": {
+			CommentActive: "Lightning Bounty activa",
+			CommentClosed: "El issue ha sido cerrado",
+			CommentOutage: "@owner el nodo lightning",
+		},
+	}
+	if len(CommentLanguages) != len(expected) {
+		t.Fatalf("expected %v languages, got %v", len(expected), len(CommentLanguages))
+	}
+	data := sampleCommentData()
+	for language, kinds := range expected {
+		repo := &Repository{File: &RepoFile{Language: language}}
+		for kind, prefix := range kinds {
+			body, err := renderTemplate(commentTemplate(repo, kind), data)
+			if err != nil {
+				t.Fatalf("unable to render %v %v template: %v", language, kind, err)
+			}
+			if !strings.HasPrefix(body, prefix) {
+				t.Fatalf("expected the %v %v comment to start with %q, got %q", language, kind, prefix, body)
+			}
+			if kind != CommentOutage && !strings.Contains(body, "21000") {
+				t.Fatalf("%v %v comment doesn't show the bounty: %q", language, kind, body)
+			}
+		}
+	}
+
+	// unknown languages and repositories without file use english
+	for _, repo := range []*Repository{nil, {}, {File: &RepoFile{Language: "xx"}}} {
+		if defaultTemplates(repo) != DefaultCommentTemplates {
+			t.Fatalf("expected the english templates for %+v", repo)
+		}
+	}
+}
+
+func TestCommentTemplateOverrides(t *testing.T) {
+	repo := &Repository{
+		Templates: &CommentTemplates{Active: "stored", Closed: "stored"},
+		File: &RepoFile{
+			Language:  "de",
+			Templates: &CommentTemplates{Active: "file"},
+		},
+	}
+	if text := commentTemplate(repo, CommentActive); text != "file" {
+		t.Fatalf("expected the template of the file, got %q", text)
+	}
+	if text := commentTemplate(repo, CommentClosed); text != "stored" {
+		t.Fatalf("expected the stored template, got %q", text)
+	}
+	if text := commentTemplate(repo, CommentOutage); text != CommentLanguages["de"].Outage {
+		t.Fatalf("expected the german default, got %q", text)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	for _, text := range []string{
+		"{{.Bounty",
+		"{{.Unknown}}",
+		"{{range .Donors}}{{.Unknown}}{{end}}",
+		"{{unknownFunc .Bounty}}",
+		"{{index .Donors 5}}",
+		"{{end}}",
+	} {
+		for _, templates := range []*CommentTemplates{{Active: text}, {Closed: text}, {Outage: text}} {
+			if err := templates.Validate(); err == nil {
+				t.Fatalf("template %q was accepted", text)
+			}
+		}
+	}
+	valid := &CommentTemplates{
+		Active: "{{.Bounty}} sats{{range .Donors}} {{.Amount}} {{.Note}}{{end}}",
+		Closed: "closed",
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parseRepoFile(".github/bounty.yml", []byte("templates:\n  active: \"{{.Bounty\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "invalid active template") {
+		t.Fatalf("expected the invalid template to be rejected, got %+v, %v", file, err)
+	}
+}
+
+func TestRenderCommentFallback(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	// valid for the sample data, but the issue has no donors
+	_, err := ts.SetCommentTemplates(ctx, "owner", "repo", &CommentTemplates{Active: "{{(index .Donors 1).Amount}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ts.renderComment(ctx, issue, CommentActive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(body, "Lightning Bounty is active") {
+		t.Fatalf("expected the default comment, got %q", body)
+	}
+}
+
+func TestCommentNote(t *testing.T) {
+	long := strings.Repeat("ä", maxNoteLength+10)
+	for note, expected := range map[string]string{
+		"thanks!":                            "thanks!",
+		"  multiple\n\nlines\tand  spaces ":  "multiple lines and spaces",
+		"**bold** _italic_ ~~gone~~ `code`":  "bold italic gone code",
+		"[click](https://example.com/phish)": "click(https://example.com/phish)",
+		"![img](https://example.com/x.png)":  "!img(https://example.com/x.png)",
+		"<img src=x onerror=alert(1)>":       "img src=x onerror=alert(1)",
+		"# heading | table | fixes #12":      "heading table fixes 12",
+		"cc @alice and @@bob, mail me@x.org": "cc alice and bob, mail mex.org",
+		"@org/team":                          "org/team",
+		"null\x00byte\x1b[31m":               "nullbyte31m",
+		"\\*escaped\\*":                      "escaped",
+		long:                                 strings.Repeat("ä", maxNoteLength) + "…",
+	} {
+		if got := commentNote(note); got != expected {
+			t.Errorf("expected note %q to be %q, got %q", note, expected, got)
+		}
+	}
+}
+
+func TestDonorNotesInComments(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	issue := ts.addIssue(t, 1)
+	payreq, err := ts.GetBountyInvoice(ctx, issue.Id, 1000, &DonorInfo{Note: "@maintainer **pay** [me](https://example.com)\n# now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := ts.node.settle(payreq, 1000)
+	err = ts.SettleInvoice(ctx, ts.issue(t, issue.Id), payreq, 1000, inv.Preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.SetCommentTemplates(ctx, "owner", "repo", &CommentTemplates{Active: "{{range .Donors}}{{.Note}}{{end}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ts.renderComment(ctx, ts.issue(t, issue.Id), CommentActive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "maintainer pay me(https://example.com) now" {
+		t.Fatalf("note wasn't sanitized: %q", body)
+	}
+
+	// the stored note is kept as it was entered
+	if note := ts.issue(t, issue.Id).Donations[payreq].Note; !strings.HasPrefix(note, "@maintainer **pay**") {
+		t.Fatalf("stored note was changed: %q", note)
+	}
+}